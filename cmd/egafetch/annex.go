@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/cobra"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+// annexFile is a completed download described as git-annex content.
+type annexFile struct {
+	File         string `json:"file"`          // slash-separated, relative to the download directory
+	Key          string `json:"key,omitempty"` // "" when git-annex has no backend for the checksum type
+	Size         int64  `json:"size"`
+	Checksum     string `json:"checksum"`
+	ChecksumType string `json:"checksum_type"`
+	URL          string `json:"ega_url"`
+	FileID       string `json:"file_id"`
+}
+
+// jsonAnnex is the JSON output of 'annex'.
+type jsonAnnex struct {
+	Directory  string      `json:"directory"`
+	DatasetID  string      `json:"dataset_id,omitempty"`
+	Repository string      `json:"repository,omitempty"` // with --register
+	Files      []annexFile `json:"files"`
+}
+
+// --- Annex command ---
+
+func newAnnexCmd() *cobra.Command {
+	var output string
+	var register bool
+
+	cmd := &cobra.Command{
+		Use:   "annex [directory]",
+		Short: "Describe or register completed downloads as git-annex content",
+		Long: `List the completed downloads in a directory (default: the configured
+output_dir, or the current directory) with what git-annex needs to track
+them: the git-annex key derived from the EGA checksum (MD5E or SHA256E),
+the size, the checksum, and the file's EGA URL.
+
+The list is printed as TSV, or as JSON with --json. Columns:
+  file  key  size  checksum  ega_url  file_id
+
+With --register, the files are added to the git-annex repository or
+DataLad dataset that contains the directory, with the backend matching
+their EGA checksum, and their EGA file ID, dataset ID, and URL are set as
+git-annex metadata (ega_file_id, ega_dataset_id, ega_url). git-annex hashes
+each file as it is added, and the command fails if that does not match the
+EGA checksum. Record the result with 'datalad save' or 'git commit'. The
+list is then only written with --json or --output.`,
+		Example: `  egafetch annex ./data > annex.tsv
+  egafetch annex ./data --json
+  egafetch annex ./dataset/EGAD00001001938 --register && datalad save -d ./dataset -m "Add EGAD00001001938"`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, err := defaultDir(args)
+			if err != nil {
+				return err
+			}
+
+			sm := state.NewStateManager(dir)
+			files, err := annexFiles(sm)
+			if err != nil {
+				return err
+			}
+			if len(files) == 0 {
+				return fmt.Errorf("no completed downloads with checksums in %s", dir)
+			}
+			var datasetID string
+			if manifest, err := sm.LoadManifest(); err == nil && manifest != nil {
+				datasetID = manifest.DatasetID
+			}
+
+			out := jsonAnnex{Directory: dir, DatasetID: datasetID, Files: files}
+			if register {
+				ctx, cancel := signalContext()
+				defer cancel()
+				out.Repository, err = registerAnnexFiles(ctx, dir, datasetID, files)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(textOutput(cmd), "Registered %d file(s) in git-annex repository %s\n", len(files), out.Repository)
+				fmt.Fprintln(textOutput(cmd), "Record them with 'datalad save' or 'git commit'.")
+			}
+
+			w := cmd.OutOrStdout()
+			if output != "" {
+				f, err := os.Create(output)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			if jsonOutput {
+				enc := json.NewEncoder(w)
+				enc.SetIndent("", "  ")
+				return enc.Encode(out)
+			}
+			if register && output == "" {
+				return nil
+			}
+			return writeAnnexTSV(w, files)
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Write the list to this file instead of stdout")
+	cmd.Flags().BoolVar(&register, "register", false, "Add the files to the enclosing git-annex repository or DataLad dataset")
+
+	return cmd
+}
+
+// annexFiles returns the completed downloads in sm's directory that have a
+// checksum and are still there, sorted by file name.
+func annexFiles(sm *state.StateManager) ([]annexFile, error) {
+	states, err := sm.ListFileStates()
+	if err != nil {
+		return nil, err
+	}
+	var files []annexFile
+	for _, fs := range states {
+		if fs.Status != state.StatusComplete || fs.ChecksumExpected == "" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(sm.BaseDir(), fs.FileName)); err != nil {
+			slog.Warn("Skipping completed file that is not in the directory", "file", fs.FileName, "error", err)
+			continue
+		}
+		files = append(files, annexFile{
+			File:         filepath.ToSlash(fs.FileName),
+			Key:          annexKey(fs.FileName, fs.Size, fs.ChecksumExpected, fs.ChecksumType),
+			Size:         fs.Size,
+			Checksum:     strings.ToLower(fs.ChecksumExpected),
+			ChecksumType: strings.ToLower(fs.ChecksumType),
+			URL:          api.FileURL(fs.FileID),
+			FileID:       fs.FileID,
+		})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].File < files[j].File })
+	return files, nil
+}
+
+// annexBackend returns the git-annex backend that keys files by checksums
+// of checksumType, keeping their extension, or "" if there is none.
+func annexBackend(checksumType string) string {
+	switch strings.ToUpper(checksumType) {
+	case "MD5":
+		return "MD5E"
+	case "SHA256":
+		return "SHA256E"
+	}
+	return ""
+}
+
+// annexKey returns the git-annex key of a file named name, as git-annex
+// would compute it with the backend for checksumType, e.g.
+// MD5E-s1024--d41d8cd98f00b204e9800998ecf8427e.bam.
+func annexKey(name string, size int64, checksum, checksumType string) string {
+	backend := annexBackend(checksumType)
+	if backend == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s-s%d--%s%s", backend, size, strings.ToLower(checksum), annexExtension(name))
+}
+
+// annexExtension returns the extension git-annex keeps in the keys of a
+// file named name with its default settings: up to two trailing extensions
+// (annex.maxextensions) of at most four alphanumeric characters each
+// (annex.maxextensionlength), e.g. ".vcf.gz" or ".bam".
+func annexExtension(name string) string {
+	parts := strings.Split(filepath.Base(name), ".")
+	var ext string
+	for i := len(parts) - 1; i > 0 && len(parts)-i <= 2; i-- {
+		p := parts[i]
+		if p == "" || len(p) > 4 || strings.IndexFunc(p, func(r rune) bool { return r > unicode.MaxASCII || !unicode.IsLetter(r) && !unicode.IsDigit(r) }) >= 0 {
+			break
+		}
+		ext = "." + p + ext
+	}
+	return ext
+}
+
+// writeAnnexTSV writes files as TSV with a header row.
+func writeAnnexTSV(w io.Writer, files []annexFile) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "file\tkey\tsize\tchecksum\tega_url\tfile_id")
+	for _, f := range files {
+		fmt.Fprintf(bw, "%s\t%s\t%d\t%s\t%s\t%s\n", f.File, f.Key, f.Size, f.Checksum, f.URL, f.FileID)
+	}
+	return bw.Flush()
+}
+
+// registerAnnexFiles adds files in dir to the git-annex repository that
+// contains dir, keyed with the backend of their EGA checksums, and sets
+// their EGA metadata. It returns the repository's top-level directory.
+func registerAnnexFiles(ctx context.Context, dir, datasetID string, files []annexFile) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(absDir); err == nil {
+		absDir = resolved
+	}
+	top, err := runGit(ctx, absDir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", fmt.Errorf("%s is not in a git repository; create a DataLad dataset ('datalad create') or run 'git init && git annex init' first", dir)
+	}
+	top = filepath.FromSlash(top)
+	if uuid, _ := runGit(ctx, top, "config", "--get", "annex.uuid"); uuid == "" {
+		return "", fmt.Errorf("git-annex is not initialized in %s; run 'git annex init' there first", top)
+	}
+
+	rel := make([]string, len(files))
+	for i, f := range files {
+		p, err := filepath.Rel(top, filepath.Join(absDir, filepath.FromSlash(f.File)))
+		if err != nil {
+			return "", err
+		}
+		rel[i] = filepath.ToSlash(p)
+	}
+
+	// Add the files of each backend in one batch; files whose checksum has
+	// no backend get the repository's default.
+	byBackend := make(map[string][]int)
+	var backends []string
+	for i, f := range files {
+		b := annexBackend(f.ChecksumType)
+		if _, ok := byBackend[b]; !ok {
+			backends = append(backends, b)
+		}
+		byBackend[b] = append(byBackend[b], i)
+	}
+	for _, backend := range backends {
+		idx := byBackend[backend]
+		args := []string{"add"}
+		if backend != "" {
+			args = append(args, "--backend="+backend)
+		}
+		lines := make([]string, len(idx))
+		for j, i := range idx {
+			lines[j] = rel[i]
+		}
+		results, err := annexBatch(ctx, top, args, lines)
+		if err != nil {
+			return "", err
+		}
+		for j, i := range idx {
+			if err := checkAnnexAdd(results[j], files[i]); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	lines := make([]string, len(files))
+	for i, f := range files {
+		fields := map[string][]string{"ega_file_id": {f.FileID}, "ega_url": {f.URL}}
+		if datasetID != "" {
+			fields["ega_dataset_id"] = []string{datasetID}
+		}
+		line, err := json.Marshal(map[string]interface{}{"file": rel[i], "fields": fields})
+		if err != nil {
+			return "", err
+		}
+		lines[i] = string(line)
+	}
+	results, err := annexBatch(ctx, top, []string{"metadata"}, lines)
+	if err != nil {
+		return "", err
+	}
+	for i, r := range results {
+		if !r.Success {
+			return "", fmt.Errorf("git annex metadata %s: %s", files[i].File, r.errorMessage())
+		}
+	}
+	return top, nil
+}
+
+// annexResult is one line of git-annex --json output.
+type annexResult struct {
+	File          string   `json:"file"`
+	Key           string   `json:"key"`
+	Success       bool     `json:"success"`
+	Note          string   `json:"note"`
+	ErrorMessages []string `json:"error-messages"`
+	skipped       bool     // a blank line: git-annex had nothing to do
+}
+
+func (r annexResult) errorMessage() string {
+	if len(r.ErrorMessages) > 0 {
+		return strings.Join(r.ErrorMessages, "; ")
+	}
+	if r.Note != "" {
+		return r.Note
+	}
+	return "failed"
+}
+
+// checkAnnexAdd checks the result of adding f: git-annex must have hashed
+// it to the key its EGA checksum gives, apart from the extension.
+func checkAnnexAdd(r annexResult, f annexFile) error {
+	if r.skipped {
+		// Already annexed (e.g. by an earlier --register).
+		return nil
+	}
+	if !r.Success {
+		return fmt.Errorf("git annex add %s: %s", f.File, r.errorMessage())
+	}
+	if f.Key != "" && annexKeyHash(r.Key) != annexKeyHash(f.Key) {
+		return fmt.Errorf("git annex add %s: key %s does not match the EGA checksum (%s)", f.File, r.Key, f.Key)
+	}
+	return nil
+}
+
+// annexKeyHash returns the backend, size, and checksum of a git-annex key,
+// without its extension.
+func annexKeyHash(key string) string {
+	prefix, rest, _ := strings.Cut(key, "--")
+	hash, _, _ := strings.Cut(rest, ".")
+	return prefix + "--" + hash
+}
+
+// annexBatch runs 'git annex COMMAND --batch --json' in dir, sends it lines
+// one at a time, and returns its result for each.
+func annexBatch(ctx context.Context, dir string, args []string, lines []string) ([]annexResult, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", append(append([]string{"-C", dir, "annex"}, args...), "--batch", "--json")...)
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("git annex: %w", err)
+	}
+	wait := func(err error) error {
+		stdin.Close()
+		if waitErr := cmd.Wait(); err == nil {
+			err = waitErr
+		}
+		if err == nil {
+			return nil
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("git annex %s: %s", args[0], lastLine(msg))
+		}
+		return fmt.Errorf("git annex %s: %w", args[0], err)
+	}
+
+	results := make([]annexResult, 0, len(lines))
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for _, line := range lines {
+		if _, err := io.WriteString(stdin, line+"\n"); err != nil {
+			return nil, wait(err)
+		}
+		if !scanner.Scan() {
+			err := scanner.Err()
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, wait(err)
+		}
+		var r annexResult
+		if text := strings.TrimSpace(scanner.Text()); text == "" {
+			r.skipped = true
+		} else if err := json.Unmarshal([]byte(text), &r); err != nil {
+			return nil, wait(fmt.Errorf("parse output: %w", err))
+		}
+		results = append(results, r)
+	}
+	if err := wait(nil); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// runGit runs git in dir and returns its trimmed output.
+func runGit(ctx context.Context, dir string, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...).Output()
+	return strings.TrimSpace(string(out)), err
+}
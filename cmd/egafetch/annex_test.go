@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+func TestAnnexKey(t *testing.T) {
+	tests := []struct {
+		name, checksumType, want string
+	}{
+		{"EGAF1/a.bam", "MD5", "MD5E-s10--0123abcd.bam"},
+		{"EGAF1/a.vcf.gz", "md5", "MD5E-s10--0123abcd.vcf.gz"},
+		{"EGAF1/a.R1.fastq.gz", "MD5", "MD5E-s10--0123abcd.gz"}, // "fastq" is too long
+		{"EGAF1/a.tar.gz.c4gh", "MD5", "MD5E-s10--0123abcd.gz.c4gh"},
+		{"EGAF1/README", "SHA256", "SHA256E-s10--0123abcd"},
+		{"EGAF1/a.bam", "SHA1", ""},
+	}
+	for _, tt := range tests {
+		if got := annexKey(tt.name, 10, "0123ABCD", tt.checksumType); got != tt.want {
+			t.Errorf("annexKey(%q, %s) = %q, want %q", tt.name, tt.checksumType, got, tt.want)
+		}
+	}
+
+	if got, want := annexKeyHash("MD5E-s10--0123abcd.vcf.gz"), annexKeyHash("MD5E-s10--0123abcd.gz"); got != want {
+		t.Errorf("annexKeyHash differs by extension: %q, %q", got, want)
+	}
+}
+
+func TestAnnexFiles(t *testing.T) {
+	dir := t.TempDir()
+	sm := state.NewStateManager(dir)
+	for _, f := range []struct {
+		spec   state.FileSpec
+		status state.FileStatus
+		write  bool
+	}{
+		{state.FileSpec{FileID: "EGAF2", FileName: filepath.Join("EGAF2", "b.bam"), Size: 4, Checksum: "ABCD", ChecksumType: "MD5"}, state.StatusComplete, true},
+		{state.FileSpec{FileID: "EGAF1", FileName: filepath.Join("EGAF1", "a.cram"), Size: 4, Checksum: "0123", ChecksumType: "MD5"}, state.StatusComplete, true},
+		{state.FileSpec{FileID: "EGAF3", FileName: filepath.Join("EGAF3", "c.bam"), Size: 4, Checksum: "4567", ChecksumType: "MD5"}, state.StatusDownloading, true},
+		{state.FileSpec{FileID: "EGAF4", FileName: filepath.Join("EGAF4", "d.bam"), Size: 4, Checksum: "89ab", ChecksumType: "MD5"}, state.StatusComplete, false},
+	} {
+		fs := state.NewFileState(f.spec, 4)
+		fs.Status = f.status
+		if err := sm.SaveFileState(fs); err != nil {
+			t.Fatal(err)
+		}
+		if f.write {
+			path := filepath.Join(dir, f.spec.FileName)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	files, err := annexFiles(sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := writeAnnexTSV(&b, files); err != nil {
+		t.Fatal(err)
+	}
+	want := "file\tkey\tsize\tchecksum\tega_url\tfile_id\n" +
+		"EGAF1/a.cram\tMD5E-s4--0123.cram\t4\t0123\thttps://ega.ebi.ac.uk:8443/v2/files/EGAF1?destinationFormat=plain\tEGAF1\n" +
+		"EGAF2/b.bam\tMD5E-s4--abcd.bam\t4\tabcd\thttps://ega.ebi.ac.uk:8443/v2/files/EGAF2?destinationFormat=plain\tEGAF2\n"
+	if b.String() != want {
+		t.Errorf("annex TSV =\n%s\nwant\n%s", b.String(), want)
+	}
+
+	_, err = registerAnnexFiles(context.Background(), dir, "", files)
+	if err == nil || !strings.Contains(err.Error(), "not in a git repository") {
+		t.Errorf("registerAnnexFiles outside a repository = %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/cobra"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+// attributeFilter selects files by the reference assembly and sequencing
+// platform that the dataset metadata records for them (--assembly,
+// --platform). A file matches a flag if any of its values matches any of
+// the flag's values.
+type attributeFilter struct {
+	assemblies []string
+	platforms  []string
+}
+
+// Metadata columns holding a file's assembly and platform. Files are linked
+// to them through their own records and those of the runs, analyses, and
+// experiments they belong to.
+var (
+	assemblyColumns = []string{"assembly", "genome_assembly", "reference_genome", "genome_id", "genome"}
+	platformColumns = []string{"instrument_platform", "platform", "instrument_model"}
+	metadataUnits   = []string{"run_accession_id", "analysis_accession_id", "experiment_accession_id"}
+)
+
+// assemblyAliases maps common names of human assemblies to the GRC name, so
+// that --assembly GRCh37 also matches hg19, b37, and hs37d5.
+var assemblyAliases = map[string]string{
+	"hg19": "grch37", "b37": "grch37", "hs37d5": "grch37", "grch37": "grch37",
+	"hg38": "grch38", "grch38": "grch38",
+	"hg18": "ncbi36", "ncbi36": "ncbi36",
+}
+
+func addAttributeFilterFlags(cmd *cobra.Command, f *attributeFilter) {
+	cmd.Flags().StringSliceVar(&f.assemblies, "assembly", nil, "Reference assemblies to include, from the dataset metadata (e.g. GRCh38; hg38 and GRCh38 are the same)")
+	cmd.Flags().StringSliceVar(&f.platforms, "platform", nil, "Sequencing platforms to include, from the dataset metadata (e.g. ILLUMINA, OXFORD_NANOPORE)")
+}
+
+func (f attributeFilter) empty() bool {
+	return len(f.assemblies) == 0 && len(f.platforms) == 0
+}
+
+// load returns the metadata mappings of the datasets among args, cached
+// next to their metadata export directories under outputDir, for apply. It
+// returns nil if no filter is set.
+func (f attributeFilter) load(ctx context.Context, apiClient *api.Client, outputDir string, args []string, refresh bool, token func() (string, error)) ([]*api.DatasetMetadata, error) {
+	if f.empty() {
+		return nil, nil
+	}
+	var metas []*api.DatasetMetadata
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "EGAD") {
+			continue
+		}
+		meta, err := cachedOrFetchMetadata(ctx, apiClient, filepath.Join(outputDir, arg+"-metadata"), arg, refresh, token)
+		if errors.Is(err, errMetadataPasswordRequired) {
+			return nil, fmt.Errorf("--assembly and --platform need the metadata of %s, which requires your password: use --cf", arg)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("--assembly and --platform need the metadata of %s: %w", arg, err)
+		}
+		metas = append(metas, meta)
+	}
+	if len(metas) == 0 {
+		return nil, fmt.Errorf("--assembly and --platform need a dataset (EGAD...) to read metadata from")
+	}
+	return metas, nil
+}
+
+// apply keeps the manifest files that match the filter in metas, the
+// metadata returned by load, and explicitly named EGAF files in args. Index
+// files without metadata of their own are kept with the file they index.
+func (f attributeFilter) apply(manifest *state.Manifest, args []string, metas []*api.DatasetMetadata) error {
+	if f.empty() {
+		return nil
+	}
+	assemblies := make(map[string][]string)
+	platforms := make(map[string][]string)
+	for _, meta := range metas {
+		fileMetadataValues(meta, assemblyColumns, assemblies)
+		fileMetadataValues(meta, platformColumns, platforms)
+	}
+	egafIDs := make(map[string]bool)
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "EGAF") {
+			egafIDs[arg] = true
+		}
+	}
+
+	all := manifest.Files
+	var kept, candidates []state.FileSpec
+	for _, file := range all {
+		match := egafIDs[file.FileID] ||
+			(matchesAny(assemblies[file.FileID], f.assemblies, assemblyMatches) &&
+				matchesAny(platforms[file.FileID], f.platforms, platformMatches))
+		unlabelled := len(assemblies[file.FileID]) == 0 && len(platforms[file.FileID]) == 0
+		if match {
+			kept = append(kept, file)
+		}
+		if match || unlabelled {
+			candidates = append(candidates, file)
+		}
+	}
+	manifest.Files = kept
+	addIndexFiles(manifest, candidates)
+
+	if len(manifest.Files) == 0 {
+		return fmt.Errorf("no files match the given --assembly/--platform filters in the dataset metadata (filtered out all %d files)", len(all))
+	}
+	if len(manifest.Files) != len(all) {
+		slog.Info("Filtered files by metadata", "matched", len(manifest.Files), "total", len(all))
+	}
+	return nil
+}
+
+// fileMetadataValues adds to values, by file accession, the first non-empty
+// of columns in each metadata record of the file or of a run, analysis, or
+// experiment it belongs to.
+func fileMetadataValues(meta *api.DatasetMetadata, columns []string, values map[string][]string) {
+	unitValues := make(map[string][]string) // "unit column\x00accession" → values
+	fileUnits := make(map[string][]string)  // file accession → unit keys
+	for _, records := range [][]map[string]interface{}{
+		meta.StudyExperimentRunSample, meta.RunSample,
+		meta.StudyAnalysisSample, meta.AnalysisSample, meta.SampleFile,
+	} {
+		for _, rec := range records {
+			fileID := formatValue(rec["file_accession_id"])
+			value := firstValue(rec, columns...)
+			for _, unit := range metadataUnits {
+				id := formatValue(rec[unit])
+				if id == "" {
+					continue
+				}
+				key := unit + "\x00" + id
+				if value != "" {
+					unitValues[key] = appendNew(unitValues[key], value)
+				}
+				if fileID != "" {
+					fileUnits[fileID] = appendNew(fileUnits[fileID], key)
+				}
+			}
+			if fileID != "" && value != "" {
+				values[fileID] = appendNew(values[fileID], value)
+			}
+		}
+	}
+	for fileID, units := range fileUnits {
+		for _, key := range units {
+			for _, value := range unitValues[key] {
+				values[fileID] = appendNew(values[fileID], value)
+			}
+		}
+	}
+}
+
+func appendNew(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// matchesAny reports whether one of values matches one of wants, or true if
+// there are no wants.
+func matchesAny(values, wants []string, match func(value, want string) bool) bool {
+	if len(wants) == 0 {
+		return true
+	}
+	for _, want := range wants {
+		for _, value := range values {
+			if match(value, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// assemblyMatches reports whether an assembly value such as "GRCh38.p13" or
+// "Homo sapiens (hg38)" names the assembly want.
+func assemblyMatches(value, want string) bool {
+	want = normalizeAssembly(strings.TrimSpace(want))
+	if strings.EqualFold(value, want) {
+		return true
+	}
+	for _, word := range metadataWords(value) {
+		if normalizeAssembly(word) == want {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeAssembly(s string) string {
+	s = strings.ToLower(s)
+	if alias, ok := assemblyAliases[s]; ok {
+		return alias
+	}
+	return s
+}
+
+// platformMatches reports whether a platform value names the platform want,
+// as the whole value ("OXFORD_NANOPORE") or one of its words ("Illumina" in
+// the instrument model "Illumina NovaSeq 6000").
+func platformMatches(value, want string) bool {
+	want = strings.TrimSpace(want)
+	if strings.EqualFold(value, want) {
+		return true
+	}
+	for _, word := range metadataWords(value) {
+		if strings.EqualFold(word, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// metadataWords splits a metadata value into its alphanumeric words.
+func metadataWords(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+func TestAttributeFilter(t *testing.T) {
+	meta := &api.DatasetMetadata{
+		StudyExperimentRunSample: []map[string]interface{}{
+			{"run_accession_id": "EGAR1", "file_accession_id": "EGAF1", "instrument_platform": "ILLUMINA"},
+			{"run_accession_id": "EGAR2", "file_accession_id": "EGAF2", "instrument_model": "Oxford Nanopore PromethION"},
+		},
+		StudyAnalysisSample: []map[string]interface{}{
+			{"analysis_accession_id": "EGAZ1", "genome_id": "GRCh37.p13"},
+			{"analysis_accession_id": "EGAZ2", "assembly": "Homo sapiens (hg38)"},
+		},
+		AnalysisSample: []map[string]interface{}{
+			{"analysis_accession_id": "EGAZ1", "file_accession_id": "EGAF3"},
+			{"analysis_accession_id": "EGAZ2", "file_accession_id": "EGAF4"},
+			{"analysis_accession_id": "EGAZ2", "file_accession_id": "EGAF5"},
+		},
+	}
+	files := []state.FileSpec{
+		{FileID: "EGAF1", FileName: "EGAF1/r1.fastq.gz"},
+		{FileID: "EGAF2", FileName: "EGAF2/r2.fastq.gz"},
+		{FileID: "EGAF3", FileName: "EGAF3/s.b37.bam"},
+		{FileID: "EGAF4", FileName: "EGAF4/s.b38.bam"},
+		{FileID: "EGAF5", FileName: "EGAF5/s.b38.vcf.gz"},
+		{FileID: "EGAF6", FileName: "EGAF6/s.b38.bam.bai"}, // no metadata: follows its BAM
+		{FileID: "EGAF7", FileName: "EGAF7/s.b37.bam.bai"},
+		{FileID: "EGAF8", FileName: "EGAF8/README.txt"},
+	}
+
+	tests := []struct {
+		name   string
+		filter attributeFilter
+		args   []string
+		want   string
+	}{
+		{"assembly", attributeFilter{assemblies: []string{"GRCh38"}}, []string{"EGAD1"}, "EGAF4,EGAF5,EGAF6"},
+		{"assembly alias", attributeFilter{assemblies: []string{"hg19"}}, []string{"EGAD1"}, "EGAF3,EGAF7"},
+		{"platform model", attributeFilter{platforms: []string{"oxford"}}, []string{"EGAD1"}, "EGAF2"},
+		{"platform and assembly", attributeFilter{assemblies: []string{"GRCh38"}, platforms: []string{"ILLUMINA"}}, []string{"EGAD1"}, ""},
+		{"explicit file", attributeFilter{platforms: []string{"ILLUMINA"}}, []string{"EGAD1", "EGAF8"}, "EGAF1,EGAF8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &state.Manifest{Files: append([]state.FileSpec(nil), files...)}
+			err := tt.filter.apply(m, tt.args, []*api.DatasetMetadata{meta})
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("apply kept %v, want an error", m.Files)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, f := range m.Files {
+				ids = append(ids, f.FileID)
+			}
+			if got := strings.Join(ids, ","); got != tt.want {
+				t.Errorf("kept %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+	"gopkg.in/yaml.v3"
+)
+
+// batchSpec is a --batch file: several downloads run by one invocation.
+// JSON files are accepted too, since the YAML parser reads JSON.
+type batchSpec struct {
+	Parallel int          `yaml:"parallel"` // entries run at once (default 1)
+	Entries  []batchEntry `yaml:"entries"`
+}
+
+// batchEntry is one download in a batch file. Filters replace the
+// corresponding command-line flags when set; exclude_ids add to them.
+type batchEntry struct {
+	Name       string   `yaml:"name"`
+	IDs        []string `yaml:"ids"`
+	FromFile   []string `yaml:"from_file"`
+	Output     string   `yaml:"output"`
+	Include    []string `yaml:"include"`
+	Exclude    []string `yaml:"exclude"`
+	ExcludeIDs []string `yaml:"exclude_ids"`
+}
+
+// loadBatch reads a batch file into output groups, one or more per entry,
+// and returns how many entries may run at once. Relative paths in the file
+// are resolved against its directory (rclone remotes and bucket URLs are
+// kept as they are); entries without an output directory use defaultOutput.
+func loadBatch(path, defaultOutput string) ([]outputGroup, int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("read batch file: %w", err)
+	}
+	var spec batchSpec
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&spec); err != nil {
+		return nil, 0, fmt.Errorf("parse batch file %s: %w", path, err)
+	}
+	if len(spec.Entries) == 0 {
+		return nil, 0, fmt.Errorf("batch file %s has no entries", path)
+	}
+	if spec.Parallel == 0 {
+		spec.Parallel = 1
+	}
+	if spec.Parallel < 1 {
+		return nil, 0, fmt.Errorf("batch file %s: parallel must be at least 1", path)
+	}
+
+	baseDir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if _, isRemote, _ := rcloneRemote(p); p == "" || p == "-" || filepath.IsAbs(p) || isRemote {
+			return p
+		}
+		return filepath.Join(baseDir, p)
+	}
+
+	var groups []outputGroup
+	for i, e := range spec.Entries {
+		name := e.Name
+		if name == "" {
+			name = fmt.Sprintf("entry %d", i+1)
+		}
+		args := append([]string{}, e.IDs...)
+		for _, f := range e.FromFile {
+			args = append(args, resolve(f))
+		}
+		ids, err := expandArgs(args)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %w", name, err)
+		}
+		if len(ids) == 0 {
+			return nil, 0, fmt.Errorf("%s: no identifiers given", name)
+		}
+		for j, id := range ids {
+			if id, dir, mapped := strings.Cut(id, "="); mapped {
+				ids[j] = id + "=" + resolve(strings.TrimSpace(dir))
+			}
+		}
+		excluded, err := readExcludedIDs(e.ExcludeIDs, nil)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %w", name, err)
+		}
+		output := resolve(e.Output)
+		if output == "" {
+			output = defaultOutput
+		}
+
+		entryGroups, err := groupByOutputDir(ids, output)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %w", name, err)
+		}
+		for _, g := range entryGroups {
+			g.name = name
+			g.include = e.Include
+			g.exclude = e.Exclude
+			g.excluded = excluded
+			groups = append(groups, g)
+		}
+	}
+
+	// Two downloads in one directory at once would share state files.
+	if spec.Parallel > 1 {
+		seen := make(map[string]string)
+		for _, g := range groups {
+			dir := filepath.Clean(g.dir)
+			if other, ok := seen[dir]; ok {
+				return nil, 0, fmt.Errorf("batch entries %q and %q both write to %s; use parallel: 1 or separate directories", other, g.name, dir)
+			}
+			seen[dir] = g.name
+		}
+	}
+	return groups, spec.Parallel, nil
+}
+
+// runBatch downloads each group with at most parallel running at once. A
+// failed entry does not stop the others; results are returned in batch
+// order along with the first failure.
+func runBatch(ctx context.Context, groups []outputGroup, parallel int, download func(outputGroup) (interface{}, error)) ([]interface{}, error) {
+	results := make([]interface{}, len(groups))
+	errs := make([]error, len(groups))
+
+	var eg errgroup.Group
+	eg.SetLimit(parallel)
+	for i, g := range groups {
+		if ctx.Err() != nil {
+			break
+		}
+		eg.Go(func() error {
+			slog.Info("Starting batch entry", "entry", g.name, "identifiers", len(g.args), "output_dir", g.dir)
+			results[i], errs[i] = download(g)
+			if errs[i] != nil {
+				slog.Warn("Batch entry failed", "entry", g.name, "error", errs[i])
+			}
+			return nil
+		})
+	}
+	eg.Wait()
+
+	var kept []interface{}
+	for _, r := range results {
+		if r != nil {
+			kept = append(kept, r)
+		}
+	}
+
+	var failed int
+	var firstErr error
+	for _, err := range errs {
+		if err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr != nil {
+		return kept, fmt.Errorf("%d of %d batch download(s) failed: %w", failed, len(groups), firstErr)
+	}
+	if err := ctx.Err(); err != nil {
+		return kept, err
+	}
+	return kept, nil
+}
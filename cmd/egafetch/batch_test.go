@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeBatchFile writes content to name in dir and returns its path.
+func writeBatchFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadBatch(t *testing.T) {
+	dir := t.TempDir()
+	writeBatchFile(t, dir, "germline.txt", "EGAD00001000002\n")
+	path := writeBatchFile(t, dir, "ingest.yaml", `
+parallel: 2
+entries:
+  - name: tumour
+    ids: [EGAD00001000001, EGAF00000000009=extra]
+    output: proj1
+    include: ["*.bam"]
+  - from_file: [germline.txt]
+    output: /data/proj2
+    exclude_ids: [EGAF00000000001]
+  - ids: [EGAD00001000003]
+`)
+
+	groups, parallel, err := loadBatch(path, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parallel != 2 {
+		t.Errorf("parallel = %d, want 2", parallel)
+	}
+
+	want := []outputGroup{
+		{dir: filepath.Join(dir, "proj1"), args: []string{"EGAD00001000001"}, name: "tumour", include: []string{"*.bam"}, excluded: map[string]bool{}},
+		{dir: filepath.Join(dir, "extra"), args: []string{"EGAF00000000009"}, name: "tumour", include: []string{"*.bam"}, excluded: map[string]bool{}},
+		{dir: "/data/proj2", args: []string{"EGAD00001000002"}, name: "entry 2", excluded: map[string]bool{"EGAF00000000001": true}},
+		{dir: "default", args: []string{"EGAD00001000003"}, name: "entry 3", excluded: map[string]bool{}},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("groups =\n%+v\nwant\n%+v", groups, want)
+	}
+}
+
+func TestLoadBatchErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "no entries", content: "parallel: 2\n", wantErr: "has no entries"},
+		{name: "unknown key", content: "entries:\n  - ids: [EGAD00001000001]\n    layout: flat\n", wantErr: "field layout not found"},
+		{name: "bad parallel", content: "parallel: -1\nentries:\n  - ids: [EGAD00001000001]\n", wantErr: "parallel must be at least 1"},
+		{name: "no identifiers", content: "entries:\n  - name: empty\n", wantErr: "empty: no identifiers given"},
+		{name: "missing identifier file", content: "entries:\n  - from_file: [missing.txt]\n", wantErr: "cannot open identifier file"},
+		{name: "shared directory in parallel", content: "parallel: 2\nentries:\n  - name: a\n    ids: [EGAD00001000001]\n  - name: b\n    ids: [EGAD00001000002]\n",
+			wantErr: `batch entries "a" and "b" both write to default`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeBatchFile(t, t.TempDir(), "batch.yaml", tt.content)
+			_, _, err := loadBatch(path, "default")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
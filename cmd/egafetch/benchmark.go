@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/khan-lab/EGAfetch/internal/ui"
+)
+
+// Chunk-size recommendation bounds.
+const (
+	chunkSeconds   = 15       // a chunk should take about this long per stream, so request overhead stays small
+	minChunkAdvice = 16 << 20 // smallest chunk size recommended
+	maxChunkAdvice = 256 << 20
+)
+
+// diskResult is the write throughput measured in a directory.
+type diskResult struct {
+	writers int
+	bytes   int64
+	elapsed time.Duration
+}
+
+func (r diskResult) rate() float64 {
+	if r.elapsed <= 0 {
+		return 0
+	}
+	return float64(r.bytes) / r.elapsed.Seconds()
+}
+
+// --- Benchmark command ---
+
+func newBenchmarkCmd() *cobra.Command {
+	var (
+		settings  []string
+		duration  time.Duration
+		rangeSize string
+		outputDir string
+		diskSize  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "benchmark",
+		Short: "Measure network and disk throughput and find the bottleneck",
+		Long: `Measure the throughput from EGA, as speedtest does, and the write throughput
+of the output directory separately, then report which of the two limits
+downloads and suggest --parallel-files, --parallel-chunks, and --chunk-size.
+
+The network is measured with each of --settings for --duration, discarding
+what is received. The disk is measured by writing random data to temporary
+files in --output from as many writers as the best setting has streams, for
+--duration or until --disk-size is written, including the time to flush it
+to storage. The files are removed afterwards.
+
+Each downloaded byte is written twice, to its chunk file and then to the
+output file, so the disk limits downloads to half its write throughput.
+
+Like quickstart, this logs in with the public test account in memory only.`,
+		Example: `  egafetch benchmark
+  egafetch benchmark -o /scratch/ega --duration 30s`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			parsed, err := parseSpeedSettings(settings)
+			if err != nil {
+				return err
+			}
+			size, err := parseSize(rangeSize)
+			if err != nil {
+				return fmt.Errorf("invalid range-size: %w", err)
+			}
+			limit, err := parseSize(diskSize)
+			if err != nil {
+				return fmt.Errorf("invalid disk-size: %w", err)
+			}
+			if duration <= 0 {
+				return fmt.Errorf("--duration must be positive")
+			}
+			if outputDir == "" {
+				if outputDir, err = defaultDir(nil); err != nil {
+					return err
+				}
+			}
+
+			ctx, cancel := signalContext()
+			defer cancel()
+
+			results, err := measureSettings(ctx, parsed, size, duration)
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			printSpeedResults(out, results)
+			best, ok := recommendSetting(results)
+			if !ok {
+				return fmt.Errorf("no data could be downloaded from EGA; run 'egafetch doctor' to find out why")
+			}
+
+			writers := best.setting.files * best.setting.chunks
+			slog.Info("Measuring disk writes...", "dir", outputDir, "writers", writers, "duration", duration)
+			disk, err := measureDisk(ctx, outputDir, writers, limit, duration)
+			if ctx.Err() != nil {
+				return withExitCode(exitInterrupted, ctx.Err())
+			}
+			if err != nil {
+				return err
+			}
+
+			network, diskLimit := best.rate(), disk.rate()/2
+			fmt.Fprintln(out)
+			fmt.Fprintf(out, "Network: %s/s with %d streams\n", ui.FormatBytes(int64(network)), writers)
+			fmt.Fprintf(out, "Disk:    %s/s writing from %d writers to %s (downloads up to %s/s)\n",
+				ui.FormatBytes(int64(disk.rate())), disk.writers, outputDir, ui.FormatBytes(int64(diskLimit)))
+			fmt.Fprintln(out)
+			pick := best
+			if diskLimit < network {
+				fmt.Fprintln(out, "Bottleneck: disk. More connections will not help; keeping chunks on other storage (--tmp-dir) halves the writes to it.")
+				// Fewer streams suffice to reach what the disk can take.
+				pick, _ = recommendSetting(capRates(results, diskLimit))
+			} else {
+				fmt.Fprintln(out, "Bottleneck: network.")
+			}
+			streams := pick.setting.files * pick.setting.chunks
+			chunk := recommendChunkSize(min(pick.rate(), diskLimit) / float64(streams))
+			fmt.Fprintf(out, "Recommended: --parallel-files %d --parallel-chunks %d --chunk-size %s\n",
+				pick.setting.files, pick.setting.chunks, formatChunkSize(chunk))
+			fmt.Fprintf(out, "Save it with: egafetch config set parallel_files %d && egafetch config set parallel_chunks %d && egafetch config set chunk_size %s\n",
+				pick.setting.files, pick.setting.chunks, formatChunkSize(chunk))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&settings, "settings", defaultSpeedSettings, "Network settings to measure, as FILESxCHUNKS")
+	cmd.Flags().DurationVar(&duration, "duration", 10*time.Second, "How long to measure each network setting, and the disk")
+	cmd.Flags().StringVar(&rangeSize, "range-size", "16M", "Size of each requested byte range")
+	cmd.Flags().StringVarP(&outputDir, "output", "o", "", "Directory whose disk to measure (default: the configured output_dir, or .)")
+	cmd.Flags().StringVar(&diskSize, "disk-size", "2G", "Most data the disk measurement writes")
+
+	return cmd
+}
+
+// measureDisk writes random data to files in a temporary directory under
+// dir from writers goroutines at once, until duration has passed or limit
+// bytes are written, and returns the throughput including the final sync.
+func measureDisk(ctx context.Context, dir string, writers int, limit int64, duration time.Duration) (diskResult, error) {
+	tmp, err := os.MkdirTemp(dir, ".egafetch-benchmark-")
+	if err != nil {
+		return diskResult{}, fmt.Errorf("create benchmark directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// Random, so that compressing filesystems write it all.
+	buf := make([]byte, 1<<20)
+	rand.Read(buf)
+	runCtx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		written  int64
+		firstErr error
+	)
+	share := max(limit/int64(writers), int64(len(buf)))
+	start := time.Now()
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := writeFile(runCtx, filepath.Join(tmp, strconv.Itoa(i)), buf, share)
+			mu.Lock()
+			defer mu.Unlock()
+			written += n
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return diskResult{}, fmt.Errorf("benchmark disk: %w", firstErr)
+	}
+	return diskResult{writers: writers, bytes: written, elapsed: time.Since(start)}, nil
+}
+
+// writeFile writes buf to path repeatedly until ctx is done or limit bytes
+// are written, syncs it, and returns the bytes written.
+func writeFile(ctx context.Context, path string, buf []byte, limit int64) (int64, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var n int64
+	for n < limit && ctx.Err() == nil {
+		w, err := f.Write(buf[:min(int64(len(buf)), limit-n)])
+		n += int64(w)
+		if err != nil {
+			return n, err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return n, err
+	}
+	return n, f.Close()
+}
+
+// capRates returns results with each throughput lowered to at most limit
+// bytes per second, so that recommendSetting picks the fewest streams that
+// reach it.
+func capRates(results []speedResult, limit float64) []speedResult {
+	capped := make([]speedResult, len(results))
+	for i, r := range results {
+		capped[i] = r
+		if r.rate() > limit {
+			capped[i].bytes = int64(limit * r.elapsed.Seconds())
+		}
+	}
+	return capped
+}
+
+// recommendChunkSize returns the power-of-two chunk size, within
+// minChunkAdvice and maxChunkAdvice, that a stream receiving perStream bytes
+// per second downloads in about chunkSeconds.
+func recommendChunkSize(perStream float64) int64 {
+	want := perStream * chunkSeconds
+	if want <= minChunkAdvice {
+		return minChunkAdvice
+	}
+	size := int64(1) << int(math.Log2(want))
+	return min(max(size, minChunkAdvice), maxChunkAdvice)
+}
+
+// formatChunkSize formats a chunk size from recommendChunkSize as a
+// --chunk-size value, e.g. 64M.
+func formatChunkSize(n int64) string {
+	return strconv.FormatInt(n>>20, 10) + "M"
+}
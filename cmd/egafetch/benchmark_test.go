@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMeasureDisk(t *testing.T) {
+	dir := t.TempDir()
+	r, err := measureDisk(context.Background(), dir, 3, 6<<20, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.bytes != 6<<20 || r.writers != 3 || r.rate() <= 0 {
+		t.Errorf("measured %+v; want 6 MiB from 3 writers", r)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("left %d entries behind", len(entries))
+	}
+}
+
+func TestRecommendChunkSize(t *testing.T) {
+	tests := []struct {
+		perStream float64
+		want      int64
+	}{
+		{perStream: 100 << 10, want: 16 << 20},
+		{perStream: 3 << 20, want: 32 << 20},
+		{perStream: 5 << 20, want: 64 << 20},
+		{perStream: 100 << 20, want: 256 << 20},
+	}
+	for _, tt := range tests {
+		if got := recommendChunkSize(tt.perStream); got != tt.want {
+			t.Errorf("recommendChunkSize(%.0f) = %s; want %s", tt.perStream, formatChunkSize(got), formatChunkSize(tt.want))
+		}
+	}
+}
+
+func TestCapRates(t *testing.T) {
+	result := func(files, chunks int, mb int64) speedResult {
+		return speedResult{setting: speedSetting{files: files, chunks: chunks}, bytes: mb << 20, elapsed: time.Second}
+	}
+	results := []speedResult{result(1, 1, 20), result(1, 8, 60), result(4, 8, 100)}
+
+	// A disk that takes 50 MB/s is reached with 8 streams.
+	got, ok := recommendSetting(capRates(results, 50<<20))
+	if !ok || got.setting != (speedSetting{files: 1, chunks: 8}) {
+		t.Errorf("got %v, %v; want 1x8", got.setting, ok)
+	}
+}
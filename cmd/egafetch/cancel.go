@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+// startJob records the current process as the download running in sm's
+// directory, refusing to start if another live download already owns it.
+func startJob(sm *state.StateManager) (*state.Job, error) {
+	host, _ := os.Hostname()
+	prev, err := sm.LoadJob()
+	if err != nil {
+		return nil, err
+	}
+	if prev != nil && prev.Status == state.JobRunning && prev.Host == host &&
+		prev.PID != os.Getpid() && processAlive(prev.PID) {
+		return nil, fmt.Errorf("another download (pid %d) is already running in %s", prev.PID, sm.BaseDir())
+	}
+
+	job := &state.Job{
+		PID:       os.Getpid(),
+		Host:      host,
+		Status:    state.JobRunning,
+		Args:      os.Args[1:],
+		StartedAt: time.Now(),
+	}
+	if err := sm.SaveJob(job); err != nil {
+		return nil, fmt.Errorf("save job: %w", err)
+	}
+	return job, nil
+}
+
+// finishJob records how the download recorded by startJob ended.
+func finishJob(sm *state.StateManager, job *state.Job, err error) {
+	switch {
+	case interrupted.Load():
+		job.Status = state.JobCancelled
+	case err != nil:
+		job.Status = state.JobFailed
+	default:
+		job.Status = state.JobCompleted
+	}
+	// Keep the cancel request written by 'egafetch cancel', if any.
+	if onDisk, loadErr := sm.LoadJob(); loadErr == nil && onDisk != nil {
+		job.CancelRequestedAt = onDisk.CancelRequestedAt
+	}
+	if saveErr := sm.SaveJob(job); saveErr != nil {
+		slog.Debug("Could not save job", "error", saveErr)
+	}
+}
+
+// cancelPollInterval is how often a download checks its job record for a
+// cancel request.
+const cancelPollInterval = time.Second
+
+// watchCancelRequest polls the job record in sm's directory and calls stop
+// once 'egafetch cancel' has recorded a cancel request. This is how cancel
+// reaches downloads on Windows, where it cannot send a signal. It returns
+// when ctx is done.
+func watchCancelRequest(ctx context.Context, sm *state.StateManager, stop context.CancelFunc) {
+	ticker := time.NewTicker(cancelPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		job, err := sm.LoadJob()
+		if err == nil && job != nil && job.CancelRequestedAt != nil {
+			slog.Warn("Cancel requested. Saving state...")
+			interrupted.Store(true)
+			stop()
+			return
+		}
+	}
+}
+
+// --- Cancel command ---
+
+func newCancelCmd() *cobra.Command {
+	var timeout time.Duration
+	var noWait bool
+
+	cmd := &cobra.Command{
+		Use:   "cancel [directory]",
+		Short: "Stop a download running in a directory",
+		Long: `Stop the download running in an output directory (default: the configured
+output_dir, or the current directory), for example one started with nohup
+or inside a batch job.
+
+A cancel request is recorded in .egafetch/job.json and the download process
+is sent SIGINT, which lets it save state and exit as if Ctrl+C had been
+pressed; the job is then marked as cancelled. On Windows, where no signal
+can be sent, the download picks up the request within a second instead.
+Re-run 'egafetch download' to resume later.
+
+Must be run on the same host as the download.`,
+		Example: `  egafetch cancel ./data
+  egafetch cancel ./data --timeout 2m`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, err := defaultDir(args)
+			if err != nil {
+				return err
+			}
+
+			sm := state.NewStateManager(dir)
+			job, err := sm.LoadJob()
+			if err != nil {
+				return err
+			}
+			if job == nil || job.Status != state.JobRunning {
+				return fmt.Errorf("no download is running in %s", dir)
+			}
+			if host, _ := os.Hostname(); job.Host != host {
+				return fmt.Errorf("download in %s is running on host %s; run 'egafetch cancel' there", dir, job.Host)
+			}
+
+			if !processAlive(job.PID) {
+				job.Status = state.JobCancelled
+				if err := sm.SaveJob(job); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Download process %d is no longer running; marked the download as cancelled.\n", job.PID)
+				return nil
+			}
+
+			now := time.Now()
+			job.CancelRequestedAt = &now
+			if err := sm.SaveJob(job); err != nil {
+				return err
+			}
+			if err := stopProcess(job.PID); err != nil {
+				return fmt.Errorf("signal process %d: %w", job.PID, err)
+			}
+			if noWait {
+				fmt.Fprintf(cmd.OutOrStdout(), "Asked download process %d to stop.\n", job.PID)
+				return nil
+			}
+			slog.Info("Waiting for the download process to stop", "pid", job.PID)
+
+			deadline := time.Now().Add(timeout)
+			for processAlive(job.PID) {
+				if time.Now().After(deadline) {
+					return fmt.Errorf("download process %d did not stop within %s", job.PID, timeout)
+				}
+				time.Sleep(200 * time.Millisecond)
+			}
+
+			// The worker marks itself cancelled on exit; cover the case where
+			// it died before it could.
+			if final, err := sm.LoadJob(); err == nil && final != nil && final.Status == state.JobRunning {
+				final.Status = state.JobCancelled
+				if err := sm.SaveJob(final); err != nil {
+					return err
+				}
+			}
+
+			fmt.Fprintln(cmd.OutOrStdout(), "Download cancelled. Run 'egafetch download' again to resume.")
+			return nil
+		},
+	}
+
+	cmd.Flags().DurationVar(&timeout, "timeout", 30*time.Second, "How long to wait for the download to stop")
+	cmd.Flags().BoolVar(&noWait, "no-wait", false, "Return after requesting the stop without waiting")
+
+	return cmd
+}
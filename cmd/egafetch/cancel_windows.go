@@ -0,0 +1,32 @@
+//go:build windows
+
+package main
+
+import "syscall"
+
+const (
+	processQueryLimitedInformation = 0x1000
+	stillActive                    = 259
+)
+
+// processAlive reports whether a process with the given PID is running.
+func processAlive(pid int) bool {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		// Access denied means the process exists but belongs to someone else.
+		return err == syscall.ERROR_ACCESS_DENIED
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
+
+// stopProcess is a no-op: Windows cannot deliver SIGINT to another process,
+// so the download notices the cancel request in its job record instead (see
+// watchCancelRequest).
+func stopProcess(pid int) error {
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// checkpointSignals are the signals that batch schedulers send before they
+// preempt or kill a job: SIGTERM, and SIGUSR1 as requested with SLURM's
+// --signal or PBS's qsig.
+var checkpointSignals = []os.Signal{syscall.SIGTERM, syscall.SIGUSR1}
@@ -0,0 +1,12 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// checkpointSignals are the signals sent before the process is killed. On
+// Windows, Go delivers SIGTERM when the console closes or the user logs off.
+var checkpointSignals = []os.Signal{syscall.SIGTERM}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+)
+
+// Shell completion for accessions reads listings cached under
+// ~/.egafetch/cache/ whenever 'list', 'download', or 'summary' fetch them, so
+// pressing <Tab> never contacts the API.
+
+const (
+	listingCacheDirName  = "cache"
+	datasetListCacheName = "datasets.json"
+	fileListCacheDirName = "files"
+)
+
+// cachedFile is a file entry in a cached dataset listing.
+type cachedFile struct {
+	FileID   string `json:"file_id"`
+	FileName string `json:"file_name"`
+}
+
+// listingCacheDir returns ~/.egafetch/cache/, or "" if the home directory is
+// unknown.
+func listingCacheDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".egafetch", listingCacheDirName)
+}
+
+// writeListingCache writes v as JSON to name under the listing cache.
+// Caching is best effort: errors are ignored.
+func writeListingCache(name string, v interface{}) {
+	dir := listingCacheDir()
+	if dir == "" {
+		return
+	}
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return
+	}
+	os.Rename(tmp, path)
+}
+
+// readListingCache decodes name from the listing cache into v and reports
+// whether it was found.
+func readListingCache(name string, v interface{}) bool {
+	dir := listingCacheDir()
+	if dir == "" {
+		return false
+	}
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, v) == nil
+}
+
+// listDatasets lists authorized datasets and caches their IDs for completion.
+func listDatasets(ctx context.Context, apiClient *api.Client) ([]api.DatasetInfo, error) {
+	datasets, err := apiClient.ListDatasets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(datasets))
+	for i, d := range datasets {
+		ids[i] = d.DatasetID
+	}
+	writeListingCache(datasetListCacheName, ids)
+	return datasets, nil
+}
+
+// listDatasetFiles lists a dataset's files and caches their IDs and names
+// for completion.
+func listDatasetFiles(ctx context.Context, apiClient *api.Client, datasetID string) ([]api.DatasetFile, error) {
+	files, err := apiClient.ListDatasetFiles(ctx, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	cached := make([]cachedFile, len(files))
+	for i, f := range files {
+		cached[i] = cachedFile{FileID: f.FileID, FileName: filepath.Base(f.FileName)}
+	}
+	writeListingCache(filepath.Join(fileListCacheDirName, datasetID+".json"), cached)
+	return files, nil
+}
+
+// cachedDatasetIDs returns every dataset ID known from cached listings.
+func cachedDatasetIDs() []string {
+	set := make(map[string]bool)
+	var ids []string
+	readListingCache(datasetListCacheName, &ids)
+	for _, id := range ids {
+		set[id] = true
+	}
+	if dir := listingCacheDir(); dir != "" {
+		entries, _ := os.ReadDir(filepath.Join(dir, fileListCacheDirName))
+		for _, e := range entries {
+			set[strings.TrimSuffix(e.Name(), ".json")] = true
+		}
+	}
+
+	ids = ids[:0]
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// cachedFileCompletions returns "EGAF...\tname" completions from the cached
+// listings of the given datasets, or of every cached dataset if none given.
+func cachedFileCompletions(datasetIDs []string) []string {
+	if len(datasetIDs) == 0 {
+		datasetIDs = cachedDatasetIDs()
+	}
+	var completions []string
+	for _, id := range datasetIDs {
+		var files []cachedFile
+		if !readListingCache(filepath.Join(fileListCacheDirName, id+".json"), &files) {
+			continue
+		}
+		for _, f := range files {
+			completions = append(completions, f.FileID+"\t"+f.FileName)
+		}
+	}
+	return completions
+}
+
+// isAccessionPrefix reports whether s could be the start of an EGA
+// accession ("", "E", "EG", "EGAD0...", ...).
+func isAccessionPrefix(s string) bool {
+	return strings.HasPrefix("EGA", s) || strings.HasPrefix(s, "EGA")
+}
+
+// filterCompletions keeps the completions that start with toComplete and are
+// not already among args.
+func filterCompletions(completions, args []string, toComplete string) []string {
+	used := make(map[string]bool, len(args))
+	for _, a := range args {
+		used[a] = true
+	}
+	var out []string
+	for _, c := range completions {
+		id, _, _ := strings.Cut(c, "\t")
+		if strings.HasPrefix(id, toComplete) && !used[id] {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// completeDatasetIDs completes EGAD accessions from cached listings. When
+// single is set, only the first argument is completed.
+func completeDatasetIDs(single bool) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if single && len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return filterCompletions(cachedDatasetIDs(), args, toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeAccessions completes EGAD and EGAF accessions from cached listings.
+// File IDs are taken from datasets already on the command line when there
+// are any. With allowFiles, arguments not starting with "EGA" fall back to
+// file-path completion (for identifier files).
+func completeAccessions(single, allowFiles bool) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if single && len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		if allowFiles && !isAccessionPrefix(toComplete) {
+			return nil, cobra.ShellCompDirectiveDefault
+		}
+
+		var datasets []string
+		for _, a := range args {
+			if strings.HasPrefix(a, "EGAD") {
+				datasets = append(datasets, a)
+			}
+		}
+		completions := cachedDatasetIDs()
+		if strings.HasPrefix(toComplete, "EGAF") || len(datasets) > 0 {
+			completions = append(completions, cachedFileCompletions(datasets)...)
+		}
+		return filterCompletions(completions, args, toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/khan-lab/EGAfetch/internal/config"
+	"github.com/khan-lab/EGAfetch/internal/download"
+	"github.com/khan-lab/EGAfetch/internal/notify"
+	"github.com/khan-lab/EGAfetch/internal/ui"
+)
+
+// applyConfig fills flags not given on the command line from config.yaml,
+// with EGAFETCH_* environment variables taking precedence over the file.
+// flagKeys maps flag names to config keys.
+func applyConfig(cmd *cobra.Command, flagKeys map[string]string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	for flagName, key := range flagKeys {
+		f := cmd.Flags().Lookup(flagName)
+		if f == nil || f.Changed {
+			continue
+		}
+		v, err := cfg.Get(key)
+		if err != nil {
+			return err
+		}
+		if v == "" {
+			continue
+		}
+		// Set the value directly so the flag still reads as not Changed.
+		if err := f.Value.Set(v); err != nil {
+			return fmt.Errorf("invalid %s in config: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// defaultDir returns the directory argument of commands like 'status', or
+// the configured output_dir when none was given.
+func defaultDir(args []string) (string, error) {
+	if len(args) > 0 {
+		return args[0], nil
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return "", fmt.Errorf("load config: %w", err)
+	}
+	if cfg.OutputDir != "" {
+		return cfg.OutputDir, nil
+	}
+	return ".", nil
+}
+
+// validateConfigValue checks a value before it is written to config.yaml.
+func validateConfigValue(key, value string) error {
+	if value == "" {
+		return nil
+	}
+	switch key {
+	case "chunk_size", "max_bandwidth", "max_memory":
+		if _, err := parseSize(value); err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+	case "metadata_format":
+		switch value {
+		case "tsv", "csv", "json", "ndjson":
+		default:
+			return fmt.Errorf("unsupported metadata_format %q (use tsv, csv, json, or ndjson)", value)
+		}
+	case "webhook":
+		for _, raw := range strings.Split(value, ",") {
+			if u, err := url.Parse(strings.TrimSpace(raw)); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return fmt.Errorf("invalid webhook %q: expected an http(s) URL", raw)
+			}
+		}
+	case "endpoints":
+		for _, raw := range strings.Split(value, ",") {
+			if u, err := url.Parse(strings.TrimSpace(raw)); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return fmt.Errorf("invalid endpoint %q: expected an http(s) URL such as https://ega.ebi.ac.uk:8443/v2", raw)
+			}
+		}
+	case "buffer_size":
+		if n, err := parseSize(value); err != nil || n < 4<<10 || n > 64<<20 {
+			return fmt.Errorf("invalid buffer_size %q: expected a size from 4K to 64M", value)
+		}
+	case "file_mode", "dir_mode":
+		if _, err := parseFileMode(value); err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+	case "progress":
+		if !slices.Contains(ui.Renderers, value) {
+			return fmt.Errorf("unsupported progress %q (use %s)", value, strings.Join(ui.Renderers, ", "))
+		}
+	case "progress_interval", "dns_cache_ttl", "dial_keep_alive", "dial_fallback_delay", "drain":
+		if d, err := time.ParseDuration(value); err != nil || d < 0 {
+			return fmt.Errorf("invalid %s %q (use a duration such as 30s or 5m)", key, value)
+		}
+	case "stall_timeout":
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			return fmt.Errorf("invalid stall_timeout %q (use a positive duration such as 60s or 5m)", value)
+		}
+	case "log_max_size", "small_file_size":
+		if _, err := parseSize(value); err != nil && value != "0" {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+	case "stripe_count":
+		if n, err := strconv.Atoi(value); err != nil || n < -1 {
+			return fmt.Errorf("invalid stripe_count %q (use a number of OSTs, -1 for all, or 0 for the filesystem default)", value)
+		}
+	case "stripe_size":
+		if value == "0" {
+			return nil
+		}
+		if n, err := parseSize(value); err != nil || n%download.StripeSizeUnit != 0 {
+			return fmt.Errorf("invalid stripe_size %q: expected a multiple of 64K, such as 4M", value)
+		}
+	case "log_max_age":
+		if d, err := time.ParseDuration(value); err != nil || d < 0 {
+			return fmt.Errorf("invalid log_max_age %q (use a duration such as 720h)", value)
+		}
+	case "webhook_format":
+		if !slices.Contains(notify.Formats, value) {
+			return fmt.Errorf("unsupported webhook_format %q (use %s)", value, strings.Join(notify.Formats, ", "))
+		}
+	}
+	return nil
+}
+
+// --- Config command ---
+
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "View and change default settings",
+		Long: `Manage ~/.egafetch/config.yaml, which supplies defaults for download
+settings across commands.
+
+Precedence is: command-line flags, then EGAFETCH_* environment variables
+(e.g. EGAFETCH_PARALLEL_FILES), then config.yaml, then built-in defaults.`,
+	}
+
+	cmd.AddCommand(newConfigGetCmd(), newConfigSetCmd(), newConfigListCmd())
+	return cmd
+}
+
+func completeConfigKeys(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var keys []string
+	for _, k := range config.Keys {
+		keys = append(keys, k.Name+"\t"+k.Description)
+	}
+	return filterCompletions(keys, args, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+func newConfigGetCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:               "get KEY",
+		Short:             "Print the effective value of a setting",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeConfigKeys,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load()
+			if err != nil {
+				return fmt.Errorf("load config: %w", err)
+			}
+			v, err := cfg.Get(args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), v)
+			return nil
+		},
+	}
+}
+
+func newConfigSetCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "set KEY VALUE",
+		Short: "Set a setting in config.yaml (an empty VALUE clears it)",
+		Example: `  egafetch config set parallel_files 8
+  egafetch config set output_dir /data/ega
+  egafetch config set max_bandwidth ""`,
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeConfigKeys,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, value := args[0], args[1]
+			if _, ok := config.LookupKey(key); !ok {
+				return fmt.Errorf("unknown config key %q", key)
+			}
+			if err := validateConfigValue(key, value); err != nil {
+				return err
+			}
+
+			cfg, err := config.LoadFile()
+			if err != nil {
+				return err
+			}
+			if err := cfg.Set(key, value); err != nil {
+				return fmt.Errorf("invalid %s: %w", key, err)
+			}
+			if err := config.Save(cfg); err != nil {
+				return err
+			}
+
+			path, _ := config.Path()
+			if value == "" {
+				fmt.Fprintf(cmd.OutOrStdout(), "Cleared %s in %s\n", key, path)
+			} else {
+				fmt.Fprintf(cmd.OutOrStdout(), "Set %s = %s in %s\n", key, value, path)
+			}
+			return nil
+		},
+	}
+}
+
+func newConfigListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List all settings and where each value comes from",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fileCfg, err := config.LoadFile()
+			if err != nil {
+				return err
+			}
+			cfg, err := config.Load()
+			if err != nil {
+				return fmt.Errorf("load config: %w", err)
+			}
+
+			var rows [][]string
+			for _, k := range config.Keys {
+				v, _ := cfg.Get(k.Name)
+				fileValue, _ := fileCfg.Get(k.Name)
+				source := "default"
+				switch {
+				case os.Getenv(k.Env) != "":
+					source = k.Env
+				case fileValue != "":
+					source = "config.yaml"
+				}
+				if v == "" {
+					v = k.Default
+				}
+				if v == "" {
+					v = "-"
+				}
+				rows = append(rows, []string{k.Name, v, source})
+			}
+
+			path, _ := config.Path()
+			fmt.Fprintf(cmd.OutOrStdout(), "Config file: %s\n", path)
+			ui.PrintTable(cmd.OutOrStdout(), []string{"Key", "Value", "Source"}, rows)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// Command results go to stdout even with --quiet, which only silences the
+// logger.
+func TestCommandResultsOnStdout(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("USERPROFILE", t.TempDir())
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var out bytes.Buffer
+	config := newConfigSetCmd()
+	config.SetOut(&out)
+	config.SetArgs([]string{"parallel_files", "8"})
+	if err := config.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "Set parallel_files = 8 in ") {
+		t.Errorf("config set printed %q", out.String())
+	}
+
+	out.Reset()
+	clean := newCleanCmd()
+	clean.SetOut(&out)
+	clean.SetArgs([]string{t.TempDir()})
+	if err := clean.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "Cleaned 0 completed state file(s) in ") {
+		t.Errorf("clean printed %q", out.String())
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/auth"
+	"github.com/khan-lab/EGAfetch/internal/config"
+	"github.com/khan-lab/EGAfetch/internal/state"
+	"github.com/khan-lab/EGAfetch/internal/ui"
+)
+
+const (
+	// doctorTimeout bounds each network check.
+	doctorTimeout = 15 * time.Second
+	// maxClockSkew is the largest clock difference from EGA that is not
+	// reported; larger offsets can make tokens look expired or not yet valid.
+	maxClockSkew = time.Minute
+	// lowDiskSpace is reported when no download in the directory says how
+	// much space is needed.
+	lowDiskSpace = 10 << 30
+)
+
+// doctorResult is the outcome of one diagnostic check.
+type doctorResult struct {
+	status string // "OK", "WARN", "FAIL", or "SKIP"
+	detail string
+	hint   string // remediation, shown for WARN and FAIL
+}
+
+func okResult(format string, args ...interface{}) doctorResult {
+	return doctorResult{status: "OK", detail: fmt.Sprintf(format, args...)}
+}
+
+func warnResult(hint, format string, args ...interface{}) doctorResult {
+	return doctorResult{status: "WARN", detail: fmt.Sprintf(format, args...), hint: hint}
+}
+
+func failResult(hint, format string, args ...interface{}) doctorResult {
+	return doctorResult{status: "FAIL", detail: fmt.Sprintf(format, args...), hint: hint}
+}
+
+// --- Doctor command ---
+
+func newDoctorCmd() *cobra.Command {
+	var configFile string
+
+	cmd := &cobra.Command{
+		Use:   "doctor [directory]",
+		Short: "Check that this machine can download from EGA",
+		Long: `Run a series of checks and print pass/fail with suggested fixes:
+
+  - proxy settings (HTTPS_PROXY, NO_PROXY) and DNS for the EGA hosts
+  - reachability of the EGA data, metadata, and login servers
+  - clock skew against EGA
+  - the saved login, and whether EGA accepts it
+  - write permission and free space in the download directory
+    (default: the configured output_dir, or the current directory)
+  - the open-file limit against the configured parallelism
+
+Run it on the machine (or cluster node) that will do the download. Exits
+non-zero if any check fails.`,
+		Example: `  egafetch doctor
+  egafetch doctor /scratch/ega --cf credentials.json`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, err := defaultDir(args)
+			if err != nil {
+				return err
+			}
+			cfg, err := config.Load()
+			if err != nil {
+				return fmt.Errorf("load config: %w", err)
+			}
+
+			ctx, cancel := signalContext()
+			defer cancel()
+
+			out := cmd.OutOrStdout()
+			var failed int
+			report := func(name string, r doctorResult) {
+				fmt.Fprintf(out, "  %-4s  %-18s %s\n", r.status, name, r.detail)
+				if r.hint != "" {
+					fmt.Fprintf(out, "        %-18s -> %s\n", "", r.hint)
+				}
+				if r.status == "FAIL" {
+					failed++
+				}
+			}
+
+			urls := append(append([]string{}, api.BaseURLs...), auth.TokenEndpoints...)
+			proxied := false
+			for _, u := range urls {
+				if p := proxyFor(u); p != "" {
+					proxied = true
+				}
+			}
+
+			report("Proxy", checkProxy(urls))
+			unresolved := make(map[string]bool)
+			for _, host := range uniqueHosts(urls) {
+				r := checkDNS(ctx, host, proxied)
+				report("DNS", r)
+				unresolved[host] = r.status == "FAIL"
+			}
+			var serverDate time.Time
+			connected := make(map[string]bool)
+			for _, u := range urls {
+				parsed, err := url.Parse(u)
+				if err != nil || connected[parsed.Host] || unresolved[parsed.Hostname()] {
+					continue
+				}
+				connected[parsed.Host] = true
+				r, date := checkReachable(ctx, u)
+				report("Connect", r)
+				if serverDate.IsZero() {
+					serverDate = date
+				}
+			}
+			report("Clock", checkClock(serverDate))
+			report("Login", checkLogin(ctx, configFile))
+			report("Directory", checkWritable(dir))
+			report("Disk space", checkDiskSpace(dir))
+			report("Open files", checkOpenFiles(cfg))
+
+			if failed > 0 {
+				return fmt.Errorf("%d check(s) failed", failed)
+			}
+			fmt.Fprintln(out)
+			fmt.Fprintln(out, "No problems found.")
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&configFile, "cf", "", "JSON config file with credentials")
+	cmd.Flags().StringVar(&configFile, "config-file", "", "JSON config file with credentials (alias for --cf)")
+
+	return cmd
+}
+
+// proxyFor returns the proxy URL, with credentials redacted, that requests
+// to rawURL go through according to the environment, or "" for none.
+func proxyFor(rawURL string) string {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return ""
+	}
+	p, err := http.ProxyFromEnvironment(req)
+	if err != nil || p == nil {
+		return ""
+	}
+	return p.Redacted()
+}
+
+// uniqueHosts returns the host names of urls, without ports, in order.
+func uniqueHosts(urls []string) []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || seen[u.Hostname()] {
+			continue
+		}
+		seen[u.Hostname()] = true
+		hosts = append(hosts, u.Hostname())
+	}
+	return hosts
+}
+
+func checkProxy(urls []string) doctorResult {
+	var via []string
+	for _, u := range urls {
+		if p := proxyFor(u); p != "" && !slices.Contains(via, p) {
+			via = append(via, p)
+		}
+	}
+	if len(via) == 0 {
+		return okResult("none (direct connection)")
+	}
+	return okResult("via %s", strings.Join(via, ", "))
+}
+
+func checkDNS(ctx context.Context, host string, proxied bool) doctorResult {
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err == nil {
+		return okResult("%s -> %s", host, addrs[0])
+	}
+	if proxied {
+		// The proxy resolves names itself, so this only matters without it.
+		return warnResult("fine if the proxy handles name resolution", "%s does not resolve locally: %v", host, err)
+	}
+	return failResult("check /etc/resolv.conf; compute nodes often have no external DNS, so set HTTPS_PROXY or run from a login/transfer node",
+		"%s: %v", host, err)
+}
+
+// checkReachable connects to rawURL and returns the server's Date header.
+// Any HTTP response counts as reachable; only the connection is tested.
+func checkReachable(ctx context.Context, rawURL string) (doctorResult, time.Time) {
+	u, _ := url.Parse(rawURL)
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return failResult("", "%s: %v", u.Host, err), time.Time{}
+	}
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		hint := "outbound HTTPS to " + u.Host + " may be blocked by a firewall; ask your admins, or set HTTPS_PROXY"
+		if p := proxyFor(rawURL); p != "" {
+			hint = "check that the proxy " + p + " is running and allows " + u.Host + ", or fix HTTPS_PROXY/NO_PROXY"
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			hint = "the connection timed out; " + hint
+		}
+		return failResult(hint, "%s: %v", u.Host, err), time.Time{}
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	date, _ := http.ParseTime(resp.Header.Get("Date"))
+	return okResult("%s (%s)", u.Host, time.Since(start).Round(time.Millisecond)), date
+}
+
+func checkClock(serverDate time.Time) doctorResult {
+	if serverDate.IsZero() {
+		return doctorResult{status: "SKIP", detail: "no server time available"}
+	}
+	skew := time.Since(serverDate).Round(time.Second)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > maxClockSkew {
+		return warnResult("sync the system clock (e.g. enable NTP with 'timedatectl set-ntp true')",
+			"local clock differs from EGA by %s", skew)
+	}
+	return okResult("within %s of EGA", maxClockSkew)
+}
+
+func checkLogin(ctx context.Context, configFile string) doctorResult {
+	mgr, err := auth.NewManager()
+	if err != nil {
+		return failResult("remove ~/.egafetch/credentials.json and log in again", "%v", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	if err := ensureAuth(ctx, mgr, configFile); err != nil {
+		return failResult("check the username and password in "+configFile, "%v", err)
+	}
+	if mgr.Status() == nil {
+		return failResult("run 'egafetch auth login', or pass --cf", "not logged in")
+	}
+	datasets, err := api.NewClient(mgr).ListDatasets(ctx)
+	if err != nil {
+		return failResult("run 'egafetch auth login' again", "%s: %v", mgr.Username(), err)
+	}
+	return okResult("%s (%d authorized dataset(s))", mgr.Username(), len(datasets))
+}
+
+func checkWritable(dir string) doctorResult {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return failResult("choose a directory you can write to with -o or 'egafetch config set output_dir'", "%v", err)
+	}
+	f, err := os.CreateTemp(dir, ".egafetch-doctor-")
+	if err != nil {
+		return failResult("choose a directory you can write to with -o or 'egafetch config set output_dir'", "%v", err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return okResult("%s is writable", dir)
+}
+
+func checkDiskSpace(dir string) doctorResult {
+	free, err := freeSpace(dir)
+	if err != nil {
+		return doctorResult{status: "SKIP", detail: err.Error()}
+	}
+
+	// Compare against what an existing download in dir still needs.
+	sm := state.NewStateManager(dir)
+	if manifest, err := sm.LoadManifest(); err == nil && manifest != nil {
+		need := estimateDownload(manifest, sm).Remaining
+		if uint64(need) > free {
+			return failResult("free up space or download to another filesystem",
+				"%s free, %s still to download", ui.FormatBytes(int64(free)), ui.FormatBytes(need))
+		}
+		return okResult("%s free, %s still to download", ui.FormatBytes(int64(free)), ui.FormatBytes(need))
+	}
+	if free < lowDiskSpace {
+		return warnResult("check with 'egafetch size' how much the download needs", "only %s free", ui.FormatBytes(int64(free)))
+	}
+	return okResult("%s free", ui.FormatBytes(int64(free)))
+}
+
+func checkOpenFiles(cfg *config.Config) doctorResult {
+	limit, supported := openFileLimit()
+	if !supported {
+		return doctorResult{status: "SKIP", detail: "not applicable on this platform"}
+	}
+	files, chunks := cfg.ParallelFiles, cfg.ParallelChunks
+	if files == 0 {
+		files = 4
+	}
+	if chunks == 0 {
+		chunks = 8
+	}
+	// Each chunk holds a connection and a chunk file; leave room for the rest.
+	need := uint64(files*chunks*2 + 64)
+	if limit < need {
+		return warnResult(fmt.Sprintf("raise it with 'ulimit -n %d', or lower --parallel-files/--parallel-chunks", need),
+			"limit %d, %d files x %d chunks needs about %d", limit, files, chunks, need)
+	}
+	return okResult("limit %d (about %d needed)", limit, need)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUniqueHosts(t *testing.T) {
+	got := uniqueHosts([]string{
+		"https://ega.ebi.ac.uk:8443/v2",
+		"https://metadata.ega-archive.org",
+		"https://ega.ebi.ac.uk:8443/ega-openid-connect-server/token",
+		"https://idp.ega-archive.org/realms/EGA/protocol/openid-connect/token",
+	})
+	want := []string{"ega.ebi.ac.uk", "metadata.ega-archive.org", "idp.ega-archive.org"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCheckClock(t *testing.T) {
+	tests := []struct {
+		name   string
+		server time.Time
+		want   string
+	}{
+		{name: "no date", want: "SKIP"},
+		{name: "in sync", server: time.Now().Add(-5 * time.Second), want: "OK"},
+		{name: "local clock behind", server: time.Now().Add(10 * time.Minute), want: "WARN"},
+		{name: "local clock ahead", server: time.Now().Add(-2 * time.Minute), want: "WARN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkClock(tt.server); got.status != tt.want {
+				t.Errorf("status = %s (%s), want %s", got.status, got.detail, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+// drsObject is a file in the manifest written by download --drs-manifest,
+// shaped like a GA4GH DRS v1 DrsObject so that workflow engines that read
+// DRS manifests can use files fetched by egafetch.
+type drsObject struct {
+	ID            string            `json:"id"`
+	Name          string            `json:"name"`
+	SelfURI       string            `json:"self_uri"`
+	Size          int64             `json:"size"`
+	Checksums     []drsChecksum     `json:"checksums"`
+	AccessMethods []drsAccessMethod `json:"access_methods"`
+	Aliases       []string          `json:"aliases,omitempty"`
+	DatasetID     string            `json:"dataset_id,omitempty"`
+}
+
+type drsChecksum struct {
+	Checksum string `json:"checksum"`
+	Type     string `json:"type"`
+}
+
+type drsAccessMethod struct {
+	Type      string       `json:"type"`
+	AccessURL drsAccessURL `json:"access_url"`
+}
+
+type drsAccessURL struct {
+	URL string `json:"url"`
+}
+
+// drsChecksumTypes maps EGA checksum types to the IANA hash names DRS uses.
+var drsChecksumTypes = map[string]string{"md5": "md5", "sha256": "sha-256"}
+
+// drsManifest collects the resolved files of every output directory of a
+// download and writes them to path as a JSON array of DRS objects.
+type drsManifest struct {
+	path    string
+	mu      sync.Mutex
+	objects map[string]drsObject
+}
+
+func newDRSManifest(path string) *drsManifest {
+	return &drsManifest{path: path, objects: make(map[string]drsObject)}
+}
+
+// add adds the files of manifest and rewrites the manifest file, so that it
+// lists every file resolved so far before any is downloaded. localDir is the
+// directory the files are downloaded into, or "" if they do not stay there.
+func (m *drsManifest) add(manifest *state.Manifest, localDir string) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range manifest.Files {
+		m.objects[f.FileID] = newDRSObject(f, manifest.DatasetID, localDir)
+	}
+	objects := make([]drsObject, 0, len(m.objects))
+	for _, o := range m.objects {
+		objects = append(objects, o)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].ID < objects[j].ID })
+
+	data, err := json.MarshalIndent(objects, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(m.path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("write DRS manifest: %w", err)
+	}
+	return nil
+}
+
+// newDRSObject describes f, with an https access method for the EGA
+// download API (which needs an EGA access token) and, if localDir is set, a
+// file access method for the downloaded copy.
+func newDRSObject(f state.FileSpec, datasetID, localDir string) drsObject {
+	fileURL := api.FileURL(f.FileID)
+	host := ""
+	if u, err := url.Parse(fileURL); err == nil {
+		host = u.Host
+	}
+	name := path.Base(filepath.ToSlash(f.FileName))
+	var aliases []string
+	if f.OriginalName != "" {
+		aliases = []string{name}
+		name = path.Base(f.OriginalName)
+	}
+
+	o := drsObject{
+		ID:            f.FileID,
+		Name:          name,
+		SelfURI:       "drs://" + host + "/" + f.FileID,
+		Size:          f.Size,
+		Checksums:     []drsChecksum{},
+		AccessMethods: []drsAccessMethod{{Type: "https", AccessURL: drsAccessURL{URL: fileURL}}},
+		Aliases:       aliases,
+		DatasetID:     datasetID,
+	}
+	if t, ok := drsChecksumTypes[f.ChecksumType]; ok && f.Checksum != "" {
+		o.Checksums = append(o.Checksums, drsChecksum{Checksum: f.Checksum, Type: t})
+	}
+	if localDir != "" {
+		if abs, err := filepath.Abs(filepath.Join(localDir, f.FileName)); err == nil {
+			p := filepath.ToSlash(abs)
+			if !strings.HasPrefix(p, "/") {
+				p = "/" + p // a Windows drive letter
+			}
+			u := url.URL{Scheme: "file", Path: p}
+			o.AccessMethods = append(o.AccessMethods, drsAccessMethod{Type: "file", AccessURL: drsAccessURL{URL: u.String()}})
+		}
+	}
+	return o
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+func TestDRSManifest(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "drs.json")
+	m := newDRSManifest(path)
+	if err := m.add(&state.Manifest{DatasetID: "EGAD1", Files: []state.FileSpec{
+		{FileID: "EGAF2", FileName: filepath.Join("EGAF2", "b_.bam"), OriginalName: "b:.bam", Size: 5, Checksum: "aa", ChecksumType: "sha256"},
+	}}, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.add(&state.Manifest{Files: []state.FileSpec{
+		{FileID: "EGAF1", FileName: filepath.Join("EGAF1", "a.vcf.gz"), Size: 3},
+	}}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var objects []drsObject
+	if err := json.Unmarshal(data, &objects); err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[0].ID != "EGAF1" || objects[1].ID != "EGAF2" {
+		t.Fatalf("manifest = %s, want EGAF1 and EGAF2 in order", data)
+	}
+
+	a, b := objects[0], objects[1]
+	if a.Name != "a.vcf.gz" || len(a.Checksums) != 0 || len(a.AccessMethods) != 1 || a.AccessMethods[0].Type != "https" {
+		t.Errorf("EGAF1 = %+v, want no checksum and only the https access method", a)
+	}
+	if !strings.HasPrefix(a.SelfURI, "drs://") || !strings.HasSuffix(a.SelfURI, "/EGAF1") {
+		t.Errorf("EGAF1 self_uri = %q", a.SelfURI)
+	}
+	if b.Name != "b:.bam" || len(b.Aliases) != 1 || b.Aliases[0] != "b_.bam" || b.DatasetID != "EGAD1" {
+		t.Errorf("EGAF2 = %+v, want the EGA name with the local name as alias", b)
+	}
+	if len(b.Checksums) != 1 || b.Checksums[0] != (drsChecksum{Checksum: "aa", Type: "sha-256"}) {
+		t.Errorf("EGAF2 checksums = %+v", b.Checksums)
+	}
+	if len(b.AccessMethods) != 2 || b.AccessMethods[1].Type != "file" || !strings.HasSuffix(b.AccessMethods[1].AccessURL.URL, "/EGAF2/b_.bam") {
+		t.Errorf("EGAF2 access methods = %+v, want https and the local file", b.AccessMethods)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// envPrefix starts the environment variable of every flag.
+const envPrefix = "EGAFETCH_"
+
+// envFlags records the flags set from the environment, by flag name, with
+// the variable that set them.
+var envFlags = map[string]string{}
+
+// flagEnvNames returns the environment variables for flag name of cmd, most
+// specific first: EGAFETCH_<COMMAND>_<FLAG> (e.g. EGAFETCH_DOWNLOAD_OUTPUT),
+// then EGAFETCH_<FLAG> (e.g. EGAFETCH_OUTPUT).
+func flagEnvNames(cmd *cobra.Command, name string) []string {
+	flag := strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	var names []string
+	if path := strings.Fields(cmd.CommandPath()); len(path) > 1 {
+		scope := strings.ToUpper(strings.Join(path[1:], "_"))
+		names = append(names, envPrefix+strings.ReplaceAll(scope, "-", "_")+"_"+flag)
+	}
+	return append(names, envPrefix+flag)
+}
+
+// applyEnvFlags sets every flag of cmd not given on the command line from
+// its environment variable, if set, as if it had been given. It runs first
+// in the root command's PersistentPreRunE, so config.yaml only fills flags
+// that neither set.
+func applyEnvFlags(cmd *cobra.Command) error {
+	var firstErr error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if f.Changed || f.Name == "help" || f.Name == "version" || firstErr != nil {
+			return
+		}
+		for _, env := range flagEnvNames(cmd, f.Name) {
+			v, ok := os.LookupEnv(env)
+			if !ok || v == "" {
+				continue
+			}
+			if err := cmd.Flags().Set(f.Name, v); err != nil {
+				firstErr = fmt.Errorf("invalid %s: %w", env, err)
+				return
+			}
+			envFlags[f.Name] = env
+			return
+		}
+	})
+	return firstErr
+}
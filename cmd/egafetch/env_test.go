@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestApplyEnvFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{name: "unset", want: "out=. files=4 restart=false format=[]"},
+		{name: "general", env: map[string]string{"EGAFETCH_OUTPUT": "/scratch", "EGAFETCH_PARALLEL_FILES": "8", "EGAFETCH_RESTART": "1"},
+			want: "out=/scratch files=8 restart=true format=[]"},
+		{name: "command wins", env: map[string]string{"EGAFETCH_FORMAT": "tsv", "EGAFETCH_DOWNLOAD_FORMAT": "BAM,CRAM"},
+			want: "out=. files=4 restart=false format=[BAM CRAM]"},
+		{name: "flag wins", env: map[string]string{"EGAFETCH_OUTPUT": "/scratch"}, args: []string{"-o", "data"},
+			want: "out=data files=4 restart=false format=[]"},
+		{name: "invalid", env: map[string]string{"EGAFETCH_PARALLEL_FILES": "many"},
+			wantErr: "invalid EGAFETCH_PARALLEL_FILES"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			var (
+				output  string
+				files   int
+				restart bool
+				formats []string
+				got     string
+			)
+			root := &cobra.Command{Use: "egafetch"}
+			cmd := &cobra.Command{
+				Use: "download",
+				RunE: func(cmd *cobra.Command, args []string) error {
+					if err := applyEnvFlags(cmd); err != nil {
+						return err
+					}
+					got = fmt.Sprintf("out=%s files=%d restart=%v format=%v", output, files, restart, formats)
+					return nil
+				},
+			}
+			cmd.Flags().StringVarP(&output, "output", "o", ".", "")
+			cmd.Flags().IntVar(&files, "parallel-files", 4, "")
+			cmd.Flags().BoolVar(&restart, "restart", false, "")
+			cmd.Flags().StringSliceVar(&formats, "format", nil, "")
+			root.AddCommand(cmd)
+			root.SetArgs(append([]string{"download"}, tt.args...))
+
+			err := root.Execute()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlagEnvNames(t *testing.T) {
+	root := &cobra.Command{Use: "egafetch"}
+	auth := &cobra.Command{Use: "auth"}
+	login := &cobra.Command{Use: "login"}
+	root.AddCommand(auth)
+	auth.AddCommand(login)
+
+	if got, want := flagEnvNames(login, "config-file"), []string{"EGAFETCH_AUTH_LOGIN_CONFIG_FILE", "EGAFETCH_CONFIG_FILE"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("flagEnvNames(login) = %v, want %v", got, want)
+	}
+	if got, want := flagEnvNames(root, "quiet"), []string{"EGAFETCH_QUIET"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("flagEnvNames(root) = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/auth"
+	"github.com/khan-lab/EGAfetch/internal/verify"
+)
+
+// Exit codes. These are part of the CLI contract (see docs/commands/exit-codes.md)
+// so that job scripts can branch on the failure class; do not renumber them.
+const (
+	exitOK           = 0
+	exitFailure      = 1   // any error not covered below
+	exitAuth         = 3   // not logged in, bad credentials, or rejected token (401)
+	exitForbidden    = 4   // no access to the dataset or file (403)
+	exitNetwork      = 5   // network failure, or EGA unavailable (5xx, 429)
+	exitChecksum     = 6   // a file failed checksum verification
+	exitPartial      = 7   // some files downloaded, others failed
+	exitCheckpointed = 8   // stopped by SIGTERM/SIGUSR1 from a scheduler, with state saved
+	exitInterrupted  = 130 // stopped by SIGINT or 'egafetch cancel'
+)
+
+// interrupted is set by signalContext when a signal cancels the command.
+var interrupted atomic.Bool
+
+// checkpointed is set with interrupted when the signal was a checkpoint
+// signal (see checkpointSignals).
+var checkpointed atomic.Bool
+
+// exitError attaches an explicit exit code to an error.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// withExitCode wraps err so that the process exits with code.
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// exitCode maps an error returned by a command to the process exit code.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	if checkpointed.Load() {
+		return exitCheckpointed
+	}
+	if interrupted.Load() {
+		return exitInterrupted
+	}
+
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	return classifyError(err)
+}
+
+// classifyError determines the failure class of err from the errors it wraps.
+func classifyError(err error) int {
+	var apiErr *api.APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.StatusCode == 401:
+			return exitAuth
+		case apiErr.StatusCode == 403:
+			return exitForbidden
+		case apiErr.IsRetryable():
+			return exitNetwork
+		}
+		return exitFailure
+	}
+
+	var authErr *auth.AuthError
+	if errors.As(err, &authErr) {
+		if authErr.StatusCode >= 500 {
+			return exitNetwork
+		}
+		return exitAuth
+	}
+	if errors.Is(err, auth.ErrNotAuthenticated) || errors.Is(err, errMetadataPasswordRequired) {
+		return exitAuth
+	}
+
+	if errors.Is(err, verify.ErrChecksumMismatch) {
+		return exitChecksum
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded) {
+		return exitNetwork
+	}
+
+	return exitFailure
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/auth"
+	"github.com/khan-lab/EGAfetch/internal/verify"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"unauthorized", &api.APIError{StatusCode: 401}, exitAuth},
+		{"forbidden", &api.APIError{StatusCode: 403}, exitForbidden},
+		{"rate limited", &api.APIError{StatusCode: 429}, exitNetwork},
+		{"server error", &api.APIError{StatusCode: 503}, exitNetwork},
+		{"not found", &api.APIError{StatusCode: 404}, exitFailure},
+		{"wrapped API error", fmt.Errorf("list files: %w", &api.APIError{StatusCode: 403}), exitForbidden},
+		{"bad credentials", &auth.AuthError{StatusCode: 401}, exitAuth},
+		{"auth server down", &auth.AuthError{StatusCode: 502}, exitNetwork},
+		{"not logged in", fmt.Errorf("download: %w", auth.ErrNotAuthenticated), exitAuth},
+		{"metadata password", errMetadataPasswordRequired, exitAuth},
+		{"checksum mismatch", fmt.Errorf("a.bam: %w", verify.ErrChecksumMismatch), exitChecksum},
+		{"network error", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, exitNetwork},
+		{"truncated body", io.ErrUnexpectedEOF, exitNetwork},
+		{"timeout", context.DeadlineExceeded, exitNetwork},
+		{"other", errors.New("boom"), exitFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyError(tt.err); got != tt.want {
+				t.Errorf("classifyError(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExitCodeExplicit(t *testing.T) {
+	err := withExitCode(exitPartial, fmt.Errorf("2 of 3 files failed: %w", &api.APIError{StatusCode: 403}))
+	if got := exitCode(err); got != exitPartial {
+		t.Errorf("exitCode = %d, want %d", got, exitPartial)
+	}
+	if got := exitCode(nil); got != exitOK {
+		t.Errorf("exitCode(nil) = %d, want %d", got, exitOK)
+	}
+}
+
+func TestExitCodeInterrupted(t *testing.T) {
+	defer interrupted.Store(false)
+	defer checkpointed.Store(false)
+	err := fmt.Errorf("download: %w", context.Canceled)
+
+	interrupted.Store(true)
+	if got := exitCode(err); got != exitInterrupted {
+		t.Errorf("exitCode after SIGINT = %d, want %d", got, exitInterrupted)
+	}
+	checkpointed.Store(true)
+	if got := exitCode(err); got != exitCheckpointed {
+		t.Errorf("exitCode after a checkpoint signal = %d, want %d", got, exitCheckpointed)
+	}
+}
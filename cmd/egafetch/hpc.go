@@ -0,0 +1,458 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/auth"
+	"github.com/khan-lab/EGAfetch/internal/download"
+	"github.com/khan-lab/EGAfetch/internal/state"
+	"github.com/khan-lab/EGAfetch/internal/ui"
+)
+
+// --- HPC commands ---
+
+func newHPCCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "hpc",
+		Short: "Generate cluster jobs that download in parallel",
+	}
+
+	cmd.AddCommand(newHPCSlurmCmd())
+	return cmd
+}
+
+// slurmJob describes the sbatch array script written by 'hpc slurm'.
+type slurmJob struct {
+	Name           string
+	Shards         int
+	ShardDir       string // absolute; holds shard-NNN.txt
+	LogDir         string // absolute
+	Output         string // absolute; each task downloads into shard-NNN below it, unless Shared
+	Shared         bool   // all tasks download into Output with 'download --shared'
+	Layout         state.Layout
+	Egafetch       string // egafetch binary run by each task
+	ConfigFile     string // --cf passed to each task, if any
+	Time           string
+	CPUs           int
+	Mem            string
+	Partition      string
+	Account        string
+	MaxRunning     int // array tasks running at once (0 = no limit)
+	ParallelFiles  int
+	ParallelChunks int
+}
+
+func newHPCSlurmCmd() *cobra.Command {
+	var (
+		output          string
+		dir             string
+		shards          int
+		configFile      string
+		includePatterns []string
+		excludePatterns []string
+		formats         []string
+		withIndexes     bool
+		attributes      attributeFilter
+		fromFiles       []string
+		excludeIDs      []string
+		excludeFiles    []string
+		walltime        string
+		throughput      string
+		cpus            int
+		mem             string
+		partition       string
+		account         string
+		maxRunning      int
+		shared          bool
+		preservePaths   bool
+		flatten         bool
+		parallelFiles   int
+		parallelChunks  int
+	)
+
+	cmd := &cobra.Command{
+		Use:               "slurm [EGAD.../EGAF.../file.txt/-]",
+		ValidArgsFunction: completeAccessions(false, true),
+		Short:             "Write a SLURM job array that downloads a manifest in shards",
+		Long: `Resolve datasets and files exactly as 'download' would, split the files
+into --shards lists of similar total size, and write an sbatch script whose
+array tasks each download one list.
+
+Each task downloads into its own directory, {output}/shard-NNN, so tasks on
+different nodes never share download state. With --shared, all tasks
+download into {output} itself as one 'download --shared'. Submitting the
+script again resumes every unfinished shard.
+
+The time limit of each task is estimated from the size of the largest shard
+and --throughput, with headroom for verification and retries; set --time to
+use your own.`,
+		Example: `  egafetch hpc slurm EGAD00001001938 --shards 20 -o /scratch/ega
+  sbatch egafetch-slurm/egafetch.sbatch
+
+  egafetch hpc slurm EGAD00001001938 --shards 50 --max-running 10 --partition transfer --format BAM`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(fromFiles) > 0 {
+				return nil
+			}
+			return cobra.MinimumNArgs(1)(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := applyConfig(cmd, map[string]string{
+				"output":          "output_dir",
+				"parallel-files":  "parallel_files",
+				"parallel-chunks": "parallel_chunks",
+			}); err != nil {
+				return err
+			}
+			if shards < 1 {
+				return fmt.Errorf("--shards must be at least 1")
+			}
+			if maxRunning < 0 {
+				return fmt.Errorf("--max-running must not be negative")
+			}
+			speed, err := parseSize(throughput)
+			if err != nil {
+				return fmt.Errorf("invalid throughput: %w", err)
+			}
+			if _, isRemote, _ := rcloneRemote(output); isRemote {
+				return fmt.Errorf("--output must be a directory on a filesystem the compute nodes share")
+			}
+			layout, err := pathLayout(preservePaths, flatten)
+			if err != nil {
+				return err
+			}
+			// Each task names only its own shard's files apart, so shards
+			// sharing one directory must keep a directory per accession.
+			if shared && layout != state.LayoutByFile {
+				return fmt.Errorf("--preserve-paths and --flatten cannot be combined with --shared")
+			}
+
+			args, err = expandArgs(append(args, fromFiles...))
+			if err != nil {
+				return err
+			}
+			if len(args) == 0 {
+				return fmt.Errorf("no identifiers given")
+			}
+			for _, arg := range args {
+				if strings.Contains(arg, "=") {
+					return fmt.Errorf("%s: ID=DIR output directories are not supported; shards are written under --output", arg)
+				}
+			}
+			excluded, err := readExcludedIDs(excludeIDs, excludeFiles)
+			if err != nil {
+				return err
+			}
+
+			mgr, err := auth.NewManager()
+			if err != nil {
+				return err
+			}
+			ctx, cancel := signalContext()
+			defer cancel()
+			if err := ensureAuth(ctx, mgr, configFile); err != nil {
+				return err
+			}
+
+			apiClient := api.NewClient(mgr)
+			naming := outputNaming{layout: layout, generated: download.GeneratedNames(download.DownloadOptions{}, nil)}
+			manifest, err := resolveManifest(ctx, apiClient, args, naming)
+			if err != nil {
+				return err
+			}
+			matchedExclusions := make(map[string]bool)
+			if err := excludeFileIDs(manifest, excluded, matchedExclusions); err != nil {
+				return err
+			}
+			warnUnmatchedExclusions(excluded, matchedExclusions)
+			metas, err := attributes.load(ctx, apiClient, output, args, false, configFileMetadataToken(ctx, mgr, configFile))
+			if err != nil {
+				return err
+			}
+			if err := attributes.apply(manifest, args, metas); err != nil {
+				return err
+			}
+			if err := applyFilters(ctx, manifest, args, includePatterns, excludePatterns, formats, withIndexes); err != nil {
+				return err
+			}
+			if len(manifest.Files) == 0 {
+				return fmt.Errorf("no files to download")
+			}
+
+			parts := shardFiles(manifest.Files, shards)
+			var largest int64
+			for _, part := range parts {
+				largest = max(largest, totalSize(part))
+			}
+			if walltime == "" {
+				walltime = slurmTime(slurmWalltime(largest, speed))
+			}
+			if cpus == 0 {
+				cpus = parallelFiles
+			}
+
+			job := slurmJob{
+				Name:           "egafetch",
+				Shards:         len(parts),
+				Time:           walltime,
+				CPUs:           cpus,
+				Mem:            mem,
+				Partition:      partition,
+				Account:        account,
+				MaxRunning:     maxRunning,
+				ParallelFiles:  parallelFiles,
+				ParallelChunks: parallelChunks,
+				Shared:         shared,
+				Layout:         layout,
+			}
+			if manifest.DatasetID != "" {
+				job.Name += "-" + manifest.DatasetID
+			}
+			for _, p := range []struct {
+				dst *string
+				src string
+			}{
+				{&job.ShardDir, filepath.Join(dir, "shards")},
+				{&job.LogDir, filepath.Join(dir, "logs")},
+				{&job.Output, output},
+				{&job.ConfigFile, configFile},
+			} {
+				if p.src == "" {
+					continue
+				}
+				abs, err := filepath.Abs(p.src)
+				if err != nil {
+					return err
+				}
+				*p.dst = abs
+			}
+			// Tasks run on other nodes, which find this binary on the
+			// shared filesystem if it is there, or on their PATH otherwise.
+			job.Egafetch = "egafetch"
+			if exe, err := os.Executable(); err == nil {
+				job.Egafetch = exe
+			}
+
+			script, err := writeSlurmJob(job, parts, dir)
+			if err != nil {
+				return err
+			}
+
+			w := cmd.OutOrStdout()
+			fmt.Fprintf(w, "Wrote %d shard(s) of %d file(s), %s, to %s\n",
+				len(parts), len(manifest.Files), ui.FormatBytes(totalSize(manifest.Files)), job.ShardDir)
+			fmt.Fprintf(w, "Largest shard: %s; time limit %s per task\n", ui.FormatBytes(largest), walltime)
+			fmt.Fprintf(w, "Submit with:   sbatch %s\n", script)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", ".", "Download directory on a shared filesystem; each task writes to shard-NNN below it")
+	cmd.Flags().StringVarP(&dir, "dir", "d", "egafetch-slurm", "Directory to write the sbatch script, shard lists, and task logs to")
+	cmd.Flags().IntVar(&shards, "shards", 10, "Number of array tasks to split the files across")
+	cmd.Flags().StringVar(&configFile, "cf", "", "JSON config file with credentials, also passed to each task")
+	cmd.Flags().StringVar(&configFile, "config-file", "", "JSON config file with credentials (alias for --cf)")
+	cmd.Flags().StringSliceVar(&includePatterns, "include", nil, "Glob patterns to include (matched against file name)")
+	cmd.Flags().StringSliceVar(&excludePatterns, "exclude", nil, "Glob patterns to exclude (matched against file name)")
+	cmd.Flags().StringSliceVar(&formats, "format", nil, "File formats to include, e.g. BAM,CRAM,VCF (matched against the file extension)")
+	cmd.Flags().BoolVar(&withIndexes, "with-indexes", false, "Also include index files (.bai, .crai, .tbi, .csi) of the selected files")
+	addAttributeFilterFlags(cmd, &attributes)
+	cmd.Flags().StringSliceVar(&excludeIDs, "exclude-id", nil, "File IDs (EGAF...) to skip (repeatable)")
+	cmd.Flags().StringArrayVar(&excludeFiles, "exclude-file", nil, "Text file of file IDs to skip, one per line (repeatable)")
+	cmd.Flags().StringArrayVar(&fromFiles, "from-file", nil, "Read identifiers from a text file, one per line (\"-\" for stdin; repeatable)")
+	cmd.Flags().StringVar(&walltime, "time", "", "Time limit of each task, in SLURM format (default: estimated from --throughput)")
+	cmd.Flags().StringVar(&throughput, "throughput", "50M", "Expected download speed per task in bytes/s, used to estimate --time")
+	cmd.Flags().IntVar(&cpus, "cpus", 0, "CPUs per task (default: --parallel-files, one per file being verified)")
+	cmd.Flags().StringVar(&mem, "mem", "4G", "Memory per task, in SLURM format")
+	cmd.Flags().StringVar(&partition, "partition", "", "SLURM partition to submit to")
+	cmd.Flags().StringVar(&account, "account", "", "SLURM account to charge")
+	cmd.Flags().IntVar(&maxRunning, "max-running", 0, "Array tasks running at once (0 = no limit)")
+	cmd.Flags().BoolVar(&shared, "shared", false, "Download every shard into --output itself, with 'download --shared'")
+	cmd.Flags().BoolVar(&preservePaths, "preserve-paths", false, "Keep the directories of EGA file names in each task's output directory")
+	cmd.Flags().BoolVar(&flatten, "flatten", false, "Put every file directly in each task's output directory")
+	cmd.Flags().IntVar(&parallelFiles, "parallel-files", 4, "Files each task downloads in parallel")
+	cmd.Flags().IntVar(&parallelChunks, "parallel-chunks", 8, "Chunks per file each task downloads in parallel")
+
+	return cmd
+}
+
+// shardFiles splits files into at most n lists of similar total size: each
+// file, largest first, goes to the list with the fewest bytes so far. Files
+// keep their manifest order within a list.
+func shardFiles(files []state.FileSpec, n int) [][]state.FileSpec {
+	n = min(n, len(files))
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return files[order[a]].Size > files[order[b]].Size })
+
+	assigned := make([][]int, n)
+	loads := make([]int64, n)
+	for _, i := range order {
+		smallest := 0
+		for s := 1; s < n; s++ {
+			if loads[s] < loads[smallest] {
+				smallest = s
+			}
+		}
+		assigned[smallest] = append(assigned[smallest], i)
+		loads[smallest] += files[i].Size
+	}
+
+	shards := make([][]state.FileSpec, n)
+	for s, idx := range assigned {
+		sort.Ints(idx)
+		for _, i := range idx {
+			shards[s] = append(shards[s], files[i])
+		}
+	}
+	return shards
+}
+
+// totalSize returns the combined size of files.
+func totalSize(files []state.FileSpec) int64 {
+	var n int64
+	for _, f := range files {
+		n += f.Size
+	}
+	return n
+}
+
+// slurmWalltime estimates how long a task downloading size bytes at rate
+// bytes/s needs: half as long again for verification and retries, plus 30
+// minutes, rounded up to 15 minutes.
+func slurmWalltime(size, rate int64) time.Duration {
+	const step = 15 * time.Minute
+	d := time.Duration(float64(size)/float64(rate)*1.5*float64(time.Second)) + 30*time.Minute
+	if r := d % step; r != 0 {
+		d += step - r
+	}
+	return d
+}
+
+// slurmTime formats d as a SLURM time limit, D-HH:MM:SS or HH:MM:SS.
+func slurmTime(d time.Duration) string {
+	secs := int64(d.Round(time.Second) / time.Second)
+	days, secs := secs/86400, secs%86400
+	hms := fmt.Sprintf("%02d:%02d:%02d", secs/3600, secs/60%60, secs%60)
+	if days > 0 {
+		return fmt.Sprintf("%d-%s", days, hms)
+	}
+	return hms
+}
+
+// shardName returns the name of the shard list and output directory of
+// array task i (1-based).
+func shardName(i int) string {
+	return fmt.Sprintf("shard-%03d", i)
+}
+
+// writeSlurmJob writes the shard lists, the log directory, and the sbatch
+// script of job to dir, replacing lists left by an earlier run, and returns
+// the script's path.
+func writeSlurmJob(job slurmJob, shards [][]state.FileSpec, dir string) (string, error) {
+	for _, d := range []string{job.ShardDir, job.LogDir} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return "", fmt.Errorf("create %s: %w", d, err)
+		}
+	}
+	stale, _ := filepath.Glob(filepath.Join(job.ShardDir, "shard-*.txt"))
+	for _, path := range stale {
+		if err := os.Remove(path); err != nil {
+			return "", fmt.Errorf("remove old shard list: %w", err)
+		}
+	}
+
+	for i, files := range shards {
+		var b strings.Builder
+		fmt.Fprintf(&b, "# Shard %d of %d: %d file(s), %s\n", i+1, len(shards), len(files), ui.FormatBytes(totalSize(files)))
+		for _, f := range files {
+			fmt.Fprintf(&b, "%s # %s (%s)\n", f.FileID, filepath.ToSlash(f.FileName), ui.FormatBytes(f.Size))
+		}
+		path := filepath.Join(job.ShardDir, shardName(i+1)+".txt")
+		if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+			return "", fmt.Errorf("write shard list: %w", err)
+		}
+	}
+
+	path := filepath.Join(dir, "egafetch.sbatch")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
+	if err != nil {
+		return "", fmt.Errorf("write sbatch script: %w", err)
+	}
+	writeSlurmScript(f, job)
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("write sbatch script: %w", err)
+	}
+	return path, nil
+}
+
+// writeSlurmScript writes the sbatch array script of job to w.
+func writeSlurmScript(w io.Writer, job slurmJob) {
+	array := fmt.Sprintf("1-%d", job.Shards)
+	if job.MaxRunning > 0 {
+		array += fmt.Sprintf("%%%d", job.MaxRunning)
+	}
+
+	fmt.Fprintln(w, "#!/bin/bash")
+	fmt.Fprintf(w, "#SBATCH --job-name=%s\n", job.Name)
+	fmt.Fprintf(w, "#SBATCH --array=%s\n", array)
+	fmt.Fprintf(w, "#SBATCH --time=%s\n", job.Time)
+	fmt.Fprintf(w, "#SBATCH --cpus-per-task=%d\n", job.CPUs)
+	fmt.Fprintf(w, "#SBATCH --mem=%s\n", job.Mem)
+	if job.Partition != "" {
+		fmt.Fprintf(w, "#SBATCH --partition=%s\n", job.Partition)
+	}
+	if job.Account != "" {
+		fmt.Fprintf(w, "#SBATCH --account=%s\n", job.Account)
+	}
+	fmt.Fprintf(w, "#SBATCH --output=%s\n", filepath.Join(job.LogDir, "%x_%A_%a.out"))
+	// Checkpoint two minutes before the time limit, rather than be killed.
+	fmt.Fprintln(w, "#SBATCH --signal=B:USR1@120")
+	fmt.Fprintln(w)
+	into := "its own directory"
+	if job.Shared {
+		into = "the shared output directory"
+	}
+	fmt.Fprintln(w, "# Written by 'egafetch hpc slurm'. Each array task downloads one shard list")
+	fmt.Fprintf(w, "# into %s; submit again to resume unfinished shards.\n", into)
+	fmt.Fprintln(w, "set -euo pipefail")
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "shard=$(printf 'shard-%%03d' \"$SLURM_ARRAY_TASK_ID\")\n")
+	fmt.Fprintf(w, "exec %s download \\\n", shellQuote(job.Egafetch))
+	fmt.Fprintf(w, "  --from-file %s\"/$shard.txt\" \\\n", shellQuote(job.ShardDir))
+	if job.Shared {
+		fmt.Fprintf(w, "  --output %s --shared \\\n", shellQuote(job.Output))
+	} else {
+		fmt.Fprintf(w, "  --output %s\"/$shard\" \\\n", shellQuote(job.Output))
+	}
+	if job.ConfigFile != "" {
+		fmt.Fprintf(w, "  --cf %s \\\n", shellQuote(job.ConfigFile))
+	}
+	switch job.Layout {
+	case state.LayoutPreserve:
+		fmt.Fprintln(w, "  --preserve-paths \\")
+	case state.LayoutFlat:
+		fmt.Fprintln(w, "  --flatten \\")
+	}
+	fmt.Fprintf(w, "  --parallel-files %d --parallel-chunks %d \\\n", job.ParallelFiles, job.ParallelChunks)
+	fmt.Fprintln(w, "  --no-metadata --no-notify --progress plain")
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=@%+") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
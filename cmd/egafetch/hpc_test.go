@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+func TestShardFiles(t *testing.T) {
+	files := []state.FileSpec{
+		{FileID: "EGAF1", Size: 10},
+		{FileID: "EGAF2", Size: 70},
+		{FileID: "EGAF3", Size: 40},
+		{FileID: "EGAF4", Size: 30},
+		{FileID: "EGAF5", Size: 50},
+	}
+	shards := shardFiles(files, 2)
+	var got []string
+	for _, s := range shards {
+		var ids []string
+		for _, f := range s {
+			ids = append(ids, f.FileID)
+		}
+		got = append(got, strings.Join(ids, ","))
+	}
+	// 70 | 50, 40 | 30 -> 70+30, 50+40+10, each in manifest order.
+	want := []string{"EGAF2,EGAF4", "EGAF1,EGAF3,EGAF5"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("shardFiles = %v, want %v", got, want)
+	}
+
+	if n := len(shardFiles(files, 20)); n != len(files) {
+		t.Errorf("shardFiles with more shards than files = %d shards, want %d", n, len(files))
+	}
+}
+
+func TestSlurmTime(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{90 * time.Minute, "01:30:00"},
+		{26*time.Hour + 5*time.Second, "1-02:00:05"},
+	}
+	for _, tt := range tests {
+		if got := slurmTime(tt.d); got != tt.want {
+			t.Errorf("slurmTime(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+
+	// 100 GiB at 50 MiB/s is 2048s; with headroom 3072s + 30m = 4872s,
+	// rounded up to 5400s.
+	if got := slurmWalltime(100<<30, 50<<20); got != 90*time.Minute {
+		t.Errorf("slurmWalltime = %v, want 1h30m", got)
+	}
+}
+
+func TestWriteSlurmJob(t *testing.T) {
+	dir := t.TempDir()
+	job := slurmJob{
+		Name:           "egafetch-EGAD1",
+		Shards:         2,
+		ShardDir:       filepath.Join(dir, "shards"),
+		LogDir:         filepath.Join(dir, "logs"),
+		Output:         "/scratch/my data",
+		Egafetch:       "/opt/egafetch",
+		Time:           "01:30:00",
+		CPUs:           4,
+		Mem:            "4G",
+		Partition:      "transfer",
+		MaxRunning:     1,
+		ParallelFiles:  4,
+		ParallelChunks: 8,
+	}
+	// A list from an earlier run with more shards is removed.
+	if err := os.MkdirAll(job.ShardDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(job.ShardDir, "shard-003.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	shards := [][]state.FileSpec{
+		{{FileID: "EGAF1", FileName: "EGAF1/a.bam", Size: 10}},
+		{{FileID: "EGAF2", FileName: "EGAF2/b.bam", Size: 20}},
+	}
+	script, err := writeSlurmJob(job, shards, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lists, _ := filepath.Glob(filepath.Join(job.ShardDir, "*.txt"))
+	if len(lists) != 2 {
+		t.Fatalf("shard lists = %v, want 2", lists)
+	}
+	f, err := os.Open(filepath.Join(job.ShardDir, "shard-002.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ids, err := readIdentifiers(f, "shard-002.txt")
+	if err != nil || len(ids) != 1 || ids[0] != "EGAF2" {
+		t.Errorf("shard-002.txt identifiers = %v, %v; want [EGAF2]", ids, err)
+	}
+
+	data, err := os.ReadFile(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"#SBATCH --array=1-2%1\n",
+		"#SBATCH --time=01:30:00\n",
+		"#SBATCH --partition=transfer\n",
+		"#SBATCH --signal=B:USR1@120\n",
+		"exec /opt/egafetch download \\\n",
+		"--output '/scratch/my data'\"/$shard\"",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("script missing %q:\n%s", want, data)
+		}
+	}
+	if strings.Contains(string(data), "--account") {
+		t.Errorf("script sets --account without one:\n%s", data)
+	}
+
+	job.Shared = true
+	var b strings.Builder
+	writeSlurmScript(&b, job)
+	if want := "--output '/scratch/my data' --shared \\\n"; !strings.Contains(b.String(), want) {
+		t.Errorf("shared script missing %q:\n%s", want, b.String())
+	}
+
+	job.Shared, job.Layout = false, state.LayoutFlat
+	b.Reset()
+	writeSlurmScript(&b, job)
+	if want := "  --flatten \\\n"; !strings.Contains(b.String(), want) {
+		t.Errorf("flat script missing %q:\n%s", want, b.String())
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+// toolIndexer indexes verified files for download --index with the htslib
+// tools: samtools for BAM and CRAM, tabix for bgzip-compressed VCF, and
+// bcftools for BCF. Each field is the tool's command, by default found on
+// the PATH.
+type toolIndexer struct {
+	samtools string
+	tabix    string
+	bcftools string
+}
+
+// indexCommand returns the command that indexes the file at path and the
+// index file it writes, or false if the file's format is not indexed.
+func (ix toolIndexer) indexCommand(path string) (args []string, index string, ok bool) {
+	name := strings.ToLower(filepath.Base(path))
+	switch {
+	case strings.HasSuffix(name, ".bam"):
+		return []string{ix.samtools, "index", path}, path + ".bai", true
+	case strings.HasSuffix(name, ".cram"):
+		return []string{ix.samtools, "index", path}, path + ".crai", true
+	case strings.HasSuffix(name, ".vcf.gz"), strings.HasSuffix(name, ".vcf.bgz"):
+		return []string{ix.tabix, "-f", "-p", "vcf", path}, path + ".tbi", true
+	case strings.HasSuffix(name, ".bcf"):
+		return []string{ix.bcftools, "index", "-f", path}, path + ".csi", true
+	}
+	return nil, "", false
+}
+
+// indexName returns the index file of the file at path, or false if its
+// format is not indexed.
+func (ix toolIndexer) indexName(path string) (string, bool) {
+	_, index, ok := ix.indexCommand(path)
+	return index, ok
+}
+
+func (ix toolIndexer) Index(ctx context.Context, path string) ([]string, error) {
+	args, index, ok := ix.indexCommand(path)
+	if !ok {
+		return nil, nil
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		tool := filepath.Base(args[0])
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s", tool, lastLine(msg))
+		}
+		return nil, fmt.Errorf("%s: %w", tool, err)
+	}
+	if _, err := os.Stat(index); err != nil {
+		return nil, fmt.Errorf("%s wrote no index: %w", filepath.Base(args[0]), err)
+	}
+	return []string{index}, nil
+}
+
+// checkIndexTools reports an error if a tool that --index needs for the
+// files in the manifest cannot be found, so that a missing tool is noticed
+// before the download rather than after it.
+func (ix toolIndexer) checkIndexTools(manifest *state.Manifest) error {
+	flags := map[string]string{ix.samtools: "--samtools", ix.tabix: "--tabix", ix.bcftools: "--bcftools"}
+	checked := make(map[string]bool)
+	for _, f := range manifest.Files {
+		args, _, ok := ix.indexCommand(f.FileName)
+		if !ok || checked[args[0]] {
+			continue
+		}
+		checked[args[0]] = true
+		if _, err := exec.LookPath(args[0]); err != nil {
+			return fmt.Errorf("--index needs %s to index %s (install it, or set %s): %w", args[0], filepath.Base(f.FileName), flags[args[0]], err)
+		}
+	}
+	return nil
+}
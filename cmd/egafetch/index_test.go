@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+func TestIndexCommand(t *testing.T) {
+	ix := toolIndexer{samtools: "samtools", tabix: "tabix", bcftools: "bcftools"}
+	tests := []struct {
+		name, tool, index string
+	}{
+		{"a.bam", "samtools", "a.bam.bai"},
+		{"a.CRAM", "samtools", "a.CRAM.crai"},
+		{"a.g.vcf.gz", "tabix", "a.g.vcf.gz.tbi"},
+		{"a.bcf", "bcftools", "a.bcf.csi"},
+		{"a.vcf", "", ""}, // tabix needs bgzip compression
+		{"a.bam.bai", "", ""},
+		{"a.fastq.gz", "", ""},
+	}
+	for _, tt := range tests {
+		args, index, ok := ix.indexCommand(tt.name)
+		if tt.tool == "" {
+			if ok {
+				t.Errorf("indexCommand(%q) = %v, want no index", tt.name, args)
+			}
+			continue
+		}
+		if !ok || args[0] != tt.tool || index != tt.index {
+			t.Errorf("indexCommand(%q) = %v, %q, %v; want %s writing %q", tt.name, args, index, ok, tt.tool, tt.index)
+		}
+	}
+}
+
+func TestToolIndexer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake tools are shell scripts")
+	}
+	dir := t.TempDir()
+	samtools := filepath.Join(dir, "samtools")
+	script := "#!/bin/sh\n[ \"$1\" = index ] && touch \"$2.bai\"\n"
+	if err := os.WriteFile(samtools, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tabix := filepath.Join(dir, "tabix")
+	if err := os.WriteFile(tabix, []byte("#!/bin/sh\necho '[E::hts_idx_push] Unsorted positions' >&2\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	ix := toolIndexer{samtools: samtools, tabix: tabix, bcftools: filepath.Join(dir, "missing")}
+
+	path := filepath.Join(dir, "a.bam")
+	files, err := ix.Index(context.Background(), path)
+	if err != nil || len(files) != 1 || files[0] != path+".bai" {
+		t.Errorf("Index(a.bam) = %v, %v; want [%s]", files, err, path+".bai")
+	}
+	if _, err := ix.Index(context.Background(), filepath.Join(dir, "a.vcf.gz")); err == nil || !strings.Contains(err.Error(), "tabix: [E::hts_idx_push] Unsorted positions") {
+		t.Errorf("Index with a failing tool = %v", err)
+	}
+	if files, err := ix.Index(context.Background(), filepath.Join(dir, "a.fastq.gz")); files != nil || err != nil {
+		t.Errorf("Index(a.fastq.gz) = %v, %v; want nothing", files, err)
+	}
+
+	manifest := &state.Manifest{Files: []state.FileSpec{{FileName: "EGAF1/a.bam"}, {FileName: "EGAF2/b.vcf.gz"}}}
+	if err := ix.checkIndexTools(manifest); err != nil {
+		t.Errorf("checkIndexTools with the tools present = %v", err)
+	}
+	manifest.Files = append(manifest.Files, state.FileSpec{FileName: "EGAF3/c.bcf"})
+	if err := ix.checkIndexTools(manifest); err == nil || !strings.Contains(err.Error(), "--bcftools") {
+		t.Errorf("checkIndexTools with bcftools missing = %v", err)
+	}
+}
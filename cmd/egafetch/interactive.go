@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/state"
+	"github.com/khan-lab/EGAfetch/internal/ui"
+)
+
+// pickDatasets lets the user choose among their authorized datasets.
+func pickDatasets(ctx context.Context, apiClient *api.Client) ([]string, error) {
+	slog.Info("Fetching authorized datasets...")
+	datasets, err := listDatasets(ctx, apiClient)
+	if err != nil {
+		return nil, err
+	}
+	if len(datasets) == 0 {
+		return nil, fmt.Errorf("no authorized datasets found")
+	}
+
+	items := make([]ui.PickerItem, len(datasets))
+	for i, d := range datasets {
+		items[i] = ui.PickerItem{ID: d.DatasetID}
+		if details, err := apiClient.GetDatasetDetails(ctx, d.DatasetID); err == nil {
+			items[i].Label = details.Title
+		}
+	}
+
+	chosen, err := ui.Pick("Select datasets:", items)
+	if err != nil {
+		return nil, err
+	}
+	if len(chosen) == 0 {
+		return nil, fmt.Errorf("no datasets selected")
+	}
+	ids := make([]string, len(chosen))
+	for i, c := range chosen {
+		ids[i] = datasets[c].DatasetID
+	}
+	return ids, nil
+}
+
+// pickManifestFiles lets the user choose which of the manifest's files to
+// download and drops the rest.
+func pickManifestFiles(manifest *state.Manifest) error {
+	items := make([]ui.PickerItem, len(manifest.Files))
+	for i, f := range manifest.Files {
+		items[i] = ui.PickerItem{ID: f.FileID, Label: f.FileName, Size: f.Size}
+	}
+
+	chosen, err := ui.Pick(fmt.Sprintf("Select files to download (%d available):", len(items)), items)
+	if err != nil {
+		return err
+	}
+	if len(chosen) == 0 {
+		return fmt.Errorf("no files selected")
+	}
+	files := make([]state.FileSpec, len(chosen))
+	for i, c := range chosen {
+		files[i] = manifest.Files[c]
+	}
+	manifest.Files = files
+	return nil
+}
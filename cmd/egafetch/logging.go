@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/ui"
+)
+
+// Logging flags, registered on the root command.
+var (
+	quiet     bool
+	verbosity int
+	logFile   string
+	noColor   bool
+)
+
+// consoleLevel returns the minimum level shown on the terminal.
+func consoleLevel() slog.Level {
+	switch {
+	case quiet:
+		return slog.LevelWarn
+	case verbosity >= 2:
+		return api.LevelTrace
+	case verbosity == 1:
+		return slog.LevelDebug
+	}
+	return slog.LevelInfo
+}
+
+// setupLogging installs the default logger for the global --quiet,
+// -v/--verbose, and --log-file flags, and applies --no-color. It runs before
+// every command.
+func setupLogging(cmd *cobra.Command, args []string) error {
+	if quiet && verbosity > 0 {
+		return fmt.Errorf("--quiet and --verbose cannot be combined")
+	}
+	if noColor {
+		ui.SetColor(false)
+	}
+
+	// Log files always record debug detail, plus HTTP tracing with -vv.
+	fileLevel := slog.LevelDebug
+	if verbosity >= 2 {
+		fileLevel = api.LevelTrace
+	}
+	runLog.setLevel(fileLevel)
+
+	handler := multiHandler{newConsoleHandler(consoleLevel(), verbosity > 0), runLog.handler()}
+	if logFile != "" {
+		f, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("open log file: %w", err)
+		}
+		handler = append(handler, slog.NewJSONHandler(f, &slog.HandlerOptions{Level: fileLevel}))
+	}
+
+	slog.SetDefault(slog.New(handler).With("command", cmd.CommandPath()))
+	return nil
+}
+
+// showProgress reports whether the live progress display should be drawn.
+// Verbose runs log per-file events instead, and quiet runs print nothing.
+func showProgress() bool {
+	return !quiet && verbosity == 0
+}
+
+// progressRenderer resolves --progress for downloads running parallel batch
+// entries at once.
+func progressRenderer(mode string, parallel int) string {
+	if !showProgress() {
+		return ui.RendererNone
+	}
+	return resolveRenderer(mode, terminalStderr(), parallel)
+}
+
+// terminalStderr reports whether stderr is a terminal that understands
+// cursor movement.
+func terminalStderr() bool {
+	return term.IsTerminal(int(os.Stderr.Fd())) && os.Getenv("TERM") != "dumb"
+}
+
+// noSpinners is set by 'download --progress plain' or 'none'.
+var noSpinners bool
+
+// startSpinner shows text with a spinner on stderr during a slow phase, when
+// the live display could be drawn. Otherwise it returns a nil spinner, which
+// draws nothing.
+func startSpinner(text string) *ui.Spinner {
+	if noSpinners || !showProgress() || !terminalStderr() {
+		return nil
+	}
+	return ui.StartSpinner(os.Stderr, text)
+}
+
+// resolveRenderer picks the live display for auto on a terminal, and plain
+// lines elsewhere (log files, batch job output) so they are not filled with
+// cursor movement. Concurrent batch entries would overwrite each other's
+// live display, so they get plain lines too.
+func resolveRenderer(mode string, terminal bool, parallel int) string {
+	if mode == ui.RendererAuto {
+		mode = ui.RendererPlain
+		if terminal {
+			mode = ui.RendererANSI
+		}
+	}
+	if (mode == ui.RendererANSI || mode == ui.RendererChunks) && parallel > 1 {
+		return ui.RendererPlain
+	}
+	return mode
+}
+
+// consoleHandler writes log records to stderr as plain lines: the message
+// followed by its attributes as key=value. Warnings and errors get a prefix;
+// attributes added to the logger itself (e.g. command) are shown only when
+// verbose.
+type consoleHandler struct {
+	level   slog.Level
+	verbose bool
+	attrs   []slog.Attr
+	mu      *sync.Mutex
+}
+
+func newConsoleHandler(level slog.Level, verbose bool) *consoleHandler {
+	return &consoleHandler{level: level, verbose: verbose, mu: &sync.Mutex{}}
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	switch {
+	case r.Level >= slog.LevelError:
+		b.WriteString("Error: ")
+	case r.Level >= slog.LevelWarn:
+		b.WriteString("Warning: ")
+	case r.Level < slog.LevelInfo:
+		b.WriteString("[debug] ")
+	}
+	b.WriteString(r.Message)
+
+	writeAttr := func(a slog.Attr) bool {
+		if a.Key == "command" {
+			return true
+		}
+		v := a.Value.String()
+		if v == "" || strings.ContainsAny(v, " \t\"=") {
+			v = fmt.Sprintf("%q", v)
+		}
+		fmt.Fprintf(&b, " %s=%s", a.Key, v)
+		return true
+	}
+	if h.verbose {
+		for _, a := range h.attrs {
+			writeAttr(a)
+		}
+	}
+	r.Attrs(writeAttr)
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var err error
+	ui.ClearSpinner(func() { _, err = os.Stderr.WriteString(b.String()) })
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &h2
+}
+
+func (h *consoleHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// multiHandler sends each record to every handler that accepts its level.
+type multiHandler []slog.Handler
+
+func (m multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range m {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var firstErr error
+	for _, h := range m {
+		if h.Enabled(ctx, r.Level) {
+			if err := h.Handle(ctx, r.Clone()); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(multiHandler, len(m))
+	for i, h := range m {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	out := make(multiHandler, len(m))
+	for i, h := range m {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
@@ -3,14 +3,22 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -22,8 +30,8 @@ import (
 
 	"github.com/khan-lab/EGAfetch/internal/api"
 	"github.com/khan-lab/EGAfetch/internal/auth"
-	"github.com/khan-lab/EGAfetch/internal/config"
 	"github.com/khan-lab/EGAfetch/internal/download"
+	"github.com/khan-lab/EGAfetch/internal/notify"
 	"github.com/khan-lab/EGAfetch/internal/state"
 	"github.com/khan-lab/EGAfetch/internal/ui"
 	"github.com/khan-lab/EGAfetch/internal/verify"
@@ -39,40 +47,126 @@ func main() {
 European Genome-phenome Archive (EGA) with parallel chunked downloads,
 automatic resume, and checksum verification.`,
 		Version: version,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := applyEnvFlags(cmd); err != nil {
+				return err
+			}
+			if err := setupLogging(cmd, args); err != nil {
+				return err
+			}
+			if err := startProfiling(); err != nil {
+				return err
+			}
+			return setupJSONOutput(cmd, args)
+		},
+		// Errors are reported through the logger so they reach --log-file.
+		SilenceErrors: true,
 	}
 
 	rootCmd.SetVersionTemplate(fmt.Sprintf("egafetch version %s\n", version))
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Only print warnings, errors, and results")
+	rootCmd.PersistentFlags().CountVarP(&verbosity, "verbose", "v", "Print debug detail (-v), and HTTP requests (-vv)")
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "Append a JSON log of the run to this file")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "Disable colored output (also set by NO_COLOR)")
+	rootCmd.PersistentFlags().DurationVar(&checkpointGrace, "checkpoint-grace", 20*time.Second, "Time to save state after SIGTERM or SIGUSR1 before exiting anyway (0 = no limit)")
+	rootCmd.PersistentFlags().StringVar(&pprofAddr, "pprof", "", "Serve net/http/pprof profiles on this address while the command runs (e.g. localhost:6060)")
+	rootCmd.PersistentFlags().StringVar(&cpuProfile, "cpu-profile", "", "Write a CPU profile of the command to this file")
+	rootCmd.PersistentFlags().StringVar(&memProfile, "mem-profile", "", "Write a heap profile to this file when the command ends")
+	rootCmd.PersistentFlags().BoolVar(&jsonOutput, "json", false, "Print results as JSON on stdout (list, info, status, verify, download, size, annex)")
 
 	rootCmd.AddCommand(
 		newAuthCmd(),
-		newDownloadCmd(),
-		newListCmd(),
-		newInfoCmd(),
+		supportsJSON(newDownloadCmd()),
+		supportsJSON(newListCmd()),
+		supportsJSON(newInfoCmd()),
+		newSummaryCmd(),
+		supportsJSON(newSizeCmd()),
 		newMetadataCmd(),
-		newStatusCmd(),
-		newVerifyCmd(),
+		newSamplesheetCmd(),
+		supportsJSON(newStatusCmd()),
+		supportsJSON(newVerifyCmd()),
+		newWorkflowCmd(),
+		supportsJSON(newAnnexCmd()),
+		newHPCCmd(),
 		newCleanCmd(),
+		newCancelCmd(),
+		newQuickstartCmd(),
+		newDoctorCmd(),
+		newSpeedtestCmd(),
+		newBenchmarkCmd(),
+		newServeCmd(),
+		newRPCCmd(),
+		newConfigCmd(),
 	)
 
-	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+	slog.SetDefault(slog.New(newConsoleHandler(slog.LevelInfo, false)))
+	err := rootCmd.Execute()
+	stopProfiling()
+	if err != nil {
+		code := exitCode(err)
+		// exit_code is a logger attribute so the console shows it only when verbose.
+		slog.With("exit_code", code).Error(err.Error())
+		os.Exit(code)
 	}
 }
 
-// signalContext returns a context that is cancelled on SIGINT/SIGTERM.
+// checkpointGrace is how long a command may take to save its state after a
+// checkpoint signal (--checkpoint-grace); 0 waits as long as it takes.
+var checkpointGrace time.Duration
+
+// signalContext returns a context that is cancelled on SIGINT or one of the
+// checkpointSignals. After a checkpoint signal the command exits with
+// exitCheckpointed, or with exitInterrupted if it is still saving its state
+// when checkpointGrace runs out, so that the scheduler never has to kill it.
 func signalContext() (context.Context, context.CancelFunc) {
+	ctx, _, cancel := drainingSignalContext(0)
+	return ctx, cancel
+}
+
+// drainingSignalContext is signalContext, except that with drain > 0 a
+// signal first closes the returned channel, for the command to stop starting
+// work and finish what is in flight, and cancels the context only after
+// drain, or on a second signal. With drain <= 0 the channel is nil.
+func drainingSignalContext(drain time.Duration) (context.Context, <-chan struct{}, context.CancelFunc) {
 	ctx, cancel := context.WithCancel(context.Background())
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	var draining chan struct{}
+	if drain > 0 {
+		draining = make(chan struct{})
+	}
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, append([]os.Signal{syscall.SIGINT}, checkpointSignals...)...)
 	go func() {
 		select {
-		case <-sigs:
-			fmt.Fprintln(os.Stderr, "\nInterrupted. Saving state...")
+		case sig := <-sigs:
+			if slices.Contains(checkpointSignals, sig) {
+				slog.Warn("Checkpointing. Saving state...", "signal", sig.String())
+				checkpointed.Store(true)
+				if checkpointGrace > 0 {
+					time.AfterFunc(checkpointGrace, func() {
+						slog.Error("State not saved within the checkpoint grace period; exiting", "grace", checkpointGrace)
+						os.Exit(exitInterrupted)
+					})
+				}
+			} else if draining == nil {
+				slog.Warn("Interrupted. Saving state...")
+			}
+			interrupted.Store(true)
+			if draining != nil {
+				slog.Warn("Finishing chunks in flight. Interrupt again to stop at once.", "drain", drain)
+				close(draining)
+				select {
+				case <-sigs:
+					slog.Warn("Interrupted. Saving state...")
+				case <-time.After(drain):
+					slog.Warn("Drain deadline reached. Saving state...", "drain", drain)
+				case <-ctx.Done():
+				}
+			}
 			cancel()
 		case <-ctx.Done():
 		}
 	}()
-	return ctx, cancel
+	return ctx, draining, cancel
 }
 
 // --- Auth commands ---
@@ -126,12 +220,12 @@ func newAuthLoginCmd() *cobra.Command {
 			ctx, cancel := signalContext()
 			defer cancel()
 
-			fmt.Println("Authenticating...")
+			slog.Info("Authenticating...")
 			if err := mgr.Login(ctx, username, password); err != nil {
 				return err
 			}
 
-			fmt.Println("Login successful!")
+			fmt.Fprintln(cmd.OutOrStdout(), "Login successful!")
 			return nil
 		},
 	}
@@ -154,7 +248,7 @@ func newAuthStatusCmd() *cobra.Command {
 
 			creds := mgr.Status()
 			if creds == nil {
-				ui.PrintAuthStatus("", "", false)
+				ui.PrintAuthStatus(cmd.OutOrStdout(), "", "", false)
 				return nil
 			}
 
@@ -162,7 +256,7 @@ func newAuthStatusCmd() *cobra.Command {
 			if creds.IsExpired(0) {
 				expiresIn = "expired"
 			}
-			ui.PrintAuthStatus(creds.Username, expiresIn, true)
+			ui.PrintAuthStatus(cmd.OutOrStdout(), creds.Username, expiresIn, true)
 			return nil
 		},
 	}
@@ -180,7 +274,7 @@ func newAuthLogoutCmd() *cobra.Command {
 			if err := mgr.Logout(); err != nil {
 				return err
 			}
-			fmt.Println("Logged out.")
+			fmt.Fprintln(cmd.OutOrStdout(), "Logged out.")
 			return nil
 		},
 	}
@@ -200,41 +294,192 @@ func newDownloadCmd() *cobra.Command {
 	var maxBandwidth string
 	var includePatterns []string
 	var excludePatterns []string
+	var formats []string
+	var withIndexes bool
+	var preservePaths, flatten bool
+	var ifExists string
 	var adaptiveChunks bool
+	var autoTune bool
+	var directIO bool
+	var noHedge bool
+	var bufferSize string
+	var maxMemory string
+	var smallFileSize string
+	var stallTimeout time.Duration
+	var drain time.Duration
+	var stripeCount int
+	var stripeSize string
+	var dnsCacheTTL, dialKeepAlive, dialFallbackDelay time.Duration
+	var webhooks []string
+	var webhookFormat string
+	var noNotify bool
+	var progressJSON string
+	var statusListen string
+	var progressMode string
+	var progressInterval time.Duration
+	var progressFile string
+	var fileMode, dirMode, group string
+	var tmpDir string
+	var reports []string
+	var refreshMetadata bool
+	var fromFiles []string
+	var dryRun bool
+	var interactive bool
+	var resumeOnly bool
+	var excludeIDs []string
+	var excludeFiles []string
+	var batchFile string
+	var shared bool
+	var md5Files bool
+	var index bool
+	var indexer toolIndexer
+	var attributes attributeFilter
+	var tarPath string
+	var drsManifestPath string
+	var endpoints []string
 
 	cmd := &cobra.Command{
-		Use:   "download [EGAD.../EGAF.../file.txt]",
-		Short: "Download datasets or files from EGA",
+		Use:               "download [EGAD.../EGAF.../file.txt/-]",
+		ValidArgsFunction: completeAccessions(false, true),
+		Short:             "Download datasets or files from EGA",
 		Long: `Download datasets or files from EGA. Arguments can be dataset IDs
 (EGAD...), file IDs (EGAF...), or text files containing one identifier
-per line (blank lines and #comments are ignored).
+per line (blank lines and #comments are ignored). Identifier files can also
+be given with --from-file, and "-" reads identifiers from stdin.
 
 Re-running the same command automatically resumes incomplete downloads.
-Use --restart to force a fresh download from scratch.`,
-		Args: cobra.MinimumNArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// Load persistent config; CLI flags override config values.
-			cfg, err := config.Load()
+Use --restart to force a fresh download from scratch.
+
+Use --resume-only to finish files that already have progress in the
+output directory without starting any new ones.
+
+Use --dry-run to print the files that would be downloaded, after all
+filters are applied, without downloading anything.
+
+With --interactive, datasets (when none are given) and files are chosen
+from a searchable terminal list showing sizes and the selected total.
+
+With --batch, downloads are read from a YAML or JSON file listing entries,
+each with its own identifiers, filters, and output directory.
+
+With --shared, several hosts can download into one output directory on a
+shared filesystem: each claims the files it downloads, so no file is
+downloaded twice, and each runs until every file is complete.
+
+With --tar, each file is added to a tar archive (or a stream on stdout, with
+--tar -) once verified and then removed, so the output directory only stages
+the files being downloaded.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(fromFiles) > 0 || interactive || batchFile != "" {
+				return nil
+			}
+			return cobra.MinimumNArgs(1)(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) (retErr error) {
+			// Expand identifier files (and stdin) up front so list errors
+			// surface before authentication.
+			args, err := expandArgs(append(args, fromFiles...))
 			if err != nil {
-				return fmt.Errorf("load config: %w", err)
+				return err
+			}
+			excluded, err := readExcludedIDs(excludeIDs, excludeFiles)
+			if err != nil {
+				return err
+			}
+
+			if batchFile != "" && (len(args) > 0 || interactive) {
+				return fmt.Errorf("--batch cannot be combined with identifiers, --from-file, or --interactive")
+			}
+			if len(args) == 0 && !interactive && batchFile == "" {
+				return fmt.Errorf("no identifiers given")
 			}
-			if !cmd.Flags().Changed("chunk-size") && cfg.ChunkSize != "" {
-				chunkSize = cfg.ChunkSize
+			if resumeOnly && restart {
+				return fmt.Errorf("--resume-only cannot be combined with --restart")
 			}
-			if !cmd.Flags().Changed("parallel-files") && cfg.ParallelFiles > 0 {
-				parallelFiles = cfg.ParallelFiles
+			layout, err := pathLayout(preservePaths, flatten)
+			if err != nil {
+				return err
 			}
-			if !cmd.Flags().Changed("parallel-chunks") && cfg.ParallelChunks > 0 {
-				parallelChunks = cfg.ParallelChunks
+			if shared && restart {
+				return fmt.Errorf("--shared cannot be combined with --restart, which would clear the state of the other hosts")
 			}
-			if !cmd.Flags().Changed("max-bandwidth") && cfg.MaxBandwidth != "" {
-				maxBandwidth = cfg.MaxBandwidth
+			if shared && tmpDir != "" {
+				return fmt.Errorf("--shared cannot be combined with --tmp-dir: chunks must be in the shared output directory")
 			}
-			if !cmd.Flags().Changed("output") && cfg.OutputDir != "" {
-				output = cfg.OutputDir
+			if !slices.Contains(download.IfExistsPolicies, ifExists) {
+				return fmt.Errorf("invalid --if-exists %q (use %s)", ifExists, strings.Join(download.IfExistsPolicies, ", "))
 			}
-			if !cmd.Flags().Changed("metadata-format") && cfg.MetadataFormat != "" {
-				metadataFormat = cfg.MetadataFormat
+			// A fresh start replaces files left from the previous attempt.
+			if restart && !cmd.Flags().Changed("if-exists") {
+				ifExists = download.IfExistsOverwrite
+			}
+
+			// Flags override EGAFETCH_* variables, which override config.yaml.
+			if err := applyConfig(cmd, downloadConfigFlags); err != nil {
+				return err
+			}
+			if !slices.Contains(ui.Renderers, progressMode) {
+				return fmt.Errorf("invalid --progress %q (use %s)", progressMode, strings.Join(ui.Renderers, ", "))
+			}
+			// Spinners redraw their line, which plain and none rule out.
+			if progressMode == ui.RendererPlain || progressMode == ui.RendererNone {
+				noSpinners = true
+			}
+			if progressFile != "" && progressMode != ui.RendererChunks {
+				return fmt.Errorf("--progress-file requires --progress chunks")
+			}
+			if tarPath != "" {
+				if err := checkTarFlags(tarPath, output, batchFile, shared, progressJSON); err != nil {
+					return err
+				}
+			}
+			logPolicy, err := loadRunLogPolicy()
+			if err != nil {
+				return err
+			}
+			if !slices.Contains(notify.Formats, webhookFormat) {
+				return fmt.Errorf("invalid --webhook-format %q (use %s)", webhookFormat, strings.Join(notify.Formats, ", "))
+			}
+			if err := validateConfigValue("webhook", strings.Join(webhooks, ",")); err != nil {
+				return err
+			}
+			if err := validateConfigValue("endpoints", strings.Join(endpoints, ",")); err != nil {
+				return err
+			}
+			for i := range endpoints {
+				endpoints[i] = strings.TrimSpace(endpoints[i])
+			}
+			if err := validateConfigValue("buffer_size", bufferSize); err != nil {
+				return err
+			}
+			bufferBytes, _ := parseSize(bufferSize)
+			if err := validateConfigValue("small_file_size", smallFileSize); err != nil {
+				return err
+			}
+			smallBytes, _ := parseSize(smallFileSize) // "0" = never
+			if stripeCount < -1 {
+				return fmt.Errorf("invalid --stripe-count %d (use a number of OSTs, -1 for all, or 0 for the filesystem default)", stripeCount)
+			}
+			if err := validateConfigValue("stripe_size", stripeSize); err != nil {
+				return err
+			}
+			stripeBytes, _ := parseSize(stripeSize) // "" and "0" = the filesystem default
+			if directIO && !download.DirectIOSupported {
+				slog.Warn("--direct-io is only supported on Linux; using buffered I/O")
+			}
+			for _, f := range reports {
+				if !slices.Contains(reportFormats, f) {
+					return fmt.Errorf("invalid --report %q (use %s)", f, strings.Join(reportFormats, ", "))
+				}
+			}
+
+			var groups []outputGroup
+			parallel := 1
+			if batchFile != "" {
+				groups, parallel, err = loadBatch(batchFile, output)
+				if err != nil {
+					return err
+				}
 			}
 
 			chunkBytes, err := parseSize(chunkSize)
@@ -251,12 +496,52 @@ Use --restart to force a fresh download from scratch.`,
 				limiter = rate.NewLimiter(rate.Limit(bwBytes), 256*1024)
 			}
 
+			perms, err := outputPermissions(fileMode, dirMode, group)
+			if err != nil {
+				return err
+			}
+
 			opts := download.DownloadOptions{
 				ParallelFiles:    parallelFiles,
 				ParallelChunks:   parallelChunks,
 				ChunkSize:        chunkBytes,
 				Limiter:          limiter,
 				AdaptiveChunking: adaptiveChunks,
+				AutoTune:         autoTune,
+				IfExists:         ifExists,
+				Permissions:      perms,
+				Shared:           shared,
+				MD5Files:         md5Files,
+				Endpoints:        endpoints,
+				DirectIO:         directIO,
+				NoHedge:          noHedge,
+				BufferSize:       int(bufferBytes),
+				SmallFileSize:    smallBytes,
+				StallTimeout:     stallTimeout,
+				Striping:         download.Striping{Count: stripeCount, Size: stripeBytes},
+			}
+			var indexName func(string) (string, bool)
+			if index {
+				opts.Indexer = indexer
+				indexName = indexer.indexName
+			}
+			naming := outputNaming{layout: layout, generated: download.GeneratedNames(opts, indexName)}
+			if maxMemory != "" {
+				budget, err := parseSize(maxMemory)
+				if err != nil {
+					return fmt.Errorf("invalid --max-memory: %w", err)
+				}
+				reduced, err := download.FitMemory(&opts, budget)
+				if err != nil {
+					return fmt.Errorf("--max-memory: %w", err)
+				}
+				if reduced {
+					slog.Warn("Lowered parallelism to fit --max-memory", "max_memory", maxMemory,
+						"parallel_files", opts.ParallelFiles, "parallel_chunks", opts.ParallelChunks)
+				}
+			}
+			if shared {
+				runNameSuffix = sharedRunNameSuffix()
 			}
 
 			mgr, err := auth.NewManager()
@@ -264,135 +549,692 @@ Use --restart to force a fresh download from scratch.`,
 				return err
 			}
 
-			ctx, cancel := signalContext()
+			if drain > 0 && checkpointGrace > 0 && drain >= checkpointGrace {
+				slog.Warn("--drain is not shorter than --checkpoint-grace; a checkpoint may exit before state is saved",
+					"drain", drain, "checkpoint_grace", checkpointGrace)
+			}
+			ctx, drainCh, cancel := drainingSignalContext(drain)
 			defer cancel()
+			opts.Drain = drainCh
 
-			if err := ensureAuth(ctx, mgr, configFile); err != nil {
-				return err
+			var notifier *runNotifier
+			if !dryRun {
+				notifier = newRunNotifier(webhooks, webhookFormat, !noNotify && interactiveSession())
+				defer func() { notifier.finish(retErr) }()
 			}
 
-			apiClient := api.NewClient(mgr)
-			sm := state.NewStateManager(output)
+			var progress *progressStream
+			if !dryRun && progressJSON != "" {
+				progress, err = openProgressStream(cmd, progressJSON)
+				if err != nil {
+					return err
+				}
+				defer progress.Close()
+			}
+
+			var status *statusServer
+			if !dryRun && statusListen != "" {
+				status, err = startStatusServer(statusListen)
+				if err != nil {
+					return err
+				}
+				defer status.Close()
+			}
 
-			// If --restart is set, wipe all existing state for a fresh download.
-			if restart {
-				fmt.Println("Restarting: clearing previous download state...")
-				if err := sm.Reset(); err != nil {
-					return fmt.Errorf("reset state: %w", err)
+			var archive *tarArchive
+			if !dryRun && tarPath != "" {
+				archive, err = openTarArchive(cmd, tarPath)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					if err := archive.Close(); err != nil && retErr == nil {
+						retErr = err
+					}
+				}()
+				// stdout carries the archive; results go to stderr.
+				if tarPath == "-" {
+					cmd.SetOut(cmd.ErrOrStderr())
 				}
 			}
 
-			// Resolve args into a manifest.
-			manifest, err := resolveManifest(ctx, apiClient, args)
-			if err != nil {
+			if err := ensureAuth(ctx, mgr, configFile); err != nil {
 				return err
 			}
 
-			// Apply include/exclude filters.
-			if len(includePatterns) > 0 || len(excludePatterns) > 0 {
-				egafIDs := make(map[string]bool)
-				for _, arg := range args {
-					if strings.HasPrefix(arg, "EGAF") {
-						egafIDs[arg] = true
-					}
+			apiClient := api.NewClientWithDialer(mgr, api.DialOptions{
+				KeepAlive:     offIfZero(dialKeepAlive),
+				FallbackDelay: offIfZero(dialFallbackDelay),
+				DNSCacheTTL:   offIfZero(dnsCacheTTL),
+			})
+			if interactive && len(args) == 0 {
+				args, err = pickDatasets(ctx, apiClient)
+				if err != nil {
+					return err
 				}
-				beforeCount := len(manifest.Files)
-				if err := filterManifest(manifest, includePatterns, excludePatterns, egafIDs); err != nil {
+			}
+
+			if batchFile == "" {
+				groups, err = groupByOutputDir(args, output)
+				if err != nil {
 					return err
 				}
-				if len(manifest.Files) == 0 {
-					return fmt.Errorf("no files match the given include/exclude patterns (filtered out all %d files)", beforeCount)
+			}
+
+			// Batch entries may set their own filters; --exclude-id applies
+			// to every entry.
+			allExcluded := make(map[string]bool)
+			for i := range groups {
+				g := &groups[i]
+				if g.include == nil {
+					g.include = includePatterns
+				}
+				if g.exclude == nil {
+					g.exclude = excludePatterns
+				}
+				if g.excluded == nil {
+					g.excluded = make(map[string]bool)
 				}
-				if len(manifest.Files) != beforeCount {
-					fmt.Printf("Filtered: %d of %d files match patterns\n", len(manifest.Files), beforeCount)
+				for id := range excluded {
+					g.excluded[id] = true
+				}
+				for id := range g.excluded {
+					allExcluded[id] = true
 				}
 			}
 
-			fmt.Printf("Downloading %d file(s) to %s\n", len(manifest.Files), output)
+			var exclusionsMu sync.Mutex
+			matchedExclusions := make(map[string]bool)
 
-			// Set up progress tracking.
-			tracker := ui.NewProgressTracker()
-			for _, f := range manifest.Files {
-				tracker.RegisterFile(f.FileID, f.FileName, f.Size)
+			var drs *drsManifest
+			if drsManifestPath != "" {
+				drs = newDRSManifest(drsManifestPath)
 			}
 
-			orch := download.NewOrchestrator(apiClient, sm, opts)
-			orch.SetProgressCallback(func(fileID string, bytesDownloaded, totalBytes int64) {
-				tracker.UpdateProgress(fileID, bytesDownloaded, totalBytes)
-			})
-			orch.SetFileCallbacks(
-				func(fileID, fileName string) { tracker.FileStarted(fileID, fileName) },
-				func(fileID, fileName string, err error) {
+			// downloadTo runs the download into one output directory and
+			// returns its JSON result when --json is set.
+			downloadTo := func(g outputGroup) (_ interface{}, retErr error) {
+				output, args := g.dir, g.args
+				// An rclone remote is downloaded into a local staging
+				// directory, and each file uploaded once verified.
+				remote, isRemote, err := rcloneRemote(output)
+				if err != nil {
+					return nil, err
+				}
+				var uploader stagedUploader
+				groupOpts := opts
+				if archive != nil {
+					uploader = archive
+					groupOpts.Uploader = archive
+				}
+				if isRemote {
+					if shared {
+						return nil, fmt.Errorf("--shared requires an output directory on a shared filesystem, not an rclone remote")
+					}
+					if archive != nil {
+						return nil, fmt.Errorf("--tar cannot be combined with an rclone: or bucket output")
+					}
+					output = rcloneStagingDir(tmpDir, remote)
+					if !dryRun {
+						if uploader, err = newRcloneUploader(g.dir, remote); err != nil {
+							return nil, err
+						}
+						groupOpts.Uploader = uploader
+					}
+					slog.Info("Staging files for upload", "remote", remote, "staging_dir", output)
+				}
+				sm := state.NewStateManager(output)
+
+				// Claim the directory before touching its state, so --restart
+				// cannot wipe a download that is still running there. Shared
+				// downloads claim each file instead, and do not record a job.
+				if !dryRun && shared {
+					if err := checkSharedJob(sm); err != nil {
+						return nil, err
+					}
+				}
+				if !dryRun && !shared {
+					if err := checkNotShared(sm); err != nil {
+						return nil, err
+					}
+					job, err := startJob(sm)
 					if err != nil {
-						tracker.FileFailed(fileID, fileName, err)
+						return nil, err
+					}
+					defer func() { finishJob(sm, job, retErr) }()
+
+					watchCtx, stopWatch := context.WithCancel(ctx)
+					defer stopWatch()
+					go watchCancelRequest(watchCtx, sm, cancel)
+
+					// If --restart is set, wipe all existing state for a fresh
+					// download, keeping the job record just written.
+					if restart {
+						slog.Info("Restarting: clearing previous download state...", "output_dir", output)
+						if err := sm.Reset(); err != nil {
+							return nil, fmt.Errorf("reset state: %w", err)
+						}
+						if err := sm.SaveJob(job); err != nil {
+							return nil, fmt.Errorf("save job: %w", err)
+						}
+					}
+
+					if tmpDir != "" && !isRemote {
+						if err := sm.SetChunksDir(chunksDirUnder(tmpDir, output)); err != nil {
+							return nil, err
+						}
+					}
+				}
+				if !dryRun {
+					path, closeLog, err := openRunLog(sm, logPolicy)
+					if err != nil {
+						slog.Warn("Could not write run log", "error", err)
 					} else {
-						tracker.FileCompleted(fileID, fileName)
+						defer func() { closeLog(retErr) }()
+						slog.Debug("Writing run log", "file", path)
 					}
-				},
-				func(fileID, fileName string) { tracker.FileSkipped(fileID, fileName) },
-			)
+				}
 
-			if err := orch.Download(ctx, manifest); err != nil {
-				tracker.Stop()
-				return err
-			}
-			tracker.Stop()
+				// Resolve args into a manifest.
+				manifest, err := resolveManifest(ctx, apiClient, args, naming)
+				if err != nil {
+					return nil, err
+				}
 
-			// Fetch dataset metadata if applicable.
-			if !noMetadata && manifest.DatasetID != "" {
-				switch metadataFormat {
-				case "tsv", "csv", "json":
-				default:
-					fmt.Printf("Warning: unsupported metadata format %q, skipping metadata download\n", metadataFormat)
-					goto skipMeta
+				exclusionsMu.Lock()
+				err = excludeFileIDs(manifest, g.excluded, matchedExclusions)
+				exclusionsMu.Unlock()
+				if err != nil {
+					return nil, err
+				}
+				metas, err := attributes.load(ctx, apiClient, output, args, refreshMetadata, configFileMetadataToken(ctx, mgr, configFile))
+				if err != nil {
+					return nil, err
+				}
+				if err := attributes.apply(manifest, args, metas); err != nil {
+					return nil, err
+				}
+				if err := applyFilters(ctx, manifest, args, g.include, g.exclude, formats, withIndexes); err != nil {
+					return nil, err
 				}
 
-				var metaPassword string
-				if configFile != "" {
-					_, metaPassword, _ = loadConfigFile(configFile)
+				if interactive {
+					if err := pickManifestFiles(manifest); err != nil {
+						return nil, err
+					}
 				}
+				logManifest(ctx, manifest)
 
-				if metaPassword == "" {
-					fmt.Println("Warning: metadata download requires password (use --cf for automatic metadata). Skipping metadata.")
-				} else {
-					metaToken, metaErr := mgr.GetMetadataToken(ctx, metaPassword)
-					if metaErr != nil {
-						fmt.Printf("Warning: metadata auth failed (%v). Skipping metadata download.\n", metaErr)
-					} else {
-						metaDir := filepath.Join(output, manifest.DatasetID+"-metadata")
-						if metaErr = fetchAndWriteMetadata(ctx, apiClient, metaToken, manifest.DatasetID, metaDir, metadataFormat); metaErr != nil {
-							fmt.Printf("Warning: metadata download failed (%v). Files were downloaded successfully.\n", metaErr)
+				// Files uploaded or archived do not stay in the output directory.
+				localDir := output
+				if isRemote || archive != nil {
+					localDir = ""
+				}
+				if err := drs.add(manifest, localDir); err != nil {
+					return nil, err
+				}
+
+				if resumeOnly {
+					if err := keepInProgressFiles(manifest, sm); err != nil {
+						return nil, err
+					}
+					if len(manifest.Files) == 0 {
+						fmt.Fprintln(textOutput(cmd), "No in-progress files to resume.")
+						if jsonOutput {
+							return newJSONDownloadSummary(manifest, sm), nil
+						}
+						return nil, nil
+					}
+				}
+
+				if dryRun {
+					if jsonOutput {
+						return newJSONFileList(manifest.DatasetID, manifestFileInfo(manifest)), nil
+					}
+					printDryRun(cmd.OutOrStdout(), manifest, sm, restart)
+					return nil, nil
+				}
+
+				if index {
+					if err := indexer.checkIndexTools(manifest); err != nil {
+						return nil, err
+					}
+				}
+
+				slog.Info("Downloading files", "files", len(manifest.Files), "output_dir", output)
+
+				// Set up progress tracking.
+				renderer := progressRenderer(progressMode, parallel)
+				tracker := newProgressTracker(renderer, progressInterval)
+				for _, f := range manifest.Files {
+					tracker.RegisterFile(f.FileID, f.FileName, f.Size)
+					// Resumed files start from the bytes already on disk.
+					if fs, err := sm.LoadFileState(f.FileID); err == nil && fs != nil && fs.Status != state.StatusComplete {
+						tracker.FileResumed(f.FileID, fs.BytesDownloaded())
+					}
+				}
+				progress.addManifest(manifest, sm)
+				status.addManifest(manifest, sm)
+
+				recorder := newSessionRecorder(manifest, sm)
+				saveReport := func(err error) {
+					paths, reportErr := writeReports(sm, recorder.build(manifest, sm, err), reports)
+					if reportErr != nil {
+						slog.Warn("Could not write download report", "error", reportErr)
+					}
+					for _, path := range paths {
+						slog.Info("Wrote download report", "file", path)
+					}
+				}
+
+				orch := download.NewOrchestrator(apiClient, sm, groupOpts)
+				if renderer == ui.RendererChunks {
+					tracker.ShowChunks(progressFile)
+					orch.SetChunkCallback(tracker.ChunkProgress)
+				}
+				orch.SetProgressCallback(func(fileID string, bytesDownloaded, totalBytes int64) {
+					tracker.UpdateProgress(fileID, bytesDownloaded, totalBytes)
+					progress.bytes(output, fileID, bytesDownloaded)
+					status.bytes(output, fileID, bytesDownloaded)
+				})
+				orch.SetFileCallbacks(
+					func(fileID, fileName string) {
+						slog.Debug("Downloading file", "file", fileName, "file_id", fileID)
+						tracker.FileStarted(fileID, fileName)
+						progress.fileStarted(output, fileID)
+					},
+					func(fileID, fileName string, err error) {
+						if err != nil {
+							// The progress display already reports failures.
+							level := slog.LevelWarn
+							if renderer != ui.RendererNone {
+								level = slog.LevelDebug
+							}
+							slog.Log(ctx, level, "File failed", "file", fileName, "file_id", fileID, "error", err)
+							tracker.FileFailed(fileID, fileName, err)
+							notifier.fileFailed(output, fileID, fileName, err)
+							progress.fileFailed(output, fileID, err)
+						} else {
+							slog.Debug("Completed file", "file", fileName, "file_id", fileID)
+							tracker.FileCompleted(fileID, fileName)
+							progress.fileCompleted(output, fileID, false)
+						}
+					},
+					func(fileID, fileName string) {
+						slog.Debug("Skipped file (already complete)", "file", fileName, "file_id", fileID)
+						if archive != nil {
+							slog.Warn("Skipped file is not in the tar archive", "file", fileName, "file_id", fileID)
 						}
+						tracker.FileSkipped(fileID, fileName)
+						recorder.fileSkipped(fileID)
+						progress.fileCompleted(output, fileID, true)
+					},
+				)
+
+				if err := orch.Download(ctx, manifest); err != nil {
+					tracker.Stop()
+					printRunStats(cmd, tracker, sm)
+					summary := newJSONDownloadSummary(manifest, sm)
+					summary.Error = err.Error()
+					notifier.addSummary(summary)
+					progress.runSummary(summary, err)
+					saveReport(err)
+					// Failures without a more specific class exit as partial
+					// when some files made it.
+					if summary.Complete > 0 && classifyError(err) == exitFailure {
+						err = withExitCode(exitPartial, fmt.Errorf("%d of %d file(s) complete: %w", summary.Complete, len(manifest.Files), err))
 					}
+					return summary, err
+				}
+				tracker.Stop()
+				printRunStats(cmd, tracker, sm)
+				saveReport(nil)
+				if notifier != nil || progress != nil {
+					summary := newJSONDownloadSummary(manifest, sm)
+					notifier.addSummary(summary)
+					progress.runSummary(summary, nil)
+				}
+				if files, chunks, ok := orch.TunedParallelism(); ok {
+					slog.Info("Auto-tuned parallelism", "parallel_files", files, "parallel_chunks", chunks)
 				}
+
+				var metadataDir string
+
+				// Fetch dataset metadata if applicable.
+				if !noMetadata && manifest.DatasetID != "" {
+					switch metadataFormat {
+					case "tsv", "csv", "json", "ndjson":
+					default:
+						slog.Warn("Unsupported metadata format, skipping metadata download", "format", metadataFormat)
+						goto skipMeta
+					}
+
+					metaDir := filepath.Join(output, manifest.DatasetID+"-metadata")
+					// One host of a shared download exports the metadata.
+					if shared {
+						release, holder, err := holdClaim(sm, manifest.DatasetID+"-metadata")
+						if err != nil {
+							slog.Warn("Metadata download failed; files were downloaded successfully", "dataset", manifest.DatasetID, "error", err)
+							goto skipMeta
+						}
+						if release == nil {
+							slog.Info("Metadata is being exported by another host", "host", holder.Host, "pid", holder.PID)
+							goto skipMeta
+						}
+						defer release()
+					}
+					meta, metaErr := cachedOrFetchMetadata(ctx, apiClient, metaDir, manifest.DatasetID, refreshMetadata, configFileMetadataToken(ctx, mgr, configFile))
+					if metaErr == nil {
+						metaErr = writeMetadataExport(meta, manifest.DatasetID, metaDir, metadataFormat, defaultMergeOptions(), nil)
+					}
+					if metaErr == nil && uploader != nil {
+						metaErr = uploader.UploadDir(ctx, metaDir, manifest.DatasetID+"-metadata")
+					}
+					if metaErr == nil {
+						metadataDir = metaDir
+						slog.Info("Metadata saved", "dir", metaDir)
+					}
+					switch {
+					case errors.Is(metaErr, errMetadataPasswordRequired):
+						slog.Warn("metadata download requires password (use --cf for automatic metadata). Skipping metadata.")
+					case metaErr != nil:
+						slog.Warn("Metadata download failed; files were downloaded successfully", "dataset", manifest.DatasetID, "error", metaErr)
+					}
+				}
+			skipMeta:
+
+				fmt.Fprintln(textOutput(cmd), "Download complete!")
+				if jsonOutput {
+					summary := newJSONDownloadSummary(manifest, sm)
+					summary.MetadataDir = metadataDir
+					return summary, nil
+				}
+				return nil, nil
 			}
-		skipMeta:
 
-			fmt.Println("\nDownload complete!")
+			if batchFile != "" {
+				results, err := runBatch(ctx, groups, parallel, downloadTo)
+				if jsonOutput {
+					printDownloadResults(cmd, results, len(groups))
+				}
+				if err != nil {
+					return err
+				}
+				warnUnmatchedExclusions(allExcluded, matchedExclusions)
+				return nil
+			}
+
+			var results []interface{}
+			for _, g := range groups {
+				result, err := downloadTo(g)
+				if result != nil {
+					results = append(results, result)
+				}
+				if err != nil {
+					if jsonOutput {
+						printDownloadResults(cmd, results, len(groups))
+					}
+					return err
+				}
+			}
+			warnUnmatchedExclusions(allExcluded, matchedExclusions)
+			if jsonOutput {
+				return printDownloadResults(cmd, results, len(groups))
+			}
 			return nil
 		},
 	}
 
-	cmd.Flags().StringVarP(&output, "output", "o", ".", "Output directory")
+	cmd.Flags().StringVarP(&output, "output", "o", ".", "Output directory, rclone:REMOTE:PATH to upload each file to an rclone remote, or s3:// or gs://BUCKET/PREFIX")
 	cmd.Flags().IntVar(&parallelFiles, "parallel-files", 4, "Number of files to download in parallel")
 	cmd.Flags().IntVar(&parallelChunks, "parallel-chunks", 8, "Number of chunks per file to download in parallel")
 	cmd.Flags().StringVar(&chunkSize, "chunk-size", "64M", "Size of each chunk (e.g., 64M, 128M)")
 	cmd.Flags().BoolVar(&restart, "restart", false, "Force fresh download, removing any existing progress")
+	cmd.Flags().StringVar(&ifExists, "if-exists", download.IfExistsVerify, "What to do with output files that exist without download state (verify, skip, overwrite, rename)")
+	cmd.Flags().BoolVar(&md5Files, "md5-files", false, "Also write a .md5 file next to each downloaded file, besides MD5SUMS and SHA256SUMS")
+	cmd.Flags().BoolVar(&index, "index", false, "Index verified BAM, CRAM, VCF (bgzip), and BCF files (.bai, .crai, .tbi, .csi) with samtools, tabix, or bcftools")
+	cmd.Flags().StringVar(&indexer.samtools, "samtools", "samtools", "samtools command used by --index for BAM and CRAM files")
+	cmd.Flags().StringVar(&indexer.tabix, "tabix", "tabix", "tabix command used by --index for VCF files")
+	cmd.Flags().StringVar(&indexer.bcftools, "bcftools", "bcftools", "bcftools command used by --index for BCF files")
 	cmd.Flags().StringVar(&configFile, "cf", "", "JSON config file with credentials")
 	cmd.Flags().StringVar(&configFile, "config-file", "", "JSON config file with credentials (alias for --cf)")
 	cmd.Flags().BoolVar(&noMetadata, "no-metadata", false, "Skip downloading dataset metadata")
-	cmd.Flags().StringVar(&metadataFormat, "metadata-format", "tsv", "Metadata output format (tsv, csv, json)")
+	cmd.Flags().StringVar(&metadataFormat, "metadata-format", "tsv", "Metadata output format (tsv, csv, json, ndjson)")
+	cmd.Flags().BoolVar(&refreshMetadata, "refresh-metadata", false, "Re-fetch metadata even if a fresh cached copy exists")
 	cmd.Flags().StringVar(&maxBandwidth, "max-bandwidth", "", "Global bandwidth limit (e.g., 100M, 1G)")
 	cmd.Flags().StringSliceVar(&includePatterns, "include", nil, "Glob patterns to include (matched against file name)")
 	cmd.Flags().StringSliceVar(&excludePatterns, "exclude", nil, "Glob patterns to exclude (matched against file name)")
+	cmd.Flags().StringSliceVar(&formats, "format", nil, "File formats to include, e.g. BAM,CRAM,VCF (matched against the file extension)")
+	cmd.Flags().BoolVar(&withIndexes, "with-indexes", false, "Also include index files (.bai, .crai, .tbi, .csi) of the selected files")
+	cmd.Flags().BoolVar(&preservePaths, "preserve-paths", false, "Keep the directories of EGA file names in the output directory, instead of a directory per EGAF accession")
+	cmd.Flags().BoolVar(&flatten, "flatten", false, "Put every file directly in the output directory, instead of a directory per EGAF accession")
+	addAttributeFilterFlags(cmd, &attributes)
+	cmd.Flags().StringSliceVar(&excludeIDs, "exclude-id", nil, "File IDs (EGAF...) to skip (repeatable)")
+	cmd.Flags().StringArrayVar(&excludeFiles, "exclude-file", nil, "Text file of file IDs to skip, one per line (repeatable)")
 	cmd.Flags().BoolVar(&adaptiveChunks, "adaptive-chunks", false, "Auto-adjust chunk size based on throughput")
+	cmd.Flags().BoolVar(&autoTune, "auto-tune", false, "Adjust parallel files and chunks at runtime based on throughput, up to --parallel-files/--parallel-chunks")
+	cmd.Flags().StringVar(&bufferSize, "buffer-size", "1M", "Read buffer of each chunk download (4K to 64M); larger buffers mean fewer system calls at high parallelism")
+	cmd.Flags().StringVar(&maxMemory, "max-memory", "", "Cap the I/O buffers of downloads in flight (e.g. 256M), lowering --parallel-chunks, then --parallel-files, to fit")
+	cmd.Flags().StringVar(&smallFileSize, "small-file-size", "8M", "Download files up to this size in one request, without chunks (0 = never)")
+	cmd.Flags().DurationVar(&stallTimeout, "stall-timeout", 60*time.Second, "Retry a chunk whose response delivers no data for this long")
+	cmd.Flags().DurationVar(&drain, "drain", 0, "On interrupt, finish the chunks in flight for up to this long before stopping (0 = stop at once)")
+	cmd.Flags().DurationVar(&dnsCacheTTL, "dns-cache-ttl", 5*time.Minute, "How long to reuse resolved server addresses, for slow or rate-limited DNS resolvers (0 = resolve every connection)")
+	cmd.Flags().DurationVar(&dialKeepAlive, "dial-keep-alive", 30*time.Second, "Interval of TCP keep-alive probes on connections (0 = off)")
+	cmd.Flags().DurationVar(&dialFallbackDelay, "dial-fallback-delay", 300*time.Millisecond, "Time to wait for an IPv6 connection before also trying IPv4 (0 = try addresses one at a time)")
+	cmd.Flags().BoolVar(&noHedge, "no-hedge", false, "Never send a second request for a chunk far slower than the rest near the end of a file")
+	cmd.Flags().IntVar(&stripeCount, "stripe-count", 0, "On Lustre, stripe output files of 1 GiB or more over this many OSTs (-1 = all, 0 = filesystem default)")
+	cmd.Flags().StringVar(&stripeSize, "stripe-size", "", "On Lustre, stripe size of output files of 1 GiB or more, a multiple of 64K (e.g. 4M; default: filesystem default)")
+	cmd.Flags().BoolVar(&directIO, "direct-io", false, "Write chunk and output files with O_DIRECT, bypassing the page cache (Linux; for Lustre/GPFS)")
+	cmd.Flags().BoolVar(&resumeOnly, "resume-only", false, "Only resume files with existing progress; never start new files")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the files that would be downloaded and exit")
+	cmd.Flags().BoolVarP(&interactive, "interactive", "i", false, "Choose datasets and files from an interactive list")
+	cmd.Flags().StringArrayVar(&fromFiles, "from-file", nil, "Read identifiers from a text file, one per line (\"-\" for stdin; repeatable)")
+	cmd.Flags().StringVar(&batchFile, "batch", "", "YAML/JSON file listing several downloads, each with its own options")
+	cmd.Flags().StringSliceVar(&webhooks, "webhook", nil, "URL to notify of file failures and the end of the run (repeatable)")
+	cmd.Flags().StringVar(&webhookFormat, "webhook-format", notify.FormatAuto, "Webhook payload format (auto, json, slack, teams)")
+	cmd.Flags().BoolVar(&noNotify, "no-notify", false, "Do not show a desktop notification when the download ends")
+	cmd.Flags().StringSliceVar(&endpoints, "endpoint", nil, "Data API base URL to download from, e.g. a Federated EGA node (repeatable; failed over between in order)")
+	cmd.Flags().StringVar(&drsManifestPath, "drs-manifest", "", "Write the resolved files as a GA4GH DRS manifest (JSON) to this file")
+	cmd.Flags().StringVar(&tarPath, "tar", "", "Write verified files to this tar archive (\"-\" for stdout), keeping only files in progress in --output")
+	cmd.Flags().BoolVar(&shared, "shared", false, "Share the output directory with downloads on other hosts, each file downloaded by one of them")
+	cmd.Flags().StringVar(&tmpDir, "tmp-dir", "", "Keep chunk files under this directory (e.g. fast local disk) instead of the output directory")
+	cmd.Flags().StringVar(&fileMode, "file-mode", "", "Octal permissions for downloaded files, e.g. 0640")
+	cmd.Flags().StringVar(&dirMode, "dir-mode", "", "Octal permissions for output directories, e.g. 2750")
+	cmd.Flags().StringVar(&group, "group", "", "Group to own downloaded files and directories (name or ID)")
+	cmd.Flags().StringVar(&progressMode, "progress", ui.RendererAuto, "Progress display (auto, ansi, plain, chunks, none); auto draws the live display only on a terminal")
+	cmd.Flags().DurationVar(&progressInterval, "progress-interval", 30*time.Second, "How often plain progress prints a summary line of the whole download (0 = never)")
+	cmd.Flags().StringVar(&progressFile, "progress-file", "", "File ID (EGAF...) whose chunks --progress chunks lists (default: the active file furthest behind)")
+	cmd.Flags().StringVar(&progressJSON, "progress-json", "", "Write progress events as NDJSON to stdout, or to a file or named pipe with --progress-json=PATH")
+	cmd.Flags().Lookup("progress-json").NoOptDefVal = "-"
+	cmd.Flags().StringVar(&statusListen, "status-listen", "", "Serve the live download state as JSON and an HTML page on this address, e.g. 127.0.0.1:8642")
+	cmd.Flags().StringSliceVar(&reports, "report", []string{"md", "html"}, "Formats of the end-of-run report in .egafetch/reports/ (md, html, none)")
 
 	return cmd
 }
 
+// downloadConfigFlags maps download flags to their config.yaml keys.
+var downloadConfigFlags = map[string]string{
+	"chunk-size":          "chunk_size",
+	"parallel-files":      "parallel_files",
+	"parallel-chunks":     "parallel_chunks",
+	"max-bandwidth":       "max_bandwidth",
+	"output":              "output_dir",
+	"metadata-format":     "metadata_format",
+	"webhook":             "webhook",
+	"webhook-format":      "webhook_format",
+	"tmp-dir":             "tmp_dir",
+	"file-mode":           "file_mode",
+	"dir-mode":            "dir_mode",
+	"group":               "group",
+	"progress":            "progress",
+	"progress-interval":   "progress_interval",
+	"endpoint":            "endpoints",
+	"buffer-size":         "buffer_size",
+	"max-memory":          "max_memory",
+	"small-file-size":     "small_file_size",
+	"stall-timeout":       "stall_timeout",
+	"drain":               "drain",
+	"stripe-count":        "stripe_count",
+	"stripe-size":         "stripe_size",
+	"dns-cache-ttl":       "dns_cache_ttl",
+	"dial-keep-alive":     "dial_keep_alive",
+	"dial-fallback-delay": "dial_fallback_delay",
+}
+
+// printRunStats prints the end-of-run table of the files tracker saw,
+// unless quiet. Retries come from the saved state of each file.
+func printRunStats(cmd *cobra.Command, tracker *ui.ProgressTracker, sm *state.StateManager) {
+	if quiet {
+		return
+	}
+	stats := tracker.Stats()
+	for i := range stats {
+		if fs, err := sm.LoadFileState(stats[i].FileID); err == nil && fs != nil {
+			stats[i].Retries = fs.RetryCount
+		}
+	}
+	// Write the table at once, so parallel batch entries do not interleave.
+	var b strings.Builder
+	ui.PrintRunStats(&b, stats)
+	fmt.Fprint(textOutput(cmd), b.String())
+}
+
+// newProgressTracker returns the tracker drawing progress with renderer.
+// Plain progress adds a summary line every interval.
+func newProgressTracker(renderer string, interval time.Duration) *ui.ProgressTracker {
+	switch renderer {
+	case ui.RendererANSI, ui.RendererChunks:
+		return ui.NewProgressTracker()
+	case ui.RendererPlain:
+		return ui.NewPlainProgressTracker(os.Stderr, interval)
+	}
+	return ui.NewSilentProgressTracker()
+}
+
+// chunksDirUnder returns the chunk directory for outputDir under --tmp-dir:
+// one directory per output directory, so downloads sharing a temporary
+// directory do not collide.
+func chunksDirUnder(tmpDir, outputDir string) string {
+	if abs, err := filepath.Abs(outputDir); err == nil {
+		outputDir = abs
+	}
+	sum := sha256.Sum256([]byte(outputDir))
+	return filepath.Join(tmpDir, "egafetch-"+hex.EncodeToString(sum[:])[:12])
+}
+
+// outputGroup is a set of identifiers downloaded into the same directory.
+type outputGroup struct {
+	dir  string
+	args []string
+
+	// Set per batch entry (see loadBatch), or from the flags otherwise.
+	name             string
+	include, exclude []string
+	excluded         map[string]bool
+}
+
+// groupByOutputDir splits identifiers of the form ID=DIR from plain ones,
+// which go to defaultDir, and groups them by directory in first-seen order.
+func groupByOutputDir(args []string, defaultDir string) ([]outputGroup, error) {
+	var groups []outputGroup
+	index := make(map[string]int)
+	add := func(dir, id string) {
+		i, ok := index[dir]
+		if !ok {
+			i = len(groups)
+			index[dir] = i
+			groups = append(groups, outputGroup{dir: dir})
+		}
+		groups[i].args = append(groups[i].args, id)
+	}
+
+	for _, arg := range args {
+		id, dir, mapped := strings.Cut(arg, "=")
+		id, dir = strings.TrimSpace(id), strings.TrimSpace(dir)
+		if !mapped {
+			add(filepath.Clean(defaultDir), id)
+			continue
+		}
+		if dir == "" {
+			return nil, fmt.Errorf("missing output directory in %q (expected ID=DIR)", arg)
+		}
+		add(filepath.Clean(dir), id)
+	}
+	if len(groups) == 0 {
+		groups = append(groups, outputGroup{dir: defaultDir})
+	}
+	return groups, nil
+}
+
+// printDownloadResults prints one JSON result per output directory: a single
+// document for one directory, or an array when identifiers were mapped to
+// several.
+func printDownloadResults(cmd *cobra.Command, results []interface{}, groups int) error {
+	if groups == 1 && len(results) == 1 {
+		return printJSON(cmd, results[0])
+	}
+	if results == nil {
+		results = []interface{}{}
+	}
+	return printJSON(cmd, results)
+}
+
+// keepInProgressFiles drops manifest files that have no download state in
+// sm, or are already complete, so only interrupted or failed files remain.
+func keepInProgressFiles(manifest *state.Manifest, sm *state.StateManager) error {
+	before := len(manifest.Files)
+	kept := manifest.Files[:0]
+	for _, f := range manifest.Files {
+		fs, err := sm.LoadFileState(f.FileID)
+		if err != nil {
+			return fmt.Errorf("load state for %s: %w", f.FileID, err)
+		}
+		if fs != nil && !fs.IsComplete() {
+			kept = append(kept, f)
+		}
+	}
+	manifest.Files = kept
+	if len(kept) > 0 {
+		slog.Info("Resume only: keeping files with progress to resume", "files", len(kept), "total", before)
+	}
+	return nil
+}
+
+// manifestFileInfo returns display information for the manifest's files.
+func manifestFileInfo(manifest *state.Manifest) []ui.FileInfo {
+	files := make([]ui.FileInfo, 0, len(manifest.Files))
+	for _, f := range manifest.Files {
+		files = append(files, ui.FileInfo{
+			FileID:       f.FileID,
+			FileName:     f.FileName,
+			FileSize:     f.Size,
+			Checksum:     f.Checksum,
+			ChecksumType: f.ChecksumType,
+		})
+	}
+	return files
+}
+
+// printDryRun prints to w the files a download would fetch, with sizes and
+// totals, noting files already complete in the output directory unless
+// restart is set.
+func printDryRun(w io.Writer, manifest *state.Manifest, sm *state.StateManager, restart bool) {
+	files := manifestFileInfo(manifest)
+	fmt.Fprintf(w, "Dry run: %d file(s) would be downloaded to %s\n", len(files), sm.BaseDir())
+	ui.PrintDatasetFiles(w, files)
+	if restart {
+		return
+	}
+	if est := estimateDownload(manifest, sm); est.CompleteFiles > 0 {
+		fmt.Fprintf(w, "%d file(s) (%s) already complete; %s left to download.\n\n",
+			est.CompleteFiles, ui.FormatBytes(est.CompleteBytes), ui.FormatBytes(est.TotalBytes-est.CompleteBytes))
+	}
+}
+
 // expandArgs expands CLI args: EGAD/EGAF identifiers pass through unchanged,
-// anything else is treated as a file containing one identifier per line.
-// Blank lines and lines starting with # are ignored.
+// "-" reads identifiers from stdin, and anything else is treated as a file
+// containing one identifier per line (see readIdentifiers). Identifiers may
+// carry an output directory as ID=DIR (see groupByOutputDir).
 func expandArgs(args []string) ([]string, error) {
 	var expanded []string
 	for _, arg := range args {
@@ -400,34 +1242,108 @@ func expandArgs(args []string) ([]string, error) {
 			expanded = append(expanded, arg)
 			continue
 		}
+		if arg == "-" {
+			ids, err := readIdentifiers(os.Stdin, "stdin")
+			if err != nil {
+				return nil, err
+			}
+			expanded = append(expanded, ids...)
+			continue
+		}
 		f, err := os.Open(arg)
 		if err != nil {
 			return nil, fmt.Errorf("cannot open identifier file %q: %w", arg, err)
 		}
-		scanner := bufio.NewScanner(f)
-		lineNum := 0
-		for scanner.Scan() {
-			lineNum++
-			line := strings.TrimSpace(scanner.Text())
-			if line == "" || strings.HasPrefix(line, "#") {
-				continue
-			}
-			if !strings.HasPrefix(line, "EGAD") && !strings.HasPrefix(line, "EGAF") {
-				f.Close()
-				return nil, fmt.Errorf("%s:%d: unrecognized identifier %q (expected EGAD... or EGAF...)", arg, lineNum, line)
-			}
-			expanded = append(expanded, line)
-		}
+		ids, err := readIdentifiers(f, arg)
 		f.Close()
-		if err := scanner.Err(); err != nil {
-			return nil, fmt.Errorf("read identifier file %q: %w", arg, err)
+		if err != nil {
+			return nil, err
 		}
+		expanded = append(expanded, ids...)
 	}
 	return expanded, nil
 }
 
+// readIdentifiers reads one EGAD/EGAF identifier per line from r. Blank
+// lines and # comments (whole-line or trailing) are ignored; name is used
+// in error messages.
+func readIdentifiers(r io.Reader, name string) ([]string, error) {
+	var ids []string
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "EGAD") && !strings.HasPrefix(line, "EGAF") {
+			return nil, fmt.Errorf("%s:%d: unrecognized identifier %q (expected EGAD... or EGAF...)", name, lineNum, line)
+		}
+		ids = append(ids, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read identifier file %q: %w", name, err)
+	}
+	return ids, nil
+}
+
+// datasetFileSpec converts a dataset file listing entry into a download spec.
+func datasetFileSpec(f *api.DatasetFile, layout state.Layout) state.FileSpec {
+	checksum, checksumType := f.GetChecksum()
+	name, original := outputFileName(f.FileID, f.FileName, layout)
+	return state.FileSpec{
+		FileID:       f.FileID,
+		FileName:     name,
+		OriginalName: original,
+		Size:         f.DownloadSize(),
+		Checksum:     checksum,
+		ChecksumType: checksumType,
+	}
+}
+
+// sanitizeFileNames is set on platforms where EGA file names may not be
+// valid as they are.
+var sanitizeFileNames = runtime.GOOS == "windows"
+
+// pathLayout returns the layout of output paths that --preserve-paths and
+// --flatten choose.
+func pathLayout(preservePaths, flatten bool) (state.Layout, error) {
+	switch {
+	case preservePaths && flatten:
+		return state.LayoutByFile, fmt.Errorf("--preserve-paths cannot be combined with --flatten")
+	case preservePaths:
+		return state.LayoutPreserve, nil
+	case flatten:
+		return state.LayoutFlat, nil
+	}
+	return state.LayoutByFile, nil
+}
+
+// outputFileName returns the path of a file in the output directory under
+// layout, by default EGAF.../name, from its EGA file name. If the name had
+// to change to be valid on this platform, original is the EGA base name.
+func outputFileName(fileID, egaName string, layout state.Layout) (name, original string) {
+	name, original = state.OutputPath(fileID, egaName, layout, sanitizeFileNames)
+	if original != "" {
+		slog.Debug("Renamed file to be valid on this platform", "file_id", fileID, "ega_name", original, "file", filepath.Base(name))
+	}
+	return name, original
+}
+
+// outputNaming is how resolveManifest names the output files: their layout,
+// and the files the download writes besides them, which they must not take.
+type outputNaming struct {
+	layout    state.Layout
+	generated state.GeneratedNames
+}
+
 // resolveManifest takes CLI args (dataset IDs, file IDs, or identifier files) and builds a manifest.
-func resolveManifest(ctx context.Context, apiClient *api.Client, args []string) (*state.Manifest, error) {
+func resolveManifest(ctx context.Context, apiClient *api.Client, args []string, naming outputNaming) (*state.Manifest, error) {
 	// Expand any file-path args into individual identifiers.
 	ids, err := expandArgs(args)
 	if err != nil {
@@ -438,44 +1354,40 @@ func resolveManifest(ctx context.Context, apiClient *api.Client, args []string)
 		CreatedAt: time.Now(),
 	}
 
-	for _, arg := range ids {
+	spinner := startSpinner("Resolving identifiers")
+	defer spinner.Stop()
+	for i, arg := range ids {
+		if len(ids) > 1 {
+			spinner.Updatef("Resolving identifiers (%d/%d, %d files)", i+1, len(ids), len(manifest.Files))
+		}
 		if strings.HasPrefix(arg, "EGAD") {
 			// Dataset ID — fetch file list.
 			manifest.DatasetID = arg
-			fmt.Printf("Fetching file list for dataset %s...\n", arg)
-			files, err := apiClient.ListDatasetFiles(ctx, arg)
+			slog.InfoContext(ctx, "Fetching file list...", "dataset", arg)
+			if len(ids) == 1 {
+				spinner.Updatef("Fetching file list of %s", arg)
+			}
+			files, err := listDatasetFiles(ctx, apiClient, arg)
 			if err != nil {
 				return nil, fmt.Errorf("list dataset %s: %w", arg, err)
 			}
 			for i := range files {
-				checksum, checksumType := files[i].GetChecksum()
-				// Use EGAF accession ID as directory instead of the API path (EGAZ...).
-				// Strip .cip extension — EGA serves decrypted content in plain mode.
-				baseName := strings.TrimSuffix(filepath.Base(files[i].FileName), ".cip")
-				outputName := filepath.Join(files[i].FileID, baseName)
-				manifest.Files = append(manifest.Files, state.FileSpec{
-					FileID:       files[i].FileID,
-					FileName:     outputName,
-					Size:         files[i].FileSize - 16, // IV stripped in plain mode
-					Checksum:     checksum,
-					ChecksumType: checksumType,
-				})
+				manifest.Files = append(manifest.Files, datasetFileSpec(&files[i], naming.layout))
 			}
 		} else if strings.HasPrefix(arg, "EGAF") {
 			// Individual file ID — fetch metadata.
-			fmt.Printf("Fetching metadata for %s...\n", arg)
+			slog.InfoContext(ctx, "Fetching file metadata...", "file_id", arg)
 			meta, err := apiClient.GetFileMetadata(ctx, arg)
 			if err != nil {
 				return nil, fmt.Errorf("get metadata for %s: %w", arg, err)
 			}
 			checksum, checksumType := meta.GetChecksum()
-			// Strip .cip extension — EGA serves decrypted content in plain mode.
-			baseName := strings.TrimSuffix(filepath.Base(meta.FileName), ".cip")
-			outputName := filepath.Join(meta.FileID, baseName)
+			name, original := outputFileName(meta.FileID, meta.FileName, naming.layout)
 			manifest.Files = append(manifest.Files, state.FileSpec{
 				FileID:       meta.FileID,
-				FileName:     outputName,
-				Size:         meta.FileSize - 16, // IV stripped in plain mode
+				FileName:     name,
+				OriginalName: original,
+				Size:         meta.DownloadSize(),
 				Checksum:     checksum,
 				ChecksumType: checksumType,
 			})
@@ -487,6 +1399,7 @@ func resolveManifest(ctx context.Context, apiClient *api.Client, args []string)
 	if len(manifest.Files) == 0 {
 		return nil, fmt.Errorf("no files found for the given identifiers")
 	}
+	manifest.Files = state.UniqueFileNames(manifest.Files, naming.generated)
 
 	return manifest, nil
 }
@@ -495,12 +1408,18 @@ func resolveManifest(ctx context.Context, apiClient *api.Client, args []string)
 
 func newListCmd() *cobra.Command {
 	var configFile string
+	var dir string
 
 	cmd := &cobra.Command{
-		Use:   "list [EGAD...]",
-		Short: "List authorized datasets, or files in a dataset",
-		Args:  cobra.MaximumNArgs(1),
+		Use:               "list [EGAD...]",
+		ValidArgsFunction: completeDatasetIDs(true),
+		Short:             "List authorized datasets, or files in a dataset",
+		Args:              cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := applyConfig(cmd, map[string]string{"dir": "output_dir"}); err != nil {
+				return err
+			}
+
 			mgr, err := auth.NewManager()
 			if err != nil {
 				return err
@@ -517,14 +1436,17 @@ func newListCmd() *cobra.Command {
 
 			if len(args) == 0 {
 				// No dataset ID — list all authorized datasets.
-				fmt.Println("Fetching authorized datasets...")
-				datasets, err := apiClient.ListDatasets(ctx)
+				slog.Info("Fetching authorized datasets...")
+				spinner := startSpinner("Fetching authorized datasets")
+				defer spinner.Stop()
+				datasets, err := listDatasets(ctx, apiClient)
 				if err != nil {
 					return err
 				}
 				// Fetch rich details from the public metadata API.
 				summaries := make([]ui.DatasetSummary, len(datasets))
 				for i, d := range datasets {
+					spinner.Updatef("Fetching dataset details (%d/%d)", i+1, len(datasets))
 					summaries[i].DatasetID = d.DatasetID
 					details, err := apiClient.GetDatasetDetails(ctx, d.DatasetID)
 					if err == nil {
@@ -533,7 +1455,15 @@ func newListCmd() *cobra.Command {
 						summaries[i].NumSamples = details.NumSamples
 					}
 				}
-				ui.PrintDatasets(summaries)
+				spinner.Stop()
+				if jsonOutput {
+					out := make([]jsonDataset, len(summaries))
+					for i, d := range summaries {
+						out[i] = jsonDataset(d)
+					}
+					return printJSON(cmd, out)
+				}
+				ui.PrintDatasets(cmd.OutOrStdout(), summaries)
 				return nil
 			}
 
@@ -543,12 +1473,16 @@ func newListCmd() *cobra.Command {
 				return fmt.Errorf("expected dataset ID (EGAD...)")
 			}
 
-			fmt.Printf("Fetching files for dataset %s...\n", datasetID)
-			files, err := apiClient.ListDatasetFiles(ctx, datasetID)
+			slog.Info("Fetching file list...", "dataset", datasetID)
+			spinner := startSpinner("Fetching file list of " + datasetID)
+			files, err := listDatasetFiles(ctx, apiClient, datasetID)
+			spinner.Stop()
 			if err != nil {
 				return err
 			}
 
+			samples := localSampleAnnotations(dir, datasetID)
+
 			var displayFiles []ui.FileInfo
 			for i := range files {
 				checksum, checksumType := files[i].GetChecksum()
@@ -558,15 +1492,20 @@ func newListCmd() *cobra.Command {
 					FileSize:     files[i].FileSize,
 					Checksum:     checksum,
 					ChecksumType: checksumType,
+					Sample:       samples[files[i].FileID],
 				})
 			}
-			ui.PrintDatasetFiles(displayFiles)
+			if jsonOutput {
+				return printJSON(cmd, newJSONFileList(datasetID, displayFiles))
+			}
+			ui.PrintDatasetFiles(cmd.OutOrStdout(), displayFiles)
 			return nil
 		},
 	}
 
 	cmd.Flags().StringVar(&configFile, "cf", "", "JSON config file with credentials")
 	cmd.Flags().StringVar(&configFile, "config-file", "", "JSON config file with credentials (alias for --cf)")
+	cmd.Flags().StringVarP(&dir, "dir", "d", ".", "Directory with cached or exported metadata, used to show each file's sample")
 
 	return cmd
 }
@@ -577,13 +1516,24 @@ func newInfoCmd() *cobra.Command {
 	var configFile string
 
 	cmd := &cobra.Command{
-		Use:   "info EGAF...",
-		Short: "Show file metadata",
-		Args:  cobra.ExactArgs(1),
+		Use:               "info EGAF...|EGAD...",
+		ValidArgsFunction: completeAccessions(true, false),
+		Short:             "Show file or dataset metadata",
+		Long: `Show metadata for a file (EGAF...) or a dataset (EGAD...).
+
+Dataset information comes from the EGA public metadata API and does not
+require authentication.`,
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if strings.HasPrefix(args[0], "EGAD") {
+				ctx, cancel := signalContext()
+				defer cancel()
+				return printPublicDatasetInfo(ctx, cmd, api.NewClient(nil), args[0])
+			}
+
 			fileID := args[0]
 			if !strings.HasPrefix(fileID, "EGAF") {
-				return fmt.Errorf("expected file ID (EGAF...)")
+				return fmt.Errorf("expected file ID (EGAF...) or dataset ID (EGAD...)")
 			}
 
 			mgr, err := auth.NewManager()
@@ -606,12 +1556,28 @@ func newInfoCmd() *cobra.Command {
 			}
 
 			checksum, checksumType := meta.GetChecksum()
-			fmt.Printf("File ID:       %s\n", meta.FileID)
-			fmt.Printf("File Name:     %s\n", meta.FileName)
-			fmt.Printf("File Size:     %s (%d bytes)\n", ui.FormatBytes(meta.FileSize), meta.FileSize)
-			fmt.Printf("Checksum:      %s\n", checksum)
-			fmt.Printf("Checksum Type: %s\n", checksumType)
-			fmt.Printf("Status:        %s\n", meta.FileStatus)
+			if jsonOutput {
+				return printJSON(cmd, struct {
+					jsonFile
+					Status string `json:"status,omitempty"`
+				}{
+					jsonFile: jsonFile{
+						FileID:       meta.FileID,
+						FileName:     meta.FileName,
+						Size:         meta.FileSize,
+						Checksum:     checksum,
+						ChecksumType: checksumType,
+					},
+					Status: meta.FileStatus,
+				})
+			}
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "File ID:       %s\n", meta.FileID)
+			fmt.Fprintf(out, "File Name:     %s\n", meta.FileName)
+			fmt.Fprintf(out, "File Size:     %s (%d bytes)\n", ui.FormatBytes(meta.FileSize), meta.FileSize)
+			fmt.Fprintf(out, "Checksum:      %s\n", checksum)
+			fmt.Fprintf(out, "Checksum Type: %s\n", checksumType)
+			fmt.Fprintf(out, "Status:        %s\n", meta.FileStatus)
 			return nil
 		},
 	}
@@ -622,91 +1588,305 @@ func newInfoCmd() *cobra.Command {
 	return cmd
 }
 
+// printPublicDatasetInfo prints dataset details and entity counts from the
+// EGA public metadata API to cmd's output.
+func printPublicDatasetInfo(ctx context.Context, cmd *cobra.Command, apiClient *api.Client, datasetID string) error {
+	meta, err := apiClient.FetchPublicDatasetMetadata(ctx, datasetID)
+	if err != nil {
+		return err
+	}
+
+	numSamples := meta.Details.NumSamples
+	if numSamples == 0 {
+		numSamples = len(meta.Samples)
+	}
+	if jsonOutput {
+		return printJSON(cmd, map[string]interface{}{
+			"dataset_id":  datasetID,
+			"title":       meta.Details.Title,
+			"description": meta.Details.Description,
+			"num_samples": numSamples,
+			"studies":     len(meta.Studies),
+			"experiments": len(meta.Experiments),
+			"runs":        len(meta.Runs),
+			"analyses":    len(meta.Analyses),
+			"files":       len(meta.Files),
+		})
+	}
+
+	title := meta.Details.Title
+	if title == "" {
+		title = "-"
+	}
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Dataset ID:    %s\n", datasetID)
+	fmt.Fprintf(out, "Title:         %s\n", title)
+	fmt.Fprintf(out, "Samples:       %d\n", numSamples)
+	fmt.Fprintf(out, "Studies:       %d\n", len(meta.Studies))
+	fmt.Fprintf(out, "Experiments:   %d\n", len(meta.Experiments))
+	fmt.Fprintf(out, "Runs:          %d\n", len(meta.Runs))
+	fmt.Fprintf(out, "Analyses:      %d\n", len(meta.Analyses))
+	fmt.Fprintf(out, "Files:         %d\n", len(meta.Files))
+	if meta.Details.Description != "" {
+		fmt.Fprintf(out, "\n%s\n", meta.Details.Description)
+	}
+	return nil
+}
+
 // --- Metadata command ---
 
 func newMetadataCmd() *cobra.Command {
 	var format string
 	var output string
 	var configFile string
+	var public bool
+	var refresh bool
+	var all bool
+	var parallel int
+	var mergeBase string
+	var joinKeys []string
+	var joinType string
+	var xml bool
+	var fields []string
+	var stream bool
 
 	cmd := &cobra.Command{
-		Use:   "metadata EGAD...",
-		Short: "Download dataset metadata (TSV, CSV, or JSON)",
-		Args:  cobra.ExactArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			datasetID := args[0]
-			if !strings.HasPrefix(datasetID, "EGAD") {
-				return fmt.Errorf("expected dataset ID (EGAD...)")
+		Use:               "metadata EGAD... | --all",
+		ValidArgsFunction: completeDatasetIDs(false),
+		Short:             "Download dataset metadata (TSV, CSV, JSON, or NDJSON)",
+		Long: `Download dataset metadata from the EGA metadata API.
+
+By default the private metadata API is used, which requires EGA credentials
+and DAC-approved access to the dataset. With --public, only the public
+metadata API is queried: no login or password is needed, so datasets can be
+inspected before access has been approved.
+
+Fetched mappings are cached in .egafetch/metadata-cache in the output
+directory for 24 hours; use --refresh to query the API again.
+
+With several datasets (or --all for every authorized dataset), mappings are
+fetched concurrently and each dataset is written to {output}/{datasetID}-metadata,
+together with a combined merged table across all datasets in {output}.
+
+For very large datasets, --stream writes each mapping to NDJSON as it is
+decoded, keeping memory flat. The merged table, PEP files, and cache are
+skipped in this mode since they need every mapping in memory.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if all {
+				return cobra.NoArgs(cmd, args)
+			}
+			return cobra.MinimumNArgs(1)(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) (retErr error) {
+			defer func() {
+				if retErr == nil && output != "" {
+					fmt.Fprintf(cmd.OutOrStdout(), "Metadata saved to %s/\n", filepath.Clean(output))
+				}
+			}()
+			for _, id := range args {
+				if !strings.HasPrefix(id, "EGAD") {
+					return fmt.Errorf("expected dataset ID (EGAD...), got %q", id)
+				}
 			}
 
+			if stream && !cmd.Flags().Changed("format") {
+				format = "ndjson"
+			} else if err := applyConfig(cmd, map[string]string{"format": "metadata_format"}); err != nil {
+				return err
+			}
 			switch format {
-			case "tsv", "csv", "json":
+			case "tsv", "csv", "json", "ndjson":
 			default:
-				return fmt.Errorf("unsupported format %q (use tsv, csv, or json)", format)
+				return fmt.Errorf("unsupported format %q (use tsv, csv, json, or ndjson)", format)
+			}
+			if stream {
+				switch {
+				case format != "ndjson":
+					return fmt.Errorf("--stream writes NDJSON and cannot be combined with --format %s", format)
+				case public:
+					return fmt.Errorf("--stream requires the private metadata API and cannot be combined with --public")
+				case xml || len(fields) > 0:
+					return fmt.Errorf("--stream writes only the mapping files and cannot be combined with --xml or --fields")
+				}
 			}
 
-			if output == "" {
-				output = datasetID + "-metadata"
+			if parallel < 1 {
+				return fmt.Errorf("--parallel must be at least 1")
+			}
+
+			merge, err := parseMergeOptions(mergeBase, joinKeys, joinType)
+			if err != nil {
+				return err
+			}
+			fields = parseFields(fields)
+
+			ctx, cancel := signalContext()
+			defer cancel()
+
+			if public {
+				if all {
+					return fmt.Errorf("--all lists authorized datasets and cannot be combined with --public")
+				}
+				if xml {
+					return fmt.Errorf("--xml requires the private metadata API and cannot be combined with --public")
+				}
+				if len(fields) > 0 {
+					return fmt.Errorf("--fields selects merged metadata columns and cannot be combined with --public")
+				}
+				if len(args) == 1 {
+					if output == "" {
+						output = args[0] + "-metadata"
+					}
+					return fetchAndWritePublicMetadata(ctx, api.NewClient(nil), args[0], output, format)
+				}
+				if output == "" {
+					output = "metadata"
+				}
+				for _, id := range args {
+					dir := filepath.Join(output, id+"-metadata")
+					if err := fetchAndWritePublicMetadata(ctx, api.NewClient(nil), id, dir, format); err != nil {
+						return fmt.Errorf("%s: %w", id, err)
+					}
+				}
+				return nil
 			}
 
 			mgr, err := auth.NewManager()
 			if err != nil {
 				return err
 			}
+			apiClient := api.NewClient(mgr)
+
+			datasetIDs := args
+			if all {
+				if err := ensureAuth(ctx, mgr, configFile); err != nil {
+					return err
+				}
+				slog.Info("Fetching authorized datasets...")
+				datasets, err := listDatasets(ctx, apiClient)
+				if err != nil {
+					return err
+				}
+				for _, d := range datasets {
+					datasetIDs = append(datasetIDs, d.DatasetID)
+				}
+				if len(datasetIDs) == 0 {
+					return fmt.Errorf("no authorized datasets found")
+				}
+			}
+
+			token := onceToken(func() (string, error) {
+				return obtainMetadataToken(ctx, mgr, configFile)
+			})
+
+			if stream {
+				if len(datasetIDs) == 1 && !all {
+					if output == "" {
+						output = datasetIDs[0] + "-metadata"
+					}
+					return streamMetadataExport(ctx, apiClient, token, datasetIDs[0], output)
+				}
+				if output == "" {
+					output = "metadata"
+				}
+				for _, id := range datasetIDs {
+					slog.Info("Writing metadata", "dataset", id)
+					if err := streamMetadataExport(ctx, apiClient, token, id, filepath.Join(output, id+"-metadata")); err != nil {
+						return fmt.Errorf("%s: %w", id, err)
+					}
+				}
+				return nil
+			}
 
-			ctx, cancel := signalContext()
-			defer cancel()
-
-			// If config file provided, login to download API and read password for metadata API.
-			var metaPassword string
-			if configFile != "" {
-				username, password, err := loadConfigFile(configFile)
+			if len(datasetIDs) == 1 && !all {
+				if output == "" {
+					output = datasetIDs[0] + "-metadata"
+				}
+				meta, err := cachedOrFetchMetadata(ctx, apiClient, output, datasetIDs[0], refresh, token)
 				if err != nil {
 					return err
 				}
-				if err := mgr.Login(ctx, username, password); err != nil {
-					return fmt.Errorf("login from config file: %w", err)
-				}
-				metaPassword = password
-			} else {
-				if mgr.Username() == "" {
-					return fmt.Errorf("not authenticated; run 'egafetch auth login' first")
+				if err := writeMetadataExport(meta, datasetIDs[0], output, format, merge, fields); err != nil {
+					return err
 				}
-				// Metadata API uses a separate IdP — need password.
-				fmt.Print("EGA Password: ")
-				passwordBytes, err := term.ReadPassword(int(syscall.Stdin))
-				fmt.Println()
-				if err != nil {
-					return fmt.Errorf("read password: %w", err)
+				if xml {
+					return writeMetadataXML(ctx, apiClient, token, meta, output, refresh)
 				}
-				metaPassword = string(passwordBytes)
+				return nil
 			}
 
-			fmt.Printf("Authenticating with metadata API...\n")
-			metaToken, err := mgr.GetMetadataToken(ctx, metaPassword)
-			if err != nil {
-				return err
+			if output == "" {
+				output = "metadata"
 			}
-
-			apiClient := api.NewClient(mgr)
-			return fetchAndWriteMetadata(ctx, apiClient, metaToken, datasetID, output, format)
+			return fetchAndWriteMetadataBatch(ctx, apiClient, token, datasetIDs, output, format, merge, fields, xml, parallel, refresh)
 		},
 	}
 
-	cmd.Flags().StringVarP(&format, "format", "f", "tsv", "Output format (tsv, csv, json)")
-	cmd.Flags().StringVarP(&output, "output", "o", "", "Output directory (default: {datasetID}-metadata)")
+	cmd.Flags().StringVarP(&format, "format", "f", "tsv", "Output format (tsv, csv, json, ndjson)")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Output directory (default: {datasetID}-metadata, or metadata for several datasets)")
 	cmd.Flags().StringVar(&configFile, "cf", "", "JSON config file with credentials")
 	cmd.Flags().StringVar(&configFile, "config-file", "", "JSON config file with credentials (alias for --cf)")
+	cmd.Flags().BoolVar(&public, "public", false, "Use only the public metadata API (no authentication)")
+	cmd.Flags().BoolVar(&refresh, "refresh", false, "Re-fetch metadata even if a fresh cached copy exists")
+	cmd.Flags().BoolVar(&all, "all", false, "Fetch metadata for every authorized dataset")
+	cmd.Flags().IntVar(&parallel, "parallel", 4, "Number of datasets to fetch in parallel")
+	addMergeFlags(cmd, &mergeBase, &joinKeys, &joinType)
+	cmd.Flags().BoolVar(&xml, "xml", false, "Also download the original submitted XML documents")
+	cmd.Flags().StringSliceVar(&fields, "fields", nil, "Comma-separated columns to keep in the merged metadata file, in order")
+	cmd.Flags().BoolVar(&stream, "stream", false, "Stream mappings straight to NDJSON files without merging (for very large datasets)")
+
+	cmd.AddCommand(newMetadataSearchCmd())
 
 	return cmd
 }
 
+// obtainMetadataToken returns a Bearer token for the EGA metadata API. If
+// configFile is provided, it logs in to the download API and reuses the
+// password for the metadata IdP; otherwise the user is prompted for it.
+func obtainMetadataToken(ctx context.Context, mgr *auth.Manager, configFile string) (string, error) {
+	var metaPassword string
+	if configFile != "" {
+		username, password, err := loadConfigFile(configFile)
+		if err != nil {
+			return "", err
+		}
+		if err := mgr.Login(ctx, username, password); err != nil {
+			return "", fmt.Errorf("login from config file: %w", err)
+		}
+		metaPassword = password
+	} else {
+		if mgr.Username() == "" {
+			return "", auth.ErrNotAuthenticated
+		}
+		// Metadata API uses a separate IdP — need password.
+		fmt.Print("EGA Password: ")
+		passwordBytes, err := term.ReadPassword(int(syscall.Stdin))
+		fmt.Println()
+		if err != nil {
+			return "", fmt.Errorf("read password: %w", err)
+		}
+		metaPassword = string(passwordBytes)
+	}
+
+	slog.Info("Authenticating with metadata API...")
+	return mgr.GetMetadataToken(ctx, metaPassword)
+}
+
 // writeRecords writes a slice of maps to a file in the given format.
 func writeRecords(path, format string, records []map[string]interface{}) error {
-	if format == "json" {
+	return writeRecordsColumns(path, format, records, nil)
+}
+
+// writeRecordsColumns is like writeRecords but writes TSV/CSV columns in the
+// given order. A nil columns writes every column, sorted by name.
+func writeRecordsColumns(path, format string, records []map[string]interface{}, columns []string) error {
+	switch format {
+	case "json":
 		return writeJSON(path, records)
+	case "ndjson":
+		return writeNDJSON(path, records)
 	}
-	return writeDelimited(path, format, records)
+	return writeDelimited(path, format, records, columns)
 }
 
 // writeJSON writes records as a JSON array.
@@ -722,25 +1902,46 @@ func writeJSON(path string, records []map[string]interface{}) error {
 	return enc.Encode(records)
 }
 
-// writeDelimited writes records as TSV or CSV.
-func writeDelimited(path, format string, records []map[string]interface{}) error {
+// writeNDJSON writes records as newline-delimited JSON, one object per line.
+func writeNDJSON(path string, records []map[string]interface{}) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// writeDelimited writes records as TSV or CSV. A nil columns writes every
+// column, sorted by name.
+func writeDelimited(path, format string, records []map[string]interface{}, columns []string) error {
 	if len(records) == 0 {
 		// Write empty file.
 		return os.WriteFile(path, nil, 0644)
 	}
 
-	// Collect all column names in stable order.
-	colSet := make(map[string]struct{})
-	for _, rec := range records {
-		for k := range rec {
-			colSet[k] = struct{}{}
+	if columns == nil {
+		// Collect all column names in stable order.
+		colSet := make(map[string]struct{})
+		for _, rec := range records {
+			for k := range rec {
+				colSet[k] = struct{}{}
+			}
 		}
+		columns = make([]string, 0, len(colSet))
+		for k := range colSet {
+			columns = append(columns, k)
+		}
+		sort.Strings(columns)
 	}
-	columns := make([]string, 0, len(colSet))
-	for k := range colSet {
-		columns = append(columns, k)
-	}
-	sort.Strings(columns)
 
 	f, err := os.Create(path)
 	if err != nil {
@@ -798,65 +1999,178 @@ func formatValue(v interface{}) string {
 	}
 }
 
-// buildMergedMetadata merges study_experiment_run_sample with sample_file
-// on sample_accession_id to produce a single wide table.
-func buildMergedMetadata(meta *api.DatasetMetadata) []map[string]interface{} {
-	// Build a lookup from sample_accession_id → sample_file record.
-	sampleFileMap := make(map[string]map[string]interface{})
+// mergeOptions controls how buildMergedMetadata joins the mapping tables.
+type mergeOptions struct {
+	// Base selects the table(s) joined with sample_file: "auto" (every
+	// non-empty path), "sequencing", "analysis", or a mapping name.
+	Base string
+	// JoinKeys are the columns that must all be equal for a base record and
+	// a sample_file record to be joined.
+	JoinKeys []string
+	// Join is "left" (keep base records without a match) or "inner".
+	Join string
+}
+
+// defaultMergeOptions returns the options used when none are given: both
+// metadata paths, joined on sample_accession_id, keeping unmatched records.
+func defaultMergeOptions() mergeOptions {
+	return mergeOptions{Base: "auto", JoinKeys: []string{"sample_accession_id"}, Join: "left"}
+}
+
+// addMergeFlags registers the flags that configure the merged metadata table.
+func addMergeFlags(cmd *cobra.Command, base *string, joinKeys *[]string, join *string) {
+	cmd.Flags().StringVar(base, "merge-base", "auto", "Base table for the merged metadata (auto, sequencing, analysis, or a mapping name)")
+	cmd.Flags().StringSliceVar(joinKeys, "join-key", []string{"sample_accession_id"}, "Columns joining the base table with sample_file")
+	cmd.Flags().StringVar(join, "join", "left", "Join type for the merged metadata (left, inner)")
+}
+
+// parseMergeOptions validates the --merge-base, --join-key, and --join flags.
+func parseMergeOptions(base string, joinKeys []string, join string) (mergeOptions, error) {
+	switch base {
+	case "auto", "sequencing", "analysis",
+		"study_experiment_run_sample", "run_sample", "study_analysis_sample", "analysis_sample":
+	default:
+		return mergeOptions{}, fmt.Errorf("invalid --merge-base %q (use auto, sequencing, analysis, or a mapping name)", base)
+	}
+	switch join {
+	case "left", "inner":
+	default:
+		return mergeOptions{}, fmt.Errorf("invalid --join %q (use left or inner)", join)
+	}
+	var keys []string
+	for _, k := range joinKeys {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return mergeOptions{}, fmt.Errorf("--join-key requires at least one column")
+	}
+	return mergeOptions{Base: base, JoinKeys: keys, Join: join}, nil
+}
+
+// mergeTable is a base table for the merge and the metadata path it belongs to.
+type mergeTable struct {
+	path    string // "sequencing" or "analysis"
+	records []map[string]interface{}
+}
+
+// mergeBaseTables returns the base tables selected by base.
+func mergeBaseTables(meta *api.DatasetMetadata, base string) []mergeTable {
+	// EGA datasets can follow the sequencing path (study→experiment→run→sample),
+	// the analysis path (study→analysis→sample), or both.
+	analysis := meta.StudyAnalysisSample
+	if len(analysis) == 0 {
+		analysis = meta.AnalysisSample
+	}
+
+	var tables []mergeTable
+	switch base {
+	case "auto":
+		if len(meta.StudyExperimentRunSample) > 0 {
+			tables = append(tables, mergeTable{"sequencing", meta.StudyExperimentRunSample})
+		}
+		if len(analysis) > 0 {
+			tables = append(tables, mergeTable{"analysis", analysis})
+		}
+	case "sequencing":
+		tables = append(tables, mergeTable{"sequencing", meta.StudyExperimentRunSample})
+	case "analysis":
+		tables = append(tables, mergeTable{"analysis", analysis})
+	case "study_experiment_run_sample":
+		tables = append(tables, mergeTable{"sequencing", meta.StudyExperimentRunSample})
+	case "run_sample":
+		tables = append(tables, mergeTable{"sequencing", meta.RunSample})
+	case "study_analysis_sample":
+		tables = append(tables, mergeTable{"analysis", meta.StudyAnalysisSample})
+	case "analysis_sample":
+		tables = append(tables, mergeTable{"analysis", meta.AnalysisSample})
+	}
+	return tables
+}
+
+// buildMergedMetadata joins the base mapping table(s) selected by opts with
+// sample_file on opts.JoinKeys to produce a single wide table. A base record
+// that matches several sample_file records yields one row per match. When
+// records from both the sequencing and analysis paths are included, a
+// metadata_path column tells them apart.
+func buildMergedMetadata(meta *api.DatasetMetadata, opts mergeOptions) []map[string]interface{} {
+	joinKey := func(rec map[string]interface{}) string {
+		parts := make([]string, len(opts.JoinKeys))
+		for i, k := range opts.JoinKeys {
+			parts[i] = formatValue(rec[k])
+			if parts[i] == "" {
+				return ""
+			}
+		}
+		return strings.Join(parts, "\x00")
+	}
+
+	// Build a lookup from join key → sample_file records.
+	sampleFileMap := make(map[string][]map[string]interface{})
 	for _, rec := range meta.SampleFile {
-		key, _ := rec["sample_accession_id"].(string)
-		if key != "" {
-			sampleFileMap[key] = rec
+		if key := joinKey(rec); key != "" {
+			sampleFileMap[key] = append(sampleFileMap[key], rec)
 		}
 	}
 
-	// Pick the first non-empty base table. EGA datasets can follow
-	// the sequencing path (study→experiment→run→sample) or the
-	// analysis path (study→analysis→sample), or both.
-	var base []map[string]interface{}
-	switch {
-	case len(meta.StudyExperimentRunSample) > 0:
-		base = meta.StudyExperimentRunSample
-	case len(meta.StudyAnalysisSample) > 0:
-		base = meta.StudyAnalysisSample
-	case len(meta.AnalysisSample) > 0:
-		base = meta.AnalysisSample
-	case len(meta.SampleFile) > 0:
-		return meta.SampleFile // nothing to merge with
-	default:
+	tables := mergeBaseTables(meta, opts.Base)
+	var nonEmpty int
+	for _, t := range tables {
+		if len(t.records) > 0 {
+			nonEmpty++
+		}
+	}
+	if nonEmpty == 0 {
+		if opts.Base == "auto" {
+			return meta.SampleFile // nothing to merge with
+		}
 		return nil
 	}
 
-	// Merge base with sample_file on sample_accession_id.
 	var result []map[string]interface{}
-	for _, baseRec := range base {
-		merged := make(map[string]interface{})
-		for k, v := range baseRec {
-			merged[k] = v
-		}
-
-		sampleID, _ := baseRec["sample_accession_id"].(string)
-		if sf, ok := sampleFileMap[sampleID]; ok {
-			for k, v := range sf {
-				// Prefix to avoid collisions with base columns.
-				if _, exists := merged[k]; exists {
-					merged["file_"+k] = v
-				} else {
+	for _, t := range tables {
+		for _, baseRec := range t.records {
+			matches := sampleFileMap[joinKey(baseRec)]
+			if len(matches) == 0 {
+				if opts.Join == "inner" {
+					continue
+				}
+				matches = []map[string]interface{}{nil}
+			}
+
+			for _, sf := range matches {
+				merged := make(map[string]interface{}, len(baseRec)+len(sf)+1)
+				for k, v := range baseRec {
 					merged[k] = v
 				}
+				for k, v := range sf {
+					// Prefix to avoid collisions with base columns.
+					if _, exists := merged[k]; exists {
+						merged["file_"+k] = v
+					} else {
+						merged[k] = v
+					}
+				}
+				if nonEmpty > 1 {
+					merged["metadata_path"] = t.path
+				}
+				result = append(result, merged)
 			}
 		}
-
-		result = append(result, merged)
 	}
 
 	return result
 }
 
-// buildPEPSampleTable creates PEP-compatible sample records from the merged
-// metadata by renaming sample_accession_id to sample_name (the PEP index column).
-func buildPEPSampleTable(merged []map[string]interface{}) []map[string]interface{} {
-	samples := make([]map[string]interface{}, 0, len(merged))
+// buildPEPTables creates PEP-compatible tables from the merged metadata by
+// renaming sample_accession_id to sample_name (the PEP index column). The
+// sample table has one row per sample. Because the merged metadata has one
+// row per file, samples with several rows also get every row in the
+// subsample table, which is nil when no sample repeats.
+func buildPEPTables(merged []map[string]interface{}) (samples, subsamples []map[string]interface{}) {
+	rows := make([]map[string]interface{}, 0, len(merged))
+	counts := make(map[string]int)
 	for _, rec := range merged {
 		row := make(map[string]interface{}, len(rec))
 		for k, v := range rec {
@@ -866,33 +2180,115 @@ func buildPEPSampleTable(merged []map[string]interface{}) []map[string]interface
 				row[k] = v
 			}
 		}
-		samples = append(samples, row)
+		rows = append(rows, row)
+		if name := formatValue(row["sample_name"]); name != "" {
+			counts[name]++
+		}
 	}
-	return samples
+
+	var repeated bool
+	seen := make(map[string]int)
+	for _, row := range rows {
+		name := formatValue(row["sample_name"])
+		if name == "" || counts[name] == 1 {
+			samples = append(samples, row)
+			continue
+		}
+		repeated = true
+		if seen[name] == 0 {
+			samples = append(samples, row)
+		}
+		seen[name]++
+	}
+	if !repeated {
+		return samples, nil
+	}
+
+	seen = make(map[string]int)
+	for _, row := range rows {
+		name := formatValue(row["sample_name"])
+		if counts[name] < 2 {
+			continue
+		}
+		seen[name]++
+		sub := make(map[string]interface{}, len(row)+1)
+		for k, v := range row {
+			sub[k] = v
+		}
+		sub["subsample_name"] = seen[name]
+		subsamples = append(subsamples, sub)
+	}
+	return samples, subsamples
 }
 
 // writePEPConfig writes a minimal PEP 2.0.0 project configuration YAML file.
-func writePEPConfig(path, datasetID, sampleTableName string) error {
+// subsampleTableName is omitted when empty.
+func writePEPConfig(path, datasetID, sampleTableName, subsampleTableName string) error {
 	f, err := os.Create(path)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	_, err = fmt.Fprintf(f, "pep_version: 2.0.0\nsample_table: %s\nname: %s\ndescription: EGA dataset %s metadata\n",
-		sampleTableName, datasetID, datasetID)
+	_, err = fmt.Fprintf(f, "pep_version: 2.0.0\nsample_table: %s\n", sampleTableName)
+	if err == nil && subsampleTableName != "" {
+		_, err = fmt.Fprintf(f, "subsample_table: %s\n", subsampleTableName)
+	}
+	if err == nil {
+		_, err = fmt.Fprintf(f, "name: %s\ndescription: EGA dataset %s metadata\n", datasetID, datasetID)
+	}
 	return err
 }
 
-// fetchAndWriteMetadata fetches dataset metadata from the EGA metadata API and
-// writes mapping files, merged metadata, and PEP files to outputDir.
-func fetchAndWriteMetadata(ctx context.Context, apiClient *api.Client, metaToken, datasetID, outputDir, format string) error {
-	fmt.Printf("Fetching metadata for %s...\n", datasetID)
-	meta, err := apiClient.FetchDatasetMappings(ctx, metaToken, datasetID)
-	if err != nil {
-		return err
+// selectFields returns records reduced to the given columns. An empty fields
+// returns records unchanged; a field absent from every record is an error.
+func selectFields(records []map[string]interface{}, fields []string) ([]map[string]interface{}, error) {
+	if len(fields) == 0 {
+		return records, nil
+	}
+
+	known := make(map[string]bool)
+	for _, rec := range records {
+		for k := range rec {
+			known[k] = true
+		}
+	}
+	for _, f := range fields {
+		if len(records) > 0 && !known[f] {
+			return nil, fmt.Errorf("unknown metadata column %q in --fields", f)
+		}
+	}
+
+	selected := make([]map[string]interface{}, len(records))
+	for i, rec := range records {
+		row := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			row[f] = rec[f]
+		}
+		selected[i] = row
+	}
+	return selected, nil
+}
+
+// parseFields normalizes a --fields list, dropping blanks and duplicates.
+func parseFields(fields []string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, f := range fields {
+		f = strings.TrimSpace(f)
+		if f != "" && !seen[f] {
+			seen[f] = true
+			out = append(out, f)
+		}
 	}
+	return out
+}
 
+// writeMetadataExport writes mapping files, merged metadata, and PEP files
+// for the dataset to outputDir. When fields is non-empty the merged file
+// contains only those columns, in that order; mapping files are always
+// complete so later commands can rebuild the merge from them.
+func writeMetadataExport(meta *api.DatasetMetadata, datasetID, outputDir, format string, merge mergeOptions, fields []string) error {
 	// Create output directory.
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		return fmt.Errorf("create output directory: %w", err)
@@ -917,35 +2313,109 @@ func fetchAndWriteMetadata(ctx context.Context, apiClient *api.Client, metaToken
 		if err := writeRecords(outPath, format, m.records); err != nil {
 			return fmt.Errorf("write %s: %w", fileName, err)
 		}
-		fmt.Printf("  %s (%d records)\n", fileName, len(m.records))
+		slog.Info("Wrote metadata table", "file", outPath, "records", len(m.records))
 	}
 
 	// Generate merged metadata file.
-	mergedRecords := buildMergedMetadata(meta)
+	mergedRecords := buildMergedMetadata(meta, merge)
+	selected, err := selectFields(mergedRecords, fields)
+	if err != nil {
+		return err
+	}
 	mergedName := datasetID + "_merged_metadata." + format
 	mergedPath := filepath.Join(outputDir, mergedName)
-	if err := writeRecords(mergedPath, format, mergedRecords); err != nil {
+	if err := writeRecordsColumns(mergedPath, format, selected, fields); err != nil {
 		return fmt.Errorf("write merged file: %w", err)
 	}
-	fmt.Printf("  %s (%d records)\n", mergedName, len(mergedRecords))
+	slog.Info("Wrote merged metadata", "file", mergedPath, "records", len(mergedRecords))
+
+	if meta.Access != nil {
+		if err := writeAccessConditions(meta.Access, outputDir, format); err != nil {
+			return err
+		}
+	}
 
 	// Generate PEP (Portable Encapsulated Project) files.
-	pepSamples := buildPEPSampleTable(mergedRecords)
+	pepSamples, pepSubsamples := buildPEPTables(mergedRecords)
 	pepSampleName := datasetID + "_samples.csv"
 	pepSamplePath := filepath.Join(outputDir, pepSampleName)
 	if err := writeRecords(pepSamplePath, "csv", pepSamples); err != nil {
 		return fmt.Errorf("write PEP sample table: %w", err)
 	}
-	fmt.Printf("  %s (%d samples)\n", pepSampleName, len(pepSamples))
+	slog.Info("Wrote PEP sample table", "file", pepSamplePath, "samples", len(pepSamples))
+
+	var pepSubsampleName string
+	if pepSubsamples != nil {
+		pepSubsampleName = datasetID + "_subsamples.csv"
+		pepSubsamplePath := filepath.Join(outputDir, pepSubsampleName)
+		if err := writeRecords(pepSubsamplePath, "csv", pepSubsamples); err != nil {
+			return fmt.Errorf("write PEP subsample table: %w", err)
+		}
+		slog.Info("Wrote PEP subsample table", "file", pepSubsamplePath, "files", len(pepSubsamples))
+	}
 
 	pepConfigName := datasetID + "_pep.yaml"
 	pepConfigPath := filepath.Join(outputDir, pepConfigName)
-	if err := writePEPConfig(pepConfigPath, datasetID, pepSampleName); err != nil {
+	if err := writePEPConfig(pepConfigPath, datasetID, pepSampleName, pepSubsampleName); err != nil {
 		return fmt.Errorf("write PEP config: %w", err)
 	}
-	fmt.Printf("  %s\n", pepConfigName)
+	slog.Info("Wrote PEP config", "file", pepConfigPath)
+	return nil
+}
+
+// fetchAndWritePublicMetadata fetches dataset metadata from the EGA public
+// metadata API and writes the dataset details and entity tables to outputDir.
+func fetchAndWritePublicMetadata(ctx context.Context, apiClient *api.Client, datasetID, outputDir, format string) error {
+	slog.Info("Fetching public metadata...", "dataset", datasetID)
+	meta, err := apiClient.FetchPublicDatasetMetadata(ctx, datasetID)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("create output directory: %w", err)
+	}
+
+	detailsName := "dataset.json"
+	f, err := os.Create(filepath.Join(outputDir, detailsName))
+	if err != nil {
+		return fmt.Errorf("write %s: %w", detailsName, err)
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(meta.Details)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("write %s: %w", detailsName, err)
+	}
+	slog.Info("Wrote dataset details", "file", filepath.Join(outputDir, detailsName))
+
+	entities := []struct {
+		name    string
+		records []map[string]interface{}
+	}{
+		{"studies", meta.Studies},
+		{"experiments", meta.Experiments},
+		{"runs", meta.Runs},
+		{"analyses", meta.Analyses},
+		{"samples", meta.Samples},
+		{"files", meta.Files},
+	}
+
+	for _, e := range entities {
+		fileName := e.name + "." + format
+		if err := writeRecords(filepath.Join(outputDir, fileName), format, e.records); err != nil {
+			return fmt.Errorf("write %s: %w", fileName, err)
+		}
+		slog.Info("Wrote metadata table", "file", filepath.Join(outputDir, fileName), "records", len(e.records))
+	}
 
-	fmt.Printf("\nMetadata saved to %s/\n", outputDir)
+	access, err := apiClient.FetchAccessConditions(ctx, datasetID)
+	if err != nil {
+		slog.Warn("Could not fetch DAC and policy information", "dataset", datasetID, "error", err)
+	} else if err := writeAccessConditions(access, outputDir, format); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -957,9 +2427,9 @@ func newStatusCmd() *cobra.Command {
 		Short: "Show download progress",
 		Args:  cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			dir := "."
-			if len(args) > 0 {
-				dir = args[0]
+			dir, err := defaultDir(args)
+			if err != nil {
+				return err
 			}
 
 			sm := state.NewStateManager(dir)
@@ -968,7 +2438,27 @@ func newStatusCmd() *cobra.Command {
 				return err
 			}
 
-			ui.PrintFileStates(states)
+			var samples map[string]ui.SampleAnnotation
+			if manifest, err := sm.LoadManifest(); err == nil && manifest != nil && manifest.DatasetID != "" {
+				samples = localSampleAnnotations(dir, manifest.DatasetID)
+			}
+
+			// Files of a --shared download may be downloading on other hosts.
+			holders, err := claimHolders(sm, time.Now())
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				out := jsonStatus{Directory: dir, Files: []jsonFileState{}, Processes: holders}
+				for _, fs := range states {
+					out.Files = append(out.Files, newJSONFileState(fs, samples[fs.FileID]))
+				}
+				return printJSON(cmd, out)
+			}
+
+			ui.PrintFileStates(cmd.OutOrStdout(), states, samples)
+			printClaimHolders(cmd.OutOrStdout(), holders)
 			return nil
 		},
 	}
@@ -982,9 +2472,9 @@ func newVerifyCmd() *cobra.Command {
 		Short: "Re-verify checksums of downloaded files",
 		Args:  cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			dir := "."
-			if len(args) > 0 {
-				dir = args[0]
+			dir, err := defaultDir(args)
+			if err != nil {
+				return err
 			}
 
 			sm := state.NewStateManager(dir)
@@ -993,39 +2483,57 @@ func newVerifyCmd() *cobra.Command {
 				return err
 			}
 
-			if len(states) == 0 {
-				fmt.Println("No downloads found to verify.")
+			if len(states) == 0 && !jsonOutput {
+				fmt.Fprintln(cmd.OutOrStdout(), "No downloads found to verify.")
 				return nil
 			}
 
-			var passed, failed, skipped int
+			out := textOutput(cmd)
+			report := jsonVerify{Directory: dir, Results: []jsonVerifyResult{}}
+			record := func(fs *state.FileState, result, reason string) {
+				report.Results = append(report.Results, jsonVerifyResult{
+					FileID:   fs.FileID,
+					FileName: fs.FileName,
+					Result:   result,
+					Reason:   reason,
+				})
+			}
 			for _, fs := range states {
 				if fs.Status != state.StatusComplete {
-					fmt.Printf("  SKIP  %s (status: %s)\n", fs.FileName, fs.Status)
-					skipped++
+					fmt.Fprintf(out, "  SKIP  %s (status: %s)\n", fs.FileName, fs.Status)
+					record(fs, "skip", "status: "+string(fs.Status))
+					report.Skipped++
 					continue
 				}
 
-				filePath := fmt.Sprintf("%s/%s", dir, fs.FileName)
+				filePath := filepath.Join(dir, fs.FileName)
 				if fs.ChecksumExpected == "" {
-					fmt.Printf("  SKIP  %s (no checksum)\n", fs.FileName)
-					skipped++
+					fmt.Fprintf(out, "  SKIP  %s (no checksum)\n", fs.FileName)
+					record(fs, "skip", "no checksum")
+					report.Skipped++
 					continue
 				}
 
 				err := verify.Verify(filePath, fs.ChecksumExpected, fs.ChecksumType)
 				if err != nil {
-					fmt.Printf("  FAIL  %s: %v\n", fs.FileName, err)
-					failed++
+					fmt.Fprintf(out, "  FAIL  %s: %v\n", fs.FileName, err)
+					record(fs, "fail", err.Error())
+					report.Failed++
 				} else {
-					fmt.Printf("  OK    %s\n", fs.FileName)
-					passed++
+					fmt.Fprintf(out, "  OK    %s\n", fs.FileName)
+					record(fs, "ok", "")
+					report.Passed++
 				}
 			}
 
-			fmt.Printf("\n%d passed, %d failed, %d skipped\n", passed, failed, skipped)
-			if failed > 0 {
-				return fmt.Errorf("%d file(s) failed verification", failed)
+			fmt.Fprintf(out, "\n%d passed, %d failed, %d skipped\n", report.Passed, report.Failed, report.Skipped)
+			if jsonOutput {
+				if err := printJSON(cmd, report); err != nil {
+					return err
+				}
+			}
+			if report.Failed > 0 {
+				return withExitCode(exitChecksum, fmt.Errorf("%d file(s) failed verification", report.Failed))
 			}
 			return nil
 		},
@@ -1040,9 +2548,9 @@ func newCleanCmd() *cobra.Command {
 		Short: "Remove temp files, keep completed downloads",
 		Args:  cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			dir := "."
-			if len(args) > 0 {
-				dir = args[0]
+			dir, err := defaultDir(args)
+			if err != nil {
+				return err
 			}
 
 			sm := state.NewStateManager(dir)
@@ -1050,7 +2558,7 @@ func newCleanCmd() *cobra.Command {
 			// Remove all chunk directories.
 			chunksDir := sm.ChunksPath()
 			if _, err := os.Stat(chunksDir); err == nil {
-				fmt.Printf("Removing chunk files from %s...\n", chunksDir)
+				slog.Info("Removing chunk files...", "dir", chunksDir)
 				if err := os.RemoveAll(chunksDir); err != nil {
 					return fmt.Errorf("remove chunks: %w", err)
 				}
@@ -1066,14 +2574,14 @@ func newCleanCmd() *cobra.Command {
 			for _, fs := range states {
 				if fs.Status == state.StatusComplete {
 					if err := sm.DeleteFileState(fs.FileID); err != nil {
-						fmt.Printf("  Warning: could not remove state for %s: %v\n", fs.FileID, err)
+						slog.Warn("Could not remove file state", "file_id", fs.FileID, "error", err)
 					} else {
 						cleaned++
 					}
 				}
 			}
 
-			fmt.Printf("Cleaned %d completed state file(s).\n", cleaned)
+			fmt.Fprintf(cmd.OutOrStdout(), "Cleaned %d completed state file(s) in %s\n", cleaned, dir)
 			return nil
 		},
 	}
@@ -1128,6 +2636,102 @@ func ensureAuth(ctx context.Context, mgr *auth.Manager, configFile string) error
 
 // --- Helpers ---
 
+// readExcludedIDs collects the file IDs given with --exclude-id and listed in
+// --exclude-file files.
+func readExcludedIDs(ids, files []string) (map[string]bool, error) {
+	fromFiles, err := expandArgs(files)
+	if err != nil {
+		return nil, err
+	}
+	excluded := make(map[string]bool)
+	for _, id := range append(ids, fromFiles...) {
+		if !strings.HasPrefix(id, "EGAF") {
+			return nil, fmt.Errorf("cannot exclude %q: expected a file ID (EGAF...)", id)
+		}
+		excluded[id] = true
+	}
+	return excluded, nil
+}
+
+// excludeFileIDs removes the excluded files from the manifest and records
+// the IDs it removed in matched.
+func excludeFileIDs(manifest *state.Manifest, excluded, matched map[string]bool) error {
+	if len(excluded) == 0 {
+		return nil
+	}
+	before := len(manifest.Files)
+	kept := manifest.Files[:0]
+	for _, f := range manifest.Files {
+		if excluded[f.FileID] {
+			matched[f.FileID] = true
+			continue
+		}
+		kept = append(kept, f)
+	}
+	manifest.Files = kept
+
+	if len(kept) == 0 {
+		return fmt.Errorf("all %d file(s) were excluded by --exclude-id/--exclude-file", before)
+	}
+	if len(kept) != before {
+		slog.Info("Excluded files by ID", "files", before-len(kept))
+	}
+	return nil
+}
+
+// warnUnmatchedExclusions warns about excluded IDs that matched no file.
+func warnUnmatchedExclusions(excluded, matched map[string]bool) {
+	var unmatched []string
+	for id := range excluded {
+		if !matched[id] {
+			unmatched = append(unmatched, id)
+		}
+	}
+	sort.Strings(unmatched)
+	for _, id := range unmatched {
+		slog.Warn("Excluded file is not among the requested files", "file_id", id)
+	}
+}
+
+// applyFilters applies format filters and include/exclude patterns to a
+// manifest resolved from args, keeping explicitly named EGAF files, and
+// reports how many remain. With withIndexes, index files of the remaining
+// files are added back.
+func applyFilters(ctx context.Context, manifest *state.Manifest, args, includes, excludes, formats []string, withIndexes bool) error {
+	if len(includes) == 0 && len(excludes) == 0 && len(formats) == 0 {
+		return nil
+	}
+	egafIDs := make(map[string]bool)
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "EGAF") {
+			egafIDs[arg] = true
+		}
+	}
+	all := append([]state.FileSpec(nil), manifest.Files...)
+	beforeCount := len(manifest.Files)
+	filterFormats(manifest, formats, egafIDs)
+	if err := filterManifest(manifest, includes, excludes, egafIDs); err != nil {
+		return err
+	}
+	if withIndexes {
+		// Index files still honour --exclude.
+		candidates := &state.Manifest{Files: all}
+		if err := filterManifest(candidates, nil, excludes, egafIDs); err != nil {
+			return err
+		}
+		if n := addIndexFiles(manifest, candidates.Files); n > 0 {
+			slog.InfoContext(ctx, "Added index files", "files", n)
+		}
+	}
+	if len(manifest.Files) == 0 {
+		return fmt.Errorf("no files match the given --format/--include/--exclude filters (filtered out all %d files)", beforeCount)
+	}
+	if len(manifest.Files) != beforeCount {
+		slog.InfoContext(ctx, "Filtered files", "matched", len(manifest.Files), "total", beforeCount)
+	}
+	return nil
+}
+
 // filterManifest filters the manifest's file list using include/exclude glob patterns.
 // Includes are applied first (file must match at least one), then excludes.
 // Files in skipFileIDs are never filtered out (explicit EGAF args).
@@ -1182,6 +2786,104 @@ func filterManifest(manifest *state.Manifest, includes, excludes []string, skipF
 	return nil
 }
 
+// indexSuffixes are the extensions of index files recognized by
+// --with-indexes.
+var indexSuffixes = []string{".bai", ".crai", ".tbi", ".csi"}
+
+// compressionSuffixes are stripped before a file's format is determined, so
+// that "VCF" matches both sample.vcf and sample.vcf.gz.
+var compressionSuffixes = []string{".gz", ".bgz", ".bz2", ".xz", ".zst"}
+
+// formatAliases maps short extensions to the format name they stand for.
+var formatAliases = map[string]string{"fq": "fastq", "fa": "fasta"}
+
+// fileFormat returns the lower-case format of a file name from its
+// extension, ignoring .cip and compression suffixes (e.g. "vcf" for
+// sample.vcf.gz).
+func fileFormat(name string) string {
+	name = strings.TrimSuffix(strings.ToLower(name), ".cip")
+	for _, suffix := range compressionSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			name = strings.TrimSuffix(name, suffix)
+			break
+		}
+	}
+	format := strings.TrimPrefix(filepath.Ext(name), ".")
+	if alias, ok := formatAliases[format]; ok {
+		return alias
+	}
+	return format
+}
+
+// filterFormats keeps the manifest files whose format (see fileFormat) is
+// one of formats, compared case-insensitively. Files in skipFileIDs are
+// always kept.
+func filterFormats(manifest *state.Manifest, formats []string, skipFileIDs map[string]bool) {
+	want := make(map[string]bool)
+	for _, f := range formats {
+		f = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(f), "."))
+		if alias, ok := formatAliases[f]; ok {
+			f = alias
+		}
+		if f != "" {
+			want[f] = true
+		}
+	}
+	if len(want) == 0 {
+		return
+	}
+	var filtered []state.FileSpec
+	for _, f := range manifest.Files {
+		if skipFileIDs[f.FileID] || want[fileFormat(filepath.Base(f.FileName))] {
+			filtered = append(filtered, f)
+		}
+	}
+	manifest.Files = filtered
+}
+
+// addIndexFiles adds back the files from all that index a file left in the
+// manifest: sample.bam.bai or sample.bai for sample.bam, sample.vcf.gz.tbi
+// for sample.vcf.gz, and so on. The manifest keeps the order of all. It
+// returns the number of files added.
+func addIndexFiles(manifest *state.Manifest, all []state.FileSpec) int {
+	kept := make(map[string]bool)
+	names := make(map[string]bool)
+	for _, f := range manifest.Files {
+		kept[f.FileID] = true
+		name := filepath.Base(f.FileName)
+		names[name] = true
+		names[strings.TrimSuffix(name, filepath.Ext(name))] = true
+	}
+
+	var added int
+	files := make([]state.FileSpec, 0, len(all))
+	for _, f := range all {
+		if kept[f.FileID] {
+			files = append(files, f)
+			continue
+		}
+		name := filepath.Base(f.FileName)
+		for _, suffix := range indexSuffixes {
+			if strings.HasSuffix(strings.ToLower(name), suffix) && names[name[:len(name)-len(suffix)]] {
+				files = append(files, f)
+				added++
+				break
+			}
+		}
+	}
+	manifest.Files = files
+	return added
+}
+
+// offIfZero returns d, or -1 for 0: a flag's "off" as the negative value
+// that turns the setting off in options where 0 means the default.
+func offIfZero(d time.Duration) time.Duration {
+	if d == 0 {
+		return -1
+	}
+	return d
+}
+
 // parseSize parses a human-readable size string (e.g., "64M", "1G") to bytes.
 func parseSize(s string) (int64, error) {
 	s = strings.TrimSpace(s)
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+func TestReadIdentifiers(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr string
+	}{
+		{name: "one per line", input: "EGAD00001000001\nEGAF00000000001\n",
+			want: []string{"EGAD00001000001", "EGAF00000000001"}},
+		{name: "comments and blank lines", input: "# header\n\n  EGAF00000000001  # first\n\t\nEGAF00000000002",
+			want: []string{"EGAF00000000001", "EGAF00000000002"}},
+		{name: "empty", input: "\n# nothing\n", want: nil},
+		{name: "unrecognized identifier", input: "EGAF00000000001\nEGAS00001000001\n",
+			wantErr: "ids.txt:2: unrecognized identifier \"EGAS00001000001\""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readIdentifiers(strings.NewReader(tt.input), "ids.txt")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupByOutputDir(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    []outputGroup
+		wantErr string
+	}{
+		{name: "no mappings", args: []string{"EGAD1", "EGAF2"},
+			want: []outputGroup{{dir: "data", args: []string{"EGAD1", "EGAF2"}}}},
+		{name: "mapped and default in first-seen order", args: []string{"EGAD1=./a/", "EGAF2", " EGAD3 = a ", "EGAF4=b"},
+			want: []outputGroup{
+				{dir: "a", args: []string{"EGAD1", "EGAD3"}},
+				{dir: "data", args: []string{"EGAF2"}},
+				{dir: "b", args: []string{"EGAF4"}},
+			}},
+		{name: "no identifiers", args: nil,
+			want: []outputGroup{{dir: "./data/"}}},
+		{name: "missing directory", args: []string{"EGAD1="},
+			wantErr: "missing output directory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := groupByOutputDir(tt.args, "./data/")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyFiltersFormats(t *testing.T) {
+	manifest := func() *state.Manifest {
+		var files []state.FileSpec
+		for i, name := range []string{
+			"a.bam", "a.bam.bai", "b.bam", "b.bai", "c.cram", "c.cram.crai",
+			"d.vcf.gz", "d.vcf.gz.tbi", "e.fq.gz", "notes.txt", "orphan.bai",
+		} {
+			id := fmt.Sprintf("EGAF%011d", i)
+			files = append(files, state.FileSpec{FileID: id, FileName: filepath.Join(id, name)})
+		}
+		return &state.Manifest{Files: files}
+	}
+	names := func(m *state.Manifest) []string {
+		var out []string
+		for _, f := range m.Files {
+			out = append(out, filepath.Base(f.FileName))
+		}
+		return out
+	}
+
+	tests := []struct {
+		name        string
+		args        []string
+		includes    []string
+		excludes    []string
+		formats     []string
+		withIndexes bool
+		want        []string
+		wantErr     string
+	}{
+		{name: "formats", formats: []string{"BAM", "vcf"},
+			want: []string{"a.bam", "b.bam", "d.vcf.gz"}},
+		{name: "formats with indexes", formats: []string{"bam", "CRAM", "vcf"}, withIndexes: true,
+			want: []string{"a.bam", "a.bam.bai", "b.bam", "b.bai", "c.cram", "c.cram.crai", "d.vcf.gz", "d.vcf.gz.tbi"}},
+		{name: "alias", formats: []string{".fastq"},
+			want: []string{"e.fq.gz"}},
+		{name: "include with indexes", includes: []string{"*.bam", "*.cram"}, withIndexes: true,
+			want: []string{"a.bam", "a.bam.bai", "b.bam", "b.bai", "c.cram", "c.cram.crai"}},
+		{name: "indexes honour exclude", formats: []string{"bam"}, excludes: []string{"*.bam.bai"}, withIndexes: true,
+			want: []string{"a.bam", "b.bam", "b.bai"}},
+		{name: "explicit file kept", args: []string{"EGAF00000000009"}, formats: []string{"bam"},
+			want: []string{"a.bam", "b.bam", "notes.txt"}},
+		{name: "nothing matches", formats: []string{"bcf"},
+			wantErr: "no files match"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := manifest()
+			err := applyFilters(context.Background(), m, tt.args, tt.includes, tt.excludes, tt.formats, tt.withIndexes)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := names(m); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutputFileName(t *testing.T) {
+	defer func(v bool) { sanitizeFileNames = v }(sanitizeFileNames)
+
+	tests := []struct {
+		egaName      string
+		sanitize     bool
+		wantName     string
+		wantOriginal string
+	}{
+		{"EGAZ00001/sample.bam.cip", false, "sample.bam", ""},
+		{"EGAZ00001/sample.bam.cip", true, "sample.bam", ""},
+		{"EGAZ00001/run:1.fastq.gz.cip", false, "run:1.fastq.gz", ""},
+		{"EGAZ00001/run:1.fastq.gz.cip", true, "run_1.fastq.gz", "run:1.fastq.gz"},
+	}
+	for _, tt := range tests {
+		sanitizeFileNames = tt.sanitize
+		name, original := outputFileName("EGAF00000000001", tt.egaName, state.LayoutByFile)
+		if want := filepath.Join("EGAF00000000001", tt.wantName); name != want || original != tt.wantOriginal {
+			t.Errorf("outputFileName(%q) with sanitize=%v = %q, %q; want %q, %q",
+				tt.egaName, tt.sanitize, name, original, want, tt.wantOriginal)
+		}
+	}
+}
+
+func TestChunksDirUnder(t *testing.T) {
+	abs, err := filepath.Abs("data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := chunksDirUnder("/scratch", "data")
+	if b := chunksDirUnder("/scratch", abs); a != b {
+		t.Errorf("relative and absolute output directories differ: %q, %q", a, b)
+	}
+	if c := chunksDirUnder("/scratch", "other"); a == c {
+		t.Errorf("different output directories share %q", a)
+	}
+	if filepath.Dir(a) != filepath.Clean("/scratch") || !strings.HasPrefix(filepath.Base(a), "egafetch-") {
+		t.Errorf("chunksDirUnder = %q, want /scratch/egafetch-...", a)
+	}
+}
+
+func TestResolveRenderer(t *testing.T) {
+	tests := []struct {
+		mode     string
+		terminal bool
+		parallel int
+		want     string
+	}{
+		{"auto", true, 1, "ansi"},
+		{"auto", false, 1, "plain"},
+		{"auto", true, 2, "plain"},
+		{"ansi", false, 1, "ansi"},
+		{"ansi", true, 4, "plain"},
+		{"plain", true, 1, "plain"},
+		{"chunks", false, 1, "chunks"},
+		{"chunks", true, 2, "plain"},
+		{"none", true, 1, "none"},
+	}
+	for _, tt := range tests {
+		if got := resolveRenderer(tt.mode, tt.terminal, tt.parallel); got != tt.want {
+			t.Errorf("resolveRenderer(%q, %v, %d) = %q, want %q", tt.mode, tt.terminal, tt.parallel, got, tt.want)
+		}
+	}
+}
+
+func TestMappingPhase(t *testing.T) {
+	got := mappingPhase("EGAD00001000001", "run_sample", 310)
+	want := "Fetching metadata of EGAD00001000001: run_sample (2/5 mappings, 310 records)"
+	if got != want {
+		t.Errorf("mappingPhase = %q, want %q", got, want)
+	}
+}
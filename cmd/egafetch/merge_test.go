@@ -0,0 +1,188 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+)
+
+type record = map[string]interface{}
+
+// testMetadata has one sequencing sample with two files, one analysis sample
+// with one file, and one sequencing sample without files.
+func testMetadata() *api.DatasetMetadata {
+	return &api.DatasetMetadata{
+		StudyExperimentRunSample: []record{
+			{"sample_accession_id": "EGAN1", "run_accession_id": "EGAR1"},
+			{"sample_accession_id": "EGAN3", "run_accession_id": "EGAR3"},
+		},
+		StudyAnalysisSample: []record{
+			{"sample_accession_id": "EGAN2", "analysis_accession_id": "EGAZ2"},
+		},
+		SampleFile: []record{
+			{"sample_accession_id": "EGAN1", "file_accession_id": "EGAF1a", "run_accession_id": "EGAR1"},
+			{"sample_accession_id": "EGAN1", "file_accession_id": "EGAF1b", "run_accession_id": "EGAR1"},
+			{"sample_accession_id": "EGAN2", "file_accession_id": "EGAF2"},
+		},
+	}
+}
+
+// rowKeys summarizes merged rows as "sample/file/path" strings, sorted.
+func rowKeys(rows []record) []string {
+	keys := make([]string, len(rows))
+	for i, r := range rows {
+		keys[i] = strings.Join([]string{formatValue(r["sample_accession_id"]), formatValue(r["file_accession_id"]), formatValue(r["metadata_path"])}, "/")
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestBuildMergedMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		meta *api.DatasetMetadata
+		opts mergeOptions
+		want []string
+	}{
+		{
+			name: "auto includes both paths with metadata_path",
+			meta: testMetadata(),
+			opts: defaultMergeOptions(),
+			want: []string{"EGAN1/EGAF1a/sequencing", "EGAN1/EGAF1b/sequencing", "EGAN2/EGAF2/analysis", "EGAN3//sequencing"},
+		},
+		{
+			name: "inner join drops records without files",
+			meta: testMetadata(),
+			opts: mergeOptions{Base: "auto", JoinKeys: []string{"sample_accession_id"}, Join: "inner"},
+			want: []string{"EGAN1/EGAF1a/sequencing", "EGAN1/EGAF1b/sequencing", "EGAN2/EGAF2/analysis"},
+		},
+		{
+			name: "single base has no metadata_path",
+			meta: testMetadata(),
+			opts: mergeOptions{Base: "sequencing", JoinKeys: []string{"sample_accession_id"}, Join: "left"},
+			want: []string{"EGAN1/EGAF1a/", "EGAN1/EGAF1b/", "EGAN3//"},
+		},
+		{
+			name: "analysis base",
+			meta: testMetadata(),
+			opts: mergeOptions{Base: "analysis", JoinKeys: []string{"sample_accession_id"}, Join: "left"},
+			want: []string{"EGAN2/EGAF2/"},
+		},
+		{
+			name: "all join keys must match",
+			meta: testMetadata(),
+			opts: mergeOptions{Base: "sequencing", JoinKeys: []string{"sample_accession_id", "run_accession_id"}, Join: "inner"},
+			want: []string{"EGAN1/EGAF1a/", "EGAN1/EGAF1b/"},
+		},
+		{
+			name: "auto without base tables returns sample_file",
+			meta: &api.DatasetMetadata{SampleFile: testMetadata().SampleFile},
+			opts: defaultMergeOptions(),
+			want: []string{"EGAN1/EGAF1a/", "EGAN1/EGAF1b/", "EGAN2/EGAF2/"},
+		},
+		{
+			name: "explicit empty base returns nothing",
+			meta: testMetadata(),
+			opts: mergeOptions{Base: "run_sample", JoinKeys: []string{"sample_accession_id"}, Join: "left"},
+			want: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rowKeys(buildMergedMetadata(tt.meta, tt.opts))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rows = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildMergedMetadataColumnCollision(t *testing.T) {
+	rows := buildMergedMetadata(testMetadata(), mergeOptions{Base: "sequencing", JoinKeys: []string{"sample_accession_id"}, Join: "inner"})
+	if len(rows) == 0 {
+		t.Fatal("no rows")
+	}
+	// run_accession_id exists in both tables, so the sample_file copy is prefixed.
+	if rows[0]["file_run_accession_id"] != "EGAR1" {
+		t.Errorf("file_run_accession_id = %v, want EGAR1", rows[0]["file_run_accession_id"])
+	}
+	if rows[0]["run_accession_id"] != "EGAR1" {
+		t.Errorf("run_accession_id = %v, want EGAR1", rows[0]["run_accession_id"])
+	}
+}
+
+func TestParseMergeOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     string
+		joinKeys []string
+		join     string
+		want     mergeOptions
+		wantErr  string
+	}{
+		{name: "defaults", base: "auto", joinKeys: []string{"sample_accession_id"}, join: "left",
+			want: defaultMergeOptions()},
+		{name: "mapping name and trimmed keys", base: "run_sample", joinKeys: []string{" sample_accession_id ", "", "run_accession_id"}, join: "inner",
+			want: mergeOptions{Base: "run_sample", JoinKeys: []string{"sample_accession_id", "run_accession_id"}, Join: "inner"}},
+		{name: "unknown base", base: "samples", joinKeys: []string{"sample_accession_id"}, join: "left",
+			wantErr: "invalid --merge-base"},
+		{name: "unknown join", base: "auto", joinKeys: []string{"sample_accession_id"}, join: "outer",
+			wantErr: "invalid --join"},
+		{name: "no keys", base: "auto", joinKeys: []string{" "}, join: "left",
+			wantErr: "at least one column"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMergeOptions(tt.base, tt.joinKeys, tt.join)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildPEPTables(t *testing.T) {
+	merged := buildMergedMetadata(testMetadata(), defaultMergeOptions())
+	samples, subsamples := buildPEPTables(merged)
+
+	var names []string
+	for _, s := range samples {
+		if _, ok := s["sample_accession_id"]; ok {
+			t.Errorf("sample row still has sample_accession_id: %v", s)
+		}
+		names = append(names, formatValue(s["sample_name"]))
+	}
+	sort.Strings(names)
+	if want := []string{"EGAN1", "EGAN2", "EGAN3"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("sample names = %q, want %q", names, want)
+	}
+
+	var subs []string
+	for _, s := range subsamples {
+		subs = append(subs, formatValue(s["sample_name"])+"/"+formatValue(s["subsample_name"])+"/"+formatValue(s["file_accession_id"]))
+	}
+	sort.Strings(subs)
+	if want := []string{"EGAN1/1/EGAF1a", "EGAN1/2/EGAF1b"}; !reflect.DeepEqual(subs, want) {
+		t.Errorf("subsamples = %q, want %q", subs, want)
+	}
+
+	// Without repeated samples there is no subsample table.
+	_, subsamples = buildPEPTables(merged[:1])
+	if subsamples != nil {
+		t.Errorf("subsamples = %v, want nil", subsamples)
+	}
+}
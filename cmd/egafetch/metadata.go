@@ -0,0 +1,610 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/auth"
+	"github.com/khan-lab/EGAfetch/internal/state"
+	"github.com/khan-lab/EGAfetch/internal/ui"
+)
+
+// metadataCacheTTL is how long cached metadata mappings are reused before the
+// metadata API is queried again.
+const metadataCacheTTL = 24 * time.Hour
+
+// errMetadataPasswordRequired is returned by a metadata token source that has
+// no password available and must not prompt for one.
+var errMetadataPasswordRequired = errors.New("metadata download requires password")
+
+// metadataCache returns the state manager whose .egafetch/metadata-cache holds
+// cached mappings for exports written to exportDir. The cache lives in the
+// export's own directory, {dir}/{datasetID}-metadata, so 'download -o DIR'
+// and 'samplesheet -d DIR' share it, and it never touches the download state
+// of DIR.
+func metadataCache(exportDir string) *state.StateManager {
+	return state.NewStateManager(exportDir)
+}
+
+// loadCachedMetadata returns the cached mappings for a dataset from the cache
+// of exportDir, or nil if there is no fresh entry.
+func loadCachedMetadata(exportDir, datasetID string) *api.DatasetMetadata {
+	var meta api.DatasetMetadata
+	fetchedAt, err := metadataCache(exportDir).LoadMetadataCache(datasetID, metadataCacheTTL, &meta)
+	if err != nil {
+		slog.Warn("Ignoring metadata cache", "error", err)
+		return nil
+	}
+	if fetchedAt.IsZero() {
+		return nil
+	}
+	slog.Info("Using cached metadata (use --refresh to update)",
+		"dataset", datasetID, "fetched", fetchedAt.Local().Format("2006-01-02 15:04"))
+	return &meta
+}
+
+// fetchAndCacheMetadata fetches the mappings for a dataset from the metadata
+// API and stores them in the cache of exportDir.
+func fetchAndCacheMetadata(ctx context.Context, apiClient *api.Client, metaToken, exportDir, datasetID string) (*api.DatasetMetadata, error) {
+	slog.Info("Fetching metadata...", "dataset", datasetID)
+	spinner := startSpinner("Fetching metadata of " + datasetID)
+	meta, err := apiClient.FetchDatasetMappings(ctx, metaToken, datasetID, func(mapping string, records int) {
+		spinner.Update(mappingPhase(datasetID, mapping, records))
+	})
+	spinner.Stop()
+	if err != nil {
+		return nil, err
+	}
+	meta.Access, err = apiClient.FetchAccessConditions(ctx, datasetID)
+	if err != nil {
+		slog.Warn("Could not fetch DAC and policy information", "dataset", datasetID, "error", err)
+	}
+	if err := metadataCache(exportDir).SaveMetadataCache(datasetID, meta); err != nil {
+		slog.Warn("Could not cache metadata", "dataset", datasetID, "error", err)
+	}
+	return meta, nil
+}
+
+// mappingPhase describes fetching a metadata mapping for a spinner, e.g.
+// "Fetching metadata of EGAD...: samples (2/5 mappings, 310 records)".
+func mappingPhase(datasetID, mapping string, records int) string {
+	return fmt.Sprintf("Fetching metadata of %s: %s (%d/%d mappings, %d records)",
+		datasetID, mapping, slices.Index(api.MappingNames, mapping)+1, len(api.MappingNames), records)
+}
+
+// cachedOrFetchMetadata returns the mappings for a dataset from the cache,
+// or fetches and caches them when the cache is missing, stale, or refresh is
+// set. token is only called when the API has to be queried.
+func cachedOrFetchMetadata(ctx context.Context, apiClient *api.Client, exportDir, datasetID string, refresh bool, token func() (string, error)) (*api.DatasetMetadata, error) {
+	if !refresh {
+		if meta := loadCachedMetadata(exportDir, datasetID); meta != nil {
+			return meta, nil
+		}
+	}
+
+	metaToken, err := token()
+	if err != nil {
+		return nil, err
+	}
+	return fetchAndCacheMetadata(ctx, apiClient, metaToken, exportDir, datasetID)
+}
+
+// configFileMetadataToken returns a token function for cachedOrFetchMetadata
+// that logs in to the metadata API with the password in configFile, for
+// commands that do not prompt for it. Without one it returns
+// errMetadataPasswordRequired.
+func configFileMetadataToken(ctx context.Context, mgr *auth.Manager, configFile string) func() (string, error) {
+	return func() (string, error) {
+		var metaPassword string
+		if configFile != "" {
+			_, metaPassword, _ = loadConfigFile(configFile)
+		}
+		if metaPassword == "" {
+			return "", errMetadataPasswordRequired
+		}
+		return mgr.GetMetadataToken(ctx, metaPassword)
+	}
+}
+
+// resolveDatasetMetadata returns the metadata mappings for a dataset. Unless
+// refresh is set, a fresh cache entry or the mapping files of a previous
+// 'egafetch metadata' export in dir are used before querying the API.
+func resolveDatasetMetadata(ctx context.Context, dir, datasetID, configFile string, refresh bool) (*api.DatasetMetadata, error) {
+	if !refresh {
+		if meta := loadCachedMetadata(dir, datasetID); meta != nil {
+			return meta, nil
+		}
+		meta, source, err := loadMetadataMappings(dir)
+		if err != nil {
+			return nil, err
+		}
+		if meta != nil {
+			slog.Info("Using local metadata", "file", source)
+			return meta, nil
+		}
+	}
+
+	mgr, err := auth.NewManager()
+	if err != nil {
+		return nil, err
+	}
+	metaToken, err := obtainMetadataToken(ctx, mgr, configFile)
+	if err != nil {
+		return nil, err
+	}
+	return fetchAndCacheMetadata(ctx, api.NewClient(mgr), metaToken, dir, datasetID)
+}
+
+// fetchAndWriteMetadataBatch fetches metadata for several datasets with at
+// most parallel requests in flight, writes each dataset to its own
+// subdirectory of outputDir, and writes a combined merged table with a
+// dataset_accession_id column. Datasets that fail are reported and skipped.
+// token should be shared (see onceToken) so the user is prompted only once.
+func fetchAndWriteMetadataBatch(ctx context.Context, apiClient *api.Client, token func() (string, error), datasetIDs []string, outputDir, format string, merge mergeOptions, fields []string, xml bool, parallel int, refresh bool) error {
+	results := make([]*api.DatasetMetadata, len(datasetIDs))
+	errs := make([]error, len(datasetIDs))
+	// Failures are recorded per dataset, so the group never cancels itself.
+	var g errgroup.Group
+	g.SetLimit(parallel)
+	for i, id := range datasetIDs {
+		if ctx.Err() != nil {
+			break
+		}
+		g.Go(func() error {
+			dir := filepath.Join(outputDir, id+"-metadata")
+			results[i], errs[i] = cachedOrFetchMetadata(ctx, apiClient, dir, id, refresh, token)
+			return nil
+		})
+	}
+	g.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Write sequentially so per-dataset output is not interleaved.
+	var combined []map[string]interface{}
+	var failed int
+	for i, id := range datasetIDs {
+		dir := filepath.Join(outputDir, id+"-metadata")
+		if errs[i] == nil {
+			slog.Info("Writing metadata", "dataset", id)
+			errs[i] = writeMetadataExport(results[i], id, dir, format, merge, fields)
+		}
+		if errs[i] == nil && xml {
+			errs[i] = writeMetadataXML(ctx, apiClient, token, results[i], dir, refresh)
+		}
+		if errs[i] != nil {
+			slog.Warn("Metadata export failed", "dataset", id, "error", errs[i])
+			failed++
+			continue
+		}
+		records, _ := selectFields(buildMergedMetadata(results[i], merge), fields)
+		for _, rec := range records {
+			row := make(map[string]interface{}, len(rec)+1)
+			for k, v := range rec {
+				row[k] = v
+			}
+			row["dataset_accession_id"] = id
+			combined = append(combined, row)
+		}
+	}
+
+	if failed < len(datasetIDs) {
+		var columns []string
+		if len(fields) > 0 {
+			columns = parseFields(append([]string{"dataset_accession_id"}, fields...))
+		}
+		combinedName := "combined_merged_metadata." + format
+		if err := writeRecordsColumns(filepath.Join(outputDir, combinedName), format, combined, columns); err != nil {
+			return fmt.Errorf("write %s: %w", combinedName, err)
+		}
+		slog.Info("Wrote combined metadata", "file", filepath.Join(outputDir, combinedName), "records", len(combined), "datasets", len(datasetIDs)-failed)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d dataset(s) failed", failed, len(datasetIDs))
+	}
+	return nil
+}
+
+// streamMetadataExport writes each mapping of datasetID to
+// outputDir/<mapping>.ndjson as it is decoded, so memory use does not grow
+// with the size of the dataset.
+func streamMetadataExport(ctx context.Context, apiClient *api.Client, token func() (string, error), datasetID, outputDir string) error {
+	metaToken, err := token()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("create output directory: %w", err)
+	}
+
+	slog.Info("Streaming metadata mappings...", "dataset", datasetID)
+	spinner := startSpinner("Streaming metadata of " + datasetID)
+	defer spinner.Stop()
+	for _, name := range api.MappingNames {
+		fileName := name + ".ndjson"
+		n, err := streamMappingToFile(ctx, apiClient, metaToken, datasetID, name, filepath.Join(outputDir, fileName), func(records int) {
+			spinner.Update(mappingPhase(datasetID, name, records))
+		})
+		if err != nil {
+			return fmt.Errorf("write %s: %w", fileName, err)
+		}
+		slog.Info("Wrote metadata table", "file", filepath.Join(outputDir, fileName), "records", n)
+	}
+	return nil
+}
+
+// streamMappingToFile streams one mapping to path as NDJSON and returns the
+// number of records written. onRecord is called with the count so far.
+func streamMappingToFile(ctx context.Context, apiClient *api.Client, metaToken, datasetID, mapping, path string, onRecord func(records int)) (int, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	n := 0
+	err = apiClient.StreamDatasetMapping(ctx, metaToken, datasetID, mapping, func(rec map[string]interface{}) error {
+		n++
+		onRecord(n)
+		return enc.Encode(rec)
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, w.Flush()
+}
+
+// writeAccessConditions writes the dataset's DAC and policy information to
+// outputDir: access_conditions.json with the full API objects, and for
+// tabular formats a single-row access_conditions table with the fields data
+// stewards usually need.
+func writeAccessConditions(access *api.AccessConditions, outputDir, format string) error {
+	jsonName := "access_conditions.json"
+	f, err := os.Create(filepath.Join(outputDir, jsonName))
+	if err != nil {
+		return fmt.Errorf("write %s: %w", jsonName, err)
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(access)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("write %s: %w", jsonName, err)
+	}
+	slog.Info("Wrote access conditions", "file", filepath.Join(outputDir, jsonName))
+
+	if format == "json" {
+		return nil
+	}
+
+	var names, emails []string
+	if contacts, ok := access.DAC["contacts"].([]interface{}); ok {
+		for _, c := range contacts {
+			contact, _ := c.(map[string]interface{})
+			if name := firstValue(contact, "name", "full_name"); name != "" {
+				names = append(names, name)
+			}
+			if email := firstValue(contact, "email", "email_address"); email != "" {
+				emails = append(emails, email)
+			}
+		}
+	}
+
+	row := map[string]interface{}{
+		"dataset_accession_id": access.DatasetID,
+		"dac_accession_id":     firstValue(access.DAC, "accession_id"),
+		"dac_title":            firstValue(access.DAC, "title", "name"),
+		"dac_contact_name":     strings.Join(names, "; "),
+		"dac_contact_email":    strings.Join(emails, "; "),
+		"policy_accession_id":  firstValue(access.Policy, "accession_id"),
+		"policy_title":         firstValue(access.Policy, "title"),
+		"policy_url":           firstValue(access.Policy, "url", "policy_url"),
+		"data_use_conditions":  dataUseConditions(access.Policy),
+	}
+	columns := []string{
+		"dataset_accession_id", "dac_accession_id", "dac_title", "dac_contact_name",
+		"dac_contact_email", "policy_accession_id", "policy_title", "policy_url",
+		"data_use_conditions",
+	}
+	tableName := "access_conditions." + format
+	if err := writeRecordsColumns(filepath.Join(outputDir, tableName), format, []map[string]interface{}{row}, columns); err != nil {
+		return fmt.Errorf("write %s: %w", tableName, err)
+	}
+	slog.Info("Wrote access conditions table", "file", filepath.Join(outputDir, tableName))
+	return nil
+}
+
+// dataUseConditions returns the policy's data-use conditions (DUO terms) as
+// a "; "-separated list, e.g. "DUO:0000007 disease specific research".
+func dataUseConditions(policy map[string]interface{}) string {
+	var terms []string
+	for _, key := range []string{"data_uses", "data_use_conditions", "duo"} {
+		list, ok := policy[key].([]interface{})
+		if !ok {
+			continue
+		}
+		for _, item := range list {
+			term, ok := item.(map[string]interface{})
+			if !ok {
+				terms = append(terms, formatValue(item))
+				continue
+			}
+			code := firstValue(term, "code", "id", "duo_id")
+			label := firstValue(term, "label", "shorthand", "description")
+			terms = append(terms, strings.TrimSpace(code+" "+label))
+		}
+		break
+	}
+	return strings.Join(terms, "; ")
+}
+
+// localSampleAnnotations maps file IDs to their samples using metadata that
+// is already on disk in {dir}/{datasetID}-metadata — the metadata cache,
+// regardless of age, or an export. It never contacts the API and returns nil
+// if no metadata is found.
+func localSampleAnnotations(dir, datasetID string) map[string]ui.SampleAnnotation {
+	exportDir := filepath.Join(dir, datasetID+"-metadata")
+	var meta *api.DatasetMetadata
+	var cached api.DatasetMetadata
+	fetchedAt, err := metadataCache(exportDir).LoadMetadataCache(datasetID, 0, &cached)
+	if err == nil && !fetchedAt.IsZero() {
+		meta = &cached
+	} else {
+		meta, _, _ = loadMetadataMappings(exportDir)
+	}
+	if meta == nil {
+		return nil
+	}
+	return sampleAnnotations(meta)
+}
+
+// sampleAnnotations maps each file in the sample_file mapping to its sample
+// accession and alias.
+func sampleAnnotations(meta *api.DatasetMetadata) map[string]ui.SampleAnnotation {
+	aliases := make(map[string]string)
+	for _, records := range mappingTables(meta) {
+		for _, rec := range records {
+			sampleID := formatValue(rec["sample_accession_id"])
+			if sampleID == "" || aliases[sampleID] != "" {
+				continue
+			}
+			aliases[sampleID] = firstValue(rec, "sample_alias", "alias", "sample_title")
+		}
+	}
+
+	annotations := make(map[string]ui.SampleAnnotation)
+	for _, rec := range meta.SampleFile {
+		fileID := formatValue(rec["file_accession_id"])
+		sampleID := formatValue(rec["sample_accession_id"])
+		if fileID == "" || sampleID == "" {
+			continue
+		}
+		if _, ok := annotations[fileID]; !ok {
+			annotations[fileID] = ui.SampleAnnotation{SampleID: sampleID, Alias: aliases[sampleID]}
+		}
+	}
+	return annotations
+}
+
+// mappingTables returns every mapping table of meta.
+func mappingTables(meta *api.DatasetMetadata) [][]map[string]interface{} {
+	return [][]map[string]interface{}{
+		meta.StudyExperimentRunSample, meta.RunSample, meta.StudyAnalysisSample,
+		meta.AnalysisSample, meta.SampleFile,
+	}
+}
+
+// onceToken wraps a metadata token source so that it is called at most once
+// and its result shared, e.g. to prompt for the password only once.
+func onceToken(fn func() (string, error)) func() (string, error) {
+	var once sync.Once
+	var token string
+	var err error
+	return func() (string, error) {
+		once.Do(func() { token, err = fn() })
+		return token, err
+	}
+}
+
+// xmlEntities maps mapping columns to the collection names used by the XML
+// document endpoints.
+var xmlEntities = []struct {
+	column string
+	entity string
+}{
+	{"study_accession_id", "studies"},
+	{"experiment_accession_id", "experiments"},
+	{"run_accession_id", "runs"},
+	{"analysis_accession_id", "analyses"},
+	{"sample_accession_id", "samples"},
+}
+
+// xmlFetchParallelism bounds concurrent XML document requests.
+const xmlFetchParallelism = 8
+
+// writeMetadataXML downloads the original submitted XML documents for every
+// study, experiment, run, analysis, and sample referenced by the mappings to
+// outputDir/xml/<entity>/<accession>.xml. Documents already on disk are
+// kept unless refresh is set.
+func writeMetadataXML(ctx context.Context, apiClient *api.Client, token func() (string, error), meta *api.DatasetMetadata, outputDir string, refresh bool) error {
+	tables := mappingTables(meta)
+
+	type xmlDoc struct{ entity, accession string }
+	var docs []xmlDoc
+	seen := make(map[xmlDoc]bool)
+	for _, e := range xmlEntities {
+		for _, records := range tables {
+			for _, rec := range records {
+				d := xmlDoc{e.entity, formatValue(rec[e.column])}
+				if d.accession != "" && !seen[d] {
+					seen[d] = true
+					docs = append(docs, d)
+				}
+			}
+		}
+	}
+	if len(docs) == 0 {
+		slog.Info("Wrote XML documents (no accessions found)", "dir", filepath.Join(outputDir, "xml"))
+		return nil
+	}
+
+	metaToken, err := token()
+	if err != nil {
+		return err
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(xmlFetchParallelism)
+	var mu sync.Mutex
+	var fetched, skipped, missing int
+	for _, d := range docs {
+		path := filepath.Join(outputDir, "xml", d.entity, d.accession+".xml")
+		if _, err := os.Stat(path); err == nil && !refresh {
+			skipped++
+			continue
+		}
+
+		g.Go(func() error {
+			data, err := apiClient.FetchXMLDocument(ctx, metaToken, d.entity, d.accession)
+			var apiErr *api.APIError
+			if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+				// Some accessions have no XML document; skip them rather
+				// than abort the export.
+				slog.Warn("No XML document, skipping", "entity", d.entity, "accession", d.accession)
+				mu.Lock()
+				missing++
+				mu.Unlock()
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return fmt.Errorf("create XML directory: %w", err)
+			}
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				return fmt.Errorf("write %s: %w", path, err)
+			}
+			mu.Lock()
+			fetched++
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	slog.Info("Wrote XML documents", "dir", filepath.Join(outputDir, "xml"), "fetched", fetched, "present", skipped, "not_found", missing)
+	return nil
+}
+
+// loadMetadataMappings reads the mapping files written by writeMetadataExport
+// from dir. It returns (nil, "", nil) if dir holds no complete export.
+func loadMetadataMappings(dir string) (*api.DatasetMetadata, string, error) {
+	for _, format := range []string{"json", "ndjson", "tsv", "csv"} {
+		if _, err := os.Stat(filepath.Join(dir, "sample_file."+format)); err != nil {
+			continue
+		}
+
+		meta := &api.DatasetMetadata{}
+		mappings := []struct {
+			name string
+			dest *[]map[string]interface{}
+		}{
+			{"study_experiment_run_sample", &meta.StudyExperimentRunSample},
+			{"run_sample", &meta.RunSample},
+			{"study_analysis_sample", &meta.StudyAnalysisSample},
+			{"analysis_sample", &meta.AnalysisSample},
+			{"sample_file", &meta.SampleFile},
+		}
+		for _, m := range mappings {
+			path := filepath.Join(dir, m.name+"."+format)
+			records, err := readRecords(path, format)
+			if err != nil {
+				return nil, "", fmt.Errorf("read %s: %w", path, err)
+			}
+			*m.dest = records
+		}
+		return meta, dir, nil
+	}
+	return nil, "", nil
+}
+
+// readRecords reads records written by writeRecords. Values read from TSV
+// and CSV files are returned as strings.
+func readRecords(path, format string) ([]map[string]interface{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch format {
+	case "json":
+		var records []map[string]interface{}
+		if err := json.NewDecoder(f).Decode(&records); err != nil {
+			return nil, err
+		}
+		return records, nil
+	case "ndjson":
+		records := []map[string]interface{}{}
+		dec := json.NewDecoder(f)
+		for {
+			var rec map[string]interface{}
+			if err := dec.Decode(&rec); err == io.EOF {
+				return records, nil
+			} else if err != nil {
+				return nil, err
+			}
+			records = append(records, rec)
+		}
+	}
+
+	r := csv.NewReader(f)
+	if format == "tsv" {
+		r.Comma = '\t'
+		r.LazyQuotes = true
+	}
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return []map[string]interface{}{}, nil
+	}
+
+	header := rows[0]
+	records := make([]map[string]interface{}, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		rec := make(map[string]interface{}, len(header))
+		for i, col := range header {
+			if i < len(row) && row[i] != "" {
+				rec[col] = row[i]
+			}
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
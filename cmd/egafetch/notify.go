@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/khan-lab/EGAfetch/internal/notify"
+)
+
+// desktopNotifyAfter is how long a run must take before it ends with a
+// desktop notification; shorter runs are still being watched.
+const desktopNotifyAfter = time.Minute
+
+// maxFailureNotices caps the file_failed events sent per run, so a dataset
+// that fails wholesale (e.g. access revoked) does not flood the channel. The
+// run_finished event reports how many were left out.
+const maxFailureNotices = 10
+
+// runNotifier sends the events of one download command to its webhooks,
+// and the end of the run to the desktop. A nil *runNotifier sends nothing.
+type runNotifier struct {
+	hooks   []notify.Webhook
+	desktop bool
+	host    string
+	start   time.Time
+
+	mu         sync.Mutex
+	summaries  []jsonDownloadSummary
+	notices    int
+	suppressed int
+	wg         sync.WaitGroup
+}
+
+// newRunNotifier returns a notifier for the given webhook URLs and, if
+// desktop is set, the desktop. It returns nil if there is nothing to notify.
+func newRunNotifier(urls []string, format string, desktop bool) *runNotifier {
+	if len(urls) == 0 && !desktop {
+		return nil
+	}
+	n := &runNotifier{start: time.Now(), desktop: desktop}
+	n.host, _ = os.Hostname()
+	for _, u := range urls {
+		n.hooks = append(n.hooks, notify.Webhook{URL: u, Format: format})
+	}
+	return n
+}
+
+// fileFailed reports a file that failed after all retries. Files stopped
+// by an interruption are not reported; run_finished covers them.
+func (n *runNotifier) fileFailed(outputDir, fileID, fileName string, err error) {
+	if n == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	n.mu.Lock()
+	if n.notices >= maxFailureNotices {
+		n.suppressed++
+		n.mu.Unlock()
+		return
+	}
+	n.notices++
+	n.mu.Unlock()
+
+	n.send(notify.Event{
+		Event:     notify.EventFileFailed,
+		OutputDir: outputDir,
+		FileID:    fileID,
+		FileName:  fileName,
+		Error:     err.Error(),
+	})
+}
+
+// addSummary records the outcome of one output directory for run_finished.
+func (n *runNotifier) addSummary(s jsonDownloadSummary) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	n.summaries = append(n.summaries, s)
+	n.mu.Unlock()
+}
+
+// finish sends run_finished for the command's result err and waits for all
+// pending deliveries.
+func (n *runNotifier) finish(err error) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	summary := &notify.RunSummary{
+		DurationSeconds:    time.Since(n.start).Round(time.Second).Seconds(),
+		SuppressedFailures: n.suppressed,
+		OutputDirs:         []string{},
+	}
+	for _, s := range n.summaries {
+		summary.OutputDirs = append(summary.OutputDirs, s.OutputDir)
+		if s.DatasetID != "" {
+			summary.DatasetIDs = append(summary.DatasetIDs, s.DatasetID)
+		}
+		summary.TotalFiles += len(s.Files)
+		summary.Complete += s.Complete
+		summary.Failed += s.Failed
+		summary.TotalSize += s.TotalSize
+	}
+	n.mu.Unlock()
+
+	summary.Status = runStatus(err, summary.Complete)
+	e := notify.Event{Event: notify.EventRunFinished, Summary: summary}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	n.send(e)
+	if n.desktop && time.Since(n.start) >= desktopNotifyAfter {
+		n.notifyDesktop(summary)
+	}
+	n.wg.Wait()
+}
+
+// runStatus classifies the result err of a download in which complete files
+// are done as one of the notify.Status values.
+func runStatus(err error, complete int) string {
+	switch {
+	case err == nil:
+		return notify.StatusComplete
+	case exitCode(err) == exitInterrupted:
+		return notify.StatusInterrupted
+	case complete > 0:
+		return notify.StatusPartial
+	}
+	return notify.StatusFailed
+}
+
+// notifyDesktop shows the outcome of the run as a desktop notification.
+func (n *runNotifier) notifyDesktop(summary *notify.RunSummary) {
+	titles := map[string]string{
+		notify.StatusComplete:    "EGAfetch: download complete",
+		notify.StatusPartial:     "EGAfetch: download incomplete",
+		notify.StatusFailed:      "EGAfetch: download failed",
+		notify.StatusInterrupted: "EGAfetch: download stopped",
+	}
+	// Desktops without a notification service are common (SSH, containers),
+	// so failures are not worth a warning.
+	if err := notify.Desktop(context.Background(), titles[summary.Status], summary.Line()); err != nil {
+		slog.Debug("Desktop notification not shown", "error", err)
+	}
+}
+
+// interactiveSession reports whether a person is likely watching this run:
+// progress is shown and stderr is a terminal.
+func interactiveSession() bool {
+	return showProgress() && term.IsTerminal(int(os.Stderr.Fd()))
+}
+
+// send delivers e to every webhook in the background. Delivery failures
+// are logged and never fail the download.
+func (n *runNotifier) send(e notify.Event) {
+	e.Time = time.Now().UTC()
+	e.Host = n.host
+	for _, h := range n.hooks {
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			// Not tied to the command's context, so the final event still
+			// goes out after Ctrl+C.
+			if err := h.Send(context.Background(), e); err != nil {
+				slog.Warn("Webhook notification failed", "event", e.Event, "host", h.Host(), "error", err)
+			}
+		}()
+	}
+}
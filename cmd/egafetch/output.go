@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+	"github.com/khan-lab/EGAfetch/internal/ui"
+)
+
+// jsonAnnotation marks commands that support the global --json flag.
+const jsonAnnotation = "egafetch/json"
+
+// jsonOutput is set by the global --json flag.
+var jsonOutput bool
+
+// supportsJSON marks cmd as accepting --json.
+func supportsJSON(cmd *cobra.Command) *cobra.Command {
+	if cmd.Annotations == nil {
+		cmd.Annotations = make(map[string]string)
+	}
+	cmd.Annotations[jsonAnnotation] = "true"
+	return cmd
+}
+
+// setupJSONOutput validates --json for cmd. It runs as the root command's
+// PersistentPreRunE.
+func setupJSONOutput(cmd *cobra.Command, args []string) error {
+	if !jsonOutput {
+		return nil
+	}
+	if cmd.Annotations[jsonAnnotation] != "true" {
+		// EGAFETCH_JSON applies to the commands that support it.
+		if _, fromEnv := envFlags["json"]; fromEnv {
+			jsonOutput = false
+			return nil
+		}
+		return fmt.Errorf("--json is not supported by '%s'", cmd.CommandPath())
+	}
+	return nil
+}
+
+// textOutput returns where cmd prints human-readable results: stdout
+// normally, or stderr under --json so that stdout carries a single JSON
+// document.
+func textOutput(cmd *cobra.Command) io.Writer {
+	if jsonOutput {
+		return cmd.ErrOrStderr()
+	}
+	return cmd.OutOrStdout()
+}
+
+// printJSON writes v to cmd's stdout as indented JSON.
+func printJSON(cmd *cobra.Command, v interface{}) error {
+	enc := json.NewEncoder(cmd.OutOrStdout())
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// jsonDataset describes an authorized dataset in 'list' JSON output.
+type jsonDataset struct {
+	DatasetID   string `json:"dataset_id"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	NumSamples  int    `json:"num_samples,omitempty"`
+}
+
+// jsonFile describes a dataset file in JSON output.
+type jsonFile struct {
+	FileID       string `json:"file_id"`
+	FileName     string `json:"file_name"`
+	Size         int64  `json:"size"`
+	Checksum     string `json:"checksum,omitempty"`
+	ChecksumType string `json:"checksum_type,omitempty"`
+	SampleID     string `json:"sample_accession_id,omitempty"`
+	SampleAlias  string `json:"sample_alias,omitempty"`
+}
+
+// jsonFileList is the JSON output of 'list EGAD...' and 'download --dry-run'.
+type jsonFileList struct {
+	DatasetID string     `json:"dataset_id,omitempty"`
+	Files     []jsonFile `json:"files"`
+	TotalSize int64      `json:"total_size"`
+}
+
+// newJSONFileList converts display file info into JSON output.
+func newJSONFileList(datasetID string, files []ui.FileInfo) jsonFileList {
+	list := jsonFileList{DatasetID: datasetID, Files: []jsonFile{}}
+	for _, f := range files {
+		list.Files = append(list.Files, jsonFile{
+			FileID:       f.FileID,
+			FileName:     f.FileName,
+			Size:         f.FileSize,
+			Checksum:     f.Checksum,
+			ChecksumType: f.ChecksumType,
+			SampleID:     f.Sample.SampleID,
+			SampleAlias:  f.Sample.Alias,
+		})
+		list.TotalSize += f.FileSize
+	}
+	return list
+}
+
+// jsonFileState describes the download state of a file in JSON output.
+type jsonFileState struct {
+	FileID          string `json:"file_id"`
+	FileName        string `json:"file_name"`
+	OriginalName    string `json:"original_name,omitempty"`
+	Status          string `json:"status"`
+	Size            int64  `json:"size"`
+	BytesDownloaded int64  `json:"bytes_downloaded"`
+	Error           string `json:"error,omitempty"`
+	SampleID        string `json:"sample_accession_id,omitempty"`
+	SampleAlias     string `json:"sample_alias,omitempty"`
+}
+
+// newJSONFileState converts a file state into JSON output.
+func newJSONFileState(fs *state.FileState, sample ui.SampleAnnotation) jsonFileState {
+	downloaded := fs.Size
+	if fs.Status != state.StatusComplete {
+		downloaded = fs.BytesDownloaded()
+	}
+	return jsonFileState{
+		FileID:          fs.FileID,
+		FileName:        fs.FileName,
+		OriginalName:    fs.OriginalName,
+		Status:          string(fs.Status),
+		Size:            fs.Size,
+		BytesDownloaded: downloaded,
+		Error:           fs.Error,
+		SampleID:        sample.SampleID,
+		SampleAlias:     sample.Alias,
+	}
+}
+
+// jsonStatus is the JSON output of 'status'.
+type jsonStatus struct {
+	Directory string          `json:"directory"`
+	Files     []jsonFileState `json:"files"`
+	Processes []claimHolder   `json:"processes,omitempty"` // of a --shared download
+}
+
+// jsonDownloadSummary is the JSON output of 'download'.
+type jsonDownloadSummary struct {
+	OutputDir   string          `json:"output_dir"`
+	DatasetID   string          `json:"dataset_id,omitempty"`
+	Files       []jsonFileState `json:"files"`
+	Complete    int             `json:"complete"`
+	Failed      int             `json:"failed"`
+	TotalSize   int64           `json:"total_size"`
+	MetadataDir string          `json:"metadata_dir,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// newJSONDownloadSummary reads the state of every manifest file from sm.
+func newJSONDownloadSummary(manifest *state.Manifest, sm *state.StateManager) jsonDownloadSummary {
+	summary := jsonDownloadSummary{
+		OutputDir: sm.BaseDir(),
+		DatasetID: manifest.DatasetID,
+		Files:     []jsonFileState{},
+	}
+	for _, f := range manifest.Files {
+		fs, err := sm.LoadFileState(f.FileID)
+		if err != nil || fs == nil {
+			fs = state.NewFileState(f, 0)
+		}
+		entry := newJSONFileState(fs, ui.SampleAnnotation{})
+		switch fs.Status {
+		case state.StatusComplete:
+			summary.Complete++
+		case state.StatusFailed:
+			summary.Failed++
+		}
+		summary.TotalSize += f.Size
+		summary.Files = append(summary.Files, entry)
+	}
+	return summary
+}
+
+// jsonVerifyResult is the outcome of verifying one file.
+type jsonVerifyResult struct {
+	FileID   string `json:"file_id"`
+	FileName string `json:"file_name"`
+	Result   string `json:"result"` // "ok", "fail", or "skip"
+	Reason   string `json:"reason,omitempty"`
+}
+
+// jsonVerify is the JSON output of 'verify'.
+type jsonVerify struct {
+	Directory string             `json:"directory"`
+	Results   []jsonVerifyResult `json:"results"`
+	Passed    int                `json:"passed"`
+	Failed    int                `json:"failed"`
+	Skipped   int                `json:"skipped"`
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"runtime"
+	"slices"
+	"strconv"
+
+	"github.com/khan-lab/EGAfetch/internal/download"
+)
+
+// parseFileMode parses an octal permission mode such as "0640" or "2775".
+// The setuid, setgid, and sticky bits are kept.
+func parseFileMode(s string) (os.FileMode, error) {
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || v == 0 || v > 0o7777 {
+		return 0, fmt.Errorf("expected an octal mode such as 0640 or 2775, got %q", s)
+	}
+	mode := os.FileMode(v & 0o777)
+	if v&0o4000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if v&0o2000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if v&0o1000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode, nil
+}
+
+// outputPermissions builds the permissions for --file-mode, --dir-mode, and
+// --group. The group is a name or numeric ID that the user must belong to.
+func outputPermissions(fileMode, dirMode, group string) (download.Permissions, error) {
+	var p download.Permissions
+	if fileMode == "" && dirMode == "" && group == "" {
+		return p, nil
+	}
+	if runtime.GOOS == "windows" {
+		return p, fmt.Errorf("--file-mode, --dir-mode, and --group are not supported on Windows")
+	}
+
+	var err error
+	if fileMode != "" {
+		if p.FileMode, err = parseFileMode(fileMode); err != nil {
+			return p, fmt.Errorf("invalid file-mode: %w", err)
+		}
+	}
+	if dirMode != "" {
+		if p.DirMode, err = parseFileMode(dirMode); err != nil {
+			return p, fmt.Errorf("invalid dir-mode: %w", err)
+		}
+	}
+	if group != "" {
+		if p.GID, err = lookupGroup(group); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// lookupGroup resolves a group name or ID, and checks that files can be
+// given to it: only members of a group (or root) can.
+func lookupGroup(group string) (int, error) {
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		if g, err = user.LookupGroupId(group); err != nil {
+			return 0, fmt.Errorf("unknown group %q", group)
+		}
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return 0, fmt.Errorf("group %q has non-numeric ID %q", group, g.Gid)
+	}
+	if gid == 0 {
+		return 0, fmt.Errorf("--group cannot be the root group")
+	}
+	if os.Geteuid() == 0 || gid == os.Getgid() {
+		return gid, nil
+	}
+	groups, err := os.Getgroups()
+	if err != nil {
+		return 0, fmt.Errorf("list your groups: %w", err)
+	}
+	if !slices.Contains(groups, gid) {
+		return 0, fmt.Errorf("you are not a member of group %q; files can only be given to your own groups (see 'id -Gn')", g.Name)
+	}
+	return gid, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseFileMode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    os.FileMode
+		wantErr string
+	}{
+		{in: "0640", want: 0o640},
+		{in: "644", want: 0o644},
+		{in: "2775", want: os.ModeSetgid | 0o775},
+		{in: "1777", want: os.ModeSticky | 0o777},
+		{in: "0", wantErr: "expected an octal mode"},
+		{in: "0855", wantErr: "expected an octal mode"},
+		{in: "17777", wantErr: "expected an octal mode"},
+		{in: "rw-r-----", wantErr: "expected an octal mode"},
+	}
+	for _, tt := range tests {
+		got, err := parseFileMode(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseFileMode(%q) error = %v, want containing %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseFileMode(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+	"time"
+)
+
+// Global profiling flags, for diagnosing throughput and memory on transfer
+// nodes without a custom build.
+var (
+	pprofAddr  string // --pprof: address to serve net/http/pprof on
+	cpuProfile string // --cpu-profile: file to write a CPU profile of the command to
+	memProfile string // --mem-profile: file to write a heap profile to at exit
+)
+
+// stopProfiling finishes the profiles that startProfiling started. It is
+// called on exit, whether or not the command succeeded.
+var stopProfiling = func() {}
+
+// startProfiling starts the profiling of the global flags. It runs before
+// every command.
+func startProfiling() error {
+	var stops []func()
+	if pprofAddr != "" {
+		ln, err := net.Listen("tcp", pprofAddr)
+		if err != nil {
+			return fmt.Errorf("--pprof: %w", err)
+		}
+		if !loopbackAddr(ln.Addr()) {
+			slog.Warn("Profiling server is reachable from other machines; it exposes the process's memory without authentication", "address", ln.Addr().String())
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Warn("Profiling server stopped", "error", err)
+			}
+		}()
+		slog.Info("Serving profiles", "url", "http://"+ln.Addr().String()+"/debug/pprof/")
+		stops = append(stops, func() { srv.Close() })
+	}
+	if cpuProfile != "" {
+		f, err := os.Create(cpuProfile)
+		if err != nil {
+			return fmt.Errorf("--cpu-profile: %w", err)
+		}
+		if err := rpprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return fmt.Errorf("--cpu-profile: %w", err)
+		}
+		stops = append(stops, func() {
+			rpprof.StopCPUProfile()
+			if err := f.Close(); err != nil {
+				slog.Warn("Could not write CPU profile", "file", cpuProfile, "error", err)
+			}
+		})
+	}
+	if memProfile != "" {
+		stops = append(stops, func() {
+			if err := writeHeapProfile(memProfile); err != nil {
+				slog.Warn("Could not write heap profile", "file", memProfile, "error", err)
+			}
+		})
+	}
+	stopProfiling = func() {
+		for _, stop := range stops {
+			stop()
+		}
+		stops = nil
+	}
+	return nil
+}
+
+// writeHeapProfile writes a profile of the memory in use to path.
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	// Up to date with the memory freed since the last collection.
+	runtime.GC()
+	if err := rpprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
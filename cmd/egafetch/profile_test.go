@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProfiling(t *testing.T) {
+	dir := t.TempDir()
+	cpuProfile, memProfile = filepath.Join(dir, "cpu.pprof"), filepath.Join(dir, "mem.pprof")
+	defer func() { cpuProfile, memProfile = "", "" }()
+
+	if err := startProfiling(); err != nil {
+		t.Fatal(err)
+	}
+	stopProfiling()
+	for _, path := range []string{cpuProfile, memProfile} {
+		if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+			t.Errorf("profile %s not written: %v", filepath.Base(path), err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+// progressBytesInterval is the minimum time between bytes events for one
+// file. The final bytes of a file are always reported.
+const progressBytesInterval = 500 * time.Millisecond
+
+// --progress-json event types.
+const (
+	progressFileStarted   = "file_started"
+	progressBytes         = "bytes"
+	progressFileCompleted = "file_completed"
+	progressFileFailed    = "file_failed"
+	progressRunSummary    = "run_summary"
+)
+
+// progressEvent is one file event in the --progress-json stream.
+type progressEvent struct {
+	Event           string    `json:"event"`
+	Time            time.Time `json:"time"`
+	OutputDir       string    `json:"output_dir"`
+	FileID          string    `json:"file_id"`
+	FileName        string    `json:"file_name"`
+	Size            int64     `json:"size"`
+	BytesDownloaded int64     `json:"bytes_downloaded"`
+	Skipped         bool      `json:"skipped,omitempty"` // file_completed: was already complete
+	Error           string    `json:"error,omitempty"`
+}
+
+// progressSummaryEvent ends the events of one output directory.
+type progressSummaryEvent struct {
+	Event  string    `json:"event"`
+	Time   time.Time `json:"time"`
+	Status string    `json:"status"`
+	jsonDownloadSummary
+}
+
+// progressFile tracks one file of the stream.
+type progressFile struct {
+	name     string
+	size     int64
+	bytes    int64
+	lastSent time.Time
+}
+
+// progressStream writes download events as newline-delimited JSON for
+// programs that draw their own progress. A nil *progressStream writes
+// nothing.
+type progressStream struct {
+	mu     sync.Mutex
+	closer io.Closer
+	enc    *json.Encoder
+	files  map[[2]string]*progressFile   // by output directory and file ID
+	wrap   func(interface{}) interface{} // wraps each event, e.g. in a JSON-RPC notification; nil = none
+}
+
+// openProgressStream opens the --progress-json destination: stdout for
+// "-", otherwise the file or named pipe at path. Opening a pipe waits for
+// its reader.
+func openProgressStream(cmd *cobra.Command, path string) (*progressStream, error) {
+	if path == "-" {
+		if jsonOutput {
+			return nil, fmt.Errorf("--progress-json cannot share stdout with --json; give it a file path (--progress-json=PATH)")
+		}
+		return newProgressStream(cmd.OutOrStdout(), nil), nil
+	}
+	flags := os.O_WRONLY
+	if info, err := os.Stat(path); err != nil || info.Mode().IsRegular() {
+		flags |= os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open progress stream: %w", err)
+	}
+	return newProgressStream(f, f), nil
+}
+
+func newProgressStream(w io.Writer, closer io.Closer) *progressStream {
+	return &progressStream{closer: closer, enc: json.NewEncoder(w), files: make(map[[2]string]*progressFile)}
+}
+
+// addManifest registers the files of one output directory, with the bytes
+// already on disk.
+func (p *progressStream) addManifest(manifest *state.Manifest, sm *state.StateManager) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, f := range manifest.Files {
+		p.files[[2]string{sm.BaseDir(), f.FileID}] = &progressFile{name: f.FileName, size: f.Size, bytes: bytesPresent(sm, f)}
+	}
+}
+
+func (p *progressStream) fileStarted(outputDir, fileID string) {
+	p.fileEvent(progressFileStarted, outputDir, fileID, nil)
+}
+
+// bytes reports the bytes of a file downloaded so far, at most every
+// progressBytesInterval.
+func (p *progressStream) bytes(outputDir, fileID string, downloaded int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f := p.file(outputDir, fileID)
+	f.bytes = downloaded
+	now := time.Now()
+	if now.Sub(f.lastSent) < progressBytesInterval && downloaded < f.size {
+		return
+	}
+	f.lastSent = now
+	p.writeLocked(p.eventLocked(progressBytes, outputDir, fileID))
+}
+
+func (p *progressStream) fileCompleted(outputDir, fileID string, skipped bool) {
+	p.fileEvent(progressFileCompleted, outputDir, fileID, func(e *progressEvent) {
+		e.BytesDownloaded = e.Size
+		e.Skipped = skipped
+	})
+}
+
+func (p *progressStream) fileFailed(outputDir, fileID string, err error) {
+	p.fileEvent(progressFileFailed, outputDir, fileID, func(e *progressEvent) {
+		e.Error = err.Error()
+	})
+}
+
+// runSummary ends the events of one output directory; err is the result of
+// its download.
+func (p *progressStream) runSummary(summary jsonDownloadSummary, err error) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.writeLocked(progressSummaryEvent{
+		Event:               progressRunSummary,
+		Time:                time.Now().UTC(),
+		Status:              runStatus(err, summary.Complete),
+		jsonDownloadSummary: summary,
+	})
+}
+
+// Close closes the destination unless it is stdout.
+func (p *progressStream) Close() error {
+	if p == nil || p.closer == nil {
+		return nil
+	}
+	return p.closer.Close()
+}
+
+func (p *progressStream) fileEvent(event, outputDir, fileID string, edit func(*progressEvent)) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e := p.eventLocked(event, outputDir, fileID)
+	if edit != nil {
+		edit(&e)
+	}
+	p.writeLocked(e)
+}
+
+func (p *progressStream) file(outputDir, fileID string) *progressFile {
+	key := [2]string{outputDir, fileID}
+	f := p.files[key]
+	if f == nil {
+		f = &progressFile{}
+		p.files[key] = f
+	}
+	return f
+}
+
+func (p *progressStream) eventLocked(event, outputDir, fileID string) progressEvent {
+	f := p.file(outputDir, fileID)
+	return progressEvent{
+		Event:           event,
+		Time:            time.Now().UTC(),
+		OutputDir:       outputDir,
+		FileID:          fileID,
+		FileName:        f.name,
+		Size:            f.size,
+		BytesDownloaded: f.bytes,
+	}
+}
+
+// writeLocked writes one event. A reader that went away stops the stream
+// but not the download.
+func (p *progressStream) writeLocked(v interface{}) {
+	if p.enc == nil {
+		return
+	}
+	if p.wrap != nil {
+		v = p.wrap(v)
+	}
+	if err := p.enc.Encode(v); err != nil {
+		slog.Warn("Progress stream closed; no more events will be written", "error", err)
+		p.enc = nil
+	}
+}
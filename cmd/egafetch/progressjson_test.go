@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+func TestProgressStream(t *testing.T) {
+	sm := state.NewStateManager(t.TempDir())
+	manifest := &state.Manifest{Files: []state.FileSpec{
+		{FileID: "EGAF00000000001", FileName: "a.bam", Size: 100},
+		{FileID: "EGAF00000000002", FileName: "b.bam", Size: 0},
+	}}
+	dir := sm.BaseDir()
+
+	var buf bytes.Buffer
+	p := newProgressStream(&buf, nil)
+	p.addManifest(manifest, sm)
+	p.fileStarted(dir, "EGAF00000000001")
+	p.bytes(dir, "EGAF00000000001", 10)
+	p.bytes(dir, "EGAF00000000001", 20) // within progressBytesInterval: dropped
+	p.bytes(dir, "EGAF00000000001", 100)
+	p.fileCompleted(dir, "EGAF00000000001", false)
+	p.fileCompleted(dir, "EGAF00000000002", true)
+	p.fileFailed(dir, "EGAF00000000003", errors.New("checksum mismatch"))
+	p.runSummary(jsonDownloadSummary{OutputDir: dir, Files: []jsonFileState{}, Complete: 2, Failed: 1}, errors.New("1 file(s) failed"))
+
+	type line struct {
+		Event           string `json:"event"`
+		FileName        string `json:"file_name"`
+		Size            *int64 `json:"size"`
+		BytesDownloaded *int64 `json:"bytes_downloaded"`
+		Skipped         bool   `json:"skipped"`
+		Error           string `json:"error"`
+		Status          string `json:"status"`
+		Complete        int    `json:"complete"`
+	}
+	var got []line
+	for _, raw := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var l line
+		if err := json.Unmarshal([]byte(raw), &l); err != nil {
+			t.Fatalf("invalid event %q: %v", raw, err)
+		}
+		got = append(got, l)
+	}
+
+	wantEvents := []string{"file_started", "bytes", "bytes", "file_completed", "file_completed", "file_failed", "run_summary"}
+	if len(got) != len(wantEvents) {
+		t.Fatalf("got %d events, want %d:\n%s", len(got), len(wantEvents), buf.String())
+	}
+	for i, want := range wantEvents {
+		if got[i].Event != want {
+			t.Errorf("event %d = %q, want %q", i, got[i].Event, want)
+		}
+	}
+	if got[0].FileName != "a.bam" || got[0].Size == nil || *got[0].Size != 100 {
+		t.Errorf("file_started = %+v, want a.bam of size 100", got[0])
+	}
+	if b := got[2].BytesDownloaded; b == nil || *b != 100 {
+		t.Errorf("final bytes event = %v, want 100", b)
+	}
+	if !got[4].Skipped || got[4].Size == nil || got[4].BytesDownloaded == nil {
+		t.Errorf("skipped empty file = %+v, want skipped with size and bytes_downloaded 0", got[4])
+	}
+	if got[5].Error != "checksum mismatch" {
+		t.Errorf("file_failed error = %q", got[5].Error)
+	}
+	if got[6].Status != "partial" || got[6].Complete != 2 {
+		t.Errorf("run_summary = %+v, want partial with 2 complete", got[6])
+	}
+}
+
+func TestProgressStreamNil(t *testing.T) {
+	var p *progressStream
+	p.fileStarted("out", "EGAF00000000001")
+	p.bytes("out", "EGAF00000000001", 1)
+	p.runSummary(jsonDownloadSummary{}, nil)
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/auth"
+	"github.com/khan-lab/EGAfetch/internal/download"
+	"github.com/khan-lab/EGAfetch/internal/state"
+	"github.com/khan-lab/EGAfetch/internal/ui"
+	"github.com/khan-lab/EGAfetch/internal/verify"
+)
+
+// EGA's public test account and its dataset, published for checking clients.
+const (
+	testAccountUsername = "ega-test-data@ebi.ac.uk"
+	testAccountPassword = "egarocks"
+	testDatasetID       = "EGAD00001003338"
+)
+
+// --- Quickstart command ---
+
+func newQuickstartCmd() *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "quickstart",
+		Short: "Check connectivity by downloading a file from the EGA test dataset",
+		Long: `Run an end-to-end check against EGA's public test account: log in,
+list the test dataset (` + testDatasetID + `), download its smallest file,
+and verify the checksum. Use it to confirm that a new installation, proxy,
+or firewall setup can reach EGA before starting a real download.
+
+The test account login is kept in memory only and does not replace your
+saved session. The file is downloaded to a temporary directory and removed
+afterwards unless --output is given.`,
+		Example: `  egafetch quickstart
+  egafetch quickstart -o ./egafetch-test`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := signalContext()
+			defer cancel()
+
+			dir := output
+			if dir == "" {
+				tmp, err := os.MkdirTemp("", "egafetch-quickstart-")
+				if err != nil {
+					return fmt.Errorf("create temporary directory: %w", err)
+				}
+				defer os.RemoveAll(tmp)
+				dir = tmp
+			}
+
+			out := cmd.OutOrStdout()
+
+			// check prints the outcome of one step and passes its error on.
+			check := func(step string, err error) error {
+				if err != nil {
+					fmt.Fprintf(out, "  FAIL  %s: %v\n", step, err)
+					return err
+				}
+				fmt.Fprintf(out, "  OK    %s\n", step)
+				return nil
+			}
+
+			mgr := auth.NewEphemeralManager()
+			if err := check("Log in as "+testAccountUsername, mgr.Login(ctx, testAccountUsername, testAccountPassword)); err != nil {
+				return err
+			}
+
+			// List directly rather than via listDatasetFiles, so the test
+			// dataset does not end up in the completion cache.
+			apiClient := api.NewClient(mgr)
+			files, err := apiClient.ListDatasetFiles(ctx, testDatasetID)
+			if err == nil && len(files) == 0 {
+				err = fmt.Errorf("dataset has no files")
+			}
+			if err := check("List files in "+testDatasetID, err); err != nil {
+				return err
+			}
+
+			// The smallest file keeps the check quick.
+			spec := datasetFileSpec(&files[0], state.LayoutByFile)
+			for i := range files[1:] {
+				f := datasetFileSpec(&files[i+1], state.LayoutByFile)
+				if f.Size > 0 && (spec.Size <= 0 || f.Size < spec.Size) {
+					spec = f
+				}
+			}
+			manifest := &state.Manifest{
+				DatasetID: testDatasetID,
+				Files:     []state.FileSpec{spec},
+				CreatedAt: time.Now(),
+			}
+
+			sm := state.NewStateManager(dir)
+			orch := download.NewOrchestrator(apiClient, sm, download.DownloadOptions{
+				ParallelFiles:  1,
+				ParallelChunks: 8,
+				ChunkSize:      64 * 1024 * 1024,
+			})
+			start := time.Now()
+			err = orch.Download(ctx, manifest)
+			elapsed := time.Since(start)
+			step := fmt.Sprintf("Download %s (%s)", spec.FileID, ui.FormatBytes(spec.Size))
+			if err == nil && elapsed > 0 {
+				step += fmt.Sprintf(" at %s/s", ui.FormatBytes(int64(float64(spec.Size)/elapsed.Seconds())))
+			}
+			if err := check(step, err); err != nil {
+				return err
+			}
+
+			filePath := filepath.Join(dir, spec.FileName)
+			if spec.Checksum == "" {
+				fmt.Fprintf(out, "  SKIP  Verify checksum (none published)\n")
+			} else if err := check("Verify "+spec.ChecksumType+" checksum", verify.Verify(filePath, spec.Checksum, spec.ChecksumType)); err != nil {
+				return err
+			}
+
+			fmt.Fprintln(out)
+			fmt.Fprintln(out, "EGAfetch can reach EGA and download data from this machine.")
+			if output != "" {
+				fmt.Fprintf(out, "Test file: %s\n", filePath)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Keep the test file in this directory (default: temporary, removed afterwards)")
+
+	return cmd
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// rclonePrefix marks an --output that is an rclone remote, e.g.
+// rclone:myremote:projects/ega.
+const rclonePrefix = "rclone:"
+
+// bucketSchemes maps the bucket URLs an --output may be given as to the
+// rclone backends they are uploaded with. Credentials come from the
+// environment, as for the provider's own tools.
+var bucketSchemes = map[string]string{
+	"s3://": ":s3,env_auth=true:",
+	"gs://": ":gcs,env_auth=true:",
+}
+
+// rcloneRemote returns the remote path of an --output such as
+// "rclone:myremote:projects/ega" or "s3://bucket/prefix", and whether
+// output is one.
+func rcloneRemote(output string) (string, bool, error) {
+	for scheme, backend := range bucketSchemes {
+		if !strings.HasPrefix(output, scheme) {
+			continue
+		}
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(output, scheme), "/")
+		if bucket == "" {
+			return "", true, fmt.Errorf("invalid output %q (expected %sBUCKET/PREFIX)", output, scheme)
+		}
+		return backend + bucket + "/" + strings.TrimSuffix(prefix, "/"), true, nil
+	}
+	if !strings.HasPrefix(output, rclonePrefix) {
+		return "", false, nil
+	}
+	remote := strings.TrimPrefix(output, rclonePrefix)
+	if name, _, ok := strings.Cut(remote, ":"); !ok || name == "" {
+		return "", true, fmt.Errorf("invalid output %q (expected rclone:REMOTE:PATH, e.g. rclone:myremote:projects/ega)", output)
+	}
+	return remote, true, nil
+}
+
+// rcloneStagingDir returns the local directory that downloads to remote are
+// staged in: under --tmp-dir, or the current directory, with a name derived
+// from the remote, so an interrupted download resumes from the same place.
+func rcloneStagingDir(tmpDir, remote string) string {
+	if tmpDir == "" {
+		tmpDir = "."
+	}
+	sum := sha256.Sum256([]byte(remote))
+	return filepath.Join(tmpDir, "egafetch-rclone-"+hex.EncodeToString(sum[:])[:12])
+}
+
+// joinRemote appends name, a slash-separated relative path, to an rclone
+// remote path.
+func joinRemote(remote, name string) string {
+	if strings.HasSuffix(remote, ":") || strings.HasSuffix(remote, "/") {
+		return remote + name
+	}
+	return remote + "/" + name
+}
+
+// rcloneUploader uploads verified files to an rclone remote with
+// 'rclone copyto', using the user's rclone configuration.
+type rcloneUploader struct {
+	remote string
+}
+
+// newRcloneUploader checks that rclone is installed and returns an uploader
+// to remote, the remote path of output.
+func newRcloneUploader(output, remote string) (*rcloneUploader, error) {
+	if _, err := exec.LookPath("rclone"); err != nil {
+		return nil, fmt.Errorf("--output %s requires rclone (https://rclone.org/install/) on the PATH", output)
+	}
+	return &rcloneUploader{remote: remote}, nil
+}
+
+func (u *rcloneUploader) Upload(ctx context.Context, localPath, name string) error {
+	return runRclone(ctx, "copyto", localPath, joinRemote(u.remote, name))
+}
+
+// UploadDir copies the local directory dir to name on the remote, leaving
+// out its .egafetch directory.
+func (u *rcloneUploader) UploadDir(ctx context.Context, dir, name string) error {
+	return runRclone(ctx, "copy", "--exclude", "/.egafetch/**", dir, joinRemote(u.remote, name))
+}
+
+// runRclone runs rclone with args, returning its error message on failure.
+func runRclone(ctx context.Context, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "rclone", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("rclone: %s", lastLine(msg))
+		}
+		return fmt.Errorf("rclone: %w", err)
+	}
+	return nil
+}
+
+// lastLine returns the last line of s, where rclone puts its error.
+func lastLine(s string) string {
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
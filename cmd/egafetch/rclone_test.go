@@ -0,0 +1,63 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRcloneRemote(t *testing.T) {
+	tests := []struct {
+		output     string
+		wantRemote string
+		wantOK     bool
+		wantErr    bool
+	}{
+		{"./data", "", false, false},
+		{"rclone:myremote:projects/ega", "myremote:projects/ega", true, false},
+		{"rclone:box:", "box:", true, false},
+		{"rclone:projects/ega", "", true, true},
+		{"rclone::path", "", true, true},
+		{"s3://ega-bucket/EGAD00001001938/", ":s3,env_auth=true:ega-bucket/EGAD00001001938", true, false},
+		{"s3://ega-bucket", ":s3,env_auth=true:ega-bucket/", true, false},
+		{"s3:///prefix", "", true, true},
+		{"gs://ega-bucket/projects/ega", ":gcs,env_auth=true:ega-bucket/projects/ega", true, false},
+		{"gs://", "", true, true},
+	}
+	for _, tt := range tests {
+		remote, ok, err := rcloneRemote(tt.output)
+		if (err != nil) != tt.wantErr || ok != tt.wantOK || (err == nil && remote != tt.wantRemote) {
+			t.Errorf("rcloneRemote(%q) = %q, %v, %v; want %q, %v, error %v", tt.output, remote, ok, err, tt.wantRemote, tt.wantOK, tt.wantErr)
+		}
+	}
+}
+
+func TestJoinRemote(t *testing.T) {
+	tests := []struct{ remote, name, want string }{
+		{"myremote:projects/ega", "EGAF1/a.bam", "myremote:projects/ega/EGAF1/a.bam"},
+		{"myremote:projects/ega/", "a.bam", "myremote:projects/ega/a.bam"},
+		{"box:", "a.bam", "box:a.bam"},
+	}
+	for _, tt := range tests {
+		if got := joinRemote(tt.remote, tt.name); got != tt.want {
+			t.Errorf("joinRemote(%q, %q) = %q, want %q", tt.remote, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRcloneStagingDir(t *testing.T) {
+	a := rcloneStagingDir("", "myremote:projects/ega")
+	if a != rcloneStagingDir("", "myremote:projects/ega") {
+		t.Error("staging directory is not stable")
+	}
+	if a == rcloneStagingDir("", "myremote:projects/other") {
+		t.Error("remotes share a staging directory")
+	}
+	if !strings.HasPrefix(filepath.Base(a), "egafetch-rclone-") || filepath.Dir(a) != "." {
+		t.Errorf("staging directory = %q", a)
+	}
+	tmp := t.TempDir()
+	if got := rcloneStagingDir(tmp, "box:"); filepath.Dir(got) != tmp {
+		t.Errorf("staging directory under --tmp-dir = %q", got)
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+	"github.com/khan-lab/EGAfetch/internal/ui"
+)
+
+// Report formats accepted by --report.
+var reportFormats = []string{"md", "html", "none"}
+
+// reportsDir is where reports are written, inside the state directory.
+const reportsDir = "reports"
+
+// runReport describes one download session into one output directory.
+type runReport struct {
+	Version     string       `json:"version"`
+	CommandLine string       `json:"command_line"`
+	Host        string       `json:"host"`
+	OutputDir   string       `json:"output_dir"`
+	DatasetID   string       `json:"dataset_id,omitempty"`
+	StartedAt   time.Time    `json:"started_at"`
+	FinishedAt  time.Time    `json:"finished_at"`
+	Error       string       `json:"error,omitempty"`
+	Transferred int64        `json:"transferred"` // bytes received in this session
+	Files       []reportFile `json:"files"`
+}
+
+// reportFile is one manifest file as it stands at the end of the session.
+type reportFile struct {
+	FileID       string        `json:"file_id"`
+	FileName     string        `json:"file_name"`
+	Size         int64         `json:"size"`
+	ChecksumType string        `json:"checksum_type,omitempty"`
+	Checksum     string        `json:"checksum,omitempty"`
+	Status       string        `json:"status"`  // complete, failed, ... (state.FileStatus)
+	Skipped      bool          `json:"skipped"` // already complete before the session
+	Elapsed      time.Duration `json:"elapsed_ns"`
+	Retries      int           `json:"retries"`
+	Error        string        `json:"error,omitempty"`
+}
+
+// sessionRecorder collects what happens to each file during a session, for
+// the report.
+type sessionRecorder struct {
+	start  time.Time
+	before map[string]int64 // bytes present per file at the start
+
+	mu      sync.Mutex
+	skipped map[string]bool
+}
+
+// newSessionRecorder notes how much of each manifest file is already present.
+func newSessionRecorder(manifest *state.Manifest, sm *state.StateManager) *sessionRecorder {
+	r := &sessionRecorder{start: time.Now(), before: make(map[string]int64), skipped: make(map[string]bool)}
+	for _, f := range manifest.Files {
+		r.before[f.FileID] = bytesPresent(sm, f)
+	}
+	return r
+}
+
+func (r *sessionRecorder) fileSkipped(fileID string) {
+	r.mu.Lock()
+	r.skipped[fileID] = true
+	r.mu.Unlock()
+}
+
+// bytesPresent returns how many bytes of f are on disk according to sm.
+func bytesPresent(sm *state.StateManager, f state.FileSpec) int64 {
+	fs, err := sm.LoadFileState(f.FileID)
+	if err != nil || fs == nil {
+		return 0
+	}
+	if fs.Status == state.StatusComplete {
+		return f.Size
+	}
+	var n int64
+	for _, c := range fs.Chunks {
+		n += c.BytesDownloaded
+	}
+	return min(n, f.Size)
+}
+
+// build assembles the report after the session ended with err.
+func (r *sessionRecorder) build(manifest *state.Manifest, sm *state.StateManager, err error) *runReport {
+	rep := &runReport{
+		Version:     version,
+		CommandLine: reportCommandLine(os.Args),
+		OutputDir:   sm.BaseDir(),
+		DatasetID:   manifest.DatasetID,
+		StartedAt:   r.start,
+		FinishedAt:  time.Now(),
+	}
+	rep.Host, _ = os.Hostname()
+	if abs, absErr := filepath.Abs(rep.OutputDir); absErr == nil {
+		rep.OutputDir = abs
+	}
+	if err != nil {
+		rep.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, f := range manifest.Files {
+		file := reportFile{
+			FileID:       f.FileID,
+			FileName:     f.FileName,
+			Size:         f.Size,
+			ChecksumType: f.ChecksumType,
+			Checksum:     f.Checksum,
+			Status:       string(state.StatusPending),
+			Skipped:      r.skipped[f.FileID],
+		}
+		if fs, _ := sm.LoadFileState(f.FileID); fs != nil {
+			file.Status = string(fs.Status)
+			file.Retries = fs.RetryCount
+			file.Error = fs.Error
+			if fs.StartedAt != nil && fs.CompletedAt != nil {
+				file.Elapsed = fs.CompletedAt.Sub(*fs.StartedAt).Round(time.Second)
+			}
+		}
+		// Files adopted by --if-exists were not transferred.
+		if !file.Skipped {
+			rep.Transferred += max(bytesPresent(sm, f)-r.before[f.FileID], 0)
+		}
+		rep.Files = append(rep.Files, file)
+	}
+	return rep
+}
+
+// Status summarizes the session: complete, interrupted, or incomplete.
+func (r *runReport) Status() string {
+	switch {
+	case r.Error == "":
+		return "complete"
+	case interrupted.Load():
+		return "interrupted"
+	}
+	return "incomplete"
+}
+
+// Duration returns the session's wall-clock time.
+func (r *runReport) Duration() time.Duration {
+	return r.FinishedAt.Sub(r.StartedAt).Round(time.Second)
+}
+
+// Throughput returns the average transfer rate, e.g. "12.3 MB/s".
+func (r *runReport) Throughput() string {
+	secs := r.FinishedAt.Sub(r.StartedAt).Seconds()
+	if secs <= 0 {
+		return "-"
+	}
+	return ui.FormatBytes(int64(float64(r.Transferred)/secs)) + "/s"
+}
+
+// Counts returns downloaded, already-complete, and failed file counts.
+func (r *runReport) Counts() (downloaded, skipped, failed int) {
+	for _, f := range r.Files {
+		switch {
+		case f.Skipped:
+			skipped++
+		case f.Status == string(state.StatusComplete):
+			downloaded++
+		case f.Status == string(state.StatusFailed):
+			failed++
+		}
+	}
+	return downloaded, skipped, failed
+}
+
+// FileSummary describes the file counts in one phrase.
+func (r *runReport) FileSummary() string {
+	downloaded, skipped, failed := r.Counts()
+	return fmt.Sprintf("%d (%d downloaded, %d already complete, %d failed, %d not finished)",
+		len(r.Files), downloaded, skipped, failed, len(r.Files)-downloaded-skipped-failed)
+}
+
+// Failures returns the files that did not complete and have an error.
+func (r *runReport) Failures() []reportFile {
+	var out []reportFile
+	for _, f := range r.Files {
+		if f.Status != string(state.StatusComplete) && f.Error != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// reportCommandLine joins argv for display, quoting arguments with spaces
+// and hiding webhook URLs, which carry secrets.
+func reportCommandLine(argv []string) string {
+	var parts []string
+	hideNext := false
+	for i, arg := range argv {
+		switch {
+		case i == 0:
+			arg = filepath.Base(arg)
+		case hideNext:
+			arg = "REDACTED"
+		case strings.HasPrefix(arg, "--webhook="):
+			arg = "--webhook=REDACTED"
+		}
+		hideNext = arg == "--webhook"
+		if strings.ContainsAny(arg, " \t\"'") {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		parts = append(parts, arg)
+	}
+	return strings.Join(parts, " ")
+}
+
+// writeReports writes rep into .egafetch/reports/ in each of formats and
+// returns the paths written.
+func writeReports(sm *state.StateManager, rep *runReport, formats []string) ([]string, error) {
+	dir := filepath.Join(sm.EgafetchPath(), reportsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create reports directory: %w", err)
+	}
+	base := filepath.Join(dir, runName(rep.StartedAt))
+
+	var paths []string
+	for _, format := range formats {
+		if format == "none" {
+			continue
+		}
+		path := base + "." + format
+		f, err := os.Create(path)
+		if err != nil {
+			return paths, fmt.Errorf("create report: %w", err)
+		}
+		if format == "html" {
+			err = writeHTMLReport(f, rep)
+		} else {
+			err = writeMarkdownReport(f, rep)
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return paths, fmt.Errorf("write report %s: %w", path, err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// writeMarkdownReport renders rep as Markdown.
+func writeMarkdownReport(w io.Writer, rep *runReport) error {
+	cell := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return strings.ReplaceAll(s, "|", `\|`)
+	}
+
+	var b strings.Builder
+	b.WriteString("# EGAfetch download report\n\n")
+	b.WriteString("| | |\n|---|---|\n")
+	row := func(k, v string) { fmt.Fprintf(&b, "| %s | %s |\n", k, cell(v)) }
+	row("Status", rep.Status())
+	row("Dataset", rep.DatasetID)
+	row("Output directory", "`"+rep.OutputDir+"`")
+	row("Started", rep.StartedAt.Format(time.RFC3339))
+	row("Finished", rep.FinishedAt.Format(time.RFC3339))
+	row("Duration", rep.Duration().String())
+	row("Transferred", fmt.Sprintf("%s (%s average)", ui.FormatBytes(rep.Transferred), rep.Throughput()))
+	row("Files", rep.FileSummary())
+	row("Host", rep.Host)
+	row("EGAfetch version", rep.Version)
+	row("Command", "`"+rep.CommandLine+"`")
+	if rep.Error != "" {
+		row("Error", rep.Error)
+	}
+
+	b.WriteString("\n## Files\n\n")
+	b.WriteString("| File ID | File name | Size | Checksum | Status | Elapsed | Retries |\n")
+	b.WriteString("|---|---|---:|---|---|---:|---:|\n")
+	for _, f := range rep.Files {
+		status := f.Status
+		if f.Skipped {
+			status += " (already complete)"
+		}
+		checksum := "-"
+		if f.Checksum != "" {
+			checksum = f.ChecksumType + " `" + f.Checksum + "`"
+		}
+		elapsed := "-"
+		if f.Elapsed > 0 {
+			elapsed = f.Elapsed.String()
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s | %d |\n",
+			f.FileID, cell(f.FileName), ui.FormatBytes(f.Size), checksum, status, elapsed, f.Retries)
+	}
+
+	if failures := rep.Failures(); len(failures) > 0 {
+		b.WriteString("\n## Failures\n\n")
+		for _, f := range failures {
+			fmt.Fprintf(&b, "- **%s** (%s): %s\n", f.FileName, f.FileID, f.Error)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"bytes": ui.FormatBytes,
+	"time":  func(t time.Time) string { return t.Format(time.RFC3339) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>EGAfetch download report{{with .DatasetID}} - {{.}}{{end}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #f3f3f3; }
+td.num { text-align: right; }
+code { font-size: 0.9em; }
+.failed { color: #b00020; }
+</style>
+</head>
+<body>
+<h1>EGAfetch download report</h1>
+<table>
+<tr><th>Status</th><td>{{.Status}}</td></tr>
+<tr><th>Dataset</th><td>{{or .DatasetID "-"}}</td></tr>
+<tr><th>Output directory</th><td><code>{{.OutputDir}}</code></td></tr>
+<tr><th>Started</th><td>{{time .StartedAt}}</td></tr>
+<tr><th>Finished</th><td>{{time .FinishedAt}}</td></tr>
+<tr><th>Duration</th><td>{{.Duration}}</td></tr>
+<tr><th>Transferred</th><td>{{bytes .Transferred}} ({{.Throughput}} average)</td></tr>
+<tr><th>Files</th><td>{{.FileSummary}}</td></tr>
+<tr><th>Host</th><td>{{or .Host "-"}}</td></tr>
+<tr><th>EGAfetch version</th><td>{{.Version}}</td></tr>
+<tr><th>Command</th><td><code>{{.CommandLine}}</code></td></tr>
+{{- with .Error}}
+<tr><th>Error</th><td class="failed">{{.}}</td></tr>
+{{- end}}
+</table>
+<h2>Files</h2>
+<table>
+<tr><th>File ID</th><th>File name</th><th>Size</th><th>Checksum</th><th>Status</th><th>Elapsed</th><th>Retries</th></tr>
+{{- range .Files}}
+<tr{{if eq .Status "failed"}} class="failed"{{end}}><td>{{.FileID}}</td><td>{{.FileName}}</td><td class="num">{{bytes .Size}}</td>
+<td>{{if .Checksum}}{{.ChecksumType}} <code>{{.Checksum}}</code>{{else}}-{{end}}</td>
+<td>{{.Status}}{{if .Skipped}} (already complete){{end}}</td><td class="num">{{if .Elapsed}}{{.Elapsed}}{{else}}-{{end}}</td><td class="num">{{.Retries}}</td></tr>
+{{- end}}
+</table>
+{{- with .Failures}}
+<h2>Failures</h2>
+<ul>
+{{- range .}}
+<li><strong>{{.FileName}}</strong> ({{.FileID}}): {{.Error}}</li>
+{{- end}}
+</ul>
+{{- end}}
+</body>
+</html>
+`))
+
+// writeHTMLReport renders rep as a standalone HTML page.
+func writeHTMLReport(w io.Writer, rep *runReport) error {
+	return htmlReport.Execute(w, rep)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+func TestReportCommandLine(t *testing.T) {
+	got := reportCommandLine([]string{"/usr/local/bin/egafetch", "download", "EGAD00001000001", "-o", "my data",
+		"--webhook", "https://hooks.slack.com/services/T/B/X", "--webhook=https://example.org/secret"})
+	want := "egafetch download EGAD00001000001 -o 'my data' --webhook REDACTED --webhook=REDACTED"
+	if got != want {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+}
+
+func TestSessionReport(t *testing.T) {
+	sm := state.NewStateManager(t.TempDir())
+	specs := []state.FileSpec{
+		{FileID: "EGAF00000000001", FileName: "EGAF00000000001/a.bam", Size: 1000, Checksum: "0123abcd", ChecksumType: "MD5"},
+		{FileID: "EGAF00000000002", FileName: "EGAF00000000002/b.bam", Size: 2000},
+		{FileID: "EGAF00000000003", FileName: "EGAF00000000003/c.bam", Size: 3000},
+	}
+	manifest := &state.Manifest{DatasetID: "EGAD00001000001", Files: specs}
+
+	save := func(spec state.FileSpec, status state.FileStatus, downloaded int64, errMsg string) {
+		t.Helper()
+		fs := state.NewFileState(spec, spec.Size)
+		fs.Status = status
+		fs.Chunks = []state.ChunkState{{Start: 0, End: spec.Size, BytesDownloaded: downloaded}}
+		fs.Error = errMsg
+		if status == state.StatusComplete {
+			done := fs.StartedAt.Add(90 * time.Second)
+			fs.CompletedAt = &done
+		}
+		if err := sm.SaveFileState(fs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a.bam was complete before the session, c.bam half done.
+	save(specs[0], state.StatusComplete, 1000, "")
+	save(specs[2], state.StatusDownloading, 1500, "")
+
+	rec := newSessionRecorder(manifest, sm)
+	rec.fileSkipped(specs[0].FileID)
+	save(specs[1], state.StatusComplete, 2000, "")
+	save(specs[2], state.StatusFailed, 2500, "HTTP 403")
+
+	rep := rec.build(manifest, sm, errors.New("1 of 3 file(s) failed"))
+	if rep.Transferred != 2000+1000 {
+		t.Errorf("transferred = %d, want 3000", rep.Transferred)
+	}
+	if downloaded, skipped, failed := rep.Counts(); downloaded != 1 || skipped != 1 || failed != 1 {
+		t.Errorf("counts = %d, %d, %d; want 1, 1, 1", downloaded, skipped, failed)
+	}
+
+	paths, err := writeReports(sm, rep, []string{"md", "html"})
+	if err != nil || len(paths) != 2 {
+		t.Fatalf("writeReports = %v, %v", paths, err)
+	}
+	md, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"| Status | incomplete |",
+		"| Files | 3 (1 downloaded, 1 already complete, 1 failed, 0 not finished) |",
+		"| EGAF00000000001 | EGAF00000000001/a.bam | 1000 B | MD5 `0123abcd` | complete (already complete) | 1m30s | 0 |",
+		"- **EGAF00000000003/c.bam** (EGAF00000000003): HTTP 403",
+	} {
+		if !strings.Contains(string(md), want) {
+			t.Errorf("Markdown report lacks %q:\n%s", want, md)
+		}
+	}
+	html, err := os.ReadFile(paths[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(html), `<tr class="failed"><td>EGAF00000000003</td>`) {
+		t.Errorf("HTML report does not mark the failed file:\n%s", html)
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/time/rate"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/auth"
+	"github.com/khan-lab/EGAfetch/internal/download"
+)
+
+// JSON-RPC 2.0 error codes returned by 'egafetch rpc'.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcJobError       = -32000 // the method failed, e.g. for an unknown job
+)
+
+// rpcMaxLine is the longest request line accepted.
+const rpcMaxLine = 16 << 20
+
+// rpcRequest is one JSON-RPC request read from stdin. Requests without an
+// ID are notifications and get no response.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// rpcNotification is an event written to stdout: "ready" once, "job" when a
+// job changes status, and "progress" for file events.
+type rpcNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// rpcJobParams are the params of the methods that act on one job.
+type rpcJobParams struct {
+	ID string `json:"id"`
+}
+
+// syncWriter serializes writes from the request loop, job status changes,
+// and file events, so that each JSON line is written whole.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// rpcServer answers JSON-RPC requests for the jobs of a downloadServer.
+type rpcServer struct {
+	*downloadServer
+	out  io.Writer          // a *syncWriter
+	stop context.CancelFunc // stops every job, for shutdown
+}
+
+func newRPCCmd() *cobra.Command {
+	var (
+		maxJobs        int
+		configFile     string
+		parallelFiles  int
+		parallelChunks int
+		chunkSize      string
+		maxBandwidth   string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "rpc",
+		Short: "Control downloads with JSON-RPC over stdin and stdout",
+		Long: `Read JSON-RPC 2.0 requests from stdin, one per line, and write responses
+and event notifications to stdout, one per line, so that programs in other
+languages can drive downloads as a subprocess. Logs go to stderr.
+
+Methods:
+  submit    {"ids": ["EGAD..."], "output": "dir", "include": [...], "exclude": [...]}
+  jobs      list jobs
+  status    {"id": "..."}  job status with per-file progress
+  report    {"id": "..."}  the job's report once it has finished
+  pause     {"id": "..."}  stop a job, keeping its state
+  resume    {"id": "..."}  continue a paused job
+  cancel    {"id": "..."}  stop a job for good
+  shutdown  stop every job and exit
+
+Notifications: "ready" on start, "job" when a job changes status, and
+"progress" with the file events of --progress-json. Closing stdin stops
+every job, like shutdown.`,
+		Example: `  # Drive egafetch from another program
+  egafetch rpc --cf credentials.json
+
+  # One request from the shell
+  echo '{"jsonrpc": "2.0", "id": 1, "method": "submit", "params": {"ids": ["EGAD00001001938"], "output": "data"}}' | egafetch rpc`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := applyConfig(cmd, map[string]string{
+				"chunk-size":      "chunk_size",
+				"parallel-files":  "parallel_files",
+				"parallel-chunks": "parallel_chunks",
+				"max-bandwidth":   "max_bandwidth",
+			}); err != nil {
+				return err
+			}
+			if maxJobs < 1 {
+				return fmt.Errorf("--max-jobs must be at least 1")
+			}
+			chunkBytes, err := parseSize(chunkSize)
+			if err != nil {
+				return fmt.Errorf("invalid chunk-size: %w", err)
+			}
+			var limiter *rate.Limiter
+			if maxBandwidth != "" {
+				bwBytes, err := parseSize(maxBandwidth)
+				if err != nil {
+					return fmt.Errorf("invalid max-bandwidth: %w", err)
+				}
+				limiter = rate.NewLimiter(rate.Limit(bwBytes), 256*1024)
+			}
+			logPolicy, err := loadRunLogPolicy()
+			if err != nil {
+				return err
+			}
+			// stdout carries the protocol; nothing may draw on the terminal.
+			noSpinners = true
+
+			mgr, err := auth.NewManager()
+			if err != nil {
+				return err
+			}
+			ctx, cancel := signalContext()
+			defer cancel()
+			if err := ensureAuth(ctx, mgr, configFile); err != nil {
+				return err
+			}
+
+			s := newRPCServer(ctx, cmd.OutOrStdout(), api.NewClient(mgr), download.DownloadOptions{
+				ParallelFiles:  parallelFiles,
+				ParallelChunks: parallelChunks,
+				ChunkSize:      chunkBytes,
+				SmallFileSize:  download.DefaultSmallFileSize,
+				Limiter:        limiter,
+			}, maxJobs)
+			s.logPolicy = logPolicy
+			return s.serve(cmd.InOrStdin())
+		},
+	}
+
+	cmd.Flags().IntVar(&maxJobs, "max-jobs", 1, "Jobs downloaded at the same time; others wait in a queue")
+	cmd.Flags().StringVar(&configFile, "cf", "", "JSON config file with credentials")
+	cmd.Flags().StringVar(&configFile, "config-file", "", "JSON config file with credentials (alias for --cf)")
+	cmd.Flags().IntVar(&parallelFiles, "parallel-files", 4, "Files downloaded simultaneously per job")
+	cmd.Flags().IntVar(&parallelChunks, "parallel-chunks", 8, "Chunks per file downloaded simultaneously")
+	cmd.Flags().StringVar(&chunkSize, "chunk-size", "64M", "Size of each download chunk")
+	cmd.Flags().StringVar(&maxBandwidth, "max-bandwidth", "", "Bandwidth limit shared by all jobs (e.g., 100M)")
+	return cmd
+}
+
+// newRPCServer returns a server that writes to out and runs at most maxJobs
+// jobs at once until ctx is done. Job outputs are paths as given, relative
+// to the working directory.
+func newRPCServer(ctx context.Context, out io.Writer, apiClient *api.Client, opts download.DownloadOptions, maxJobs int) *rpcServer {
+	w := &syncWriter{w: out}
+	ctx, stop := context.WithCancel(ctx)
+	s := &rpcServer{
+		out:  w,
+		stop: stop,
+		downloadServer: &downloadServer{
+			apiClient: apiClient,
+			opts:      opts,
+			ctx:       ctx,
+			slots:     make(chan struct{}, maxJobs),
+			jobs:      make(map[string]*serveJob),
+		},
+	}
+	s.progress = newProgressStream(w, nil)
+	s.progress.wrap = func(v interface{}) interface{} {
+		return rpcNotification{JSONRPC: "2.0", Method: "progress", Params: v}
+	}
+	s.onJobChange = func(job jsonServeJob) { s.notify("job", job) }
+	return s
+}
+
+// serve answers requests from in until it is closed, shutdown is called, or
+// the server's context is done, then stops every job and waits for them to save their state.
+func (s *rpcServer) serve(in io.Reader) error {
+	s.notify("ready", map[string]string{"version": version})
+	done := make(chan error, 1)
+	go func() {
+		sc := bufio.NewScanner(in)
+		sc.Buffer(make([]byte, 64*1024), rpcMaxLine)
+		for s.ctx.Err() == nil && sc.Scan() {
+			if line := sc.Bytes(); len(line) > 0 {
+				s.handle(line)
+			}
+		}
+		done <- sc.Err()
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-s.ctx.Done():
+	}
+	s.stop()
+	s.running.Wait()
+	if err != nil {
+		return fmt.Errorf("read requests: %w", err)
+	}
+	return nil
+}
+
+// handle answers one request line.
+func (s *rpcServer) handle(line []byte) {
+	var req rpcRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		s.respond(json.RawMessage("null"), nil, &rpcError{rpcParseError, "parse error: " + err.Error()})
+		return
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		id := req.ID
+		if len(id) == 0 {
+			id = json.RawMessage("null")
+		}
+		s.respond(id, nil, &rpcError{rpcInvalidRequest, `invalid request: "jsonrpc" must be "2.0" and "method" is required`})
+		return
+	}
+	result, rerr := s.call(req.Method, req.Params)
+	if len(req.ID) > 0 {
+		s.respond(req.ID, result, rerr)
+	}
+	// Stop only once the response is written: serve returns when stopped.
+	if req.Method == "shutdown" {
+		slog.Info("Shutting down on request")
+		s.stop()
+	}
+}
+
+// call runs a method and returns its result.
+func (s *rpcServer) call(method string, params json.RawMessage) (interface{}, *rpcError) {
+	var result interface{}
+	var err error
+	switch method {
+	case "submit":
+		var req jsonServeRequest
+		if perr := decodeRPCParams(params, &req); perr != nil {
+			return nil, perr
+		}
+		if err := req.validateIDs(); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		if req.Output == "" {
+			req.Output = "."
+		}
+		if req.Output, err = filepath.Abs(req.Output); err != nil {
+			return nil, &rpcError{rpcInvalidParams, "output: " + err.Error()}
+		}
+		result, err = s.addJob(req)
+	case "jobs":
+		result = s.listJobs()
+	case "status", "report", "pause", "resume", "cancel":
+		var p rpcJobParams
+		if perr := decodeRPCParams(params, &p); perr != nil {
+			return nil, perr
+		}
+		switch method {
+		case "status":
+			result, err = s.jobStatus(p.ID)
+		case "report":
+			result, err = s.jobReport(p.ID)
+		case "pause":
+			result, err = s.pause(p.ID)
+		case "resume":
+			result, err = s.resume(p.ID)
+		case "cancel":
+			result, err = s.cancel(p.ID)
+		}
+	case "shutdown":
+		result = struct{}{}
+	default:
+		return nil, &rpcError{rpcMethodNotFound, fmt.Sprintf("method not found: %q", method)}
+	}
+	if err != nil {
+		return nil, &rpcError{rpcJobError, err.Error()}
+	}
+	return result, nil
+}
+
+// decodeRPCParams decodes the params object of a request into v, rejecting
+// unknown fields. Missing params decode as {}.
+func decodeRPCParams(params json.RawMessage, v interface{}) *rpcError {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return &rpcError{rpcInvalidParams, "invalid params: " + err.Error()}
+	}
+	return nil
+}
+
+func (s *rpcServer) respond(id json.RawMessage, result interface{}, rerr *rpcError) {
+	s.write(rpcResponse{JSONRPC: "2.0", ID: id, Result: result, Error: rerr})
+}
+
+func (s *rpcServer) notify(method string, params interface{}) {
+	s.write(rpcNotification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// write writes one message as a line. A reader that went away stops the
+// server.
+func (s *rpcServer) write(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("Could not encode RPC message", "error", err)
+		return
+	}
+	if _, err := s.out.Write(append(data, '\n')); err != nil && s.ctx.Err() == nil {
+		slog.Warn("Could not write to stdout; stopping", "error", err)
+		s.stop()
+	}
+}
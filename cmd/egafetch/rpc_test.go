@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/khan-lab/EGAfetch/internal/download"
+)
+
+func TestRPCServer(t *testing.T) {
+	var out bytes.Buffer
+	s := newRPCServer(context.Background(), &out, nil, download.DownloadOptions{}, 1)
+	// Keep the only slot busy, so submitted jobs stay queued.
+	s.slots <- struct{}{}
+
+	output := filepath.Join(t.TempDir(), "data")
+	requests := []string{
+		`{"jsonrpc": "2.0", "id": 1, "method": "submit", "params": {"ids": ["EGAD00001000001"], "output": "` + filepath.ToSlash(output) + `"}}`,
+		`{"jsonrpc": "2.0", "id": 2, "method": "submit", "params": {"ids": ["EGAF00000000001"], "output": "` + filepath.ToSlash(output) + `"}}`,
+		`{"jsonrpc": "2.0", "id": 3, "method": "submit", "params": {"ids": ["X1"]}}`,
+		`{"jsonrpc": "2.0", "id": 4, "method": "nope"}`,
+		`{"jsonrpc": "2.0", "id": 5, "method": "status", "params": {"id": "unknown"}}`,
+		`not json`,
+		`{"jsonrpc": "2.0", "method": "jobs"}`, // a notification: no response
+		`{"jsonrpc": "2.0", "id": 6, "method": "jobs"}`,
+		`{"jsonrpc": "2.0", "id": 7, "method": "shutdown"}`,
+	}
+	if err := s.serve(strings.NewReader(strings.Join(requests, "\n") + "\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	type message struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+	responses := make(map[string]message)
+	var notifications []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var msg message
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("output line %q: %v", line, err)
+		}
+		if msg.Method != "" {
+			notifications = append(notifications, msg.Method)
+			continue
+		}
+		responses[string(msg.ID)] = msg
+	}
+
+	var job jsonServeJob
+	if err := json.Unmarshal(responses["1"].Result, &job); err != nil || job.Status != serveQueued || job.Output != output {
+		t.Errorf("submit = %s, %v; want a job queued into %s", responses["1"].Result, err, output)
+	}
+	for id, code := range map[string]int{"2": rpcJobError, "3": rpcInvalidParams, "4": rpcMethodNotFound, "5": rpcJobError, "null": rpcParseError} {
+		if r := responses[id]; r.Error == nil || r.Error.Code != code {
+			t.Errorf("response %s = %+v, want error %d", id, r, code)
+		}
+	}
+	var jobs []jsonServeJob
+	if err := json.Unmarshal(responses["6"].Result, &jobs); err != nil || len(jobs) != 1 || jobs[0].ID != job.ID {
+		t.Errorf("jobs = %s, %v; want the submitted job", responses["6"].Result, err)
+	}
+	if r, ok := responses["7"]; !ok || r.Error != nil {
+		t.Errorf("shutdown = %+v, %v", r, ok)
+	}
+	if len(responses) != 8 {
+		t.Errorf("got %d responses, want 8 (none for the notification)", len(responses))
+	}
+	if len(notifications) < 2 || notifications[0] != "ready" || notifications[1] != "job" {
+		t.Errorf("notifications = %v, want ready, then job", notifications)
+	}
+}
+
+func TestDownloadServerPauseResume(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var changes []string
+	s := &downloadServer{
+		root:        t.TempDir(),
+		ctx:         ctx,
+		slots:       make(chan struct{}, 1),
+		jobs:        make(map[string]*serveJob),
+		onJobChange: func(job jsonServeJob) { changes = append(changes, job.Status) },
+	}
+	s.slots <- struct{}{}
+
+	job, err := s.addJob(jsonServeRequest{IDs: []string{"EGAD00001000001"}, Output: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.resume(job.ID); err == nil {
+		t.Error("resume of a queued job succeeded")
+	}
+	if job, err = s.pause(job.ID); err != nil || job.Status != servePaused {
+		t.Fatalf("pause = %+v, %v", job, err)
+	}
+	if _, err := s.addJob(jsonServeRequest{IDs: []string{"EGAF00000000001"}, Output: "a"}); err == nil {
+		t.Error("a job could be submitted into the directory of a paused job")
+	}
+	if job, err = s.resume(job.ID); err != nil || job.Status != serveQueued {
+		t.Fatalf("resume = %+v, %v", job, err)
+	}
+	if job, err = s.cancel(job.ID); err != nil || job.Status != serveCancelled {
+		t.Fatalf("cancel = %+v, %v", job, err)
+	}
+	if _, err := s.pause(job.ID); err == nil {
+		t.Error("pause of a cancelled job succeeded")
+	}
+	s.running.Wait()
+
+	want := []string{serveQueued, servePaused, serveQueued, serveCancelled}
+	if strings.Join(changes, ",") != strings.Join(want, ",") {
+		t.Errorf("status changes = %v, want %v", changes, want)
+	}
+}
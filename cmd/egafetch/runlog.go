@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/khan-lab/EGAfetch/internal/config"
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+// runLogsDir is where download runs write their logs, under .egafetch/.
+const runLogsDir = "logs"
+
+// maxRunLogs is how many run logs are kept per output directory; older ones
+// are removed when a new run starts or a log is rotated.
+const maxRunLogs = 50
+
+// runLogPolicy limits how large a run log grows and how long old ones are
+// kept, from the log_max_size and log_max_age config keys.
+type runLogPolicy struct {
+	maxSize int64         // a run continues in a new log past this size; 0 = no limit
+	maxAge  time.Duration // logs last written longer ago are removed; 0 = no limit
+}
+
+// loadRunLogPolicy reads the run log policy from config.yaml and EGAFETCH_*
+// variables, with the built-in defaults for keys that are not set.
+func loadRunLogPolicy() (runLogPolicy, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return runLogPolicy{}, fmt.Errorf("load config: %w", err)
+	}
+	var p runLogPolicy
+	for _, key := range []string{"log_max_size", "log_max_age"} {
+		v, _ := cfg.Get(key)
+		if v == "" {
+			k, _ := config.LookupKey(key)
+			v = k.Default
+		}
+		if err := validateConfigValue(key, v); err != nil {
+			return runLogPolicy{}, err
+		}
+		switch key {
+		case "log_max_size":
+			if v != "0" {
+				p.maxSize, _ = parseSize(v)
+			}
+		case "log_max_age":
+			p.maxAge, _ = time.ParseDuration(v)
+		}
+	}
+	return p, nil
+}
+
+// runLog receives every log record of the process at debug level (trace
+// with -vv), whatever the console shows, and writes it to the run logs that
+// are open: a job log only the records of its job, logged with the context
+// openJobLog returns, and a run log every record. setupLogging installs it
+// behind the default logger.
+var runLog = &runLogSink{level: slog.LevelDebug}
+
+// runLogSink fans log records out to the open run log files.
+type runLogSink struct {
+	mu    sync.Mutex
+	level slog.Level
+	logs  map[*rotatingLog]slog.Handler
+}
+
+// rotatingLog is an open run log. Once it holds policy.maxSize bytes, the
+// run continues in a new log named for the time it was rotated, and logs
+// beyond the retention policy are removed, so a run lasting weeks neither
+// grows one file nor fills the directory.
+type rotatingLog struct {
+	dir    string
+	policy runLogPolicy
+	job    bool // receives only the records logged with its job's context
+	f      *os.File
+	size   int64
+}
+
+// jobLogKey is the context key of the job log that records logged with the
+// context go to.
+type jobLogKey struct{}
+
+// openRunLog starts a timestamped log in sm's .egafetch/logs directory,
+// removes old logs beyond maxRunLogs or policy.maxAge, and records the
+// command being run. The returned function records err as the result and
+// closes the log.
+func openRunLog(sm *state.StateManager, policy runLogPolicy) (path string, closeLog func(err error), err error) {
+	l, closeLog, err := startRunLog(sm, policy, false)
+	if err != nil {
+		return "", nil, err
+	}
+	return l.f.Name(), closeLog, nil
+}
+
+// openJobLog is openRunLog for one of the jobs of 'egafetch serve', which
+// run side by side in one process: the log receives only the records logged
+// with the returned context, or one derived from it, so that it holds its
+// own job's records and none of the others'.
+func openJobLog(ctx context.Context, sm *state.StateManager, policy runLogPolicy) (context.Context, func(err error), error) {
+	l, closeLog, err := startRunLog(sm, policy, true)
+	if err != nil {
+		return ctx, nil, err
+	}
+	return context.WithValue(ctx, jobLogKey{}, l), closeLog, nil
+}
+
+// startRunLog opens the log of openRunLog and openJobLog.
+func startRunLog(sm *state.StateManager, policy runLogPolicy, job bool) (*rotatingLog, func(err error), error) {
+	dir := filepath.Join(sm.EgafetchPath(), runLogsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, fmt.Errorf("create logs directory: %w", err)
+	}
+	if err := pruneRunLogs(dir, maxRunLogs-1, policy.maxAge, time.Now()); err != nil {
+		slog.Debug("Could not remove old run logs", "error", err)
+	}
+
+	l := &rotatingLog{dir: dir, policy: policy, job: job}
+	if err := l.open(time.Now()); err != nil {
+		return nil, nil, err
+	}
+	h := runLog.attach(l)
+
+	host, _ := os.Hostname()
+	start := slog.NewRecord(time.Now(), slog.LevelInfo, "Run started", 0)
+	start.AddAttrs(
+		slog.String("version", version),
+		slog.String("command_line", reportCommandLine(os.Args)),
+		slog.String("output_dir", sm.BaseDir()),
+		slog.Int("pid", os.Getpid()),
+		slog.String("host", host),
+	)
+	runLog.write(h, start)
+
+	return l, func(err error) {
+		end := slog.NewRecord(time.Now(), slog.LevelInfo, "Run finished", 0)
+		if err != nil {
+			end.Level = slog.LevelError
+			end.AddAttrs(slog.String("error", err.Error()))
+		}
+		runLog.write(h, end)
+		runLog.detach(l)
+	}, nil
+}
+
+// open starts a new log file named for now.
+func (l *rotatingLog) open(now time.Time) error {
+	path := filepath.Join(l.dir, runName(now)+".log")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("create run log: %w", err)
+	}
+	l.f, l.size = f, 0
+	if fi, err := f.Stat(); err == nil {
+		l.size = fi.Size()
+	}
+	return nil
+}
+
+// Write writes one log record, first moving to a new log if this one is
+// full. If the new log cannot be created, writing continues in the old one.
+func (l *rotatingLog) Write(p []byte) (int, error) {
+	if l.policy.maxSize > 0 && l.size > 0 && l.size+int64(len(p)) > l.policy.maxSize {
+		l.rotate(time.Now())
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+func (l *rotatingLog) rotate(now time.Time) {
+	old := l.f
+	if err := l.open(now); err != nil {
+		l.f = old
+		return
+	}
+	if l.f.Name() == old.Name() {
+		// Full within a second of starting: there is no new name to use.
+		old.Close()
+		return
+	}
+	logNote(old, now, slog.LevelInfo, "Run log continues", slog.String("file", filepath.Base(l.f.Name())))
+	old.Close()
+	logNote(l.f, now, slog.LevelInfo, "Run log continued", slog.String("file", filepath.Base(old.Name())))
+	// The new log is the newest, so it is never the one removed.
+	if err := pruneRunLogs(l.dir, maxRunLogs, l.policy.maxAge, now); err != nil {
+		logNote(l.f, now, slog.LevelDebug, "Could not remove old run logs", slog.String("error", err.Error()))
+	}
+}
+
+// logNote writes a record of the log itself straight to f, in the format of
+// the other records. The sink's lock is held, so it cannot go through slog.
+func logNote(f *os.File, now time.Time, level slog.Level, msg string, attrs ...slog.Attr) {
+	r := slog.NewRecord(now, level, msg, 0)
+	r.AddAttrs(attrs...)
+	slog.NewTextHandler(f, &slog.HandlerOptions{Level: level}).Handle(context.Background(), r)
+}
+
+func (l *rotatingLog) Close() error {
+	return l.f.Close()
+}
+
+// pruneRunLogs removes the oldest run logs in dir so that at most keep
+// remain, and, if maxAge is set, those last written more than maxAge before
+// now. Log names start with their UTC start time, so they sort by age.
+func pruneRunLogs(dir string, keep int, maxAge time.Duration, now time.Time) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var logs []string
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), "download-") || !strings.HasSuffix(e.Name(), ".log") {
+			continue
+		}
+		if maxAge > 0 {
+			if info, err := e.Info(); err == nil && now.Sub(info.ModTime()) > maxAge {
+				if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		logs = append(logs, e.Name())
+	}
+	if len(logs) <= keep {
+		return nil
+	}
+	sort.Strings(logs)
+	for _, name := range logs[:len(logs)-keep] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *runLogSink) setLevel(level slog.Level) {
+	s.mu.Lock()
+	s.level = level
+	s.mu.Unlock()
+}
+
+// attach starts writing records to l and returns its handler.
+func (s *runLogSink) attach(l *rotatingLog) slog.Handler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := slog.NewTextHandler(l, &slog.HandlerOptions{Level: s.level})
+	if s.logs == nil {
+		s.logs = make(map[*rotatingLog]slog.Handler)
+	}
+	s.logs[l] = h
+	return h
+}
+
+// detach stops writing records to l and closes it.
+func (s *runLogSink) detach(l *rotatingLog) {
+	s.mu.Lock()
+	delete(s.logs, l)
+	s.mu.Unlock()
+	l.Close()
+}
+
+// write sends r to one log only.
+func (s *runLogSink) write(h slog.Handler, r slog.Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h.Handle(context.Background(), r)
+}
+
+// handler returns the slog.Handler that feeds the sink.
+func (s *runLogSink) handler() slog.Handler {
+	return runLogHandler{sink: s}
+}
+
+// runLogHandler passes records to every open run log, and to the job log of
+// the record's context, if any. Attributes and groups
+// added to the logger are applied when a record is written, since logs are
+// opened after loggers are created.
+type runLogHandler struct {
+	sink *runLogSink
+	with []func(slog.Handler) slog.Handler
+}
+
+func (h runLogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	h.sink.mu.Lock()
+	defer h.sink.mu.Unlock()
+	return len(h.sink.logs) > 0 && level >= h.sink.level
+}
+
+func (h runLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.sink.mu.Lock()
+	defer h.sink.mu.Unlock()
+	job, _ := ctx.Value(jobLogKey{}).(*rotatingLog)
+	var firstErr error
+	for l, lh := range h.sink.logs {
+		if l.job && l != job {
+			continue
+		}
+		for _, with := range h.with {
+			lh = with(lh)
+		}
+		if err := lh.Handle(ctx, r.Clone()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (h runLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.add(func(lh slog.Handler) slog.Handler { return lh.WithAttrs(attrs) })
+}
+
+func (h runLogHandler) WithGroup(name string) slog.Handler {
+	return h.add(func(lh slog.Handler) slog.Handler { return lh.WithGroup(name) })
+}
+
+func (h runLogHandler) add(with func(slog.Handler) slog.Handler) runLogHandler {
+	h.with = append(append([]func(slog.Handler) slog.Handler{}, h.with...), with)
+	return h
+}
+
+// logManifest records the files a download resolved to at debug level, so
+// run logs show exactly what was requested.
+func logManifest(ctx context.Context, manifest *state.Manifest) {
+	var total int64
+	for _, f := range manifest.Files {
+		total += f.Size
+	}
+	slog.DebugContext(ctx, "Resolved manifest", "dataset", manifest.DatasetID, "files", len(manifest.Files), "total_size", total)
+	for _, f := range manifest.Files {
+		slog.DebugContext(ctx, "Manifest file", "file_id", f.FileID, "file_name", f.FileName, "size", f.Size, "checksum_type", f.ChecksumType)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+func TestPruneRunLogs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"download-20260101T000000Z.log",
+		"download-20260103T000000Z.log",
+		"download-20260102T000000Z.log",
+		"notes.txt",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := pruneRunLogs(dir, 2, 0, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	want := []string{"download-20260102T000000Z.log", "download-20260103T000000Z.log", "notes.txt"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestRunLog(t *testing.T) {
+	sm := state.NewStateManager(t.TempDir())
+	logger := slog.New(runLog.handler()).With("command", "egafetch download")
+
+	logger.Info("Before the log is open")
+	path, closeLog, err := openRunLog(sm, runLogPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("Chunk attempt failed, retrying", "attempt", 1)
+	closeLog(errors.New("1 file(s) failed"))
+	logger.Info("After the log is closed")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(data)
+	for _, want := range []string{
+		`msg="Run started"`,
+		`msg="Chunk attempt failed, retrying" command="egafetch download" attempt=1`,
+		`level=ERROR msg="Run finished" error="1 file(s) failed"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("log missing %q:\n%s", want, got)
+		}
+	}
+	for _, unwanted := range []string{"Before the log is open", "After the log is closed"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("log contains %q:\n%s", unwanted, got)
+		}
+	}
+}
+
+func TestJobLogs(t *testing.T) {
+	logger := slog.New(runLog.handler())
+	a, b := state.NewStateManager(t.TempDir()), state.NewStateManager(t.TempDir())
+	ctxA, closeA, err := openJobLog(context.Background(), a, runLogPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctxB, closeB, err := openJobLog(context.Background(), b, runLogPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctxA, cancel := context.WithCancel(ctxA)
+	defer cancel()
+	logger.InfoContext(ctxA, "Downloading file", "job", "a")
+	logger.InfoContext(ctxB, "Downloading file", "job", "b")
+	logger.Info("Job submitted", "job", "c")
+	closeA(nil)
+	closeB(nil)
+
+	for _, tt := range []struct {
+		sm       *state.StateManager
+		want     string
+		unwanted []string
+	}{
+		{a, "job=a", []string{"job=b", "job=c"}},
+		{b, "job=b", []string{"job=a", "job=c"}},
+	} {
+		logs, _ := filepath.Glob(filepath.Join(tt.sm.EgafetchPath(), runLogsDir, "*.log"))
+		if len(logs) != 1 {
+			t.Fatalf("logs = %v, want 1", logs)
+		}
+		data, err := os.ReadFile(logs[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := string(data)
+		if !strings.Contains(got, tt.want) {
+			t.Errorf("log missing %q:\n%s", tt.want, got)
+		}
+		for _, unwanted := range tt.unwanted {
+			if strings.Contains(got, unwanted) {
+				t.Errorf("log contains %q:\n%s", unwanted, got)
+			}
+		}
+	}
+}
+
+func TestPruneRunLogsByAge(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for name, age := range map[string]time.Duration{
+		"download-20260101T000000Z.log": 40 * 24 * time.Hour,
+		"download-20260120T000000Z.log": 20 * 24 * time.Hour,
+		"notes.txt":                     40 * 24 * time.Hour,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := pruneRunLogs(dir, maxRunLogs, 30*24*time.Hour, now); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	want := []string{"download-20260120T000000Z.log", "notes.txt"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestRotatingLog(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2026, 10, 14, 6, 12, 9, 0, time.UTC)
+	l := &rotatingLog{dir: dir, policy: runLogPolicy{maxSize: 10}}
+	if err := l.open(start); err != nil {
+		t.Fatal(err)
+	}
+	l.Write([]byte("first line\n"))
+	l.rotate(start.Add(time.Minute))
+	l.Write([]byte("second line\n"))
+	l.Close()
+
+	first, err := os.ReadFile(filepath.Join(dir, "download-20261014T061209Z.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := os.ReadFile(filepath.Join(dir, "download-20261014T061309Z.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(first); !strings.HasPrefix(got, "first line\n") || !strings.Contains(got, `msg="Run log continues" file=download-20261014T061309Z.log`) {
+		t.Errorf("first log:\n%s", got)
+	}
+	if got := string(second); !strings.Contains(got, `msg="Run log continued" file=download-20261014T061209Z.log`) || !strings.HasSuffix(got, "second line\n") {
+		t.Errorf("second log:\n%s", got)
+	}
+}
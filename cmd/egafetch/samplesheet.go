@@ -0,0 +1,490 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+// File kinds recognised when building samplesheets.
+const (
+	kindFASTQ = "fastq"
+	kindBAM   = "bam"
+	kindCRAM  = "cram"
+	kindBAI   = "bai"
+	kindCRAI  = "crai"
+	kindOther = "other"
+)
+
+// localFile is a completed download linked to its metadata.
+type localFile struct {
+	FileID string
+	Path   string // absolute path to the downloaded file
+	Kind   string
+	RunID  string
+}
+
+// sampleFiles groups the completed downloads that belong to one sample.
+type sampleFiles struct {
+	SampleID string
+	Record   map[string]interface{} // first merged metadata record for the sample
+	Files    []localFile
+}
+
+// --- Samplesheet command ---
+
+func newSamplesheetCmd() *cobra.Command {
+	var pipeline string
+	var dir string
+	var metadataDir string
+	var output string
+	var configFile string
+	var refresh bool
+
+	cmd := &cobra.Command{
+		Use:               "samplesheet EGAD...",
+		ValidArgsFunction: completeDatasetIDs(true),
+		Short:             "Write an nf-core samplesheet for downloaded files",
+		Long: `Write a ready-to-run nf-core samplesheet CSV by joining the dataset's
+metadata mappings with the local paths of completed downloads.
+
+Supported pipelines:
+  sarek    patient,sex,sample,lane,fastq_1,fastq_2 (or bam/bai, cram/crai)
+  rnaseq   sample,fastq_1,fastq_2,strandedness
+
+Metadata previously exported by 'egafetch metadata' or 'egafetch download'
+is reused when found; otherwise it is fetched from the EGA metadata API.`,
+		Example: `  egafetch samplesheet EGAD00001001938 --pipeline sarek -d ./data
+  egafetch samplesheet EGAD00001001938 --pipeline rnaseq -o rnaseq.csv`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			datasetID := args[0]
+			if !strings.HasPrefix(datasetID, "EGAD") {
+				return fmt.Errorf("expected dataset ID (EGAD...)")
+			}
+			if err := applyConfig(cmd, map[string]string{"dir": "output_dir"}); err != nil {
+				return err
+			}
+
+			switch pipeline {
+			case "sarek", "rnaseq":
+			default:
+				return fmt.Errorf("unsupported pipeline %q (use sarek or rnaseq)", pipeline)
+			}
+
+			if metadataDir == "" {
+				metadataDir = filepath.Join(dir, datasetID+"-metadata")
+			}
+
+			ctx, cancel := signalContext()
+			defer cancel()
+
+			meta, err := resolveDatasetMetadata(ctx, metadataDir, datasetID, configFile, refresh)
+			if err != nil {
+				return err
+			}
+
+			samples, err := collectSampleFiles(meta, dir)
+			if err != nil {
+				return err
+			}
+			if len(samples) == 0 {
+				return fmt.Errorf("no completed downloads in %s match the metadata of %s", dir, datasetID)
+			}
+
+			var header []string
+			var rows [][]string
+			switch pipeline {
+			case "sarek":
+				header, rows, err = buildSarekSamplesheet(samples)
+			case "rnaseq":
+				header, rows, err = buildRnaseqSamplesheet(samples)
+			}
+			if err != nil {
+				return err
+			}
+
+			if err := writeCSV(output, header, rows); err != nil {
+				return fmt.Errorf("write samplesheet: %w", err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Wrote %s: %d row(s) for %d sample(s)\n", output, len(rows), len(samples))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&pipeline, "pipeline", "", "nf-core pipeline (sarek, rnaseq)")
+	cmd.Flags().StringVarP(&dir, "dir", "d", ".", "Download directory")
+	cmd.Flags().StringVar(&metadataDir, "metadata-dir", "", "Directory with exported metadata (default: {dir}/{datasetID}-metadata)")
+	cmd.Flags().StringVarP(&output, "output", "o", "samplesheet.csv", "Output samplesheet path")
+	cmd.Flags().StringVar(&configFile, "cf", "", "JSON config file with credentials")
+	cmd.Flags().StringVar(&configFile, "config-file", "", "JSON config file with credentials (alias for --cf)")
+	cmd.Flags().BoolVar(&refresh, "refresh", false, "Re-fetch metadata even if cached or previously exported")
+	cmd.MarkFlagRequired("pipeline")
+
+	return cmd
+}
+
+// collectSampleFiles links completed downloads in dir to samples using the
+// sample_file mapping, returning samples sorted by accession.
+func collectSampleFiles(meta *api.DatasetMetadata, dir string) ([]*sampleFiles, error) {
+	sm := state.NewStateManager(dir)
+	states, err := sm.ListFileStates()
+	if err != nil {
+		return nil, err
+	}
+
+	downloaded := make(map[string]string) // file ID → absolute local path
+	for _, fs := range states {
+		if fs.Status != state.StatusComplete {
+			continue
+		}
+		path, err := filepath.Abs(filepath.Join(dir, fs.FileName))
+		if err != nil {
+			return nil, err
+		}
+		downloaded[fs.FileID] = path
+	}
+
+	// First merged record per sample provides the sample-level attributes.
+	records := make(map[string]map[string]interface{})
+	for _, rec := range buildMergedMetadata(meta, defaultMergeOptions()) {
+		id := formatValue(rec["sample_accession_id"])
+		if _, ok := records[id]; !ok && id != "" {
+			records[id] = rec
+		}
+	}
+
+	// Runs are linked to files through the merged sequencing path, if any.
+	runByFile := make(map[string]string)
+	for _, rec := range meta.StudyExperimentRunSample {
+		fileID := formatValue(rec["file_accession_id"])
+		if runID := formatValue(rec["run_accession_id"]); fileID != "" && runID != "" {
+			runByFile[fileID] = runID
+		}
+	}
+
+	bySample := make(map[string]*sampleFiles)
+	seenFile := make(map[string]bool)
+	for _, rec := range meta.SampleFile {
+		sampleID := formatValue(rec["sample_accession_id"])
+		fileID := formatValue(rec["file_accession_id"])
+		path, ok := downloaded[fileID]
+		if sampleID == "" || !ok || seenFile[fileID] {
+			continue
+		}
+		seenFile[fileID] = true
+
+		sf, ok := bySample[sampleID]
+		if !ok {
+			sf = &sampleFiles{SampleID: sampleID, Record: records[sampleID]}
+			if sf.Record == nil {
+				sf.Record = rec
+			}
+			bySample[sampleID] = sf
+		}
+		sf.Files = append(sf.Files, localFile{
+			FileID: fileID,
+			Path:   path,
+			Kind:   fileKind(path),
+			RunID:  runByFile[fileID],
+		})
+	}
+
+	samples := make([]*sampleFiles, 0, len(bySample))
+	for _, sf := range bySample {
+		sort.Slice(sf.Files, func(i, j int) bool { return sf.Files[i].Path < sf.Files[j].Path })
+		samples = append(samples, sf)
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].SampleID < samples[j].SampleID })
+	return samples, nil
+}
+
+// fileKind classifies a file by extension.
+func fileKind(path string) string {
+	name := strings.ToLower(filepath.Base(path))
+	name = strings.TrimSuffix(name, ".gz")
+	switch {
+	case strings.HasSuffix(name, ".fastq"), strings.HasSuffix(name, ".fq"):
+		return kindFASTQ
+	case strings.HasSuffix(name, ".bam"):
+		return kindBAM
+	case strings.HasSuffix(name, ".cram"):
+		return kindCRAM
+	case strings.HasSuffix(name, ".bai"):
+		return kindBAI
+	case strings.HasSuffix(name, ".crai"):
+		return kindCRAI
+	default:
+		return kindOther
+	}
+}
+
+// fastqPair is a read-1/read-2 pair (Read2 is empty for single-end data).
+type fastqPair struct {
+	Read1 string
+	Read2 string
+	RunID string
+}
+
+// mateMarkers are read-1 markers and their read-2 counterparts, in the order
+// they are tried against a file name.
+var mateMarkers = [][2]string{
+	{"_R1_", "_R2_"},
+	{"_R1.", "_R2."},
+	{"_1.f", "_2.f"},
+	{".R1.", ".R2."},
+	{".1.f", ".2.f"},
+}
+
+// pairFASTQ pairs a sample's FASTQ files into read-1/read-2 pairs by file
+// name. Each download lives in its own EGAF directory, so mates are matched
+// on base name only, preferring a mate of the same run when two runs have
+// files of the same name. Files without a recognisable mate are single-end.
+func pairFASTQ(files []localFile) []fastqPair {
+	byName := make(map[string][]localFile)
+	for _, f := range files {
+		if f.Kind == kindFASTQ {
+			base := filepath.Base(f.Path)
+			byName[base] = append(byName[base], f)
+		}
+	}
+
+	used := make(map[string]bool)
+	mateOf := func(f localFile, name string) (localFile, bool) {
+		var mate localFile
+		found := false
+		for _, m := range byName[name] {
+			if used[m.Path] {
+				continue
+			}
+			if m.RunID == f.RunID {
+				return m, true
+			}
+			if !found {
+				mate, found = m, true
+			}
+		}
+		return mate, found
+	}
+	var pairs []fastqPair
+	for _, f := range files {
+		if f.Kind != kindFASTQ || used[f.Path] {
+			continue
+		}
+		base := filepath.Base(f.Path)
+		for _, m := range mateMarkers {
+			if !strings.Contains(base, m[0]) {
+				continue
+			}
+			if mate, ok := mateOf(f, strings.Replace(base, m[0], m[1], 1)); ok {
+				used[f.Path], used[mate.Path] = true, true
+				pairs = append(pairs, fastqPair{Read1: f.Path, Read2: mate.Path, RunID: f.RunID})
+				break
+			}
+		}
+	}
+	for _, f := range files {
+		if f.Kind == kindFASTQ && !used[f.Path] {
+			used[f.Path] = true
+			pairs = append(pairs, fastqPair{Read1: f.Path, RunID: f.RunID})
+		}
+	}
+	return pairs
+}
+
+// alignmentIndexKinds are the index kinds of the alignment kinds.
+var alignmentIndexKinds = map[string]string{kindBAM: kindBAI, kindCRAM: kindCRAI}
+
+// alignmentIndexes returns the index of each BAM and CRAM file among files,
+// by path. Indexes live in their own EGAF directories, so they are matched
+// on base name: both sample.bam.bai and sample.bai belong to sample.bam. If
+// two runs have files of the same name, each gets the index of its own run.
+func alignmentIndexes(files []localFile) map[string]string {
+	type key struct{ kind, run, stem string }
+	byKey := make(map[key]string)
+	for _, f := range files {
+		for kind, index := range alignmentIndexKinds {
+			if f.Kind != index {
+				continue
+			}
+			stem := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(f.Path), "."+index), "."+kind)
+			byKey[key{kind, f.RunID, stem}] = f.Path
+			if anyRun := (key{kind, "", stem}); byKey[anyRun] == "" {
+				byKey[anyRun] = f.Path
+			}
+		}
+	}
+
+	indexes := make(map[string]string)
+	for _, f := range files {
+		if _, ok := alignmentIndexKinds[f.Kind]; !ok {
+			continue
+		}
+		stem := strings.TrimSuffix(filepath.Base(f.Path), "."+f.Kind)
+		if path := byKey[key{f.Kind, f.RunID, stem}]; path != "" {
+			indexes[f.Path] = path
+		} else if path := byKey[key{f.Kind, "", stem}]; path != "" {
+			indexes[f.Path] = path
+		}
+	}
+	return indexes
+}
+
+// unitNames names the units of one sample after their run IDs: a run ID
+// unique within the sample names its unit as it is, one shared by several
+// units is numbered (RUN_1, RUN_2), and a unit without one is named
+// fallback(i), for its position i.
+func unitNames(runIDs []string, fallback func(i int) string) []string {
+	counts := make(map[string]int)
+	for _, id := range runIDs {
+		counts[id]++
+	}
+	numbered := make(map[string]int)
+	names := make([]string, len(runIDs))
+	for i, id := range runIDs {
+		switch {
+		case id == "":
+			names[i] = fallback(i)
+		case counts[id] == 1:
+			names[i] = id
+		default:
+			numbered[id]++
+			names[i] = fmt.Sprintf("%s_%d", id, numbered[id])
+		}
+	}
+	return names
+}
+
+// firstValue returns the first non-empty value among the candidate columns.
+func firstValue(rec map[string]interface{}, columns ...string) string {
+	for _, c := range columns {
+		if v := formatValue(rec[c]); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// sarekSex maps a metadata sex value to sarek's XX/XY/NA convention.
+func sarekSex(rec map[string]interface{}) string {
+	switch strings.ToLower(firstValue(rec, "sex", "biological_sex", "gender")) {
+	case "female", "f", "xx":
+		return "XX"
+	case "male", "m", "xy":
+		return "XY"
+	default:
+		return "NA"
+	}
+}
+
+// buildSarekSamplesheet builds an nf-core/sarek samplesheet. FASTQ input is
+// preferred; datasets without FASTQ files fall back to BAM, then CRAM.
+func buildSarekSamplesheet(samples []*sampleFiles) ([]string, [][]string, error) {
+	counts := make(map[string]int)
+	for _, s := range samples {
+		for _, f := range s.Files {
+			counts[f.Kind]++
+		}
+	}
+
+	var kind, index string
+	switch {
+	case counts[kindFASTQ] > 0:
+		kind = kindFASTQ
+	case counts[kindBAM] > 0:
+		kind, index = kindBAM, kindBAI
+	case counts[kindCRAM] > 0:
+		kind, index = kindCRAM, kindCRAI
+	default:
+		return nil, nil, fmt.Errorf("no FASTQ, BAM, or CRAM files among completed downloads")
+	}
+
+	var header []string
+	if kind == kindFASTQ {
+		header = []string{"patient", "sex", "sample", "lane", "fastq_1", "fastq_2"}
+	} else {
+		header = []string{"patient", "sex", "sample", kind, index}
+	}
+
+	var rows [][]string
+	for _, s := range samples {
+		patient := firstValue(s.Record, "subject_id", "individual_accession_id", "donor_id", "sample_alias")
+		if patient == "" {
+			patient = s.SampleID
+		}
+		sex := sarekSex(s.Record)
+
+		if kind == kindFASTQ {
+			pairs := pairFASTQ(s.Files)
+			runIDs := make([]string, len(pairs))
+			for i, p := range pairs {
+				runIDs[i] = p.RunID
+			}
+			lanes := unitNames(runIDs, func(i int) string { return fmt.Sprintf("L%03d", i+1) })
+			for i, p := range pairs {
+				rows = append(rows, []string{patient, sex, s.SampleID, lanes[i], p.Read1, p.Read2})
+			}
+			continue
+		}
+
+		indexes := alignmentIndexes(s.Files)
+		for _, f := range s.Files {
+			if f.Kind == kind {
+				rows = append(rows, []string{patient, sex, s.SampleID, f.Path, indexes[f.Path]})
+			}
+		}
+	}
+
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("no %s files among completed downloads", kind)
+	}
+	return header, rows, nil
+}
+
+// buildRnaseqSamplesheet builds an nf-core/rnaseq samplesheet from FASTQ
+// files. Strandedness is left to the pipeline's auto-detection.
+func buildRnaseqSamplesheet(samples []*sampleFiles) ([]string, [][]string, error) {
+	header := []string{"sample", "fastq_1", "fastq_2", "strandedness"}
+
+	var rows [][]string
+	for _, s := range samples {
+		for _, p := range pairFASTQ(s.Files) {
+			rows = append(rows, []string{s.SampleID, p.Read1, p.Read2, "auto"})
+		}
+	}
+
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("nf-core/rnaseq requires FASTQ input, but no FASTQ files are among completed downloads")
+	}
+	return header, rows, nil
+}
+
+// writeCSV writes a header and rows to path as CSV.
+func writeCSV(path string, header []string, rows [][]string) error {
+	return writeDelimitedRows(path, ',', header, rows)
+}
+
+// writeDelimitedRows writes a header and rows to path using the given
+// field separator.
+func writeDelimitedRows(path string, comma rune, header []string, rows [][]string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Comma = comma
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	return w.WriteAll(rows)
+}
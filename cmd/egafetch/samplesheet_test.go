@@ -0,0 +1,199 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func fastqFiles(runID string, paths ...string) []localFile {
+	files := make([]localFile, len(paths))
+	for i, p := range paths {
+		files[i] = localFile{Path: p, Kind: kindFASTQ, RunID: runID}
+	}
+	return files
+}
+
+func TestPairFASTQ(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []localFile
+		want  []fastqPair
+	}{
+		{
+			name:  "paired",
+			files: fastqFiles("EGAR1", "/d/EGAF2/s_R2_001.fastq.gz", "/d/EGAF1/s_R1_001.fastq.gz"),
+			want:  []fastqPair{{Read1: "/d/EGAF1/s_R1_001.fastq.gz", Read2: "/d/EGAF2/s_R2_001.fastq.gz", RunID: "EGAR1"}},
+		},
+		{
+			name:  "missing mate",
+			files: fastqFiles("", "/d/EGAF1/s_R1.fastq.gz", "/d/EGAF2/t_2.fq.gz"),
+			want: []fastqPair{
+				{Read1: "/d/EGAF1/s_R1.fastq.gz"},
+				{Read1: "/d/EGAF2/t_2.fq.gz"},
+			},
+		},
+		{
+			name: "mixed naming",
+			files: fastqFiles("",
+				"/d/EGAF1/a_1.fastq.gz", "/d/EGAF2/a_2.fastq.gz",
+				"/d/EGAF3/b.R1.fq", "/d/EGAF4/b.R2.fq",
+				"/d/EGAF5/c_R1.fastq", "/d/EGAF6/c_2.fastq",
+			),
+			want: []fastqPair{
+				{Read1: "/d/EGAF1/a_1.fastq.gz", Read2: "/d/EGAF2/a_2.fastq.gz"},
+				{Read1: "/d/EGAF3/b.R1.fq", Read2: "/d/EGAF4/b.R2.fq"},
+				{Read1: "/d/EGAF5/c_R1.fastq"},
+				{Read1: "/d/EGAF6/c_2.fastq"},
+			},
+		},
+		{
+			name: "same names in two runs",
+			files: append(
+				fastqFiles("EGAR1", "/d/EGAF1/s_R1.fastq.gz", "/d/EGAF2/s_R2.fastq.gz"),
+				fastqFiles("EGAR2", "/d/EGAF4/s_R2.fastq.gz", "/d/EGAF3/s_R1.fastq.gz")...,
+			),
+			want: []fastqPair{
+				{Read1: "/d/EGAF1/s_R1.fastq.gz", Read2: "/d/EGAF2/s_R2.fastq.gz", RunID: "EGAR1"},
+				{Read1: "/d/EGAF3/s_R1.fastq.gz", Read2: "/d/EGAF4/s_R2.fastq.gz", RunID: "EGAR2"},
+			},
+		},
+		{
+			name:  "other kinds ignored",
+			files: []localFile{{Path: "/d/EGAF1/s.bam", Kind: kindBAM}, {Path: "/d/EGAF2/s_R1.fastq", Kind: kindFASTQ}},
+			want:  []fastqPair{{Read1: "/d/EGAF2/s_R1.fastq"}},
+		},
+	}
+	for _, tt := range tests {
+		got := pairFASTQ(tt.files)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: pairFASTQ = %+v, want %+v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range tt.want {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: pair %d = %+v, want %+v", tt.name, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestBuildSarekSamplesheet(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []*sampleFiles
+		header  string
+		rows    []string
+		wantErr string
+	}{
+		{
+			name: "fastq lanes",
+			samples: []*sampleFiles{{
+				SampleID: "EGAN1",
+				Record:   map[string]interface{}{"subject_id": "P1", "sex": "Female"},
+				Files: append(
+					fastqFiles("EGAR1", "/d/EGAF1/s_R1.fastq.gz", "/d/EGAF2/s_R2.fastq.gz", "/d/EGAF3/t_R1.fastq.gz", "/d/EGAF4/t_R2.fastq.gz"),
+					fastqFiles("", "/d/EGAF5/u_R1.fastq.gz")...,
+				),
+			}},
+			header: "patient,sex,sample,lane,fastq_1,fastq_2",
+			rows: []string{
+				"P1,XX,EGAN1,EGAR1_1,/d/EGAF1/s_R1.fastq.gz,/d/EGAF2/s_R2.fastq.gz",
+				"P1,XX,EGAN1,EGAR1_2,/d/EGAF3/t_R1.fastq.gz,/d/EGAF4/t_R2.fastq.gz",
+				"P1,XX,EGAN1,L003,/d/EGAF5/u_R1.fastq.gz,",
+			},
+		},
+		{
+			name: "bam with indexes by run",
+			samples: []*sampleFiles{{
+				SampleID: "EGAN1",
+				Record:   map[string]interface{}{},
+				Files: []localFile{
+					{Path: "/d/EGAF1/s.bam", Kind: kindBAM, RunID: "EGAR1"},
+					{Path: "/d/EGAF2/s.bam", Kind: kindBAM, RunID: "EGAR2"},
+					{Path: "/d/EGAF3/s.bai", Kind: kindBAI, RunID: "EGAR2"},
+					{Path: "/d/EGAF4/s.bam.bai", Kind: kindBAI, RunID: "EGAR1"},
+					{Path: "/d/EGAF5/other.bam", Kind: kindBAM},
+					{Path: "/d/EGAF6/x.cram", Kind: kindCRAM},
+				},
+			}},
+			header: "patient,sex,sample,bam,bai",
+			rows: []string{
+				"EGAN1,NA,EGAN1,/d/EGAF1/s.bam,/d/EGAF4/s.bam.bai",
+				"EGAN1,NA,EGAN1,/d/EGAF2/s.bam,/d/EGAF3/s.bai",
+				"EGAN1,NA,EGAN1,/d/EGAF5/other.bam,",
+			},
+		},
+		{
+			name: "cram",
+			samples: []*sampleFiles{{
+				SampleID: "EGAN1",
+				Record:   map[string]interface{}{"sex": "m"},
+				Files: []localFile{
+					{Path: "/d/EGAF1/s.cram", Kind: kindCRAM},
+					{Path: "/d/EGAF2/s.cram.crai", Kind: kindCRAI},
+				},
+			}},
+			header: "patient,sex,sample,cram,crai",
+			rows:   []string{"EGAN1,XY,EGAN1,/d/EGAF1/s.cram,/d/EGAF2/s.cram.crai"},
+		},
+		{
+			name:    "nothing usable",
+			samples: []*sampleFiles{{SampleID: "EGAN1", Files: []localFile{{Path: "/d/EGAF1/s.vcf.gz", Kind: kindOther}}}},
+			wantErr: "no FASTQ, BAM, or CRAM files",
+		},
+	}
+	for _, tt := range tests {
+		header, rows, err := buildSarekSamplesheet(tt.samples)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := strings.Join(header, ","); got != tt.header {
+			t.Errorf("%s: header = %s, want %s", tt.name, got, tt.header)
+		}
+		var got []string
+		for _, row := range rows {
+			got = append(got, strings.Join(row, ","))
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.rows, "\n") {
+			t.Errorf("%s: rows =\n%s\nwant\n%s", tt.name, strings.Join(got, "\n"), strings.Join(tt.rows, "\n"))
+		}
+	}
+}
+
+func TestBuildRnaseqSamplesheet(t *testing.T) {
+	samples := []*sampleFiles{
+		{SampleID: "EGAN1", Files: fastqFiles("", "/d/EGAF1/a_1.fq.gz", "/d/EGAF2/a_2.fq.gz")},
+		{SampleID: "EGAN2", Files: fastqFiles("", "/d/EGAF3/b_R1.fastq.gz")},
+		{SampleID: "EGAN3", Files: []localFile{{Path: "/d/EGAF4/c.bam", Kind: kindBAM}}},
+	}
+	header, rows, err := buildRnaseqSamplesheet(samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(header, ","); got != "sample,fastq_1,fastq_2,strandedness" {
+		t.Errorf("header = %s", got)
+	}
+	want := []string{
+		"EGAN1,/d/EGAF1/a_1.fq.gz,/d/EGAF2/a_2.fq.gz,auto",
+		"EGAN2,/d/EGAF3/b_R1.fastq.gz,,auto",
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("rows = %v, want %v", rows, want)
+	}
+	for i := range want {
+		if got := strings.Join(rows[i], ","); got != want[i] {
+			t.Errorf("row %d = %s, want %s", i, got, want[i])
+		}
+	}
+
+	if _, _, err := buildRnaseqSamplesheet(samples[2:]); err == nil || !strings.Contains(err.Error(), "requires FASTQ input") {
+		t.Errorf("without FASTQ files: error = %v, want one asking for FASTQ input", err)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/khan-lab/EGAfetch/internal/ui"
+)
+
+// searchKeyColumns are always shown in search results when present, so each
+// match can be traced back to the sample, run/analysis, and file it refers to.
+var searchKeyColumns = []string{
+	"sample_accession_id",
+	"experiment_accession_id",
+	"run_accession_id",
+	"analysis_accession_id",
+	"file_accession_id",
+}
+
+// --- Metadata search command ---
+
+func newMetadataSearchCmd() *cobra.Command {
+	var wheres []string
+	var dir string
+	var configFile string
+	var refresh bool
+	var mergeBase string
+	var joinKeys []string
+	var joinType string
+
+	cmd := &cobra.Command{
+		Use:               "search EGAD...",
+		ValidArgsFunction: completeDatasetIDs(true),
+		Short:             "Search dataset metadata for matching samples, runs, and files",
+		Long: `Search the merged metadata of a dataset and print the matching records.
+
+Each --where condition has the form COLUMN=VALUE (exact, case-insensitive),
+COLUMN!=VALUE (not equal), or COLUMN~VALUE (case-insensitive substring).
+All conditions must match.
+
+Metadata previously exported by 'egafetch metadata' is reused when found in
+--dir; otherwise it is fetched from the EGA metadata API.`,
+		Example: `  egafetch metadata search EGAD00001001938 --where 'library_strategy=WGS'
+  egafetch metadata search EGAD00001001938 --where 'phenotype~melanoma' --where 'sex=female'`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			datasetID := args[0]
+			if !strings.HasPrefix(datasetID, "EGAD") {
+				return fmt.Errorf("expected dataset ID (EGAD...)")
+			}
+
+			filters := make([]metadataFilter, 0, len(wheres))
+			for _, w := range wheres {
+				f, err := parseMetadataFilter(w)
+				if err != nil {
+					return err
+				}
+				filters = append(filters, f)
+			}
+
+			merge, err := parseMergeOptions(mergeBase, joinKeys, joinType)
+			if err != nil {
+				return err
+			}
+
+			if dir == "" {
+				dir = datasetID + "-metadata"
+			}
+
+			ctx, cancel := signalContext()
+			defer cancel()
+
+			meta, err := resolveDatasetMetadata(ctx, dir, datasetID, configFile, refresh)
+			if err != nil {
+				return err
+			}
+			records := buildMergedMetadata(meta, merge)
+
+			matches, err := searchRecords(records, filters)
+			if err != nil {
+				return err
+			}
+
+			printSearchResults(cmd.OutOrStdout(), matches, filters)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringArrayVar(&wheres, "where", nil, "Filter condition COLUMN=VALUE, COLUMN!=VALUE, or COLUMN~VALUE (repeatable)")
+	cmd.Flags().StringVar(&dir, "dir", "", "Directory with previously exported metadata (default: {datasetID}-metadata)")
+	cmd.Flags().StringVar(&configFile, "cf", "", "JSON config file with credentials")
+	cmd.Flags().StringVar(&configFile, "config-file", "", "JSON config file with credentials (alias for --cf)")
+	cmd.Flags().BoolVar(&refresh, "refresh", false, "Re-fetch metadata even if cached or previously exported")
+	addMergeFlags(cmd, &mergeBase, &joinKeys, &joinType)
+
+	return cmd
+}
+
+// metadataFilter is a single --where condition.
+type metadataFilter struct {
+	Column string
+	Op     string // "=", "!=", or "~"
+	Value  string
+}
+
+// parseMetadataFilter parses COLUMN=VALUE, COLUMN!=VALUE, or COLUMN~VALUE.
+func parseMetadataFilter(expr string) (metadataFilter, error) {
+	for i := 0; i < len(expr); i++ {
+		var op string
+		switch {
+		case strings.HasPrefix(expr[i:], "!="):
+			op = "!="
+		case expr[i] == '=':
+			op = "="
+		case expr[i] == '~':
+			op = "~"
+		default:
+			continue
+		}
+		column := strings.TrimSpace(expr[:i])
+		if column == "" {
+			break
+		}
+		return metadataFilter{
+			Column: column,
+			Op:     op,
+			Value:  strings.TrimSpace(expr[i+len(op):]),
+		}, nil
+	}
+	return metadataFilter{}, fmt.Errorf("invalid --where %q (expected COLUMN=VALUE, COLUMN!=VALUE, or COLUMN~VALUE)", expr)
+}
+
+// matches reports whether the record satisfies the filter.
+func (f metadataFilter) matches(rec map[string]interface{}) bool {
+	value := formatValue(rec[f.Column])
+	switch f.Op {
+	case "=":
+		return strings.EqualFold(value, f.Value)
+	case "!=":
+		return !strings.EqualFold(value, f.Value)
+	case "~":
+		return strings.Contains(strings.ToLower(value), strings.ToLower(f.Value))
+	}
+	return false
+}
+
+// searchRecords returns the records that satisfy every filter. Filters that
+// name a column absent from all records are reported as errors, since they
+// would otherwise silently match nothing.
+func searchRecords(records []map[string]interface{}, filters []metadataFilter) ([]map[string]interface{}, error) {
+	known := make(map[string]bool)
+	for _, rec := range records {
+		for k := range rec {
+			known[k] = true
+		}
+	}
+	for _, f := range filters {
+		if !known[f.Column] {
+			return nil, fmt.Errorf("unknown metadata column %q", f.Column)
+		}
+	}
+
+	var matches []map[string]interface{}
+	for _, rec := range records {
+		ok := true
+		for _, f := range filters {
+			if !f.matches(rec) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			matches = append(matches, rec)
+		}
+	}
+	return matches, nil
+}
+
+// printSearchResults prints the matching records as a table of key columns
+// plus the columns used in filters, followed by a distinct-count summary.
+func printSearchResults(w io.Writer, matches []map[string]interface{}, filters []metadataFilter) {
+	if len(matches) == 0 {
+		fmt.Fprintln(w, "No matching records.")
+		return
+	}
+
+	present := make(map[string]bool)
+	for _, rec := range matches {
+		for k := range rec {
+			present[k] = true
+		}
+	}
+
+	var columns []string
+	seen := make(map[string]bool)
+	addColumn := func(c string) {
+		if present[c] && !seen[c] {
+			seen[c] = true
+			columns = append(columns, c)
+		}
+	}
+	for _, c := range searchKeyColumns {
+		addColumn(c)
+	}
+	for _, f := range filters {
+		addColumn(f.Column)
+	}
+
+	rows := make([][]string, 0, len(matches))
+	for _, rec := range matches {
+		row := make([]string, len(columns))
+		for i, c := range columns {
+			row[i] = formatValue(rec[c])
+		}
+		rows = append(rows, row)
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return strings.Join(rows[i], "\x00") < strings.Join(rows[j], "\x00")
+	})
+
+	ui.PrintTable(w, columns, rows)
+
+	samples := countDistinct(matches, "sample_accession_id")
+	files := countDistinct(matches, "file_accession_id")
+	fmt.Fprintf(w, "\n%d matching record(s), %d sample(s), %d file(s)\n", len(matches), samples, files)
+}
+
+// countDistinct returns the number of distinct non-empty values of column.
+func countDistinct(records []map[string]interface{}, column string) int {
+	set := make(map[string]struct{})
+	for _, rec := range records {
+		if v := formatValue(rec[column]); v != "" {
+			set[v] = struct{}{}
+		}
+	}
+	return len(set)
+}
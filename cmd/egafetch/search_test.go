@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMetadataFilter(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    metadataFilter
+		wantErr bool
+	}{
+		{expr: "sex=female", want: metadataFilter{Column: "sex", Op: "=", Value: "female"}},
+		{expr: "sex!=female", want: metadataFilter{Column: "sex", Op: "!=", Value: "female"}},
+		{expr: "phenotype~cancer", want: metadataFilter{Column: "phenotype", Op: "~", Value: "cancer"}},
+		{expr: " sex = female ", want: metadataFilter{Column: "sex", Op: "=", Value: "female"}},
+		{expr: "sex=", want: metadataFilter{Column: "sex", Op: "=", Value: ""}},
+		// The first operator splits the expression; the rest is the value.
+		{expr: "title~a=b", want: metadataFilter{Column: "title", Op: "~", Value: "a=b"}},
+		{expr: "title=!x", want: metadataFilter{Column: "title", Op: "=", Value: "!x"}},
+		{expr: "sex", wantErr: true},
+		{expr: "", wantErr: true},
+		{expr: "=female", wantErr: true},
+		{expr: " !=female", wantErr: true},
+		{expr: "~cancer", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseMetadataFilter(tt.expr)
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "invalid --where") {
+				t.Errorf("parseMetadataFilter(%q) = %+v, %v; want an invalid --where error", tt.expr, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseMetadataFilter(%q) = %+v, %v; want %+v", tt.expr, got, err, tt.want)
+		}
+	}
+}
+
+func TestSearchRecords(t *testing.T) {
+	records := []map[string]interface{}{
+		{"sample_accession_id": "EGAN1", "sex": "Female", "phenotype": "Breast Cancer", "age": float64(42)},
+		{"sample_accession_id": "EGAN2", "sex": "male", "phenotype": "healthy control", "age": float64(37)},
+		{"sample_accession_id": "EGAN3", "sex": "female", "phenotype": "colorectal cancer"},
+	}
+	tests := []struct {
+		filters []string
+		want    []string // sample IDs of the matches
+		wantErr string
+	}{
+		{filters: nil, want: []string{"EGAN1", "EGAN2", "EGAN3"}},
+		{filters: []string{"sex=female"}, want: []string{"EGAN1", "EGAN3"}},
+		{filters: []string{"sex=FEMALE"}, want: []string{"EGAN1", "EGAN3"}},
+		{filters: []string{"sex!=Female"}, want: []string{"EGAN2"}},
+		{filters: []string{"phenotype~CANCER"}, want: []string{"EGAN1", "EGAN3"}},
+		{filters: []string{"phenotype~cancer", "sex!=female"}, want: nil},
+		{filters: []string{"sex=female", "phenotype~breast"}, want: []string{"EGAN1"}},
+		// Numbers compare as they are printed; a missing value is empty.
+		{filters: []string{"age=42"}, want: []string{"EGAN1"}},
+		{filters: []string{"age="}, want: []string{"EGAN3"}},
+		{filters: []string{"age!="}, want: []string{"EGAN1", "EGAN2"}},
+		// Column names are matched exactly, unlike values.
+		{filters: []string{"Sex=female"}, wantErr: `unknown metadata column "Sex"`},
+		{filters: []string{"sex=female", "tissue~blood"}, wantErr: `unknown metadata column "tissue"`},
+	}
+	for _, tt := range tests {
+		var filters []metadataFilter
+		for _, expr := range tt.filters {
+			f, err := parseMetadataFilter(expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			filters = append(filters, f)
+		}
+		matches, err := searchRecords(records, filters)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("searchRecords(%v) error = %v, want %q", tt.filters, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("searchRecords(%v): %v", tt.filters, err)
+			continue
+		}
+		var got []string
+		for _, rec := range matches {
+			got = append(got, rec["sample_accession_id"].(string))
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("searchRecords(%v) = %v, want %v", tt.filters, got, tt.want)
+		}
+	}
+}
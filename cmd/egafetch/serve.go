@@ -0,0 +1,701 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/time/rate"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/auth"
+	"github.com/khan-lab/EGAfetch/internal/download"
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+// Job states reported by 'egafetch serve'.
+const (
+	serveQueued    = "queued"
+	serveRunning   = "running"
+	serveComplete  = "complete"
+	serveFailed    = "failed"
+	serveCancelled = "cancelled"
+	servePaused    = "paused" // 'egafetch rpc' only; resumable
+)
+
+// errServeShuttingDown is returned for jobs submitted while the server
+// stops.
+var errServeShuttingDown = errors.New("server is shutting down")
+
+// errNoSuchJob is returned for job IDs the server does not know.
+var errNoSuchJob = errors.New("no such job")
+
+// jobConflictError is returned for a job whose output directory another
+// unfinished job uses.
+type jobConflictError struct {
+	id, output string
+}
+
+func (e *jobConflictError) Error() string {
+	return fmt.Sprintf("job %s is already downloading into %s", e.id, e.output)
+}
+
+// jsonServeRequest is the body of POST /jobs.
+type jsonServeRequest struct {
+	IDs     []string `json:"ids"`
+	Output  string   `json:"output,omitempty"` // relative to --root; default: the first ID
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	Layout  string   `json:"layout,omitempty"` // "preserve-paths" or "flatten"; default: a directory per EGAF
+}
+
+// serveLayouts are the output layouts a job may ask for, by the name of the
+// download flag that chooses them.
+var serveLayouts = map[string]state.Layout{
+	"":               state.LayoutByFile,
+	"preserve-paths": state.LayoutPreserve,
+	"flatten":        state.LayoutFlat,
+}
+
+// jsonServeJob is a job as returned by the API. Download is set once the
+// job's files are resolved.
+type jsonServeJob struct {
+	ID          string            `json:"id"`
+	IDs         []string          `json:"ids"`
+	Output      string            `json:"output"`
+	Status      string            `json:"status"`
+	Error       string            `json:"error,omitempty"`
+	SubmittedAt time.Time         `json:"submitted_at"`
+	StartedAt   *time.Time        `json:"started_at,omitempty"`
+	FinishedAt  *time.Time        `json:"finished_at,omitempty"`
+	Download    *jsonLiveDownload `json:"download,omitempty"`
+}
+
+// serveJob is a download submitted to the server.
+type serveJob struct {
+	req         jsonServeRequest
+	id          string
+	dir         string // output directory under --root
+	status      string
+	err         string
+	submittedAt time.Time
+	startedAt   *time.Time
+	finishedAt  *time.Time
+	cancel      context.CancelFunc
+	paused      bool          // cancelled by pause, to be resumed
+	live        *statusServer // live file states once resolved
+	report      *runReport    // set when the job has finished
+}
+
+// downloadServer runs download jobs submitted over HTTP, or over stdin with
+// 'egafetch rpc', at most maxJobs at once, each in its own directory under
+// root.
+type downloadServer struct {
+	root        string
+	token       string
+	apiClient   *api.Client
+	opts        download.DownloadOptions
+	logPolicy   runLogPolicy
+	ctx         context.Context
+	slots       chan struct{}
+	running     sync.WaitGroup
+	progress    *progressStream    // file events of every job; nil = none
+	onJobChange func(jsonServeJob) // called with s.mu held when a job changes status; nil = none
+
+	mu    sync.Mutex
+	jobs  map[string]*serveJob
+	order []string // submission order
+}
+
+func newServeCmd() *cobra.Command {
+	var (
+		listen         string
+		root           string
+		token          string
+		maxJobs        int
+		configFile     string
+		parallelFiles  int
+		parallelChunks int
+		chunkSize      string
+		maxBandwidth   string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run a download service with a REST API",
+		Long: `Run a download service: jobs submitted over HTTP are downloaded into
+directories under --root with the logged-in EGA account, and their progress
+and reports can be queried while and after they run.
+
+  POST   /jobs             submit {"ids": ["EGAD..."], "output": "dir"}
+  GET    /jobs             list jobs
+  GET    /jobs/{id}        job status with per-file progress
+  GET    /jobs/{id}/report the job's report once it has finished
+  DELETE /jobs/{id}        cancel a job
+
+Requests must send "Authorization: Bearer TOKEN" when --token (or
+EGAFETCH_SERVE_TOKEN) is set, which is required to listen beyond loopback.`,
+		Example: `  # Serve on the local machine only
+  egafetch serve --root /project/ega
+
+  # Serve the network, with a bearer token
+  EGAFETCH_SERVE_TOKEN=$(openssl rand -hex 32) egafetch serve --listen :8420 --root /project/ega`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := applyConfig(cmd, map[string]string{
+				"root":            "output_dir",
+				"chunk-size":      "chunk_size",
+				"parallel-files":  "parallel_files",
+				"parallel-chunks": "parallel_chunks",
+				"max-bandwidth":   "max_bandwidth",
+			}); err != nil {
+				return err
+			}
+			if maxJobs < 1 {
+				return fmt.Errorf("--max-jobs must be at least 1")
+			}
+			chunkBytes, err := parseSize(chunkSize)
+			if err != nil {
+				return fmt.Errorf("invalid chunk-size: %w", err)
+			}
+			var limiter *rate.Limiter
+			if maxBandwidth != "" {
+				bwBytes, err := parseSize(maxBandwidth)
+				if err != nil {
+					return fmt.Errorf("invalid max-bandwidth: %w", err)
+				}
+				limiter = rate.NewLimiter(rate.Limit(bwBytes), 256*1024)
+			}
+			logPolicy, err := loadRunLogPolicy()
+			if err != nil {
+				return err
+			}
+			// Jobs run in the background; nothing may redraw the terminal.
+			noSpinners = true
+
+			mgr, err := auth.NewManager()
+			if err != nil {
+				return err
+			}
+			ctx, cancel := signalContext()
+			defer cancel()
+			if err := ensureAuth(ctx, mgr, configFile); err != nil {
+				return err
+			}
+
+			ln, err := net.Listen("tcp", listen)
+			if err != nil {
+				return fmt.Errorf("--listen: %w", err)
+			}
+			if token == "" && !loopbackAddr(ln.Addr()) {
+				ln.Close()
+				return fmt.Errorf("--listen %s is reachable from other machines; set --token or EGAFETCH_SERVE_TOKEN", listen)
+			}
+
+			s := &downloadServer{
+				root:      root,
+				token:     token,
+				apiClient: api.NewClient(mgr),
+				opts: download.DownloadOptions{
+					ParallelFiles:  parallelFiles,
+					ParallelChunks: parallelChunks,
+					ChunkSize:      chunkBytes,
+					SmallFileSize:  download.DefaultSmallFileSize,
+					Limiter:        limiter,
+				},
+				logPolicy: logPolicy,
+				ctx:       ctx,
+				slots:     make(chan struct{}, maxJobs),
+				jobs:      make(map[string]*serveJob),
+			}
+			srv := &http.Server{Handler: s.handler(), ReadHeaderTimeout: 10 * time.Second}
+			go func() {
+				<-ctx.Done()
+				shutdown, done := context.WithTimeout(context.Background(), statusShutdownTimeout)
+				defer done()
+				srv.Shutdown(shutdown)
+			}()
+
+			slog.Info("Serving download API", "url", "http://"+ln.Addr().String()+"/jobs", "root", root, "max_jobs", maxJobs)
+			err = srv.Serve(ln)
+			// Running jobs were cancelled with ctx; let them save their state.
+			s.running.Wait()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&listen, "listen", "127.0.0.1:8420", "Address to serve the API on")
+	cmd.Flags().StringVar(&root, "root", ".", "Directory that job output directories are created in")
+	cmd.Flags().StringVar(&token, "token", "", "Bearer token required from clients (prefer EGAFETCH_SERVE_TOKEN)")
+	cmd.Flags().IntVar(&maxJobs, "max-jobs", 1, "Jobs downloaded at the same time; others wait in a queue")
+	cmd.Flags().StringVar(&configFile, "cf", "", "JSON config file with credentials")
+	cmd.Flags().StringVar(&configFile, "config-file", "", "JSON config file with credentials (alias for --cf)")
+	cmd.Flags().IntVar(&parallelFiles, "parallel-files", 4, "Files downloaded simultaneously per job")
+	cmd.Flags().IntVar(&parallelChunks, "parallel-chunks", 8, "Chunks per file downloaded simultaneously")
+	cmd.Flags().StringVar(&chunkSize, "chunk-size", "64M", "Size of each download chunk")
+	cmd.Flags().StringVar(&maxBandwidth, "max-bandwidth", "", "Bandwidth limit shared by all jobs (e.g., 100M)")
+	return cmd
+}
+
+// loopbackAddr reports whether addr only accepts local connections.
+func loopbackAddr(addr net.Addr) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s *downloadServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", s.submit)
+	mux.HandleFunc("GET /jobs", s.list)
+	mux.HandleFunc("GET /jobs/{id}", s.get)
+	mux.HandleFunc("GET /jobs/{id}/report", s.getReport)
+	mux.HandleFunc("DELETE /jobs/{id}", s.cancelJob)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.token)) != 1 {
+			writeAPIError(w, http.StatusUnauthorized, "missing or wrong bearer token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// validate checks a submitted job and returns its output directory,
+// relative to the root.
+func (req *jsonServeRequest) validate() (string, error) {
+	if err := req.validateIDs(); err != nil {
+		return "", err
+	}
+	output := req.Output
+	if output == "" {
+		output = req.IDs[0]
+	}
+	if !filepath.IsLocal(output) {
+		return "", fmt.Errorf("output: %q must be a relative path inside the server's root", output)
+	}
+	return filepath.Clean(output), nil
+}
+
+// validateIDs checks the identifiers of a submitted job.
+func (req *jsonServeRequest) validateIDs() error {
+	if len(req.IDs) == 0 {
+		return fmt.Errorf("ids: at least one EGAD or EGAF identifier is required")
+	}
+	for _, id := range req.IDs {
+		if !strings.HasPrefix(id, "EGAD") && !strings.HasPrefix(id, "EGAF") {
+			return fmt.Errorf("ids: unrecognized identifier %q: expected EGAD... or EGAF...", id)
+		}
+	}
+	if _, ok := serveLayouts[req.Layout]; !ok {
+		return fmt.Errorf("layout: unrecognized layout %q: expected preserve-paths or flatten", req.Layout)
+	}
+	return nil
+}
+
+func (s *downloadServer) submit(w http.ResponseWriter, r *http.Request) {
+	var req jsonServeRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid job: "+err.Error())
+		return
+	}
+	output, err := req.validate()
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Output = output
+
+	view, err := s.addJob(req)
+	var conflict *jobConflictError
+	switch {
+	case errors.Is(err, errServeShuttingDown):
+		writeAPIError(w, http.StatusServiceUnavailable, err.Error())
+	case errors.As(err, &conflict):
+		writeAPIError(w, http.StatusConflict, err.Error())
+	default:
+		writeAPIJSON(w, http.StatusAccepted, view)
+	}
+}
+
+// addJob queues a validated job, downloading into req.Output under the
+// root.
+func (s *downloadServer) addJob(req jsonServeRequest) (jsonServeJob, error) {
+	s.mu.Lock()
+	if s.ctx.Err() != nil {
+		s.mu.Unlock()
+		return jsonServeJob{}, errServeShuttingDown
+	}
+	if err := s.conflictLocked(req.Output, ""); err != nil {
+		s.mu.Unlock()
+		return jsonServeJob{}, err
+	}
+	job := &serveJob{
+		req:         req,
+		id:          newJobID(),
+		dir:         filepath.Join(s.root, req.Output),
+		submittedAt: time.Now(),
+	}
+	s.jobs[job.id] = job
+	s.order = append(s.order, job.id)
+	s.startLocked(job)
+	view := job.view()
+	s.mu.Unlock()
+
+	slog.Info("Job submitted", "job", job.id, "ids", strings.Join(req.IDs, ","), "output_dir", job.dir)
+	return view, nil
+}
+
+// conflictLocked returns an error if a job other than id has not finished
+// with output. Callers hold s.mu.
+func (s *downloadServer) conflictLocked(output, id string) error {
+	for _, other := range s.jobs {
+		if other.id != id && other.req.Output == output &&
+			(other.status == serveQueued || other.status == serveRunning || other.status == servePaused) {
+			return &jobConflictError{id: other.id, output: output}
+		}
+	}
+	return nil
+}
+
+// startLocked queues job to run once a slot is free. Callers hold s.mu.
+func (s *downloadServer) startLocked(job *serveJob) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	job.cancel, job.paused = cancel, false
+	s.setStatus(job, serveQueued)
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		s.run(ctx, job)
+	}()
+}
+
+func (s *downloadServer) list(w http.ResponseWriter, r *http.Request) {
+	writeAPIJSON(w, http.StatusOK, s.listJobs())
+}
+
+// listJobs returns every job in submission order.
+func (s *downloadServer) listJobs() []jsonServeJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]jsonServeJob, 0, len(s.order))
+	for _, id := range s.order {
+		jobs = append(jobs, s.jobs[id].view())
+	}
+	return jobs
+}
+
+func (s *downloadServer) get(w http.ResponseWriter, r *http.Request) {
+	view, err := s.jobStatus(r.PathValue("id"))
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, view)
+}
+
+// jobStatus returns the job with its per-file progress.
+func (s *downloadServer) jobStatus(id string) (jsonServeJob, error) {
+	s.mu.Lock()
+	job, ok := s.jobs[id]
+	var view jsonServeJob
+	var live *statusServer
+	if ok {
+		view, live = job.view(), job.live
+	}
+	s.mu.Unlock()
+	if !ok {
+		return jsonServeJob{}, errNoSuchJob
+	}
+	// Reading file states can take a while; do it without the lock.
+	if live != nil {
+		if downloads := live.snapshot().Downloads; len(downloads) > 0 {
+			view.Download = &downloads[0]
+		}
+	}
+	return view, nil
+}
+
+func (s *downloadServer) getReport(w http.ResponseWriter, r *http.Request) {
+	report, err := s.jobReport(r.PathValue("id"))
+	switch {
+	case errors.Is(err, errNoSuchJob):
+		writeAPIError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeAPIError(w, http.StatusConflict, err.Error())
+	default:
+		writeAPIJSON(w, http.StatusOK, report)
+	}
+}
+
+// jobReport returns the report of a finished job.
+func (s *downloadServer) jobReport(id string) (*runReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	switch {
+	case !ok:
+		return nil, errNoSuchJob
+	case job.report == nil || job.finishedAt == nil: // a paused job keeps its last report
+		return nil, fmt.Errorf("job has not finished")
+	}
+	return job.report, nil
+}
+
+func (s *downloadServer) cancelJob(w http.ResponseWriter, r *http.Request) {
+	view, err := s.cancel(r.PathValue("id"))
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, view)
+}
+
+// cancel stops a job for good. A running job saves its state and reports
+// cancelled once it has stopped.
+func (s *downloadServer) cancel(id string) (jsonServeJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return jsonServeJob{}, errNoSuchJob
+	}
+	job.cancel()
+	job.paused = false
+	if job.status == serveQueued || job.status == servePaused {
+		s.finish(job, serveCancelled, nil)
+	}
+	slog.Info("Job cancelled", "job", job.id)
+	return job.view(), nil
+}
+
+// pause stops a queued or running job so that resume can continue it. A
+// running job saves its state and reports paused once it has stopped.
+func (s *downloadServer) pause(id string) (jsonServeJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	switch {
+	case !ok:
+		return jsonServeJob{}, errNoSuchJob
+	case job.status != serveQueued && job.status != serveRunning:
+		return jsonServeJob{}, fmt.Errorf("job %s is %s", id, job.status)
+	}
+	job.cancel()
+	job.paused = true
+	if job.status == serveQueued {
+		s.setStatus(job, servePaused)
+	}
+	slog.Info("Job paused", "job", job.id)
+	return job.view(), nil
+}
+
+// resume queues a paused job again; its download resumes from the state
+// saved in its directory.
+func (s *downloadServer) resume(id string) (jsonServeJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	switch {
+	case !ok:
+		return jsonServeJob{}, errNoSuchJob
+	case s.ctx.Err() != nil:
+		return jsonServeJob{}, errServeShuttingDown
+	case job.status == servePaused:
+	case job.paused:
+		return jsonServeJob{}, fmt.Errorf("job %s is still stopping; resume it once it is paused", id)
+	default:
+		return jsonServeJob{}, fmt.Errorf("job %s is %s, not paused", id, job.status)
+	}
+	if err := s.conflictLocked(job.req.Output, job.id); err != nil {
+		return jsonServeJob{}, err
+	}
+	s.startLocked(job)
+	slog.Info("Job resumed", "job", job.id)
+	return job.view(), nil
+}
+
+// run waits for a free slot, then downloads the job.
+func (s *downloadServer) run(ctx context.Context, job *serveJob) {
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-ctx.Done():
+		return
+	}
+
+	s.mu.Lock()
+	if job.status != serveQueued {
+		s.mu.Unlock()
+		return
+	}
+	if job.startedAt == nil {
+		now := time.Now()
+		job.startedAt = &now
+	}
+	s.setStatus(job, serveRunning)
+	s.mu.Unlock()
+
+	report, err := s.download(ctx, job)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job.report = report
+	switch {
+	case ctx.Err() != nil && job.paused:
+		s.setStatus(job, servePaused)
+	case ctx.Err() != nil:
+		s.finish(job, serveCancelled, err)
+	case err != nil:
+		s.finish(job, serveFailed, err)
+		slog.Warn("Job failed", "job", job.id, "error", err)
+	default:
+		s.finish(job, serveComplete, nil)
+		slog.Info("Job complete", "job", job.id, "output_dir", job.dir)
+	}
+}
+
+// download runs the job's download like 'egafetch download IDS -o DIR',
+// and returns its report once the files were resolved.
+func (s *downloadServer) download(ctx context.Context, job *serveJob) (_ *runReport, retErr error) {
+	sm := state.NewStateManager(job.dir)
+	record, err := startJob(sm)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { finishJob(sm, record, retErr) }()
+	ctx, closeLog, err := openJobLog(ctx, sm, s.logPolicy)
+	if err != nil {
+		slog.Warn("Could not write run log", "job", job.id, "error", err)
+	} else {
+		defer func() { closeLog(retErr) }()
+	}
+
+	naming := outputNaming{layout: serveLayouts[job.req.Layout], generated: download.GeneratedNames(s.opts, nil)}
+	manifest, err := resolveManifest(ctx, s.apiClient, job.req.IDs, naming)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyFilters(ctx, manifest, job.req.IDs, job.req.Include, job.req.Exclude, nil, false); err != nil {
+		return nil, err
+	}
+	logManifest(ctx, manifest)
+
+	live := &statusServer{started: *job.startedAt, live: make(map[[2]string]int64)}
+	live.addManifest(manifest, sm)
+	s.progress.addManifest(manifest, sm)
+	s.mu.Lock()
+	job.live = live
+	s.mu.Unlock()
+
+	recorder := newSessionRecorder(manifest, sm)
+	orch := download.NewOrchestrator(s.apiClient, sm, s.opts)
+	orch.SetProgressCallback(func(fileID string, bytesDownloaded, totalBytes int64) {
+		live.bytes(sm.BaseDir(), fileID, bytesDownloaded)
+		s.progress.bytes(sm.BaseDir(), fileID, bytesDownloaded)
+	})
+	orch.SetFileCallbacks(
+		func(fileID, fileName string) {
+			slog.DebugContext(ctx, "Downloading file", "job", job.id, "file", fileName, "file_id", fileID)
+			s.progress.fileStarted(sm.BaseDir(), fileID)
+		},
+		func(fileID, fileName string, err error) {
+			if err != nil {
+				slog.WarnContext(ctx, "File failed", "job", job.id, "file", fileName, "file_id", fileID, "error", err)
+				s.progress.fileFailed(sm.BaseDir(), fileID, err)
+			} else {
+				s.progress.fileCompleted(sm.BaseDir(), fileID, false)
+			}
+		},
+		func(fileID, fileName string) {
+			recorder.fileSkipped(fileID)
+			s.progress.fileCompleted(sm.BaseDir(), fileID, true)
+		},
+	)
+	err = orch.Download(ctx, manifest)
+	if s.progress != nil {
+		summary := newJSONDownloadSummary(manifest, sm)
+		if err != nil {
+			summary.Error = err.Error()
+		}
+		s.progress.runSummary(summary, err)
+	}
+
+	report := recorder.build(manifest, sm, err)
+	if _, reportErr := writeReports(sm, report, []string{"md"}); reportErr != nil {
+		slog.WarnContext(ctx, "Could not write download report", "job", job.id, "error", reportErr)
+	}
+	return report, err
+}
+
+// finish records how job ended. Callers hold s.mu.
+func (s *downloadServer) finish(job *serveJob, status string, err error) {
+	now := time.Now()
+	job.finishedAt = &now
+	if err != nil {
+		job.err = err.Error()
+	}
+	s.setStatus(job, status)
+}
+
+// setStatus changes the status of job. Callers hold s.mu.
+func (s *downloadServer) setStatus(job *serveJob, status string) {
+	job.status = status
+	if s.onJobChange != nil {
+		s.onJobChange(job.view())
+	}
+}
+
+// view returns the job for the API, without its files. Callers hold s.mu.
+func (job *serveJob) view() jsonServeJob {
+	return jsonServeJob{
+		ID:          job.id,
+		IDs:         job.req.IDs,
+		Output:      job.req.Output,
+		Status:      job.status,
+		Error:       job.err,
+		SubmittedAt: job.submittedAt,
+		StartedAt:   job.startedAt,
+		FinishedAt:  job.finishedAt,
+	}
+}
+
+// newJobID returns a random job ID.
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeAPIJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		slog.Debug("API response failed", "error", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, code int, msg string) {
+	writeAPIJSON(w, code, map[string]string{"error": msg})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeRequestValidate(t *testing.T) {
+	tests := []struct {
+		req     jsonServeRequest
+		want    string
+		wantErr string
+	}{
+		{req: jsonServeRequest{IDs: []string{"EGAD00001000001"}}, want: "EGAD00001000001"},
+		{req: jsonServeRequest{IDs: []string{"EGAF00000000001"}, Output: "project/a/"}, want: "project/a"},
+		{req: jsonServeRequest{}, wantErr: "at least one"},
+		{req: jsonServeRequest{IDs: []string{"PRJEB1"}}, wantErr: "unrecognized identifier"},
+		{req: jsonServeRequest{IDs: []string{"EGAD00001000001"}, Layout: "flatten"}, want: "EGAD00001000001"},
+		{req: jsonServeRequest{IDs: []string{"EGAD00001000001"}, Layout: "flat"}, wantErr: "unrecognized layout"},
+		{req: jsonServeRequest{IDs: []string{"EGAD00001000001"}, Output: "../elsewhere"}, wantErr: "inside the server's root"},
+		{req: jsonServeRequest{IDs: []string{"EGAD00001000001"}, Output: "/data"}, wantErr: "inside the server's root"},
+	}
+	for _, tt := range tests {
+		got, err := tt.req.validate()
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validate(%+v) error = %v, want %q", tt.req, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("validate(%+v) = %q, %v; want %q", tt.req, got, err, tt.want)
+		}
+	}
+}
+
+func TestDownloadServerAPI(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &downloadServer{
+		root:  t.TempDir(),
+		token: "secret",
+		ctx:   ctx,
+		slots: make(chan struct{}, 1),
+		jobs:  make(map[string]*serveJob),
+	}
+	// Keep the only slot busy, so submitted jobs stay queued.
+	s.slots <- struct{}{}
+	h := s.handler()
+
+	do := func(method, path, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("GET", "/jobs", "", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status %d, want 401", rec.Code)
+	}
+	if rec := do("POST", "/jobs", `{"ids": ["EGAD00001000001"], "outptu": "x"}`, "secret"); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown field: status %d, want 400", rec.Code)
+	}
+
+	rec := do("POST", "/jobs", `{"ids": ["EGAD00001000001"]}`, "secret")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("submit: status %d: %s", rec.Code, rec.Body)
+	}
+	var job jsonServeJob
+	if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != serveQueued || job.Output != "EGAD00001000001" || job.ID == "" {
+		t.Errorf("submitted job = %+v", job)
+	}
+	if rec := do("POST", "/jobs", `{"ids": ["EGAF00000000001"], "output": "EGAD00001000001"}`, "secret"); rec.Code != http.StatusConflict {
+		t.Errorf("second job in the same directory: status %d, want 409", rec.Code)
+	}
+	if rec := do("GET", "/jobs/"+job.ID+"/report", "", "secret"); rec.Code != http.StatusConflict {
+		t.Errorf("report of a queued job: status %d, want 409", rec.Code)
+	}
+
+	rec = do("DELETE", "/jobs/"+job.ID, "", "secret")
+	if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != serveCancelled || job.FinishedAt == nil {
+		t.Errorf("cancelled job = %+v", job)
+	}
+	s.running.Wait()
+
+	var jobs []jsonServeJob
+	if err := json.Unmarshal(do("GET", "/jobs", "", "secret").Body.Bytes(), &jobs); err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Status != serveCancelled {
+		t.Errorf("jobs = %+v, want the one cancelled job", jobs)
+	}
+	if rec := do("GET", "/jobs/unknown", "", "secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown job: status %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+// runNameSuffix is appended to the names of run logs and reports, which
+// otherwise only hold the run's start time. --shared sets it, since runs on
+// several hosts share the directory and often start in the same second.
+var runNameSuffix string
+
+// sharedRunNameSuffix returns the runNameSuffix of a --shared download.
+func sharedRunNameSuffix() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("-%s-%d", state.SanitizeFileName(host), os.Getpid())
+}
+
+// runName returns the base name of the run log or report of a run started
+// at t.
+func runName(t time.Time) string {
+	return "download-" + t.UTC().Format("20060102T150405Z") + runNameSuffix
+}
+
+// checkNotShared refuses a download without --shared into sm's directory
+// while --shared downloads are running there.
+func checkNotShared(sm *state.StateManager) error {
+	holders, err := claimHolders(sm, time.Now())
+	if err != nil {
+		return err
+	}
+	if len(holders) > 0 {
+		return fmt.Errorf("%d shared download(s) are running in %s (on %s, pid %d); add --shared to join them",
+			len(holders), sm.BaseDir(), holders[0].Host, holders[0].PID)
+	}
+	return nil
+}
+
+// checkSharedJob refuses a --shared download into sm's directory while a
+// download without --shared is running there on this host.
+func checkSharedJob(sm *state.StateManager) error {
+	job, err := sm.LoadJob()
+	if err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	if job != nil && job.Status == state.JobRunning && job.Host == host && processAlive(job.PID) {
+		return fmt.Errorf("a download without --shared (pid %d) is running in %s", job.PID, sm.BaseDir())
+	}
+	return nil
+}
+
+// holdClaim claims key in sm's directory for this process and renews the
+// claim until release is called. If another process holds key, holder is
+// that process's claim and release is nil.
+func holdClaim(sm *state.StateManager, key string) (release func(), holder *state.Claim, err error) {
+	claim, holder, err := sm.ClaimFile(key, state.NewClaimOwner())
+	if err != nil || claim == nil {
+		return nil, holder, err
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(state.ClaimTTL / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := sm.RenewClaim(claim); err != nil {
+					slog.Debug("Could not renew claim", "claim", key, "error", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		if err := sm.ReleaseClaim(claim); err != nil {
+			slog.Debug("Could not release claim", "claim", key, "error", err)
+		}
+	}, nil, nil
+}
+
+// claimHolder is a process holding claims in a shared output directory.
+type claimHolder struct {
+	Host  string   `json:"host"`
+	PID   int      `json:"pid"`
+	Files []string `json:"files"`
+}
+
+// claimHolders returns the processes holding live claims in sm's directory,
+// by host and PID, with the files they are downloading.
+func claimHolders(sm *state.StateManager, now time.Time) ([]claimHolder, error) {
+	claims, err := sm.ListClaims()
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]int)
+	var holders []claimHolder
+	for _, c := range claims {
+		if c.Expired(now) {
+			continue
+		}
+		i, ok := index[c.Owner]
+		if !ok {
+			i = len(holders)
+			index[c.Owner] = i
+			holders = append(holders, claimHolder{Host: c.Host, PID: c.PID, Files: []string{}})
+		}
+		if strings.HasPrefix(c.FileID, "EGAF") {
+			holders[i].Files = append(holders[i].Files, c.FileID)
+		}
+	}
+	sort.Slice(holders, func(i, j int) bool {
+		if holders[i].Host != holders[j].Host {
+			return holders[i].Host < holders[j].Host
+		}
+		return holders[i].PID < holders[j].PID
+	})
+	return holders, nil
+}
+
+// printClaimHolders lists the processes of a shared download to w.
+func printClaimHolders(w io.Writer, holders []claimHolder) {
+	if len(holders) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\nShared download: %d process(es) active\n", len(holders))
+	for _, h := range holders {
+		fmt.Fprintf(w, "  %s (pid %d): %d file(s)\n", h.Host, h.PID, len(h.Files))
+	}
+}
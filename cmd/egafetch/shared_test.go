@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+func TestClaimHolders(t *testing.T) {
+	sm := state.NewStateManager(t.TempDir())
+	if err := checkNotShared(sm); err != nil {
+		t.Fatalf("checkNotShared without claims = %v", err)
+	}
+
+	for _, key := range []string{"EGAF00000000001", "EGAF00000000002", "EGAD00000000001-metadata"} {
+		if _, _, err := sm.ClaimFile(key, "node-a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	holders, err := claimHolders(sm, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(holders) != 1 || len(holders[0].Files) != 2 {
+		t.Fatalf("claimHolders = %+v, want one process with 2 files", holders)
+	}
+	if err := checkNotShared(sm); err == nil || !strings.Contains(err.Error(), "--shared") {
+		t.Errorf("checkNotShared with live claims = %v, want a hint to add --shared", err)
+	}
+
+	// Expired claims are of processes that are gone.
+	if holders, _ := claimHolders(sm, time.Now().Add(2*state.ClaimTTL)); len(holders) != 0 {
+		t.Errorf("claimHolders after the claims expire = %+v, want none", holders)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/auth"
+	"github.com/khan-lab/EGAfetch/internal/state"
+	"github.com/khan-lab/EGAfetch/internal/ui"
+)
+
+// --- Size command ---
+
+// downloadEstimate tallies how much of a manifest is already present locally.
+type downloadEstimate struct {
+	Files         int   `json:"files"`
+	TotalBytes    int64 `json:"total_bytes"`
+	CompleteFiles int   `json:"complete_files"`
+	CompleteBytes int64 `json:"complete_bytes"`
+	PartialFiles  int   `json:"partial_files"`
+	PartialBytes  int64 `json:"partial_bytes"`
+	Remaining     int64 `json:"remaining_bytes"`
+}
+
+// estimateDownload compares the manifest against the download state in sm.
+// Bytes already fetched for partial files count toward what is done.
+func estimateDownload(manifest *state.Manifest, sm *state.StateManager) downloadEstimate {
+	var est downloadEstimate
+	for _, f := range manifest.Files {
+		est.Files++
+		est.TotalBytes += f.Size
+
+		fs, err := sm.LoadFileState(f.FileID)
+		if err != nil || fs == nil {
+			continue
+		}
+		if fs.Status == state.StatusComplete {
+			est.CompleteFiles++
+			est.CompleteBytes += f.Size
+			continue
+		}
+		var partial int64
+		for _, c := range fs.Chunks {
+			partial += c.BytesDownloaded
+		}
+		if partial > f.Size {
+			partial = f.Size
+		}
+		if partial > 0 {
+			est.PartialFiles++
+			est.PartialBytes += partial
+		}
+	}
+	est.Remaining = est.TotalBytes - est.CompleteBytes - est.PartialBytes
+	return est
+}
+
+// jsonSize is the JSON output of 'size'.
+type jsonSize struct {
+	OutputDir string `json:"output_dir"`
+	DatasetID string `json:"dataset_id,omitempty"`
+	downloadEstimate
+}
+
+func newSizeCmd() *cobra.Command {
+	var output string
+	var configFile string
+	var attributes attributeFilter
+	var includePatterns []string
+	var excludePatterns []string
+	var formats []string
+	var withIndexes bool
+	var fromFiles []string
+	var excludeIDs []string
+	var excludeFiles []string
+
+	cmd := &cobra.Command{
+		Use:               "size [EGAD.../EGAF.../file.txt/-]",
+		ValidArgsFunction: completeAccessions(false, true),
+		Short:             "Estimate download size before fetching",
+		Long: `Resolve datasets and files exactly as 'download' would, including
+--format, --include/--exclude filters and identifier files, and report the number of
+files, the total size, and how much is left to download.
+
+Files already complete in --output are subtracted from the remaining size,
+as are bytes fetched so far for partially downloaded files.`,
+		Example: `  egafetch size EGAD00001001938
+  egafetch size EGAD00001001938 --include "*.bam" -o ./data
+  egafetch size identifiers.txt --cf credentials.json`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(fromFiles) > 0 {
+				return nil
+			}
+			return cobra.MinimumNArgs(1)(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			args, err := expandArgs(append(args, fromFiles...))
+			if err != nil {
+				return err
+			}
+			if len(args) == 0 {
+				return fmt.Errorf("no identifiers given")
+			}
+			excluded, err := readExcludedIDs(excludeIDs, excludeFiles)
+			if err != nil {
+				return err
+			}
+			if err := applyConfig(cmd, map[string]string{"output": "output_dir"}); err != nil {
+				return err
+			}
+
+			mgr, err := auth.NewManager()
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := signalContext()
+			defer cancel()
+
+			if err := ensureAuth(ctx, mgr, configFile); err != nil {
+				return err
+			}
+
+			apiClient := api.NewClient(mgr)
+
+			groups, err := groupByOutputDir(args, output)
+			if err != nil {
+				return err
+			}
+
+			var results []interface{}
+			matchedExclusions := make(map[string]bool)
+			for _, g := range groups {
+				manifest, err := resolveManifest(ctx, apiClient, g.args, outputNaming{})
+				if err != nil {
+					return err
+				}
+				if err := excludeFileIDs(manifest, excluded, matchedExclusions); err != nil {
+					return err
+				}
+				metas, err := attributes.load(ctx, apiClient, g.dir, g.args, false, configFileMetadataToken(ctx, mgr, configFile))
+				if err != nil {
+					return err
+				}
+				if err := attributes.apply(manifest, g.args, metas); err != nil {
+					return err
+				}
+				if err := applyFilters(ctx, manifest, g.args, includePatterns, excludePatterns, formats, withIndexes); err != nil {
+					return err
+				}
+
+				sm := state.NewStateManager(g.dir)
+				est := estimateDownload(manifest, sm)
+				if jsonOutput {
+					results = append(results, jsonSize{OutputDir: sm.BaseDir(), DatasetID: manifest.DatasetID, downloadEstimate: est})
+					continue
+				}
+				if len(groups) > 1 {
+					fmt.Fprintf(cmd.OutOrStdout(), "\n%s:\n", g.dir)
+				}
+				printEstimate(cmd.OutOrStdout(), est)
+			}
+			warnUnmatchedExclusions(excluded, matchedExclusions)
+			if jsonOutput {
+				return printDownloadResults(cmd, results, len(groups))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", ".", "Download directory to check for completed files")
+	cmd.Flags().StringVar(&configFile, "cf", "", "JSON config file with credentials")
+	cmd.Flags().StringVar(&configFile, "config-file", "", "JSON config file with credentials (alias for --cf)")
+	cmd.Flags().StringSliceVar(&includePatterns, "include", nil, "Glob patterns to include (matched against file name)")
+	cmd.Flags().StringSliceVar(&excludePatterns, "exclude", nil, "Glob patterns to exclude (matched against file name)")
+	cmd.Flags().StringSliceVar(&formats, "format", nil, "File formats to include, e.g. BAM,CRAM,VCF (matched against the file extension)")
+	cmd.Flags().BoolVar(&withIndexes, "with-indexes", false, "Also include index files (.bai, .crai, .tbi, .csi) of the selected files")
+	addAttributeFilterFlags(cmd, &attributes)
+	cmd.Flags().StringSliceVar(&excludeIDs, "exclude-id", nil, "File IDs (EGAF...) to skip (repeatable)")
+	cmd.Flags().StringArrayVar(&excludeFiles, "exclude-file", nil, "Text file of file IDs to skip, one per line (repeatable)")
+	cmd.Flags().StringArrayVar(&fromFiles, "from-file", nil, "Read identifiers from a text file, one per line (\"-\" for stdin; repeatable)")
+
+	return cmd
+}
+
+// printEstimate prints a download estimate to w.
+func printEstimate(w io.Writer, est downloadEstimate) {
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "  Files:      %d\n", est.Files)
+	fmt.Fprintf(w, "  Total:      %s (%d bytes)\n", ui.FormatBytes(est.TotalBytes), est.TotalBytes)
+	if est.CompleteFiles > 0 {
+		fmt.Fprintf(w, "  Complete:   %d file(s), %s\n", est.CompleteFiles, ui.FormatBytes(est.CompleteBytes))
+	}
+	if est.PartialFiles > 0 {
+		fmt.Fprintf(w, "  Partial:    %d file(s), %s downloaded\n", est.PartialFiles, ui.FormatBytes(est.PartialBytes))
+	}
+	fmt.Fprintf(w, "  Remaining:  %s (%d bytes)\n", ui.FormatBytes(est.Remaining), est.Remaining)
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/auth"
+	"github.com/khan-lab/EGAfetch/internal/state"
+	"github.com/khan-lab/EGAfetch/internal/ui"
+)
+
+// speedSetting is one parallel-files x parallel-chunks combination to measure.
+type speedSetting struct {
+	files  int
+	chunks int
+}
+
+func (s speedSetting) String() string {
+	return fmt.Sprintf("%dx%d", s.files, s.chunks)
+}
+
+// speedResult is the throughput measured for one setting.
+type speedResult struct {
+	setting speedSetting
+	bytes   int64
+	elapsed time.Duration
+	errors  int
+}
+
+func (r speedResult) rate() float64 {
+	if r.elapsed <= 0 {
+		return 0
+	}
+	return float64(r.bytes) / r.elapsed.Seconds()
+}
+
+// defaultSpeedSettings are the settings speedtest and benchmark measure by
+// default.
+var defaultSpeedSettings = []string{"1x1", "1x4", "1x8", "2x8", "4x8", "8x8"}
+
+// speedTolerance is the share of the best throughput at which a setting with
+// fewer connections is recommended instead.
+const speedTolerance = 0.9
+
+// --- Speedtest command ---
+
+func newSpeedtestCmd() *cobra.Command {
+	var (
+		settings  []string
+		duration  time.Duration
+		rangeSize string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "speedtest",
+		Short: "Measure throughput from EGA at different parallelism settings",
+		Long: `Download byte ranges of files in EGA's public test dataset (` + testDatasetID + `)
+with several --parallel-files x --parallel-chunks settings, and report the
+throughput reached with each. Nothing is written to disk.
+
+Each setting runs for --duration. With F files and C chunks, F*C ranges are
+in flight at once, spread over up to F different test files, as in a real
+download. The recommended setting is the one with the fewest connections
+that reaches ` + strconv.Itoa(int(speedTolerance*100)) + `% of the best throughput; more connections than that only
+add load on shared links.
+
+Like quickstart, this logs in with the public test account in memory only.`,
+		Example: `  egafetch speedtest
+  egafetch speedtest --settings 1x8,4x8,8x16 --duration 30s`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			parsed, err := parseSpeedSettings(settings)
+			if err != nil {
+				return err
+			}
+			size, err := parseSize(rangeSize)
+			if err != nil {
+				return fmt.Errorf("invalid range-size: %w", err)
+			}
+			if duration <= 0 {
+				return fmt.Errorf("--duration must be positive")
+			}
+
+			ctx, cancel := signalContext()
+			defer cancel()
+
+			results, err := measureSettings(ctx, parsed, size, duration)
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			printSpeedResults(out, results)
+
+			best, ok := recommendSetting(results)
+			if !ok {
+				return fmt.Errorf("no data could be downloaded from EGA; run 'egafetch doctor' to find out why")
+			}
+			fmt.Fprintln(out)
+			fmt.Fprintf(out, "Recommended: --parallel-files %d --parallel-chunks %d (%s/s)\n",
+				best.setting.files, best.setting.chunks, ui.FormatBytes(int64(best.rate())))
+			fmt.Fprintf(out, "Save it with: egafetch config set parallel_files %d && egafetch config set parallel_chunks %d\n",
+				best.setting.files, best.setting.chunks)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&settings, "settings", defaultSpeedSettings, "Settings to measure, as FILESxCHUNKS")
+	cmd.Flags().DurationVar(&duration, "duration", 10*time.Second, "How long to measure each setting")
+	cmd.Flags().StringVar(&rangeSize, "range-size", "16M", "Size of each requested byte range")
+
+	return cmd
+}
+
+// measureSettings logs in with the public test account and measures the
+// throughput of each setting in turn, downloading rangeSize-byte ranges of
+// the test dataset's files for duration each.
+func measureSettings(ctx context.Context, settings []speedSetting, rangeSize int64, duration time.Duration) ([]speedResult, error) {
+	mgr := auth.NewEphemeralManager()
+	if err := mgr.Login(ctx, testAccountUsername, testAccountPassword); err != nil {
+		return nil, fmt.Errorf("log in with the test account: %w", err)
+	}
+	apiClient := api.NewClient(mgr)
+	files, err := apiClient.ListDatasetFiles(ctx, testDatasetID)
+	if err != nil {
+		return nil, fmt.Errorf("list test dataset files: %w", err)
+	}
+	var specs []state.FileSpec
+	for i := range files {
+		if spec := datasetFileSpec(&files[i], state.LayoutByFile); spec.Size > 0 {
+			specs = append(specs, spec)
+		}
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("test dataset %s has no files", testDatasetID)
+	}
+	// Larger files first, so ranges rarely repeat.
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Size > specs[j].Size })
+
+	slog.Info("Measuring throughput...", "settings", len(settings), "duration", duration)
+	var results []speedResult
+	for _, s := range settings {
+		r := measureThroughput(ctx, apiClient, specs, s, rangeSize, duration)
+		if ctx.Err() != nil {
+			return nil, withExitCode(exitInterrupted, ctx.Err())
+		}
+		results = append(results, r)
+		slog.Info("Measured setting", "setting", s.String(), "throughput", ui.FormatBytes(int64(r.rate()))+"/s")
+	}
+	return results, nil
+}
+
+// printSpeedResults prints the table of measured settings.
+func printSpeedResults(out io.Writer, results []speedResult) {
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "%-8s %-8s %-8s %-12s %s\n", "Files", "Chunks", "Streams", "Throughput", "Errors")
+	fmt.Fprintln(out, strings.Repeat("-", 48))
+	for _, r := range results {
+		fmt.Fprintf(out, "%-8d %-8d %-8d %-12s %d\n", r.setting.files, r.setting.chunks,
+			r.setting.files*r.setting.chunks, ui.FormatBytes(int64(r.rate()))+"/s", r.errors)
+	}
+}
+
+// parseSpeedSettings parses FILESxCHUNKS values such as "4x8".
+func parseSpeedSettings(values []string) ([]speedSetting, error) {
+	var settings []speedSetting
+	for _, v := range values {
+		f, c, found := strings.Cut(strings.ToLower(strings.TrimSpace(v)), "x")
+		files, errF := strconv.Atoi(f)
+		chunks, errC := strconv.Atoi(c)
+		if !found || errF != nil || errC != nil || files < 1 || chunks < 1 {
+			return nil, fmt.Errorf("invalid setting %q: expected FILESxCHUNKS, e.g. 4x8", v)
+		}
+		settings = append(settings, speedSetting{files: files, chunks: chunks})
+	}
+	if len(settings) == 0 {
+		return nil, fmt.Errorf("no settings to measure")
+	}
+	return settings, nil
+}
+
+// recommendSetting returns the result with the fewest streams whose
+// throughput is within speedTolerance of the best one. It reports false if
+// nothing was downloaded.
+func recommendSetting(results []speedResult) (speedResult, bool) {
+	var best float64
+	for _, r := range results {
+		best = max(best, r.rate())
+	}
+	if best == 0 {
+		return speedResult{}, false
+	}
+	var pick speedResult
+	found := false
+	for _, r := range results {
+		if r.rate() < best*speedTolerance {
+			continue
+		}
+		streams := r.setting.files * r.setting.chunks
+		if !found || streams < pick.setting.files*pick.setting.chunks {
+			pick, found = r, true
+		}
+	}
+	return pick, found
+}
+
+// measureThroughput runs files*chunks parallel range requests against specs
+// for the given duration and counts the bytes received.
+func measureThroughput(ctx context.Context, apiClient *api.Client, specs []state.FileSpec, s speedSetting, rangeSize int64, duration time.Duration) speedResult {
+	runCtx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	var (
+		received atomic.Int64
+		failures atomic.Int64
+		wg       sync.WaitGroup
+	)
+	start := time.Now()
+	for i := 0; i < s.files*s.chunks; i++ {
+		spec := specs[(i/s.chunks)%len(specs)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for runCtx.Err() == nil {
+				if err := fetchRange(runCtx, apiClient, spec, rangeSize, &received); err != nil && runCtx.Err() == nil {
+					failures.Add(1)
+					// Back off so a failing server is not hammered.
+					select {
+					case <-runCtx.Done():
+					case <-time.After(time.Second):
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return speedResult{
+		setting: s,
+		bytes:   received.Load(),
+		elapsed: time.Since(start),
+		errors:  int(failures.Load()),
+	}
+}
+
+// fetchRange downloads a random rangeSize-byte range of spec and discards it,
+// adding the bytes received to counter as they arrive.
+func fetchRange(ctx context.Context, apiClient *api.Client, spec state.FileSpec, rangeSize int64, counter *atomic.Int64) error {
+	var offset int64
+	length := min(rangeSize, spec.Size)
+	if spec.Size > length {
+		offset = rand.Int64N(spec.Size - length + 1)
+	}
+	req, err := apiClient.NewAuthenticatedRequest(ctx, "GET", apiClient.FileDownloadURL(spec.FileID))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, err := apiClient.DoStreamRequest(req, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		counter.Add(int64(n))
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
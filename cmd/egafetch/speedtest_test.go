@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSpeedSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    []speedSetting
+		wantErr string
+	}{
+		{name: "valid", values: []string{"1x8", " 4X16 "},
+			want: []speedSetting{{files: 1, chunks: 8}, {files: 4, chunks: 16}}},
+		{name: "missing separator", values: []string{"8"}, wantErr: `invalid setting "8"`},
+		{name: "zero", values: []string{"0x8"}, wantErr: `invalid setting "0x8"`},
+		{name: "not a number", values: []string{"ax8"}, wantErr: `invalid setting "ax8"`},
+		{name: "empty", values: nil, wantErr: "no settings"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSpeedSettings(tt.values)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecommendSetting(t *testing.T) {
+	result := func(files, chunks int, mb int64) speedResult {
+		return speedResult{setting: speedSetting{files: files, chunks: chunks}, bytes: mb << 20, elapsed: time.Second}
+	}
+
+	tests := []struct {
+		name    string
+		results []speedResult
+		want    speedSetting
+		wantOK  bool
+	}{
+		{name: "fewest streams near best", results: []speedResult{result(1, 1, 20), result(1, 8, 95), result(4, 8, 100), result(8, 8, 98)},
+			want: speedSetting{files: 1, chunks: 8}, wantOK: true},
+		{name: "clear winner", results: []speedResult{result(1, 8, 50), result(4, 8, 100), result(8, 8, 60)},
+			want: speedSetting{files: 4, chunks: 8}, wantOK: true},
+		{name: "nothing downloaded", results: []speedResult{result(1, 8, 0)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := recommendSetting(tt.results)
+			if ok != tt.wantOK || got.setting != tt.want {
+				t.Errorf("got %v, %v; want %v, %v", got.setting, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+	"github.com/khan-lab/EGAfetch/internal/ui"
+)
+
+// statusShutdownTimeout bounds how long a finished download waits for
+// status requests in flight.
+const statusShutdownTimeout = 2 * time.Second
+
+// jsonLiveStatus is the JSON served by --status-listen at /status.json.
+type jsonLiveStatus struct {
+	Version   string             `json:"version"`
+	StartedAt time.Time          `json:"started_at"`
+	Downloads []jsonLiveDownload `json:"downloads"`
+}
+
+// jsonLiveDownload is the state of one output directory, with the chunks
+// of each file.
+type jsonLiveDownload struct {
+	OutputDir string         `json:"output_dir"`
+	DatasetID string         `json:"dataset_id,omitempty"`
+	Complete  int            `json:"complete"`
+	Failed    int            `json:"failed"`
+	TotalSize int64          `json:"total_size"`
+	Files     []jsonLiveFile `json:"files"`
+}
+
+// jsonLiveFile is a file state with its chunks.
+type jsonLiveFile struct {
+	jsonFileState
+	Chunks []state.ChunkState `json:"chunks"`
+}
+
+// statusServer serves the live state of the running download over HTTP.
+// A nil *statusServer serves nothing.
+type statusServer struct {
+	srv     *http.Server
+	started time.Time
+
+	mu        sync.Mutex
+	downloads []statusDownload
+	live      map[[2]string]int64 // bytes so far, by output directory and file ID
+}
+
+type statusDownload struct {
+	manifest *state.Manifest
+	sm       *state.StateManager
+}
+
+// startStatusServer listens on addr and serves the status page at "/" and
+// its JSON at "/status.json" until Close.
+func startStatusServer(addr string) (*statusServer, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("--status-listen: %w", err)
+	}
+	if !loopbackAddr(ln.Addr()) {
+		slog.Warn("Status page is reachable from other machines; it shows file names without authentication", "address", ln.Addr().String())
+	}
+
+	s := &statusServer{started: time.Now(), live: make(map[[2]string]int64)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.servePage)
+	mux.HandleFunc("GET /status.json", s.serveJSON)
+	s.srv = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Warn("Status page stopped", "error", err)
+		}
+	}()
+	slog.Info("Serving download status", "url", "http://"+ln.Addr().String()+"/")
+	return s, nil
+}
+
+// addManifest starts showing the files of manifest, downloaded into sm.
+func (s *statusServer) addManifest(manifest *state.Manifest, sm *state.StateManager) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.downloads = append(s.downloads, statusDownload{manifest, sm})
+}
+
+// bytes records the bytes downloaded so far of a file, which its saved
+// state only catches up with as chunks finish.
+func (s *statusServer) bytes(dir, fileID string, n int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.live[[2]string{dir, fileID}] = n
+}
+
+// Close stops serving, waiting briefly for requests in flight.
+func (s *statusServer) Close() error {
+	if s == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), statusShutdownTimeout)
+	defer cancel()
+	return s.srv.Shutdown(ctx)
+}
+
+// snapshot reads the saved state of every file, with live byte counts for
+// files being downloaded.
+func (s *statusServer) snapshot() jsonLiveStatus {
+	s.mu.Lock()
+	downloads := append([]statusDownload(nil), s.downloads...)
+	live := make(map[[2]string]int64, len(s.live))
+	for k, v := range s.live {
+		live[k] = v
+	}
+	s.mu.Unlock()
+
+	out := jsonLiveStatus{Version: version, StartedAt: s.started, Downloads: []jsonLiveDownload{}}
+	for _, d := range downloads {
+		dl := jsonLiveDownload{OutputDir: d.sm.BaseDir(), DatasetID: d.manifest.DatasetID, Files: []jsonLiveFile{}}
+		for _, f := range d.manifest.Files {
+			fs, err := d.sm.LoadFileState(f.FileID)
+			if err != nil || fs == nil {
+				fs = state.NewFileState(f, 0)
+			}
+			entry := jsonLiveFile{jsonFileState: newJSONFileState(fs, ui.SampleAnnotation{}), Chunks: fs.Chunks}
+			if entry.Chunks == nil {
+				entry.Chunks = []state.ChunkState{}
+			}
+			if n, ok := live[[2]string{dl.OutputDir, f.FileID}]; ok && fs.Status == state.StatusDownloading && n > entry.BytesDownloaded {
+				entry.BytesDownloaded = n
+			}
+			switch fs.Status {
+			case state.StatusComplete:
+				dl.Complete++
+			case state.StatusFailed:
+				dl.Failed++
+			}
+			dl.TotalSize += f.Size
+			dl.Files = append(dl.Files, entry)
+		}
+		out.Downloads = append(out.Downloads, dl)
+	}
+	return out
+}
+
+func (s *statusServer) serveJSON(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s.snapshot()); err != nil {
+		slog.Debug("Status request failed", "error", err)
+	}
+}
+
+func (s *statusServer) servePage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := statusPage.Execute(w, s.snapshot()); err != nil {
+		slog.Debug("Status request failed", "error", err)
+	}
+}
+
+// statusPage is the HTML status page. It reloads itself every 5 seconds.
+var statusPage = template.Must(template.New("status").Funcs(template.FuncMap{
+	"bytes": ui.FormatBytes,
+	"percent": func(done, total int64) string {
+		if total <= 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f%%", float64(done)*100/float64(total))
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5">
+<title>egafetch status</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { padding: 0.2em 0.8em; border-bottom: 1px solid #ddd; text-align: left; }
+td.num { text-align: right; }
+</style>
+</head>
+<body>
+<h1>egafetch {{.Version}}</h1>
+<p>Running since {{.StartedAt.Format "2006-01-02 15:04:05"}}. <a href="status.json">JSON</a></p>
+{{range .Downloads}}
+<h2>{{.OutputDir}}{{if .DatasetID}} ({{.DatasetID}}){{end}}</h2>
+<p>{{.Complete}} of {{len .Files}} files complete, {{.Failed}} failed, {{bytes .TotalSize}} in total.</p>
+<table>
+<tr><th>File</th><th>Status</th><th>Downloaded</th><th>Size</th><th>Progress</th><th>Error</th></tr>
+{{range .Files}}<tr><td>{{.FileName}}</td><td>{{.Status}}</td><td class="num">{{bytes .BytesDownloaded}}</td><td class="num">{{bytes .Size}}</td><td class="num">{{percent .BytesDownloaded .Size}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>
+{{else}}
+<p>Resolving files...</p>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+func TestStatusServerSnapshot(t *testing.T) {
+	sm := state.NewStateManager(t.TempDir())
+	spec := state.FileSpec{FileID: "EGAF00000000001", FileName: "a.bam", Size: 100}
+	fs := state.NewFileState(spec, 50)
+	fs.Status = state.StatusDownloading
+	fs.Chunks = []state.ChunkState{
+		{Index: 0, Start: 0, End: 50, Status: state.ChunkComplete, BytesDownloaded: 50},
+		{Index: 1, Start: 50, End: 100, Status: state.ChunkPending},
+	}
+	if err := sm.SaveFileState(fs); err != nil {
+		t.Fatal(err)
+	}
+	manifest := &state.Manifest{DatasetID: "EGAD00000000001", Files: []state.FileSpec{
+		spec,
+		{FileID: "EGAF00000000002", FileName: "<b>.bam", Size: 10},
+	}}
+
+	s := &statusServer{live: make(map[[2]string]int64)}
+	s.addManifest(manifest, sm)
+	s.bytes(sm.BaseDir(), "EGAF00000000001", 75)
+
+	rec := httptest.NewRecorder()
+	s.serveJSON(rec, httptest.NewRequest("GET", "/status.json", nil))
+	var got jsonLiveStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", rec.Body.String(), err)
+	}
+	if len(got.Downloads) != 1 || len(got.Downloads[0].Files) != 2 {
+		t.Fatalf("snapshot = %+v, want one download of two files", got)
+	}
+	dl := got.Downloads[0]
+	if dl.DatasetID != "EGAD00000000001" || dl.TotalSize != 110 {
+		t.Errorf("download = %+v", dl)
+	}
+	if f := dl.Files[0]; f.BytesDownloaded != 75 || len(f.Chunks) != 2 {
+		t.Errorf("downloading file = %+v, want 75 live bytes and 2 chunks", f)
+	}
+	if f := dl.Files[1]; f.Status != string(state.StatusPending) || f.Chunks == nil {
+		t.Errorf("file without state = %+v, want pending with empty chunks", f)
+	}
+
+	rec = httptest.NewRecorder()
+	s.servePage(rec, httptest.NewRequest("GET", "/", nil))
+	page := rec.Body.String()
+	if !strings.Contains(page, "75.0%") || !strings.Contains(page, "&lt;b&gt;.bam") {
+		t.Errorf("status page missing progress or escaped file name:\n%s", page)
+	}
+}
+
+func TestStatusServerNil(t *testing.T) {
+	var s *statusServer
+	s.addManifest(&state.Manifest{}, state.NewStateManager(t.TempDir()))
+	s.bytes("out", "EGAF00000000001", 1)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/auth"
+	"github.com/khan-lab/EGAfetch/internal/ui"
+)
+
+// --- Summary command ---
+
+func newSummaryCmd() *cobra.Command {
+	var dir string
+	var configFile string
+	var refresh bool
+
+	cmd := &cobra.Command{
+		Use:               "summary EGAD...",
+		ValidArgsFunction: completeDatasetIDs(true),
+		Short:             "Summarize a dataset's samples, sequencing, and files",
+		Long: `Print an overview of a dataset before downloading it: sample, run, and
+analysis counts, library strategies, platforms, a file-type breakdown, and
+the total size.
+
+Counts come from the dataset's metadata mappings and file listing. Metadata
+previously exported by 'egafetch metadata' is reused when found in --dir.`,
+		Example: `  egafetch summary EGAD00001001938
+  egafetch summary EGAD00001001938 --cf credentials.json`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			datasetID := args[0]
+			if !strings.HasPrefix(datasetID, "EGAD") {
+				return fmt.Errorf("expected dataset ID (EGAD...)")
+			}
+			if dir == "" {
+				dir = datasetID + "-metadata"
+			}
+
+			mgr, err := auth.NewManager()
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := signalContext()
+			defer cancel()
+
+			if err := ensureAuth(ctx, mgr, configFile); err != nil {
+				return err
+			}
+
+			apiClient := api.NewClient(mgr)
+
+			slog.Info("Fetching file list...", "dataset", datasetID)
+			spinner := startSpinner("Fetching file list of " + datasetID)
+			files, err := listDatasetFiles(ctx, apiClient, datasetID)
+			spinner.Stop()
+			if err != nil {
+				return err
+			}
+
+			meta, err := resolveDatasetMetadata(ctx, dir, datasetID, configFile, refresh)
+			if err != nil {
+				return err
+			}
+
+			printDatasetSummary(cmd.OutOrStdout(), datasetID, meta, files)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&dir, "dir", "", "Directory with previously exported metadata (default: {datasetID}-metadata)")
+	cmd.Flags().StringVar(&configFile, "cf", "", "JSON config file with credentials")
+	cmd.Flags().StringVar(&configFile, "config-file", "", "JSON config file with credentials (alias for --cf)")
+	cmd.Flags().BoolVar(&refresh, "refresh", false, "Re-fetch metadata even if cached or previously exported")
+
+	return cmd
+}
+
+// printDatasetSummary prints entity counts from the metadata mappings and a
+// file-type breakdown from the file listing.
+func printDatasetSummary(w io.Writer, datasetID string, meta *api.DatasetMetadata, files []api.DatasetFile) {
+	tables := mappingTables(meta)
+	distinct := func(column string) int {
+		var all []map[string]interface{}
+		for _, t := range tables {
+			all = append(all, t...)
+		}
+		return countDistinct(all, column)
+	}
+
+	var totalSize int64
+	for _, f := range files {
+		totalSize += f.FileSize
+	}
+
+	fmt.Fprintf(w, "\nDataset ID:    %s\n", datasetID)
+	fmt.Fprintf(w, "Samples:       %d\n", distinct("sample_accession_id"))
+	fmt.Fprintf(w, "Studies:       %d\n", distinct("study_accession_id"))
+	fmt.Fprintf(w, "Experiments:   %d\n", distinct("experiment_accession_id"))
+	fmt.Fprintf(w, "Runs:          %d\n", distinct("run_accession_id"))
+	fmt.Fprintf(w, "Analyses:      %d\n", distinct("analysis_accession_id"))
+	fmt.Fprintf(w, "Files:         %d\n", len(files))
+	fmt.Fprintf(w, "Total Size:    %s (%d bytes)\n", ui.FormatBytes(totalSize), totalSize)
+
+	// Strategies and platforms are properties of experiments; report how
+	// many runs use each.
+	strategies := tallyRuns(meta.StudyExperimentRunSample, "library_strategy")
+	platforms := tallyRuns(meta.StudyExperimentRunSample, "instrument_platform", "platform", "instrument_model")
+	if len(strategies) > 0 {
+		fmt.Fprintln(w, "\nLibrary strategies:")
+		printTally(w, strategies)
+	}
+	if len(platforms) > 0 {
+		fmt.Fprintln(w, "\nPlatforms:")
+		printTally(w, platforms)
+	}
+
+	if len(files) > 0 {
+		type typeStats struct {
+			count int
+			size  int64
+		}
+		byType := make(map[string]*typeStats)
+		for _, f := range files {
+			t := fileTypeOf(f.FileName)
+			if byType[t] == nil {
+				byType[t] = &typeStats{}
+			}
+			byType[t].count++
+			byType[t].size += f.FileSize
+		}
+		types := make([]string, 0, len(byType))
+		for t := range byType {
+			types = append(types, t)
+		}
+		sort.Slice(types, func(i, j int) bool {
+			if byType[types[i]].size != byType[types[j]].size {
+				return byType[types[i]].size > byType[types[j]].size
+			}
+			return types[i] < types[j]
+		})
+
+		rows := make([][]string, 0, len(types))
+		for _, t := range types {
+			rows = append(rows, []string{t, fmt.Sprintf("%d", byType[t].count), ui.FormatBytes(byType[t].size)})
+		}
+		fmt.Fprintln(w, "\nFile types:")
+		ui.PrintTable(w, []string{"Type", "Files", "Size"}, rows)
+	}
+}
+
+// tallyRuns counts distinct runs per value of the first non-empty column.
+// Records without a run accession are counted once per experiment instead.
+func tallyRuns(records []map[string]interface{}, columns ...string) map[string]int {
+	seen := make(map[string]bool)
+	tally := make(map[string]int)
+	for _, rec := range records {
+		value := firstValue(rec, columns...)
+		if value == "" {
+			continue
+		}
+		unit := firstValue(rec, "run_accession_id", "experiment_accession_id")
+		key := value + "\x00" + unit
+		if unit != "" && seen[key] {
+			continue
+		}
+		seen[key] = true
+		tally[value]++
+	}
+	return tally
+}
+
+// printTally prints run counts per value, most frequent first.
+func printTally(w io.Writer, tally map[string]int) {
+	values := make([]string, 0, len(tally))
+	for v := range tally {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if tally[values[i]] != tally[values[j]] {
+			return tally[values[i]] > tally[values[j]]
+		}
+		return values[i] < values[j]
+	})
+	for _, v := range values {
+		fmt.Fprintf(w, "  %-24s %d run(s)\n", v, tally[v])
+	}
+}
+
+// fileTypeOf returns a file's type from its name, ignoring encryption
+// suffixes and keeping compression ones (e.g. "sample.bam.c4gh" is "bam",
+// "r1.fastq.gz" is "fastq.gz").
+func fileTypeOf(name string) string {
+	name = strings.ToLower(filepath.Base(name))
+	for _, suffix := range []string{".c4gh", ".gpg", ".cip"} {
+		name = strings.TrimSuffix(name, suffix)
+	}
+
+	ext := filepath.Ext(name)
+	if ext == ".gz" || ext == ".bz2" {
+		inner := filepath.Ext(strings.TrimSuffix(name, ext))
+		if inner != "" {
+			ext = inner + ext
+		}
+	}
+	if ext == "" {
+		return "other"
+	}
+	return strings.TrimPrefix(ext, ".")
+}
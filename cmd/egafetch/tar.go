@@ -0,0 +1,150 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/khan-lab/EGAfetch/internal/download"
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+// stagedUploader takes the files of a download staged in a local directory:
+// an rclone remote, or a tar archive.
+type stagedUploader interface {
+	download.Uploader
+	// UploadDir copies the local directory dir, but not its .egafetch
+	// directory, to name.
+	UploadDir(ctx context.Context, dir, name string) error
+}
+
+// tarArchive writes verified files to a tar stream, for download --tar, so
+// that they can be piped to an archiver or uploader without a local copy of
+// every file. As an Uploader, it adds each file whole once it is verified,
+// and the local copy is then removed.
+type tarArchive struct {
+	mu     sync.Mutex
+	tw     *tar.Writer
+	closer io.Closer // the archive file; nil for stdout
+	err    error     // the first write error, after which the stream is unusable
+}
+
+// openTarArchive creates the archive at path, or writes it to stdout if path
+// is "-".
+func openTarArchive(cmd *cobra.Command, path string) (*tarArchive, error) {
+	if path == "-" {
+		out := cmd.OutOrStdout()
+		if f, ok := out.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+			return nil, fmt.Errorf("refusing to write a tar archive to a terminal; redirect stdout or give --tar a file path")
+		}
+		return &tarArchive{tw: tar.NewWriter(out)}, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create tar archive: %w", err)
+	}
+	return &tarArchive{tw: tar.NewWriter(f), closer: f}, nil
+}
+
+func (a *tarArchive) Upload(ctx context.Context, localPath, name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err != nil {
+		return a.err
+	}
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	// The entry is written whole even if ctx is cancelled: a partial entry
+	// would leave the archive unreadable past it.
+	if err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     info.Size(),
+		Mode:     int64(info.Mode().Perm()),
+		ModTime:  info.ModTime(),
+	}); err != nil {
+		a.err = fmt.Errorf("write tar archive: %w", err)
+		return a.err
+	}
+	if _, err := io.Copy(a.tw, f); err != nil {
+		a.err = fmt.Errorf("write tar archive: %w", err)
+		return a.err
+	}
+	return nil
+}
+
+// UploadDir adds the files under the local directory dir to the archive
+// below name, leaving out its .egafetch directory.
+func (a *tarArchive) UploadDir(ctx context.Context, dir, name string) error {
+	local := state.NewStateManager(dir).EgafetchPath()
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path == local {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		return a.Upload(ctx, path, name+"/"+filepath.ToSlash(rel))
+	})
+}
+
+// Close ends the archive, which stays valid with the files added so far.
+func (a *tarArchive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err := a.tw.Close()
+	if a.err != nil {
+		err = a.err
+	}
+	if a.closer != nil {
+		if cerr := a.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("close tar archive: %w", err)
+	}
+	return nil
+}
+
+// checkTarFlags rejects download flags that --tar cannot be combined with.
+func checkTarFlags(tarPath, output, batchFile string, shared bool, progressJSON string) error {
+	if _, isRemote, _ := rcloneRemote(output); isRemote {
+		return fmt.Errorf("--tar cannot be combined with an rclone: or bucket output")
+	}
+	if shared {
+		return fmt.Errorf("--tar cannot be combined with --shared: each host would write its own archive")
+	}
+	if batchFile != "" {
+		return fmt.Errorf("--tar cannot be combined with --batch")
+	}
+	if tarPath == "-" && jsonOutput {
+		return fmt.Errorf("--tar - cannot share stdout with --json")
+	}
+	if tarPath == "-" && progressJSON == "-" {
+		return fmt.Errorf("--tar - cannot share stdout with --progress-json; give it a file path (--progress-json=PATH)")
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestTarArchive(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		filepath.Join("EGAF1", "a.bam"):                "reads",
+		filepath.Join("EGAD1-metadata", "samples.tsv"): "sample\n",
+		// The metadata cache stays out of the archive.
+		filepath.Join("EGAD1-metadata", ".egafetch", "metadata-cache", "EGAD1", "1.json"): "{}",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetOut(&out)
+	a, err := openTarArchive(cmd, "-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := a.Upload(ctx, filepath.Join(dir, "EGAF1", "a.bam"), "EGAF1/a.bam"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.UploadDir(ctx, filepath.Join(dir, "EGAD1-metadata"), "EGAD1-metadata"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]string)
+	tr := tar.NewReader(&out)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Mode != 0640 {
+			t.Errorf("%s has mode %o, want 640", hdr.Name, hdr.Mode)
+		}
+		got[hdr.Name] = string(data)
+	}
+	if len(got) != 2 || got["EGAF1/a.bam"] != "reads" || got["EGAD1-metadata/samples.tsv"] != "sample\n" {
+		t.Errorf("archive holds %v", got)
+	}
+}
+
+func TestCheckTarFlags(t *testing.T) {
+	tests := []struct {
+		name, tarPath, output, batch, progressJSON string
+		shared, wantErr                            bool
+	}{
+		{"file", "out.tar", ".", "", "-", false, false},
+		{"stdout", "-", ".", "", "progress.ndjson", false, false},
+		{"stdout progress", "-", ".", "", "-", false, true},
+		{"rclone", "-", "rclone:box:ega", "", "", false, true},
+		{"shared", "out.tar", ".", "", "", true, true},
+		{"batch", "out.tar", ".", "batch.yaml", "", false, true},
+	}
+	for _, tt := range tests {
+		err := checkTarFlags(tt.tarPath, tt.output, tt.batch, tt.shared, tt.progressJSON)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkTarFlags = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// sampleUnit is one sequencing unit of a sample: a FASTQ pair or a single
+// alignment file, with the sample's metadata attached.
+type sampleUnit struct {
+	Sample    string
+	Unit      string
+	Read1     string
+	Read2     string
+	Alignment string // BAM or CRAM
+	Index     string // BAI or CRAI
+	Metadata  map[string]string
+}
+
+// --- Workflow command ---
+
+func newWorkflowCmd() *cobra.Command {
+	var engine string
+	var dir string
+	var metadataDir string
+	var output string
+	var configFile string
+	var refresh bool
+
+	cmd := &cobra.Command{
+		Use:               "workflow EGAD...",
+		ValidArgsFunction: completeDatasetIDs(true),
+		Short:             "Write a Snakemake or Nextflow config linking files to samples",
+		Long: `Write a workflow-manager input file mapping each sample to the local
+FASTQ/BAM/CRAM paths of its completed downloads, plus sample metadata
+columns.
+
+Engines:
+  snakemake   samples.tsv with one row per unit (FASTQ pair or alignment)
+  nextflow    params.json for 'nextflow run ... -params-file params.json'`,
+		Example: `  egafetch workflow EGAD00001001938 --engine snakemake -d ./data
+  egafetch workflow EGAD00001001938 --engine nextflow -o params.json`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			datasetID := args[0]
+			if !strings.HasPrefix(datasetID, "EGAD") {
+				return fmt.Errorf("expected dataset ID (EGAD...)")
+			}
+			if err := applyConfig(cmd, map[string]string{"dir": "output_dir"}); err != nil {
+				return err
+			}
+
+			switch engine {
+			case "snakemake":
+				if output == "" {
+					output = "samples.tsv"
+				}
+			case "nextflow":
+				if output == "" {
+					output = "params.json"
+				}
+			default:
+				return fmt.Errorf("unsupported engine %q (use snakemake or nextflow)", engine)
+			}
+
+			if metadataDir == "" {
+				metadataDir = filepath.Join(dir, datasetID+"-metadata")
+			}
+
+			ctx, cancel := signalContext()
+			defer cancel()
+
+			meta, err := resolveDatasetMetadata(ctx, metadataDir, datasetID, configFile, refresh)
+			if err != nil {
+				return err
+			}
+
+			samples, err := collectSampleFiles(meta, dir)
+			if err != nil {
+				return err
+			}
+			units := buildSampleUnits(samples)
+			if len(units) == 0 {
+				return fmt.Errorf("no completed FASTQ, BAM, or CRAM downloads in %s match the metadata of %s", dir, datasetID)
+			}
+
+			if engine == "snakemake" {
+				err = writeSnakemakeSamples(output, units)
+			} else {
+				err = writeNextflowParams(output, datasetID, units)
+			}
+			if err != nil {
+				return fmt.Errorf("write %s: %w", output, err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Wrote %s: %d unit(s) for %d sample(s)\n", output, len(units), len(samples))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&engine, "engine", "", "Workflow manager (snakemake, nextflow)")
+	cmd.Flags().StringVarP(&dir, "dir", "d", ".", "Download directory")
+	cmd.Flags().StringVar(&metadataDir, "metadata-dir", "", "Directory with exported metadata (default: {dir}/{datasetID}-metadata)")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Output path (default: samples.tsv or params.json)")
+	cmd.Flags().StringVar(&configFile, "cf", "", "JSON config file with credentials")
+	cmd.Flags().StringVar(&configFile, "config-file", "", "JSON config file with credentials (alias for --cf)")
+	cmd.Flags().BoolVar(&refresh, "refresh", false, "Re-fetch metadata even if cached or previously exported")
+	cmd.MarkFlagRequired("engine")
+
+	return cmd
+}
+
+// buildSampleUnits splits each sample's files into FASTQ pairs and
+// alignment files. Metadata columns describing individual files are dropped
+// since they do not apply to the sample as a whole.
+func buildSampleUnits(samples []*sampleFiles) []sampleUnit {
+	var units []sampleUnit
+	for _, s := range samples {
+		metadata := make(map[string]string)
+		for k, v := range s.Record {
+			if strings.Contains(k, "file") || k == "sample_accession_id" {
+				continue
+			}
+			if val := formatValue(v); val != "" {
+				metadata[k] = val
+			}
+		}
+
+		var sample []sampleUnit
+		var runIDs []string
+		for _, p := range pairFASTQ(s.Files) {
+			sample = append(sample, sampleUnit{Read1: p.Read1, Read2: p.Read2})
+			runIDs = append(runIDs, p.RunID)
+		}
+		indexes := alignmentIndexes(s.Files)
+		for _, f := range s.Files {
+			if f.Kind == kindBAM || f.Kind == kindCRAM {
+				sample = append(sample, sampleUnit{Alignment: f.Path, Index: indexes[f.Path]})
+				runIDs = append(runIDs, f.RunID)
+			}
+		}
+
+		names := unitNames(runIDs, func(i int) string { return fmt.Sprintf("%d", i+1) })
+		for i := range sample {
+			sample[i].Sample, sample[i].Unit, sample[i].Metadata = s.SampleID, names[i], metadata
+		}
+		units = append(units, sample...)
+	}
+	return units
+}
+
+// metadataColumns returns the union of metadata keys across units, sorted.
+func metadataColumns(units []sampleUnit) []string {
+	set := make(map[string]struct{})
+	for _, u := range units {
+		for k := range u.Metadata {
+			set[k] = struct{}{}
+		}
+	}
+	columns := make([]string, 0, len(set))
+	for k := range set {
+		columns = append(columns, k)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// writeSnakemakeSamples writes a Snakemake-style samples.tsv with one row
+// per unit, file path columns first and metadata columns after.
+func writeSnakemakeSamples(path string, units []sampleUnit) error {
+	metaCols := metadataColumns(units)
+	header := append([]string{"sample", "unit", "fq1", "fq2", "alignment", "index"}, metaCols...)
+
+	rows := make([][]string, 0, len(units))
+	for _, u := range units {
+		row := []string{u.Sample, u.Unit, u.Read1, u.Read2, u.Alignment, u.Index}
+		for _, c := range metaCols {
+			row = append(row, u.Metadata[c])
+		}
+		rows = append(rows, row)
+	}
+	return writeDelimitedRows(path, '\t', header, rows)
+}
+
+// nextflowSample is one entry of the samples list in a Nextflow params file.
+type nextflowSample struct {
+	Sample    string            `json:"sample"`
+	Unit      string            `json:"unit"`
+	Fastq1    string            `json:"fastq_1,omitempty"`
+	Fastq2    string            `json:"fastq_2,omitempty"`
+	Alignment string            `json:"alignment,omitempty"`
+	Index     string            `json:"index,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// writeNextflowParams writes a JSON params file usable with -params-file.
+func writeNextflowParams(path, datasetID string, units []sampleUnit) error {
+	params := struct {
+		Dataset string           `json:"dataset"`
+		Samples []nextflowSample `json:"samples"`
+	}{Dataset: datasetID}
+
+	for _, u := range units {
+		params.Samples = append(params.Samples, nextflowSample{
+			Sample:    u.Sample,
+			Unit:      u.Unit,
+			Fastq1:    u.Read1,
+			Fastq2:    u.Read2,
+			Alignment: u.Alignment,
+			Index:     u.Index,
+			Metadata:  u.Metadata,
+		})
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(params)
+}
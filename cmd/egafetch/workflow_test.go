@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBuildSampleUnits(t *testing.T) {
+	samples := []*sampleFiles{{
+		SampleID: "EGAN1",
+		Record:   map[string]interface{}{"sex": "female", "file_name": "a.fastq.gz"},
+		Files: []localFile{
+			{Path: "/d/EGAF1/a_R1.fastq.gz", Kind: kindFASTQ, RunID: "EGAR1"},
+			{Path: "/d/EGAF2/a_R2.fastq.gz", Kind: kindFASTQ, RunID: "EGAR1"},
+			{Path: "/d/EGAF3/b_R1.fastq.gz", Kind: kindFASTQ, RunID: "EGAR1"},
+			{Path: "/d/EGAF4/b_R2.fastq.gz", Kind: kindFASTQ, RunID: "EGAR1"},
+			{Path: "/d/EGAF5/c.bam", Kind: kindBAM, RunID: "EGAR2"},
+			{Path: "/d/EGAF6/c.bam.bai", Kind: kindBAI, RunID: "EGAR2"},
+			{Path: "/d/EGAF7/d.cram", Kind: kindCRAM},
+			{Path: "/d/EGAF8/d.crai", Kind: kindCRAI},
+		},
+	}}
+	units := buildSampleUnits(samples)
+
+	want := []sampleUnit{
+		{Sample: "EGAN1", Unit: "EGAR1_1", Read1: "/d/EGAF1/a_R1.fastq.gz", Read2: "/d/EGAF2/a_R2.fastq.gz"},
+		{Sample: "EGAN1", Unit: "EGAR1_2", Read1: "/d/EGAF3/b_R1.fastq.gz", Read2: "/d/EGAF4/b_R2.fastq.gz"},
+		{Sample: "EGAN1", Unit: "EGAR2", Alignment: "/d/EGAF5/c.bam", Index: "/d/EGAF6/c.bam.bai"},
+		{Sample: "EGAN1", Unit: "4", Alignment: "/d/EGAF7/d.cram", Index: "/d/EGAF8/d.crai"},
+	}
+	if len(units) != len(want) {
+		t.Fatalf("buildSampleUnits = %+v, want %d units", units, len(want))
+	}
+	for i, w := range want {
+		u := units[i]
+		if u.Sample != w.Sample || u.Unit != w.Unit || u.Read1 != w.Read1 || u.Read2 != w.Read2 || u.Alignment != w.Alignment || u.Index != w.Index {
+			t.Errorf("unit %d = %+v, want %+v", i, u, w)
+		}
+		if u.Metadata["sex"] != "female" || u.Metadata["file_name"] != "" {
+			t.Errorf("unit %d metadata = %v, want the sample's columns only", i, u.Metadata)
+		}
+	}
+}
+
+func TestUnitNames(t *testing.T) {
+	got := unitNames([]string{"EGAR1", "", "EGAR2", "EGAR1", ""}, func(i int) string { return fmt.Sprintf("L%d", i+1) })
+	want := []string{"EGAR1_1", "L2", "EGAR2", "EGAR1_2", "L5"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("unitNames = %v, want %v", got, want)
+			break
+		}
+	}
+}
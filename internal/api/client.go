@@ -2,10 +2,13 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/khan-lab/EGAfetch/internal/auth"
@@ -19,19 +22,52 @@ const (
 	metadataAPIBaseURL = "https://metadata.ega-archive.org"
 )
 
+// BaseURLs lists the EGA APIs the client calls, for connectivity checks.
+var BaseURLs = []string{dataBaseURL, metadataAPIBaseURL}
+
 // Client provides methods to interact with the EGA REST APIs.
 type Client struct {
 	tokenProvider auth.TokenProvider
 	httpClient    *http.Client
+	streamClient  *http.Client
 }
 
+// streamTimeout bounds how long a streamed response may wait for its headers,
+// or go without delivering data, before it is abandoned.
+const streamTimeout = 60 * time.Second
+
+// maxIdleConnsPerHost is how many idle connections to each API host are
+// kept open for later requests. Go's default of 2 closes most connections
+// of a parallel download as soon as their request is done, so each small
+// file of an index-heavy dataset paid for a new TCP and TLS handshake.
+const maxIdleConnsPerHost = 64
+
 // NewClient creates an API client that uses the given TokenProvider for auth.
+// The TokenProvider may be nil when only public metadata endpoints are used.
 func NewClient(tp auth.TokenProvider) *Client {
+	return NewClientWithDialer(tp, DialOptions{})
+}
+
+// NewClientWithDialer is NewClient with connections opened according to dial.
+func NewClientWithDialer(tp auth.TokenProvider, dial DialOptions) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dial.dialContext()
+	transport.ResponseHeaderTimeout = streamTimeout
+	// Requests run one after another on persistent connections, and the
+	// connections that must be opened anyway resume a cached TLS session.
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	transport.TLSClientConfig = &tls.Config{ClientSessionCache: tls.NewLRUClientSessionCache(0)}
+	traced := tracingTransport{base: transport}
 	return &Client{
 		tokenProvider: tp,
 		httpClient: &http.Client{
-			Timeout: 60 * time.Second,
+			Timeout:   60 * time.Second,
+			Transport: traced,
 		},
+		// Streamed bodies (large mappings, file chunks) may take longer than
+		// the fixed timeout to transfer, so only the wait for headers is
+		// bounded here.
+		streamClient: &http.Client{Transport: traced},
 	}
 }
 
@@ -86,53 +122,221 @@ func (c *Client) GetFileMetadata(ctx context.Context, fileID string) (*FileMetad
 // FileDownloadURL returns the full URL for streaming a file download.
 // The caller should use HTTP Range headers to download specific byte ranges.
 func (c *Client) FileDownloadURL(fileID string) string {
-	return fmt.Sprintf("%s/files/%s?destinationFormat=plain", dataBaseURL, fileID)
+	return FileURL(fileID)
+}
+
+// FileURL returns the EGA download URL of a file, which serves its content
+// in FormatPlain to authenticated requests.
+func FileURL(fileID string) string {
+	return FileURLAt(dataBaseURL, fileID)
+}
+
+// FileURLAt returns the download URL of a file at another data API, such as
+// a Federated EGA node, whose base URL corresponds to dataBaseURL.
+func FileURLAt(baseURL, fileID string) string {
+	return fmt.Sprintf("%s/files/%s?destinationFormat=%s", strings.TrimSuffix(baseURL, "/"), fileID, FormatPlain)
+}
+
+// MappingNames lists the mapping endpoints of the EGA private metadata API,
+// in the order they are fetched and exported.
+var MappingNames = []string{
+	"study_experiment_run_sample",
+	"run_sample",
+	"study_analysis_sample",
+	"analysis_sample",
+	"sample_file",
 }
 
 // FetchDatasetMappings fetches all mapping endpoints from the EGA private
 // metadata API and returns the combined result. The token parameter is a
 // metadata-specific Bearer token (from the metadata IdP, not the download IdP).
-func (c *Client) FetchDatasetMappings(ctx context.Context, token, datasetID string) (*DatasetMetadata, error) {
-	mappings := []struct {
+// onRecord, if not nil, is called with the mapping being fetched and its
+// records so far, including once with 0 as each mapping starts.
+func (c *Client) FetchDatasetMappings(ctx context.Context, token, datasetID string, onRecord func(mapping string, records int)) (*DatasetMetadata, error) {
+	result := &DatasetMetadata{}
+	dests := map[string]*[]map[string]interface{}{
+		"study_experiment_run_sample": &result.StudyExperimentRunSample,
+		"run_sample":                  &result.RunSample,
+		"study_analysis_sample":       &result.StudyAnalysisSample,
+		"analysis_sample":             &result.AnalysisSample,
+		"sample_file":                 &result.SampleFile,
+	}
+
+	for _, name := range MappingNames {
+		records := []map[string]interface{}{}
+		if onRecord != nil {
+			onRecord(name, 0)
+		}
+		err := c.StreamDatasetMapping(ctx, token, datasetID, name, func(rec map[string]interface{}) error {
+			records = append(records, rec)
+			if onRecord != nil {
+				onRecord(name, len(records))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		*dests[name] = records
+	}
+
+	return result, nil
+}
+
+// StreamDatasetMapping fetches one mapping endpoint and calls fn for each
+// record as it is decoded, so the response is never held in memory as a
+// whole. A non-nil error from fn stops the stream and is returned.
+func (c *Client) StreamDatasetMapping(ctx context.Context, token, datasetID, mapping string, fn func(map[string]interface{}) error) error {
+	url := fmt.Sprintf("%s/datasets/%s/mappings/%s", metadataAPIBaseURL, datasetID, mapping)
+	body, err := c.doStreamGetWithToken(ctx, token, url)
+	if err != nil {
+		return fmt.Errorf("fetch %s: %w", mapping, err)
+	}
+	defer body.Close()
+
+	dec := json.NewDecoder(body)
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("parse %s response: %w", mapping, err)
+	}
+	if tok == nil {
+		// A JSON null body is an empty mapping.
+		return nil
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("parse %s response: expected JSON array", mapping)
+	}
+
+	for dec.More() {
+		var rec map[string]interface{}
+		if err := dec.Decode(&rec); err != nil {
+			return fmt.Errorf("parse %s response: %w", mapping, err)
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("parse %s response: %w", mapping, err)
+	}
+	return nil
+}
+
+// GetDatasetDetails fetches rich metadata for a dataset from the EGA public
+// metadata API (no authentication required).
+func (c *Client) GetDatasetDetails(ctx context.Context, datasetID string) (*DatasetDetails, error) {
+	url := fmt.Sprintf("%s/datasets/%s", metadataAPIBaseURL, datasetID)
+
+	body, err := c.doPublicGet(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("fetch dataset details: %w", err)
+	}
+
+	var details DatasetDetails
+	if err := json.Unmarshal(body, &details); err != nil {
+		return nil, fmt.Errorf("parse dataset details: %w", err)
+	}
+	return &details, nil
+}
+
+// FetchAccessConditions fetches the data access policy and DAC of a dataset
+// from the EGA public metadata API (no authentication required). The DAC is
+// taken from the policy when the dataset does not name one directly.
+func (c *Client) FetchAccessConditions(ctx context.Context, datasetID string) (*AccessConditions, error) {
+	details, err := c.GetDatasetDetails(ctx, datasetID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &AccessConditions{DatasetID: datasetID}
+	if details.PolicyAccessionID != "" {
+		result.Policy, err = c.getPublicObject(ctx, "policies", details.PolicyAccessionID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dacID := details.DACAccessionID
+	if dacID == "" {
+		dacID, _ = result.Policy["dac_accession_id"].(string)
+	}
+	if dacID != "" {
+		result.DAC, err = c.getPublicObject(ctx, "dacs", dacID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if result.Policy == nil && result.DAC == nil {
+		return nil, fmt.Errorf("no policy or DAC listed for dataset %s", datasetID)
+	}
+	return result, nil
+}
+
+// getPublicObject fetches a single entity such as a policy or DAC from the
+// EGA public metadata API.
+func (c *Client) getPublicObject(ctx context.Context, entity, accessionID string) (map[string]interface{}, error) {
+	url := fmt.Sprintf("%s/%s/%s", metadataAPIBaseURL, entity, accessionID)
+	data, err := c.doPublicGet(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("fetch %s %s: %w", entity, accessionID, err)
+	}
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, fmt.Errorf("parse %s %s: %w", entity, accessionID, err)
+	}
+	return obj, nil
+}
+
+// FetchPublicDatasetMetadata fetches the dataset details and the publicly
+// visible studies, experiments, runs, analyses, samples, and files of a
+// dataset from the EGA public metadata API. No authentication is required,
+// so this works before DAC access has been granted. Entity lists that the
+// API does not expose for a dataset (HTTP 404) are returned empty.
+func (c *Client) FetchPublicDatasetMetadata(ctx context.Context, datasetID string) (*PublicDatasetMetadata, error) {
+	details, err := c.GetDatasetDetails(ctx, datasetID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PublicDatasetMetadata{Details: details}
+	entities := []struct {
 		name string
 		dest *[]map[string]interface{}
 	}{
-		{"study_experiment_run_sample", nil},
-		{"run_sample", nil},
-		{"study_analysis_sample", nil},
-		{"analysis_sample", nil},
-		{"sample_file", nil},
+		{"studies", &result.Studies},
+		{"experiments", &result.Experiments},
+		{"runs", &result.Runs},
+		{"analyses", &result.Analyses},
+		{"samples", &result.Samples},
+		{"files", &result.Files},
 	}
 
-	result := &DatasetMetadata{}
-	mappings[0].dest = &result.StudyExperimentRunSample
-	mappings[1].dest = &result.RunSample
-	mappings[2].dest = &result.StudyAnalysisSample
-	mappings[3].dest = &result.AnalysisSample
-	mappings[4].dest = &result.SampleFile
-
-	for _, m := range mappings {
-		url := fmt.Sprintf("%s/datasets/%s/mappings/%s", metadataAPIBaseURL, datasetID, m.name)
-		data, err := c.doGetWithToken(ctx, token, url)
+	for _, e := range entities {
+		url := fmt.Sprintf("%s/datasets/%s/%s", metadataAPIBaseURL, datasetID, e.name)
+		data, err := c.doPublicGet(ctx, url)
 		if err != nil {
-			return nil, fmt.Errorf("fetch %s: %w", m.name, err)
+			var apiErr *APIError
+			if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+				*e.dest = []map[string]interface{}{}
+				continue
+			}
+			return nil, fmt.Errorf("fetch %s: %w", e.name, err)
 		}
 
 		var records []map[string]interface{}
 		if err := json.Unmarshal(data, &records); err != nil {
-			return nil, fmt.Errorf("parse %s response: %w", m.name, err)
+			return nil, fmt.Errorf("parse %s response: %w", e.name, err)
 		}
-		*m.dest = records
+		*e.dest = records
 	}
 
 	return result, nil
 }
 
-// GetDatasetDetails fetches rich metadata for a dataset from the EGA public
-// metadata API (no authentication required).
-func (c *Client) GetDatasetDetails(ctx context.Context, datasetID string) (*DatasetDetails, error) {
-	url := fmt.Sprintf("https://metadata.ega-archive.org/datasets/%s", datasetID)
-
+// doPublicGet performs an unauthenticated GET request against a public API.
+func (c *Client) doPublicGet(ctx context.Context, url string) ([]byte, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
@@ -141,7 +345,7 @@ func (c *Client) GetDatasetDetails(ctx context.Context, datasetID string) (*Data
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("fetch dataset details: %w", err)
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -157,22 +361,35 @@ func (c *Client) GetDatasetDetails(ctx context.Context, datasetID string) (*Data
 		}
 	}
 
-	var details DatasetDetails
-	if err := json.Unmarshal(body, &details); err != nil {
-		return nil, fmt.Errorf("parse dataset details: %w", err)
+	return body, nil
+}
+
+// FetchXMLDocument fetches the original submitted XML document (ENA-style)
+// for a study, experiment, run, analysis, or sample from the EGA private
+// metadata API. entity is the plural collection name, e.g. "runs".
+func (c *Client) FetchXMLDocument(ctx context.Context, token, entity, accessionID string) ([]byte, error) {
+	url := fmt.Sprintf("%s/%s/%s/xml", metadataAPIBaseURL, entity, accessionID)
+	data, err := c.doGetWithTokenAccept(ctx, token, url, "application/xml")
+	if err != nil {
+		return nil, fmt.Errorf("fetch %s XML for %s: %w", entity, accessionID, err)
 	}
-	return &details, nil
+	return data, nil
 }
 
 // doGetWithToken performs a GET request using an explicit Bearer token
 // (for APIs that use a different auth system than the download API).
 func (c *Client) doGetWithToken(ctx context.Context, token, url string) ([]byte, error) {
+	return c.doGetWithTokenAccept(ctx, token, url, "application/json")
+}
+
+// doGetWithTokenAccept is doGetWithToken with an explicit Accept header.
+func (c *Client) doGetWithTokenAccept(ctx context.Context, token, url, accept string) ([]byte, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Accept", accept)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -195,6 +412,82 @@ func (c *Client) doGetWithToken(ctx context.Context, token, url string) ([]byte,
 	return body, nil
 }
 
+// doStreamGetWithToken performs a GET request using an explicit Bearer token
+// and returns the response body unread. The caller must close it.
+func (c *Client) doStreamGetWithToken(ctx context.Context, token, url string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		cancel(nil)
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.streamClient.Do(req)
+	if err != nil {
+		cancel(nil)
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		cancel(nil)
+		return nil, &APIError{
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+		}
+	}
+
+	return newIdleTimeoutBody(ctx, resp.Body, streamTimeout, cancel), nil
+}
+
+// ErrStreamStalled is the cancellation cause of a streamed response that
+// stopped delivering data. It wraps context.DeadlineExceeded so callers
+// treat it as a timeout.
+var ErrStreamStalled = fmt.Errorf("response stalled: %w", context.DeadlineExceeded)
+
+// idleTimeoutBody cancels a streamed response whose body delivers no data
+// for timeout, so a stalled server cannot hang the caller. Only time spent
+// waiting in Read counts, not time the caller takes between reads.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func newIdleTimeoutBody(ctx context.Context, body io.ReadCloser, timeout time.Duration, cancel context.CancelCauseFunc) *idleTimeoutBody {
+	timer := time.AfterFunc(timeout, func() { cancel(ErrStreamStalled) })
+	timer.Stop() // started by each Read
+	return &idleTimeoutBody{
+		ReadCloser: body,
+		ctx:        ctx,
+		cancel:     cancel,
+		timer:      timer,
+		timeout:    timeout,
+	}
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	b.timer.Reset(b.timeout)
+	n, err := b.ReadCloser.Read(p)
+	b.timer.Stop()
+	if err != nil && errors.Is(context.Cause(b.ctx), ErrStreamStalled) {
+		err = fmt.Errorf("read response body: no data received for %s: %w", b.timeout, ErrStreamStalled)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	err := b.ReadCloser.Close()
+	b.cancel(nil)
+	return err
+}
+
 // NewAuthenticatedRequest creates an HTTP request with the Bearer token set.
 // This is used by the chunk downloader for streaming downloads with Range headers.
 func (c *Client) NewAuthenticatedRequest(ctx context.Context, method, url string) (*http.Request, error) {
@@ -211,27 +504,53 @@ func (c *Client) NewAuthenticatedRequest(ctx context.Context, method, url string
 	return req, nil
 }
 
+// AccessToken returns the token new requests are authenticated with,
+// refreshed first if it is about to expire.
+func (c *Client) AccessToken(ctx context.Context) (string, error) {
+	return c.tokenProvider.GetAccessToken(ctx)
+}
+
+// RefreshAccessToken replaces rejected, a token the server refused, with a
+// new one for later requests. It fails if the token provider cannot refresh
+// tokens on demand.
+func (c *Client) RefreshAccessToken(ctx context.Context, rejected string) error {
+	r, ok := c.tokenProvider.(auth.TokenRefresher)
+	if !ok {
+		return errors.New("token provider cannot refresh tokens")
+	}
+	_, err := r.RefreshAccessToken(ctx, rejected)
+	return err
+}
+
 // DoStreamRequest executes an HTTP request and returns the response without
 // reading the body. The caller is responsible for closing resp.Body.
-// This is used for streaming file downloads.
-func (c *Client) DoStreamRequest(req *http.Request) (*http.Response, error) {
-	// Use a separate client without the default timeout for streaming downloads,
-	// since large chunks may take longer than 60 seconds.
-	streamClient := &http.Client{}
-	resp, err := streamClient.Do(req)
+// This is used for streaming file downloads. A body that delivers no data
+// for idle (0 = 60 seconds) fails with ErrStreamStalled.
+func (c *Client) DoStreamRequest(req *http.Request, idle time.Duration) (*http.Response, error) {
+	if idle <= 0 {
+		idle = streamTimeout
+	}
+	ctx, cancel := context.WithCancelCause(req.Context())
+	req = req.WithContext(ctx)
+	// Use the stream client without the default timeout for streaming
+	// downloads, since large chunks may take longer than 60 seconds.
+	resp, err := c.streamClient.Do(req)
 	if err != nil {
+		cancel(nil)
 		return nil, fmt.Errorf("HTTP request failed: %w", err)
 	}
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
+		cancel(nil)
 		return nil, &APIError{
 			StatusCode: resp.StatusCode,
 			Body:       string(body),
 		}
 	}
 
+	resp.Body = newIdleTimeoutBody(ctx, resp.Body, idle, cancel)
 	return resp, nil
 }
 
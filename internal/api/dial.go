@@ -0,0 +1,203 @@
+package api
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// DialOptions tunes how a Client opens connections. The zero value uses
+// the defaults.
+type DialOptions struct {
+	KeepAlive     time.Duration // interval of TCP keep-alive probes; 0 = 30 seconds, negative = off
+	FallbackDelay time.Duration // wait for an IPv6 connection before also trying IPv4; 0 = 300 ms, negative = off
+	DNSCacheTTL   time.Duration // how long resolved host addresses are reused; 0 = 5 minutes, negative = not cached
+}
+
+// Dial defaults, those of http.DefaultTransport and net.Dialer.
+const (
+	dialTimeout          = 30 * time.Second
+	defaultKeepAlive     = 30 * time.Second
+	defaultFallbackDelay = 300 * time.Millisecond
+	defaultDNSCacheTTL   = 5 * time.Minute
+)
+
+// dialContext returns the DialContext of a transport that dials with o.
+// Host names are resolved through a cache, since the resolvers of some
+// clusters are slow or rate-limited, and would otherwise add seconds to each
+// of thousands of chunk requests.
+func (o DialOptions) dialContext() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := &net.Dialer{Timeout: dialTimeout, KeepAlive: o.KeepAlive, FallbackDelay: o.FallbackDelay}
+	if d.KeepAlive == 0 {
+		d.KeepAlive = defaultKeepAlive
+	}
+	if d.FallbackDelay == 0 {
+		d.FallbackDelay = defaultFallbackDelay
+	}
+	ttl := o.DNSCacheTTL
+	if ttl == 0 {
+		ttl = defaultDNSCacheTTL
+	}
+	if ttl < 0 {
+		return d.DialContext
+	}
+	cache := newDNSCache(net.DefaultResolver.LookupHost, ttl)
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return d.DialContext(ctx, network, addr)
+		}
+		ips, err := cache.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		return dialAddrs(ctx, d, network, ips, port)
+	}
+}
+
+// dnsCache resolves host names, reusing each answer for ttl. Concurrent
+// lookups of a host wait for the one in flight, and when a lookup fails the
+// expired answer before it is used instead.
+type dnsCache struct {
+	resolve func(ctx context.Context, host string) ([]string, error)
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+}
+
+type dnsEntry struct {
+	ready   chan struct{} // closed once the fields below are set
+	addrs   []string
+	err     error
+	expires time.Time
+}
+
+func newDNSCache(resolve func(ctx context.Context, host string) ([]string, error), ttl time.Duration) *dnsCache {
+	return &dnsCache{resolve: resolve, ttl: ttl, entries: make(map[string]*dnsEntry)}
+}
+
+// lookup returns the addresses of host.
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	prev := c.entries[host]
+	if prev != nil {
+		select {
+		case <-prev.ready:
+			if prev.err == nil && time.Now().Before(prev.expires) {
+				c.mu.Unlock()
+				return prev.addrs, nil
+			}
+		default:
+			c.mu.Unlock()
+			select {
+			case <-prev.ready:
+				return prev.addrs, prev.err
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+	e := &dnsEntry{ready: make(chan struct{})}
+	c.entries[host] = e
+	c.mu.Unlock()
+
+	e.addrs, e.err = c.resolve(ctx, host)
+	if e.err != nil && prev != nil && len(prev.addrs) > 0 {
+		e.addrs, e.err = prev.addrs, nil
+	}
+	if e.err == nil {
+		e.expires = time.Now().Add(c.ttl)
+	}
+	close(e.ready)
+	return e.addrs, e.err
+}
+
+// dialAddrs connects to port at one of ips, in order, as net.Dialer does
+// when it resolves the name itself: the addresses of the first one's family
+// one after another, racing those of the other family from d.FallbackDelay
+// on (RFC 6555).
+func dialAddrs(ctx context.Context, d *net.Dialer, network string, ips []string, port string) (net.Conn, error) {
+	var primaries, fallbacks []string
+	firstV4 := net.ParseIP(ips[0]).To4() != nil
+	for _, ip := range ips {
+		if d.FallbackDelay < 0 || (net.ParseIP(ip).To4() != nil) == firstV4 {
+			primaries = append(primaries, ip)
+		} else {
+			fallbacks = append(fallbacks, ip)
+		}
+	}
+	if len(fallbacks) == 0 {
+		return dialSerial(ctx, d, network, primaries, port)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, 2)
+	dial := func(ips []string) {
+		conn, err := dialSerial(ctx, d, network, ips, port)
+		results <- result{conn, err}
+	}
+	go dial(primaries)
+	timer := time.NewTimer(d.FallbackDelay)
+	defer timer.Stop()
+
+	pending, fallbackStarted := 1, false
+	var firstErr error
+	for {
+		select {
+		case <-timer.C:
+			if !fallbackStarted {
+				fallbackStarted = true
+				pending++
+				go dial(fallbacks)
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// The other race may still connect; close what it returns.
+				go func(n int) {
+					for range n {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if !fallbackStarted {
+				fallbackStarted = true
+				pending++
+				go dial(fallbacks)
+			} else if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// dialSerial connects to port at each of ips in turn until one succeeds.
+func dialSerial(ctx context.Context, d *net.Dialer, network string, ips []string, port string) (net.Conn, error) {
+	var firstErr error
+	for _, ip := range ips {
+		conn, err := d.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, firstErr
+}
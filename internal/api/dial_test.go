@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDNSCache(t *testing.T) {
+	var calls atomic.Int32
+	fail := atomic.Bool{}
+	release := make(chan struct{})
+	c := newDNSCache(func(ctx context.Context, host string) ([]string, error) {
+		calls.Add(1)
+		<-release
+		if fail.Load() {
+			return nil, errors.New("resolver down")
+		}
+		return []string{"192.0.2.1"}, nil
+	}, time.Hour)
+
+	// Concurrent lookups share one query.
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if addrs, err := c.lookup(context.Background(), "ega.example"); err != nil || len(addrs) != 1 {
+				t.Errorf("lookup = %v, %v", addrs, err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d queries for 10 concurrent lookups; want 1", n)
+	}
+
+	c.lookup(context.Background(), "ega.example")
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d queries after a cached lookup; want 1", n)
+	}
+
+	// Expired, with the resolver failing: the old answer is used.
+	c.entries["ega.example"].expires = time.Now().Add(-time.Second)
+	fail.Store(true)
+	if addrs, err := c.lookup(context.Background(), "ega.example"); err != nil || len(addrs) != 1 {
+		t.Errorf("lookup with the resolver down = %v, %v; want the expired answer", addrs, err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d queries after expiry; want 2", n)
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	dial := DialOptions{}.dialContext()
+	conn, err := dial(context.Background(), "tcp", net.JoinHostPort("localhost", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// An unreachable IPv6 address first: IPv4 is tried alongside it.
+	d := &net.Dialer{Timeout: 5 * time.Second, FallbackDelay: 50 * time.Millisecond}
+	conn, err = dialAddrs(context.Background(), d, "tcp", []string{"100::1", "127.0.0.1"}, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// LevelTrace is the log level for per-request HTTP tracing, below
+// slog.LevelDebug (enabled with -vv).
+const LevelTrace = slog.LevelDebug - 4
+
+// tracingTransport logs each HTTP request made through base at LevelTrace.
+// Headers are not logged, so bearer tokens never reach the log.
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+func (t tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if !slog.Default().Enabled(ctx, LevelTrace) {
+		return t.base.RoundTrip(req)
+	}
+
+	var reused bool
+	req = req.WithContext(httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused },
+	}))
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	attrs := []any{
+		"method", req.Method,
+		"url", req.URL.Redacted(),
+		"duration", time.Since(start).Round(time.Millisecond),
+		"reused_conn", reused,
+	}
+	if r := req.Header.Get("Range"); r != "" {
+		attrs = append(attrs, "range", r)
+	}
+	if err != nil {
+		slog.Log(ctx, LevelTrace, "HTTP request failed", append(attrs, "error", err)...)
+		return nil, err
+	}
+	slog.Log(ctx, LevelTrace, "HTTP response", append(attrs, "status", resp.StatusCode)...)
+	return resp, nil
+}
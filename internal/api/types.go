@@ -2,6 +2,27 @@ package api
 
 import "fmt"
 
+// DestinationFormat is the form, requested with the destinationFormat query
+// parameter, in which the data API serves a file.
+type DestinationFormat string
+
+// FormatPlain serves the decrypted content of a file. It is the format
+// egafetch downloads.
+const FormatPlain DestinationFormat = "plain"
+
+// ivSize is the length of the initialization vector that EGA's encrypted
+// copy of each file starts with. The fileSize of the metadata API counts it.
+const ivSize = 16
+
+// DownloadSize returns how many bytes the data API serves, in format, of a
+// file whose metadata gives fileSize: files served plain lack the IV.
+func DownloadSize(fileSize int64, format DestinationFormat) int64 {
+	if format != FormatPlain {
+		return fileSize
+	}
+	return max(fileSize-ivSize, 0)
+}
+
 // FileMetadata represents a file as returned by the EGA metadata API.
 type FileMetadata struct {
 	FileID              string `json:"fileId"`
@@ -14,6 +35,11 @@ type FileMetadata struct {
 	FileStatus          string `json:"fileStatus"`
 }
 
+// DownloadSize returns the size of the file as egafetch downloads it.
+func (f *FileMetadata) DownloadSize() int64 {
+	return DownloadSize(f.FileSize, FormatPlain)
+}
+
 // GetChecksum returns the best available checksum value and its inferred type.
 // The EGA API may return the checksum under different field names depending on
 // the API version (plainChecksum for v2, unencryptedChecksum for v1).
@@ -52,6 +78,11 @@ type DatasetFile struct {
 	FileStatus          string `json:"fileStatus"`
 }
 
+// DownloadSize returns the size of the file as egafetch downloads it.
+func (f *DatasetFile) DownloadSize() int64 {
+	return DownloadSize(f.FileSize, FormatPlain)
+}
+
 // GetChecksum returns the best available checksum value and its inferred type.
 func (f *DatasetFile) GetChecksum() (value string, checksumType string) {
 	// Only use plain/unencrypted checksums — the encrypted file's Checksum
@@ -83,10 +114,12 @@ type DatasetInfo struct {
 
 // DatasetDetails holds rich metadata from the EGA public metadata API.
 type DatasetDetails struct {
-	AccessionID string `json:"accession_id"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	NumSamples  int    `json:"num_samples"`
+	AccessionID       string `json:"accession_id"`
+	Title             string `json:"title"`
+	Description       string `json:"description"`
+	NumSamples        int    `json:"num_samples"`
+	PolicyAccessionID string `json:"policy_accession_id,omitempty"`
+	DACAccessionID    string `json:"dac_accession_id,omitempty"`
 }
 
 // DatasetMetadata holds all mapping data fetched from the EGA metadata API.
@@ -96,6 +129,31 @@ type DatasetMetadata struct {
 	StudyAnalysisSample      []map[string]interface{} `json:"study_analysis_sample"`
 	AnalysisSample           []map[string]interface{} `json:"analysis_sample"`
 	SampleFile               []map[string]interface{} `json:"sample_file"`
+	// Access is nil when the access conditions could not be fetched or the
+	// metadata was cached by an older version.
+	Access *AccessConditions `json:"access_conditions,omitempty"`
+}
+
+// AccessConditions describes who controls access to a dataset and on what
+// terms: the data access policy (including its data-use conditions) and the
+// Data Access Committee (DAC) with its contacts, as returned by the EGA
+// public metadata API.
+type AccessConditions struct {
+	DatasetID string                 `json:"dataset_accession_id"`
+	Policy    map[string]interface{} `json:"policy,omitempty"`
+	DAC       map[string]interface{} `json:"dac,omitempty"`
+}
+
+// PublicDatasetMetadata holds the dataset details and entity lists exposed
+// by the EGA public metadata API.
+type PublicDatasetMetadata struct {
+	Details     *DatasetDetails
+	Studies     []map[string]interface{}
+	Experiments []map[string]interface{}
+	Runs        []map[string]interface{}
+	Analyses    []map[string]interface{}
+	Samples     []map[string]interface{}
+	Files       []map[string]interface{}
 }
 
 // APIError represents an error response from the EGA API.
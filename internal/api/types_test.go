@@ -0,0 +1,24 @@
+package api
+
+import "testing"
+
+func TestDownloadSize(t *testing.T) {
+	for _, tt := range []struct {
+		fileSize int64
+		format   DestinationFormat
+		want     int64
+	}{
+		{1016, FormatPlain, 1000},
+		{16, FormatPlain, 0},
+		{10, FormatPlain, 0},
+		{1016, "crypt4gh", 1016},
+	} {
+		if got := DownloadSize(tt.fileSize, tt.format); got != tt.want {
+			t.Errorf("DownloadSize(%d, %q) = %d, want %d", tt.fileSize, tt.format, got, tt.want)
+		}
+	}
+	f := DatasetFile{FileSize: 1016}
+	if got := f.DownloadSize(); got != 1000 {
+		t.Errorf("DatasetFile.DownloadSize() = %d, want 1000", got)
+	}
+}
@@ -3,8 +3,10 @@ package auth
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
@@ -34,22 +36,49 @@ const (
 	metadataClientID      = "metadata-api"
 )
 
+// TokenEndpoints lists the EGA identity servers the manager calls, for
+// connectivity checks.
+var TokenEndpoints = []string{tokenEndpoint, metadataTokenEndpoint}
+
 // TokenProvider is the interface that the API client uses to get a valid
 // access token. This allows the API client to be tested with a mock.
 type TokenProvider interface {
 	GetAccessToken(ctx context.Context) (string, error)
 }
 
+// TokenRefresher is a TokenProvider that can replace a token the server
+// rejected before it expired by the provider's clock.
+type TokenRefresher interface {
+	TokenProvider
+	// RefreshAccessToken returns a new access token in place of rejected,
+	// or the current one if rejected has already been replaced.
+	RefreshAccessToken(ctx context.Context, rejected string) (string, error)
+}
+
+// ErrNotAuthenticated is returned when there is no usable session.
+var ErrNotAuthenticated = errors.New("not authenticated; run 'egafetch auth login' first")
+
+// AuthError is a rejection from an EGA token endpoint.
+type AuthError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("authentication error (%d): %s", e.StatusCode, e.Message)
+}
+
 // Manager manages OAuth2 authentication against the EGA AAI.
 // It implements TokenProvider and is safe for concurrent use.
 type Manager struct {
 	mu         sync.Mutex
 	creds      *Credentials
 	httpClient *http.Client
+	ephemeral  bool // keep tokens in memory only
 }
 
-// Compile-time check that Manager implements TokenProvider.
-var _ TokenProvider = (*Manager)(nil)
+// Compile-time check that Manager implements TokenRefresher.
+var _ TokenRefresher = (*Manager)(nil)
 
 // NewManager creates an auth manager. It attempts to load existing
 // credentials from disk. If none exist, methods that require authentication
@@ -65,6 +94,16 @@ func NewManager() (*Manager, error) {
 	}, nil
 }
 
+// NewEphemeralManager creates an unauthenticated auth manager that never
+// reads or writes the credentials file, for one-off logins (such as the EGA
+// test account) that must not replace the user's session.
+func NewEphemeralManager() *Manager {
+	return &Manager{
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		ephemeral:  true,
+	}
+}
+
 // Login authenticates with username and password, stores the resulting tokens.
 func (m *Manager) Login(ctx context.Context, username, password string) error {
 	m.mu.Lock()
@@ -83,6 +122,10 @@ func (m *Manager) Login(ctx context.Context, username, password string) error {
 	}
 	creds.Username = username
 	m.creds = creds
+	slog.Debug("Logged in", "username", username, "expires_at", creds.ExpiresAt)
+	if m.ephemeral {
+		return nil
+	}
 
 	if err := SaveCredentials(creds); err != nil {
 		return fmt.Errorf("failed to save credentials: %w", err)
@@ -97,7 +140,7 @@ func (m *Manager) GetAccessToken(ctx context.Context) (string, error) {
 	defer m.mu.Unlock()
 
 	if m.creds == nil {
-		return "", fmt.Errorf("not authenticated; run 'egafetch auth login' first")
+		return "", ErrNotAuthenticated
 	}
 
 	if !m.creds.IsExpired(tokenRefreshMargin) {
@@ -110,10 +153,28 @@ func (m *Manager) GetAccessToken(ctx context.Context) (string, error) {
 	return m.creds.AccessToken, nil
 }
 
+// RefreshAccessToken refreshes the session unless rejected is no longer its
+// access token, so that chunks rejected together refresh it once.
+func (m *Manager) RefreshAccessToken(ctx context.Context, rejected string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.creds == nil {
+		return "", ErrNotAuthenticated
+	}
+	if m.creds.AccessToken != rejected {
+		return m.creds.AccessToken, nil
+	}
+	if err := m.refreshLocked(ctx); err != nil {
+		return "", err
+	}
+	return m.creds.AccessToken, nil
+}
+
 // refreshLocked performs a token refresh. Caller must hold m.mu.
 func (m *Manager) refreshLocked(ctx context.Context) error {
 	if m.creds == nil || m.creds.RefreshToken == "" {
-		return fmt.Errorf("no refresh token available; run 'egafetch auth login'")
+		return fmt.Errorf("no refresh token available: %w", ErrNotAuthenticated)
 	}
 
 	creds, err := m.requestToken(ctx, tokenEndpoint, url.Values{
@@ -127,6 +188,10 @@ func (m *Manager) refreshLocked(ctx context.Context) error {
 	}
 	creds.Username = m.creds.Username
 	m.creds = creds
+	slog.Debug("Refreshed access token", "username", creds.Username, "expires_at", creds.ExpiresAt)
+	if m.ephemeral {
+		return nil
+	}
 
 	if err := SaveCredentials(creds); err != nil {
 		return fmt.Errorf("failed to save refreshed credentials: %w", err)
@@ -171,7 +236,7 @@ func (m *Manager) GetMetadataToken(ctx context.Context, password string) (string
 	m.mu.Unlock()
 
 	if username == "" {
-		return "", fmt.Errorf("not authenticated; run 'egafetch auth login' first")
+		return "", ErrNotAuthenticated
 	}
 
 	creds, err := m.requestToken(ctx, metadataTokenEndpoint, url.Values{
@@ -219,9 +284,9 @@ func (m *Manager) requestToken(ctx context.Context, endpoint string, params url.
 		var tokResp tokenResponse
 		_ = json.Unmarshal(body, &tokResp)
 		if tokResp.ErrorDesc != "" {
-			return nil, fmt.Errorf("authentication error (%d): %s", resp.StatusCode, tokResp.ErrorDesc)
+			return nil, &AuthError{StatusCode: resp.StatusCode, Message: tokResp.ErrorDesc}
 		}
-		return nil, fmt.Errorf("authentication error (%d): %s", resp.StatusCode, string(body))
+		return nil, &AuthError{StatusCode: resp.StatusCode, Message: string(body)}
 	}
 
 	var tokResp tokenResponse
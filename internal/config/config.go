@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"gopkg.in/yaml.v3"
 )
@@ -11,25 +12,121 @@ import (
 // Config holds persistent user defaults from ~/.egafetch/config.yaml.
 // Zero values mean "not set" — the caller should fall back to hardcoded defaults.
 type Config struct {
-	ChunkSize      string `yaml:"chunk_size"`
-	ParallelFiles  int    `yaml:"parallel_files"`
-	ParallelChunks int    `yaml:"parallel_chunks"`
-	MaxBandwidth   string `yaml:"max_bandwidth"`
-	OutputDir      string `yaml:"output_dir"`
-	MetadataFormat string `yaml:"metadata_format"`
+	ChunkSize         string `yaml:"chunk_size,omitempty"`
+	ParallelFiles     int    `yaml:"parallel_files,omitempty"`
+	ParallelChunks    int    `yaml:"parallel_chunks,omitempty"`
+	MaxBandwidth      string `yaml:"max_bandwidth,omitempty"`
+	OutputDir         string `yaml:"output_dir,omitempty"`
+	MetadataFormat    string `yaml:"metadata_format,omitempty"`
+	Webhook           string `yaml:"webhook,omitempty"`
+	WebhookFormat     string `yaml:"webhook_format,omitempty"`
+	TmpDir            string `yaml:"tmp_dir,omitempty"`
+	FileMode          string `yaml:"file_mode,omitempty"`
+	DirMode           string `yaml:"dir_mode,omitempty"`
+	Group             string `yaml:"group,omitempty"`
+	Progress          string `yaml:"progress,omitempty"`
+	ProgressInterval  string `yaml:"progress_interval,omitempty"`
+	LogMaxSize        string `yaml:"log_max_size,omitempty"`
+	LogMaxAge         string `yaml:"log_max_age,omitempty"`
+	Endpoints         string `yaml:"endpoints,omitempty"`
+	BufferSize        string `yaml:"buffer_size,omitempty"`
+	MaxMemory         string `yaml:"max_memory,omitempty"`
+	SmallFileSize     string `yaml:"small_file_size,omitempty"`
+	StallTimeout      string `yaml:"stall_timeout,omitempty"`
+	Drain             string `yaml:"drain,omitempty"`
+	StripeCount       string `yaml:"stripe_count,omitempty"`
+	StripeSize        string `yaml:"stripe_size,omitempty"`
+	DNSCacheTTL       string `yaml:"dns_cache_ttl,omitempty"`
+	DialKeepAlive     string `yaml:"dial_keep_alive,omitempty"`
+	DialFallbackDelay string `yaml:"dial_fallback_delay,omitempty"`
 }
 
 const configFileName = "config.yaml"
 
-// Load reads ~/.egafetch/config.yaml and returns the parsed config.
-// Returns a zero-valued Config (not an error) if the file does not exist.
-func Load() (*Config, error) {
+// Key describes one setting in config.yaml.
+type Key struct {
+	Name        string // YAML key, e.g. "chunk_size"
+	Env         string // environment variable overriding the file
+	Default     string // built-in default used when unset
+	Description string
+}
+
+// Keys lists every supported setting in config.yaml order.
+var Keys = []Key{
+	{"chunk_size", "EGAFETCH_CHUNK_SIZE", "64M", "Size of each download chunk"},
+	{"parallel_files", "EGAFETCH_PARALLEL_FILES", "4", "Files downloaded simultaneously"},
+	{"parallel_chunks", "EGAFETCH_PARALLEL_CHUNKS", "8", "Chunks per file downloaded simultaneously"},
+	{"max_bandwidth", "EGAFETCH_MAX_BANDWIDTH", "", "Global bandwidth limit (e.g., 100M)"},
+	{"output_dir", "EGAFETCH_OUTPUT_DIR", ".", "Default download directory"},
+	{"metadata_format", "EGAFETCH_METADATA_FORMAT", "tsv", "Default metadata format (tsv, csv, json, ndjson)"},
+	{"webhook", "EGAFETCH_WEBHOOK", "", "Webhook URL(s) notified by downloads, comma-separated"},
+	{"webhook_format", "EGAFETCH_WEBHOOK_FORMAT", "auto", "Webhook payload format (auto, json, slack, teams)"},
+	{"tmp_dir", "EGAFETCH_TMP_DIR", "", "Directory for chunk files (default: .egafetch/chunks in the output directory)"},
+	{"file_mode", "EGAFETCH_FILE_MODE", "", "Octal permissions for downloaded files (e.g., 0640)"},
+	{"dir_mode", "EGAFETCH_DIR_MODE", "", "Octal permissions for output directories (e.g., 2750)"},
+	{"group", "EGAFETCH_GROUP", "", "Group to own downloaded files and directories"},
+	{"progress", "EGAFETCH_PROGRESS", "auto", "Progress display (auto, ansi, plain, chunks, none)"},
+	{"progress_interval", "EGAFETCH_PROGRESS_INTERVAL", "30s", "How often plain progress prints a summary line (0 = never)"},
+	{"log_max_size", "EGAFETCH_LOG_MAX_SIZE", "100M", "Size at which a run log continues in a new file (0 = no limit)"},
+	{"log_max_age", "EGAFETCH_LOG_MAX_AGE", "720h", "How long run logs in .egafetch/logs are kept (0 = until 50 newer exist)"},
+	{"endpoints", "EGAFETCH_ENDPOINTS", "", "Data API base URLs downloads fail over between, comma-separated (default: central EGA)"},
+	{"buffer_size", "EGAFETCH_BUFFER_SIZE", "1M", "Read buffer of each chunk download (4K to 64M)"},
+	{"max_memory", "EGAFETCH_MAX_MEMORY", "", "Cap on the I/O buffers of downloads in flight, lowering parallelism to fit (e.g., 256M)"},
+	{"small_file_size", "EGAFETCH_SMALL_FILE_SIZE", "8M", "Files up to this size are downloaded in one request, without chunks (0 = never)"},
+	{"stall_timeout", "EGAFETCH_STALL_TIMEOUT", "60s", "Time a chunk's response may deliver no data before the chunk is retried"},
+	{"drain", "EGAFETCH_DRAIN", "0", "On interrupt, time to finish the chunks in flight before stopping (0 = stop at once)"},
+	{"stripe_count", "EGAFETCH_STRIPE_COUNT", "0", "On Lustre, OSTs to stripe output files of 1 GiB or more over (-1 = all, 0 = filesystem default)"},
+	{"stripe_size", "EGAFETCH_STRIPE_SIZE", "", "On Lustre, stripe size of output files of 1 GiB or more, a multiple of 64K (e.g., 4M)"},
+	{"dns_cache_ttl", "EGAFETCH_DNS_CACHE_TTL", "5m", "How long resolved server addresses are reused (0 = resolve every connection)"},
+	{"dial_keep_alive", "EGAFETCH_DIAL_KEEP_ALIVE", "30s", "Interval of TCP keep-alive probes (0 = off)"},
+	{"dial_fallback_delay", "EGAFETCH_DIAL_FALLBACK_DELAY", "300ms", "Wait for IPv6 before also trying IPv4 (0 = addresses one at a time)"},
+}
+
+// LookupKey returns the Key named name.
+func LookupKey(name string) (Key, bool) {
+	for _, k := range Keys {
+		if k.Name == name {
+			return k, true
+		}
+	}
+	return Key{}, false
+}
+
+// Path returns the location of config.yaml.
+func Path() (string, error) {
 	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("get home directory: %w", err)
+	}
+	return filepath.Join(home, ".egafetch", configFileName), nil
+}
+
+// Load reads ~/.egafetch/config.yaml and applies EGAFETCH_* environment
+// overrides on top (see Keys). Returns a zero-valued Config (not an error) if
+// the file does not exist.
+func Load() (*Config, error) {
+	cfg, err := LoadFile()
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range Keys {
+		if v := os.Getenv(k.Env); v != "" {
+			if err := cfg.Set(k.Name, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", k.Env, err)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// LoadFile reads ~/.egafetch/config.yaml without environment overrides.
+// Returns a zero-valued Config (not an error) if the file does not exist.
+func LoadFile() (*Config, error) {
+	path, err := Path()
 	if err != nil {
 		return &Config{}, nil
 	}
 
-	path := filepath.Join(home, ".egafetch", configFileName)
 	data, err := os.ReadFile(path)
 	if os.IsNotExist(err) {
 		return &Config{}, nil
@@ -45,3 +142,168 @@ func Load() (*Config, error) {
 
 	return &cfg, nil
 }
+
+// Save writes cfg to ~/.egafetch/config.yaml.
+func Save(cfg *Config) error {
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create config directory: %w", err)
+	}
+
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("encode config: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("write config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Get returns the value of the named setting as a string, or "" if unset.
+func (c *Config) Get(name string) (string, error) {
+	switch name {
+	case "chunk_size":
+		return c.ChunkSize, nil
+	case "parallel_files":
+		return formatInt(c.ParallelFiles), nil
+	case "parallel_chunks":
+		return formatInt(c.ParallelChunks), nil
+	case "max_bandwidth":
+		return c.MaxBandwidth, nil
+	case "output_dir":
+		return c.OutputDir, nil
+	case "metadata_format":
+		return c.MetadataFormat, nil
+	case "webhook":
+		return c.Webhook, nil
+	case "webhook_format":
+		return c.WebhookFormat, nil
+	case "tmp_dir":
+		return c.TmpDir, nil
+	case "file_mode":
+		return c.FileMode, nil
+	case "dir_mode":
+		return c.DirMode, nil
+	case "group":
+		return c.Group, nil
+	case "progress":
+		return c.Progress, nil
+	case "progress_interval":
+		return c.ProgressInterval, nil
+	case "log_max_size":
+		return c.LogMaxSize, nil
+	case "log_max_age":
+		return c.LogMaxAge, nil
+	case "endpoints":
+		return c.Endpoints, nil
+	case "buffer_size":
+		return c.BufferSize, nil
+	case "max_memory":
+		return c.MaxMemory, nil
+	case "small_file_size":
+		return c.SmallFileSize, nil
+	case "stall_timeout":
+		return c.StallTimeout, nil
+	case "drain":
+		return c.Drain, nil
+	case "stripe_count":
+		return c.StripeCount, nil
+	case "stripe_size":
+		return c.StripeSize, nil
+	case "dns_cache_ttl":
+		return c.DNSCacheTTL, nil
+	case "dial_keep_alive":
+		return c.DialKeepAlive, nil
+	case "dial_fallback_delay":
+		return c.DialFallbackDelay, nil
+	}
+	return "", fmt.Errorf("unknown config key %q", name)
+}
+
+// Set assigns the named setting from its string form. An empty value
+// clears the setting.
+func (c *Config) Set(name, value string) error {
+	switch name {
+	case "chunk_size":
+		c.ChunkSize = value
+	case "parallel_files":
+		return parseInt(value, &c.ParallelFiles)
+	case "parallel_chunks":
+		return parseInt(value, &c.ParallelChunks)
+	case "max_bandwidth":
+		c.MaxBandwidth = value
+	case "output_dir":
+		c.OutputDir = value
+	case "metadata_format":
+		c.MetadataFormat = value
+	case "webhook":
+		c.Webhook = value
+	case "webhook_format":
+		c.WebhookFormat = value
+	case "tmp_dir":
+		c.TmpDir = value
+	case "file_mode":
+		c.FileMode = value
+	case "dir_mode":
+		c.DirMode = value
+	case "group":
+		c.Group = value
+	case "progress":
+		c.Progress = value
+	case "progress_interval":
+		c.ProgressInterval = value
+	case "log_max_size":
+		c.LogMaxSize = value
+	case "log_max_age":
+		c.LogMaxAge = value
+	case "endpoints":
+		c.Endpoints = value
+	case "buffer_size":
+		c.BufferSize = value
+	case "max_memory":
+		c.MaxMemory = value
+	case "small_file_size":
+		c.SmallFileSize = value
+	case "stall_timeout":
+		c.StallTimeout = value
+	case "drain":
+		c.Drain = value
+	case "stripe_count":
+		c.StripeCount = value
+	case "stripe_size":
+		c.StripeSize = value
+	case "dns_cache_ttl":
+		c.DNSCacheTTL = value
+	case "dial_keep_alive":
+		c.DialKeepAlive = value
+	case "dial_fallback_delay":
+		c.DialFallbackDelay = value
+	default:
+		return fmt.Errorf("unknown config key %q", name)
+	}
+	return nil
+}
+
+func formatInt(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+func parseInt(value string, dst *int) error {
+	if value == "" {
+		*dst = 0
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return fmt.Errorf("expected a positive integer, got %q", value)
+	}
+	*dst = n
+	return nil
+}
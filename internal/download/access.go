@@ -0,0 +1,57 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+// AccessError is a file download refused by the data API: with 401
+// Unauthorized because the access token is invalid or expired, or with 403
+// Forbidden because the user is not authorized for the file's dataset. It
+// wraps the *api.APIError.
+type AccessError struct {
+	FileID    string
+	FileName  string
+	DatasetID string // "" if not known
+	Err       *api.APIError
+}
+
+func (e *AccessError) Error() string {
+	file := fmt.Sprintf("%s (%s)", e.FileName, e.FileID)
+	if e.Err.StatusCode == http.StatusUnauthorized {
+		return file + ": access token invalid or expired; run `egafetch auth login` again"
+	}
+	dataset := "the file's dataset"
+	if e.DatasetID != "" {
+		dataset = "dataset " + e.DatasetID
+	}
+	return fmt.Sprintf("%s: you are not authorized for %s (check that your DAC approval is active)", file, dataset)
+}
+
+func (e *AccessError) Unwrap() error { return e.Err }
+
+// accessError returns err as an *AccessError for spec, of dataset
+// datasetID, if the data API refused it with 401 or 403, and err otherwise.
+func accessError(ctx context.Context, err error, spec state.FileSpec, datasetID string) error {
+	apiErr := refusal(err)
+	if apiErr == nil {
+		return err
+	}
+	slog.DebugContext(ctx, "Data API refused file", "file_id", spec.FileID, "error", err)
+	return &AccessError{FileID: spec.FileID, FileName: spec.FileName, DatasetID: datasetID, Err: apiErr}
+}
+
+// refusal returns the 401 or 403 response that err wraps, if any.
+func refusal(err error) *api.APIError {
+	var apiErr *api.APIError
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden) {
+		return apiErr
+	}
+	return nil
+}
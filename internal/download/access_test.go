@@ -0,0 +1,39 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+func TestAccessError(t *testing.T) {
+	spec := state.FileSpec{FileID: "EGAF00000000001", FileName: "a.bam"}
+	refused := func(status int) error {
+		return fmt.Errorf("chunk 0 failed: non-retryable error: %w", &api.APIError{StatusCode: status, Body: `{"detail":"forbidden"}`})
+	}
+
+	err := accessError(context.Background(), refused(403), spec, "EGAD00000000001")
+	if want := "a.bam (EGAF00000000001): you are not authorized for dataset EGAD00000000001"; !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("403 error = %q, want it to start with %q", err, want)
+	}
+	var apiErr *api.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 403 {
+		t.Errorf("403 error %v does not wrap the API error", err)
+	}
+	if err := accessError(context.Background(), refused(403), spec, ""); !strings.Contains(err.Error(), "the file's dataset") {
+		t.Errorf("403 error without a dataset = %q", err)
+	}
+	if err := accessError(context.Background(), refused(401), spec, ""); !strings.Contains(err.Error(), "egafetch auth login") {
+		t.Errorf("401 error = %q, want the login command", err)
+	}
+
+	plain := refused(500)
+	if err := accessError(context.Background(), plain, spec, "EGAD00000000001"); err != plain {
+		t.Errorf("500 error = %v, want it unchanged", err)
+	}
+}
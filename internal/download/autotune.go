@@ -0,0 +1,257 @@
+package download
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Automatic parallelism tuning constants.
+const (
+	autoTuneInterval    = 10 * time.Second // throughput measurement period
+	autoTuneStartChunks = 4                // chunks per file at the start
+	autoTuneGain        = 1.1              // throughput gain that justifies more connections
+	autoTuneReprobe     = 6                // settled intervals before trying more connections again
+)
+
+// slotPool limits how many goroutines hold a slot at once. Unlike a channel
+// semaphore, its limit can change while goroutines are waiting.
+type slotPool struct {
+	mu      sync.Mutex
+	limit   int
+	active  int
+	changed chan struct{} // closed and replaced whenever a slot may have opened
+}
+
+func newSlotPool(limit int) *slotPool {
+	return &slotPool{limit: limit, changed: make(chan struct{})}
+}
+
+// acquire blocks until a slot is free or ctx is done.
+func (p *slotPool) acquire(ctx context.Context) error {
+	for {
+		p.mu.Lock()
+		if p.active < p.limit {
+			p.active++
+			p.mu.Unlock()
+			return nil
+		}
+		changed := p.changed
+		p.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release frees a slot taken with acquire.
+func (p *slotPool) release() {
+	p.mu.Lock()
+	p.active--
+	p.notifyLocked()
+	p.mu.Unlock()
+}
+
+// setLimit changes the number of slots. Lowering it does not interrupt
+// holders; it only keeps new ones waiting until enough have released.
+func (p *slotPool) setLimit(limit int) {
+	p.mu.Lock()
+	p.limit = limit
+	p.notifyLocked()
+	p.mu.Unlock()
+}
+
+func (p *slotPool) notifyLocked() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// autoTuner adjusts the number of parallel files and chunks per file from
+// the aggregate throughput. It starts with one file and a few chunks, and
+// doubles chunks, then files, for as long as each step raises throughput by
+// autoTuneGain; a step that does not is undone. When the server throttles
+// (HTTP 429 or 503), it halves files, then chunks, instead. The configured
+// parallelism is the upper bound.
+type autoTuner struct {
+	bytes     atomic.Int64 // received since the last measurement
+	throttled atomic.Int64 // HTTP 429 and 503 responses since the last measurement
+
+	mu                    sync.Mutex
+	maxFiles, maxChunks   int
+	files, chunks         int
+	prevFiles, prevChunks int     // setting before the last increase
+	lastRate              float64 // bytes/sec at the current setting; 0 before the first measurement
+	settled               bool
+	idle                  int // measurements since settling
+	fileSlots             *slotPool
+	chunkSlots            map[*slotPool]struct{} // one per file being downloaded
+}
+
+func newAutoTuner(maxFiles, maxChunks int) *autoTuner {
+	t := &autoTuner{
+		maxFiles:   maxFiles,
+		maxChunks:  maxChunks,
+		files:      1,
+		chunks:     min(autoTuneStartChunks, maxChunks),
+		chunkSlots: make(map[*slotPool]struct{}),
+	}
+	t.fileSlots = newSlotPool(t.files)
+	return t
+}
+
+// parallelism returns the current number of parallel files and chunks.
+func (t *autoTuner) parallelism() (files, chunks int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.files, t.chunks
+}
+
+// newChunkSlots returns a chunk pool for one file that follows the tuned
+// chunk count until released with dropChunkSlots.
+func (t *autoTuner) newChunkSlots() *slotPool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := newSlotPool(t.chunks)
+	t.chunkSlots[p] = struct{}{}
+	return p
+}
+
+func (t *autoTuner) dropChunkSlots(p *slotPool) {
+	t.mu.Lock()
+	delete(t.chunkSlots, p)
+	t.mu.Unlock()
+}
+
+// run measures throughput every autoTuneInterval and applies the resulting
+// parallelism until ctx is done.
+func (t *autoTuner) run(ctx context.Context) {
+	ticker := time.NewTicker(autoTuneInterval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n := t.bytes.Swap(0)
+			elapsed := now.Sub(last)
+			last = now
+			if t.throttled.Swap(0) > 0 {
+				t.backOff(ctx)
+				continue
+			}
+			// Nothing in flight (for example while files merge) says
+			// nothing about the network.
+			if n == 0 || elapsed <= 0 {
+				continue
+			}
+			t.adjust(ctx, float64(n)/elapsed.Seconds())
+		}
+	}
+}
+
+// adjust feeds one measurement to step and resizes the pools if it changed
+// the setting.
+func (t *autoTuner) adjust(ctx context.Context, rate float64) {
+	t.mu.Lock()
+	changed := t.step(rate)
+	files, chunks := t.files, t.chunks
+	pools := make([]*slotPool, 0, len(t.chunkSlots))
+	for p := range t.chunkSlots {
+		pools = append(pools, p)
+	}
+	t.mu.Unlock()
+
+	slog.DebugContext(ctx, "Auto-tune measured throughput", "bytes_per_sec", int64(rate), "parallel_files", files, "parallel_chunks", chunks, "changed", changed)
+	if !changed {
+		return
+	}
+	t.fileSlots.setLimit(files)
+	for _, p := range pools {
+		p.setLimit(chunks)
+	}
+}
+
+// backOff halves the parallelism after the server throttled requests, and
+// resizes the pools.
+func (t *autoTuner) backOff(ctx context.Context) {
+	t.mu.Lock()
+	changed := t.shrink()
+	files, chunks := t.files, t.chunks
+	pools := make([]*slotPool, 0, len(t.chunkSlots))
+	for p := range t.chunkSlots {
+		pools = append(pools, p)
+	}
+	t.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	slog.WarnContext(ctx, "Server is throttling requests; lowering parallelism", "parallel_files", files, "parallel_chunks", chunks)
+	t.fileSlots.setLimit(files)
+	for _, p := range pools {
+		p.setLimit(chunks)
+	}
+}
+
+// shrink halves files, or chunks per file once there is one file, and
+// settles, so that more connections are only tried again after
+// autoTuneReprobe measurements. It reports whether the setting changed.
+// t.mu must be held.
+func (t *autoTuner) shrink() bool {
+	t.settled, t.idle = true, 0
+	switch {
+	case t.files > 1:
+		t.files = max(t.files/2, 1)
+	case t.chunks > 1:
+		t.chunks = max(t.chunks/2, 1)
+	default:
+		return false
+	}
+	return true
+}
+
+// step records the throughput measured at the current setting and picks the
+// next one. It reports whether the setting changed. t.mu must be held.
+func (t *autoTuner) step(rate float64) bool {
+	if t.settled {
+		t.idle++
+		if t.idle < autoTuneReprobe {
+			return false
+		}
+		// Conditions may have changed; see whether more connections help now.
+		t.settled = false
+		t.lastRate = rate
+		return t.grow()
+	}
+	if t.lastRate == 0 || rate >= t.lastRate*autoTuneGain {
+		t.lastRate = rate
+		return t.grow()
+	}
+	// The last increase did not pay off: undo it.
+	t.files, t.chunks = t.prevFiles, t.prevChunks
+	t.settled, t.idle = true, 0
+	return true
+}
+
+// grow doubles chunks per file, or files once chunks are at the maximum, and
+// settles when both are. t.mu must be held.
+func (t *autoTuner) grow() bool {
+	t.prevFiles, t.prevChunks = t.files, t.chunks
+	switch {
+	case t.chunks < t.maxChunks:
+		t.chunks = min(t.chunks*2, t.maxChunks)
+	case t.files < t.maxFiles:
+		t.files = min(t.files*2, t.maxFiles)
+	default:
+		t.settled, t.idle = true, 0
+		return false
+	}
+	return true
+}
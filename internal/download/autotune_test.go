@@ -0,0 +1,118 @@
+package download
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+)
+
+func TestAutoTunerStep(t *testing.T) {
+	type setting struct{ files, chunks int }
+	tests := []struct {
+		name  string
+		rates []float64 // MB/s, one per measurement
+		want  []setting // setting after each measurement
+	}{
+		{name: "grows chunks then files while throughput rises",
+			rates: []float64{10, 20, 40, 60},
+			want:  []setting{{1, 8}, {1, 16}, {2, 16}, {4, 16}}},
+		{name: "undoes a step without gain and settles",
+			rates: []float64{10, 20, 21, 30, 30},
+			want:  []setting{{1, 8}, {1, 16}, {1, 8}, {1, 8}, {1, 8}}},
+		{name: "probes again after settling",
+			rates: []float64{10, 10, 10, 10, 10, 10, 10, 10},
+			want:  []setting{{1, 8}, {1, 4}, {1, 4}, {1, 4}, {1, 4}, {1, 4}, {1, 4}, {1, 8}}},
+		{name: "stops at the maximum",
+			rates: []float64{10, 20, 40, 80, 160, 320},
+			want:  []setting{{1, 8}, {1, 16}, {2, 16}, {4, 16}, {4, 16}, {4, 16}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tuner := newAutoTuner(4, 16)
+			if tuner.files != 1 || tuner.chunks != autoTuneStartChunks {
+				t.Fatalf("start = %dx%d, want 1x%d", tuner.files, tuner.chunks, autoTuneStartChunks)
+			}
+			for i, mbps := range tt.rates {
+				tuner.step(mbps * 1024 * 1024)
+				if got := (setting{tuner.files, tuner.chunks}); got != tt.want[i] {
+					t.Fatalf("after measurement %d (%v MB/s): %dx%d, want %dx%d",
+						i+1, mbps, got.files, got.chunks, tt.want[i].files, tt.want[i].chunks)
+				}
+			}
+		})
+	}
+}
+
+func TestSlotPoolSetLimit(t *testing.T) {
+	p := newSlotPool(1)
+	ctx := context.Background()
+	if err := p.acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		if err := p.acquire(ctx); err == nil {
+			close(acquired)
+		}
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("second acquire succeeded with limit 1")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	p.setLimit(2)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("raising the limit did not release the waiter")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := p.acquire(ctx); err == nil {
+		t.Error("acquire beyond the limit succeeded on a cancelled context")
+	}
+}
+
+func TestAutoTunerBackOff(t *testing.T) {
+	tuner := newAutoTuner(4, 16)
+	for _, mbps := range []float64{10, 20, 40, 60} {
+		tuner.step(mbps * 1024 * 1024)
+	}
+	if tuner.files != 4 || tuner.chunks != 16 {
+		t.Fatalf("grown to %dx%d, want 4x16", tuner.files, tuner.chunks)
+	}
+	pool := tuner.newChunkSlots()
+
+	// Files are halved first, then chunks, down to one of each.
+	want := [][2]int{{2, 16}, {1, 16}, {1, 8}, {1, 4}, {1, 2}, {1, 1}, {1, 1}}
+	for i, w := range want {
+		tuner.backOff(context.Background())
+		if tuner.files != w[0] || tuner.chunks != w[1] {
+			t.Fatalf("after back-off %d: %dx%d, want %dx%d", i+1, tuner.files, tuner.chunks, w[0], w[1])
+		}
+	}
+	if pool.limit != 1 || tuner.fileSlots.limit != 1 {
+		t.Errorf("pools not resized: %d files, %d chunks", tuner.fileSlots.limit, pool.limit)
+	}
+	if !isThrottled(&api.APIError{StatusCode: 429}) || !isThrottled(&api.APIError{StatusCode: 503}) || isThrottled(&api.APIError{StatusCode: 500}) {
+		t.Error("isThrottled misclassifies status codes")
+	}
+
+	// More connections are tried again only after settling.
+	for i := 0; i < autoTuneReprobe-1; i++ {
+		tuner.step(10 * 1024 * 1024)
+	}
+	if tuner.chunks != 1 {
+		t.Fatalf("grew to %d chunks before reprobing", tuner.chunks)
+	}
+	tuner.step(10 * 1024 * 1024)
+	if tuner.chunks != 2 {
+		t.Errorf("reprobe after throttling: %d chunks, want 2", tuner.chunks)
+	}
+}
@@ -0,0 +1,94 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// DefaultBufferSize is the size of the buffer each chunk download reads
+// into when DownloadOptions.BufferSize is 0.
+const DefaultBufferSize = 1 << 20
+
+// bufferPools holds a *sync.Pool of *[]byte buffers for each buffer size in
+// use, shared by every chunk download so that buffers are reused across
+// attempts, chunks, and files instead of allocated for each.
+var bufferPools sync.Map
+
+// getBuffer returns a buffer of size bytes (DefaultBufferSize if size is 0)
+// from its pool. Return it with putBuffer.
+func getBuffer(size int) *[]byte {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	p, _ := bufferPools.LoadOrStore(size, &sync.Pool{
+		New: func() any {
+			b := make([]byte, size)
+			return &b
+		},
+	})
+	return p.(*sync.Pool).Get().(*[]byte)
+}
+
+// putBuffer returns a buffer from getBuffer to its pool.
+func putBuffer(b *[]byte) {
+	if p, ok := bufferPools.Load(len(*b)); ok {
+		p.(*sync.Pool).Put(b)
+	}
+}
+
+// waitBytes waits until limiter allows n bytes, in steps no larger than its
+// burst, which a large read can exceed.
+func waitBytes(ctx context.Context, limiter *rate.Limiter, n int) error {
+	for n > 0 {
+		step := min(n, limiter.Burst())
+		if err := limiter.WaitN(ctx, step); err != nil {
+			return err
+		}
+		n -= step
+	}
+	return nil
+}
+
+// bufferMemory returns the bytes of buffers that downloads with opts hold at
+// most: for each request in flight a read buffer, the buffer its response
+// is sniffed with, and with direct I/O a write buffer, and for each file a
+// partial merge's buffers. Unless opts.NoHedge, every chunk may also have a
+// hedged request in flight.
+func bufferMemory(opts DownloadOptions) int64 {
+	stream, merge := int64(opts.BufferSize), int64(0)
+	if stream <= 0 {
+		stream = DefaultBufferSize
+	}
+	stream += sniffSize
+	if opts.DirectIO && DirectIOSupported {
+		stream += directBufferSize
+		merge = 2 * directBufferSize
+	}
+	streams := int64(opts.ParallelChunks)
+	if !opts.NoHedge {
+		streams *= 2
+	}
+	return int64(opts.ParallelFiles) * (streams*stream + merge)
+}
+
+// FitMemory lowers opts.ParallelChunks, then opts.ParallelFiles, until the
+// buffers of the downloads running at once fit in budget bytes, and reports
+// whether it had to. It fails if even one chunk at a time does not fit.
+func FitMemory(opts *DownloadOptions, budget int64) (bool, error) {
+	files, chunks := opts.ParallelFiles, opts.ParallelChunks
+	one := *opts
+	one.ParallelFiles, one.ParallelChunks = 1, 1
+	if need := bufferMemory(one); need > budget {
+		return false, fmt.Errorf("memory budget of %d bytes is below the %d bytes one chunk download needs; use a smaller buffer size", budget, need)
+	}
+	for opts.ParallelChunks > 1 && bufferMemory(*opts) > budget {
+		opts.ParallelChunks--
+	}
+	for opts.ParallelFiles > 1 && bufferMemory(*opts) > budget {
+		opts.ParallelFiles--
+	}
+	return opts.ParallelFiles != files || opts.ParallelChunks != chunks, nil
+}
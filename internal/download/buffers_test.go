@@ -0,0 +1,51 @@
+package download
+
+import (
+	"context"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestBuffers(t *testing.T) {
+	b := getBuffer(0)
+	if len(*b) != DefaultBufferSize {
+		t.Errorf("default buffer has %d bytes, want %d", len(*b), DefaultBufferSize)
+	}
+	putBuffer(b)
+	if b := getBuffer(64 << 10); len(*b) != 64<<10 {
+		t.Errorf("64K buffer has %d bytes", len(*b))
+	}
+
+	// A read larger than the limiter's burst is let through in steps.
+	limiter := rate.NewLimiter(rate.Inf, 256*1024)
+	if err := waitBytes(context.Background(), limiter, 4<<20); err != nil {
+		t.Errorf("waitBytes past the burst: %v", err)
+	}
+}
+
+func TestFitMemory(t *testing.T) {
+	opts := DownloadOptions{ParallelFiles: 4, ParallelChunks: 8, BufferSize: 1 << 20, NoHedge: true}
+	reduced, err := FitMemory(&opts, 10<<20)
+	if err != nil || !reduced || opts.ParallelFiles != 4 || opts.ParallelChunks != 2 {
+		t.Errorf("10M budget: %d files x %d chunks, %v, %v; want 4 x 2", opts.ParallelFiles, opts.ParallelChunks, reduced, err)
+	}
+	// Each request also has a sniff buffer: 3 chunks do not fit in 3M.
+	reduced, err = FitMemory(&opts, 3<<20)
+	if err != nil || !reduced || opts.ParallelFiles != 2 || opts.ParallelChunks != 1 {
+		t.Errorf("3M budget: %d files x %d chunks, %v, %v; want 2 x 1", opts.ParallelFiles, opts.ParallelChunks, reduced, err)
+	}
+
+	// With hedging, each chunk may hold the buffers of two requests.
+	hedged := DownloadOptions{ParallelFiles: 4, ParallelChunks: 8, BufferSize: 1 << 20}
+	reduced, err = FitMemory(&hedged, 10<<20)
+	if err != nil || !reduced || hedged.ParallelFiles != 4 || hedged.ParallelChunks != 1 {
+		t.Errorf("10M budget with hedging: %d files x %d chunks, %v, %v; want 4 x 1", hedged.ParallelFiles, hedged.ParallelChunks, reduced, err)
+	}
+	if reduced, _ := FitMemory(&opts, 1<<30); reduced {
+		t.Error("parallelism changed under a budget it fits")
+	}
+	if _, err := FitMemory(&opts, 512<<10); err == nil {
+		t.Error("a budget below one buffer was accepted")
+	}
+}
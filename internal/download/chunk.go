@@ -1,15 +1,19 @@
 package download
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"math/rand"
+	"mime"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -19,9 +23,11 @@ import (
 )
 
 const (
-	maxChunkRetries = 5
-	baseDelay       = 1 * time.Second
-	maxDelay        = 60 * time.Second
+	maxChunkRetries  = 5
+	maxTokenReissues = 3          // requests re-issued with a new token per Download, on top of the retries
+	resumeOverlap    = 256 * 1024 // bytes at the end of a chunk file downloaded again on resume
+	baseDelay        = 1 * time.Second
+	maxDelay         = 60 * time.Second
 )
 
 // BytesWrittenCallback is called during streaming with the number of new bytes written.
@@ -34,6 +40,19 @@ type ChunkDownloader struct {
 	chunksDir      string
 	onBytesWritten BytesWrittenCallback
 	limiter        *rate.Limiter // nil = no throttling
+
+	// With endpoints set, each attempt downloads fileID from the current
+	// endpoint instead of downloadURL.
+	endpoints *endpointSet
+	fileID    string
+
+	tuner        *autoTuner    // told of throttled requests; nil unless DownloadOptions.AutoTune
+	directIO     bool          // write the chunk file with O_DIRECT (DownloadOptions.DirectIO)
+	bufferSize   int           // bytes read at a time (DownloadOptions.BufferSize); 0 = DefaultBufferSize
+	path         string        // the file to write; "" = the chunk's file in chunksDir
+	stallTimeout time.Duration // abandon a response that delivers no data for this long; 0 = 60 seconds
+	retries      *retryBudget  // shared with all chunk downloads; nil = each retries on its own
+	run          *chunkRun     // progress published to the goroutines watching the download; nil = none
 }
 
 // NewChunkDownloader creates a chunk downloader for the given file.
@@ -49,10 +68,14 @@ func NewChunkDownloader(apiClient *api.Client, downloadURL string, chunksDir str
 
 // Download downloads the chunk with retry logic and exponential backoff.
 func (d *ChunkDownloader) Download(ctx context.Context, chunk *state.ChunkState) error {
+	if err := d.trimTail(chunk); err != nil {
+		return err
+	}
 	var lastErr error
+	reissues, reissue := 0, false
 
 	for attempt := 0; attempt <= maxChunkRetries; attempt++ {
-		if attempt > 0 {
+		if attempt > 0 && !reissue {
 			delay := baseDelay * time.Duration(1<<(attempt-1))
 			if delay > maxDelay {
 				delay = maxDelay
@@ -65,8 +88,41 @@ func (d *ChunkDownloader) Download(ctx context.Context, chunk *state.ChunkState)
 			case <-time.After(delay + jitter):
 			}
 		}
+		reissue = false
 
-		lastErr = d.attemptDownload(ctx, chunk)
+		if d.retries != nil {
+			if err := d.retries.wait(ctx); err != nil {
+				return err
+			}
+		}
+		url, endpoint := d.downloadURL, 0
+		if d.endpoints != nil {
+			url, endpoint = d.endpoints.fileURL(d.fileID)
+		}
+		lastErr = d.attemptDownload(ctx, chunk, url)
+		var expired *tokenExpiredError
+		if errors.As(lastErr, &expired) && reissues < maxTokenReissues && expired.renew(ctx, d.apiClient) == nil {
+			// Not a failure of the chunk or the server: resume from the
+			// bytes on disk at once, without using up a retry.
+			if d.retries != nil {
+				d.retries.report(ctx, nil)
+			}
+			slog.DebugContext(ctx, "Access token expired; re-issuing chunk request", "chunk", chunk.Index,
+				"offset", chunk.Start+chunk.BytesDownloaded, "error", expired.err)
+			reissues++
+			reissue = true
+			attempt--
+			continue
+		}
+		if d.retries != nil {
+			d.retries.report(ctx, lastErr)
+		}
+		if d.endpoints != nil {
+			d.endpoints.report(ctx, endpoint, lastErr)
+		}
+		if d.tuner != nil && isThrottled(lastErr) {
+			d.tuner.throttled.Add(1)
+		}
 		if lastErr == nil {
 			return nil
 		}
@@ -75,15 +131,85 @@ func (d *ChunkDownloader) Download(ctx context.Context, chunk *state.ChunkState)
 			return fmt.Errorf("non-retryable error: %w", lastErr)
 		}
 
+		slog.DebugContext(ctx, "Chunk attempt failed, retrying", "url", url, "chunk", chunk.Index,
+			"attempt", attempt+1, "error", lastErr)
 		chunk.RetryCount++
 		chunk.Status = state.ChunkFailed
+		d.publish(chunk)
 	}
 
 	return fmt.Errorf("chunk %d failed after %d retries: %w", chunk.Index, maxChunkRetries, lastErr)
 }
 
-// attemptDownload performs a single download attempt for a chunk.
-func (d *ChunkDownloader) attemptDownload(ctx context.Context, chunk *state.ChunkState) error {
+// checkContentRange checks that resp holds the bytes from rangeStart to
+// rangeEnd (inclusive) requested for a chunk starting at chunkStart: a
+// 206 response must say so in its Content-Range, and a 200 response, with
+// the whole file, only serves a chunk at its start.
+func checkContentRange(resp *http.Response, chunkStart, rangeStart, rangeEnd int64) error {
+	if resp.StatusCode == http.StatusOK {
+		if chunkStart != 0 {
+			return fmt.Errorf("server ignored the range request for bytes %d-%d", rangeStart, rangeEnd)
+		}
+		return nil
+	}
+	var start, end int64
+	cr := resp.Header.Get("Content-Range")
+	if _, err := fmt.Sscanf(cr, "bytes %d-%d/", &start, &end); err != nil || start != rangeStart || end != rangeEnd {
+		return fmt.Errorf("requested bytes %d-%d, but response has Content-Range %q", rangeStart, rangeEnd, cr)
+	}
+	return nil
+}
+
+// errProxyInterference means that a chunk response was an HTML or JSON
+// page, such as the login page of a captive portal or the error page of a
+// proxy, served with a success status in place of the file's bytes.
+var errProxyInterference = errors.New("proxy interference")
+
+// sniffSize is the buffer payloadBody reads the first bytes of a response
+// into, as http.DetectContentType looks at no more.
+const sniffSize = 512
+
+// payloadBody returns the body of resp to read the chunk's bytes from, or
+// errProxyInterference if its Content-Type, or for one other than
+// application/octet-stream its first bytes, show an HTML or JSON page.
+func payloadBody(resp *http.Response) (io.Reader, error) {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/octet-stream":
+		return resp.Body, nil
+	case mediaType == "text/html", mediaType == "application/xhtml+xml",
+		mediaType == "application/json", strings.HasSuffix(mediaType, "+json"):
+		return nil, fmt.Errorf("%w: got a %s response instead of file data; is a captive portal or proxy intercepting the connection?", errProxyInterference, mediaType)
+	}
+	// Sniff what the first read brings, without waiting for more. A read
+	// error is returned again by the next read.
+	br := bufio.NewReaderSize(resp.Body, sniffSize)
+	br.Peek(1)
+	head, _ := br.Peek(br.Buffered())
+	if strings.HasPrefix(http.DetectContentType(head), "text/html") {
+		return nil, fmt.Errorf("%w: got an HTML page instead of file data; is a captive portal or proxy intercepting the connection?", errProxyInterference)
+	}
+	return br, nil
+}
+
+// trimTail truncates the last resumeOverlap bytes of a partly downloaded
+// chunk file, to download them again. A crash mid-write can leave a torn
+// tail, which the file system extended the file by but never filled, and
+// appending after it would carry it into the merged file unnoticed.
+func (d *ChunkDownloader) trimTail(chunk *state.ChunkState) error {
+	path := d.chunkPath(chunk.Index)
+	info, err := os.Stat(path)
+	if err != nil || info.Size() == 0 || info.Size() >= chunk.End-chunk.Start {
+		return nil
+	}
+	if err := os.Truncate(path, max(info.Size()-resumeOverlap, 0)); err != nil {
+		return fmt.Errorf("truncate the tail of chunk %d: %w", chunk.Index, err)
+	}
+	return nil
+}
+
+// attemptDownload performs a single download attempt for a chunk from url.
+func (d *ChunkDownloader) attemptDownload(ctx context.Context, chunk *state.ChunkState, url string) error {
 	chunkPath := d.chunkPath(chunk.Index)
 
 	// Check existing progress for resume.
@@ -102,6 +228,7 @@ func (d *ChunkDownloader) attemptDownload(ctx context.Context, chunk *state.Chun
 		f.Close()
 		chunk.Status = state.ChunkComplete
 		chunk.BytesDownloaded = 0
+		d.publish(chunk)
 		return nil
 	}
 
@@ -112,11 +239,12 @@ func (d *ChunkDownloader) attemptDownload(ctx context.Context, chunk *state.Chun
 		}
 		chunk.Status = state.ChunkComplete
 		chunk.BytesDownloaded = expectedSize
+		d.publish(chunk)
 		return nil
 	}
 
 	// Build request with Range header.
-	req, err := d.apiClient.NewAuthenticatedRequest(ctx, "GET", d.downloadURL)
+	req, err := d.apiClient.NewAuthenticatedRequest(ctx, "GET", url)
 	if err != nil {
 		return err
 	}
@@ -125,19 +253,34 @@ func (d *ChunkDownloader) attemptDownload(ctx context.Context, chunk *state.Chun
 	rangeEnd := chunk.End - 1 // HTTP Range is inclusive
 	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", rangeStart, rangeEnd))
 
-	resp, err := d.apiClient.DoStreamRequest(req)
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	resp, err := d.apiClient.DoStreamRequest(req, d.stallTimeout)
 	if err != nil {
+		var apiErr *api.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized {
+			return &tokenExpiredError{token: token, rejected: true, err: err}
+		}
 		return err
 	}
 	defer resp.Body.Close()
 
+	if err := checkContentRange(resp, chunk.Start, rangeStart, rangeEnd); err != nil {
+		return err
+	}
+	body, err := payloadBody(resp)
+	if err != nil {
+		return err
+	}
+	counted := chunk.BytesDownloaded
 	// If we requested a Range but the server returned 200 (not 206 Partial Content),
 	// the server ignored the Range header and is sending the full content.
 	// We must truncate the existing file to avoid appending full content to partial data.
 	if existingSize > 0 && resp.StatusCode == http.StatusOK {
 		existingSize = 0
 		chunk.BytesDownloaded = 0
+		d.publish(chunk)
 	}
+	remaining := expectedSize - existingSize
 
 	// Ensure chunks directory exists.
 	if err := os.MkdirAll(filepath.Dir(chunkPath), 0755); err != nil {
@@ -151,47 +294,114 @@ func (d *ChunkDownloader) attemptDownload(ctx context.Context, chunk *state.Chun
 	} else {
 		openFlags |= os.O_APPEND
 	}
-	f, err := os.OpenFile(chunkPath, openFlags, 0644)
+	f, direct, err := openFile(chunkPath, openFlags, d.directIO)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-
 	// Use a progress-aware writer so the UI updates during streaming.
-	var written int64
-	buf := make([]byte, 32*1024)
-	for {
-		nr, readErr := resp.Body.Read(buf)
-		if nr > 0 {
-			nw, writeErr := f.Write(buf[:nr])
-			if writeErr != nil {
-				return writeErr
-			}
-			if d.limiter != nil {
-				if err := d.limiter.WaitN(ctx, nw); err != nil {
-					return err
-				}
-			}
-			written += int64(nw)
-			chunk.BytesDownloaded = existingSize + written
-			if d.onBytesWritten != nil {
-				d.onBytesWritten(int64(nw))
-			}
-		}
-		if readErr != nil {
-			if readErr == io.EOF {
-				break
+	cw := &chunkWriter{ctx: ctx, d: d, chunk: chunk, existing: existingSize, counted: counted}
+	// Runs after the close below: the written range leaves the page cache.
+	defer func() { dropCache(chunkPath, existingSize, cw.written) }()
+	w := newWriter(f, direct, existingSize)
+	defer w.Close()
+	cw.w = w
+
+	buf := getBuffer(d.bufferSize)
+	defer putBuffer(buf)
+	// Hide any WriterTo of the body, which would bypass the pooled buffer.
+	_, err = io.CopyBuffer(cw, struct{ io.Reader }{io.LimitReader(body, remaining)}, *buf)
+	if err == nil && cw.written < remaining {
+		// A clean EOF from a proxy or a server that gave up would otherwise
+		// only surface as a checksum failure once the whole file is merged.
+		err = fmt.Errorf("response ended after %d of %d bytes: %w", cw.written, remaining, io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		// A stream cut while its token expired: the server ends transfers
+		// whose token runs out.
+		if ctx.Err() == nil {
+			if current, terr := d.apiClient.AccessToken(ctx); terr == nil && current != token {
+				return &tokenExpiredError{token: token, err: err}
 			}
-			return readErr
 		}
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
 	}
 
 	chunk.Status = state.ChunkComplete
 	return nil
 }
 
+// tokenExpiredError is a chunk request refused, or a stream cut short,
+// because its access token expired.
+type tokenExpiredError struct {
+	token    string // the token of the request
+	rejected bool   // refused with 401 Unauthorized, rather than cut after the token was replaced
+	err      error
+}
+
+func (e *tokenExpiredError) Error() string { return "access token expired: " + e.err.Error() }
+
+func (e *tokenExpiredError) Unwrap() error { return e.err }
+
+// renew makes sure that later requests use a new token.
+func (e *tokenExpiredError) renew(ctx context.Context, apiClient *api.Client) error {
+	if !e.rejected {
+		return nil
+	}
+	return apiClient.RefreshAccessToken(ctx, e.token)
+}
+
+// chunkWriter writes the bytes of a chunk download to its file, throttled
+// by the limiter, updating the chunk's progress as they arrive.
+type chunkWriter struct {
+	ctx      context.Context
+	d        *ChunkDownloader
+	w        io.Writer
+	chunk    *state.ChunkState
+	existing int64 // bytes in the chunk file before this attempt
+	written  int64
+	counted  int64 // bytes of the chunk passed to onBytesWritten; those downloaded again are not passed twice
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	nw, err := cw.w.Write(p)
+	if err != nil {
+		return nw, err
+	}
+	if cw.d.limiter != nil {
+		if err := waitBytes(cw.ctx, cw.d.limiter, nw); err != nil {
+			return nw, err
+		}
+	}
+	cw.written += int64(nw)
+	cw.chunk.BytesDownloaded = cw.existing + cw.written
+	cw.d.publish(cw.chunk)
+	if n := cw.chunk.BytesDownloaded - cw.counted; n > 0 {
+		cw.counted = cw.chunk.BytesDownloaded
+		if cw.d.onBytesWritten != nil {
+			cw.d.onBytesWritten(n)
+		}
+	}
+	return nw, nil
+}
+
+// publish passes the progress of chunk to the goroutines watching its
+// download through d.run, as they must not read the chunk while it is
+// written.
+func (d *ChunkDownloader) publish(chunk *state.ChunkState) {
+	if d.run != nil {
+		d.run.bytes.Store(chunk.BytesDownloaded)
+		d.run.retries.Store(int32(chunk.RetryCount))
+	}
+}
+
 // chunkPath returns the path to the chunk file on disk.
 func (d *ChunkDownloader) chunkPath(index int) string {
+	if d.path != "" {
+		return d.path
+	}
 	return filepath.Join(d.chunksDir, fmt.Sprintf("%03d.part", index))
 }
 
@@ -200,6 +410,14 @@ func ChunkPath(chunksDir string, index int) string {
 	return filepath.Join(chunksDir, fmt.Sprintf("%03d.part", index))
 }
 
+// isThrottled reports whether err is the server refusing a request for
+// load: HTTP 429 Too Many Requests or 503 Service Unavailable.
+func isThrottled(err error) bool {
+	var apiErr *api.APIError
+	return errors.As(err, &apiErr) &&
+		(apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode == http.StatusServiceUnavailable)
+}
+
 // isRetryableError checks whether an error is worth retrying.
 // Note: net.Error must be checked BEFORE context errors because Go's net.Dialer
 // wraps dial timeouts with context.DeadlineExceeded internally. Without this
@@ -217,6 +435,12 @@ func isRetryableError(err error) bool {
 		return true
 	}
 
+	// A stalled response is retried on a new connection (it wraps
+	// context.DeadlineExceeded, so this must come before the check below).
+	if errors.Is(err, api.ErrStreamStalled) {
+		return true
+	}
+
 	// Context cancellation by the caller is not retryable.
 	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
 		return false
@@ -0,0 +1,237 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+func TestChunkDownloadStall(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			// Black-holed: a little data, then nothing until abandoned.
+			w.Header().Set("Content-Range", "bytes 0-9999/10000")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte(content[:100]))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer srv.Close()
+
+	chunksDir := t.TempDir()
+	d := NewChunkDownloader(api.NewClient(staticToken{}), srv.URL, chunksDir, nil, nil)
+	d.stallTimeout = 100 * time.Millisecond
+	chunk := &state.ChunkState{Index: 0, Start: 0, End: int64(len(content))}
+	if err := d.Download(context.Background(), chunk); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(ChunkPath(chunksDir, 0))
+	if err != nil || string(data) != content {
+		t.Fatalf("chunk file = %d bytes, %v; want the %d bytes served", len(data), err, len(content))
+	}
+	if requests.Load() != 2 || chunk.RetryCount != 1 {
+		t.Errorf("%d requests, %d retries; want the stalled one retried once", requests.Load(), chunk.RetryCount)
+	}
+}
+
+// rotatingToken hands out t1, t2, ... as each is replaced.
+type rotatingToken struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (p *rotatingToken) GetAccessToken(context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return "t" + strconv.Itoa(p.n+1), nil
+}
+
+func (p *rotatingToken) RefreshAccessToken(_ context.Context, rejected string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if rejected == "t"+strconv.Itoa(p.n+1) {
+		p.n++
+	}
+	return "t" + strconv.Itoa(p.n+1), nil
+}
+
+func TestChunkDownloadTokenExpiry(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	tokens := &rotatingToken{}
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.Header.Get("Authorization") {
+		case "Bearer t1":
+			// The token expires mid-transfer, and the server drops the stream.
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(content[:100]))
+			w.(http.Flusher).Flush()
+			tokens.RefreshAccessToken(r.Context(), "t1")
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		case "Bearer t2":
+			// Revoked early: refused.
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+		default:
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+		}
+	}))
+	defer srv.Close()
+
+	chunksDir := t.TempDir()
+	d := NewChunkDownloader(api.NewClient(tokens), srv.URL, chunksDir, nil, nil)
+	chunk := &state.ChunkState{Index: 0, Start: 0, End: int64(len(content))}
+	start := time.Now()
+	if err := d.Download(context.Background(), chunk); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(ChunkPath(chunksDir, 0))
+	if err != nil || string(data) != content {
+		t.Fatalf("chunk file = %d bytes, %v; want the %d bytes served", len(data), err, len(content))
+	}
+	if requests.Load() != 3 || chunk.RetryCount != 0 || time.Since(start) > baseDelay {
+		t.Errorf("%d requests, %d retries in %v; want 3 requests re-issued at once", requests.Load(), chunk.RetryCount, time.Since(start))
+	}
+}
+
+func TestChunkDownloadTornTail(t *testing.T) {
+	content := strings.Repeat("0123456789", 100_000)
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer srv.Close()
+
+	// A crash left zeros where the last write should have been.
+	chunksDir := t.TempDir()
+	torn := content[:600_000] + strings.Repeat("\x00", 4096)
+	if err := os.WriteFile(ChunkPath(chunksDir, 0), []byte(torn), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var reported int64
+	d := NewChunkDownloader(api.NewClient(staticToken{}), srv.URL, chunksDir, func(n int64) { reported += n }, nil)
+	chunk := &state.ChunkState{Index: 0, Start: 0, End: int64(len(content)), BytesDownloaded: int64(len(torn))}
+	if err := d.Download(context.Background(), chunk); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(ChunkPath(chunksDir, 0))
+	if err != nil || string(data) != content {
+		t.Fatalf("chunk file = %d bytes, %v; want the %d bytes served", len(data), err, len(content))
+	}
+	if want := fmt.Sprintf("bytes=%d-%d", len(torn)-resumeOverlap, len(content)-1); len(ranges) != 1 || ranges[0] != want {
+		t.Errorf("requested %q, want %q", ranges, want)
+	}
+	if want := int64(len(content) - len(torn)); reported != want {
+		t.Errorf("reported %d bytes, want the %d not downloaded before", reported, want)
+	}
+}
+
+func TestChunkDownloadShortBody(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			// Chunked, so that ending early is a clean EOF.
+			w.Header().Set("Content-Range", "bytes 0-9999/10000")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte(content[:4000]))
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer srv.Close()
+
+	chunksDir := t.TempDir()
+	d := NewChunkDownloader(api.NewClient(staticToken{}), srv.URL, chunksDir, nil, nil)
+	chunk := &state.ChunkState{Index: 0, Start: 0, End: int64(len(content))}
+	if err := d.Download(context.Background(), chunk); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(ChunkPath(chunksDir, 0))
+	if err != nil || string(data) != content {
+		t.Fatalf("chunk file = %d bytes, %v; want the %d bytes served", len(data), err, len(content))
+	}
+	if requests.Load() != 2 || chunk.RetryCount != 1 {
+		t.Errorf("%d requests, %d retries; want the short response retried once", requests.Load(), chunk.RetryCount)
+	}
+}
+
+func TestCheckContentRange(t *testing.T) {
+	tests := []struct {
+		status     int
+		header     string
+		chunkStart int64
+		ok         bool
+	}{
+		{http.StatusPartialContent, "bytes 1000-1999/5000", 1000, true},
+		{http.StatusPartialContent, "bytes 0-999/5000", 1000, false},
+		{http.StatusPartialContent, "bytes 1000-4999/5000", 1000, false},
+		{http.StatusPartialContent, "", 1000, false},
+		{http.StatusOK, "", 0, true},
+		{http.StatusOK, "", 1000, false},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+		resp.Header.Set("Content-Range", tt.header)
+		if err := checkContentRange(resp, tt.chunkStart, 1000, 1999); (err == nil) != tt.ok {
+			t.Errorf("checkContentRange(%d %q, chunk at %d) = %v, want ok %v", tt.status, tt.header, tt.chunkStart, err, tt.ok)
+		}
+	}
+}
+
+func TestPayloadBody(t *testing.T) {
+	page := "<!DOCTYPE html><html><body>Please log in to continue</body></html>"
+	gzip := "\x1f\x8b\x08\x04\x00\x00\x00\x00\x00\xff\x06\x00BC\x02\x00"
+	tests := []struct {
+		contentType, body string
+		ok                bool
+	}{
+		{"application/octet-stream", page, true}, // an HTML file of the dataset
+		{"text/html; charset=utf-8", page, false},
+		{"application/json", `{"error":"forbidden"}`, false},
+		{"application/problem+json", `{"title":"forbidden"}`, false},
+		{"", page, false},
+		{"", gzip, true},
+		{"text/plain", "##fileformat=VCFv4.2\n", true},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}, Body: io.NopCloser(strings.NewReader(tt.body))}
+		if tt.contentType != "" {
+			resp.Header.Set("Content-Type", tt.contentType)
+		}
+		body, err := payloadBody(resp)
+		if (err == nil) != tt.ok || (err != nil && !errors.Is(err, errProxyInterference)) {
+			t.Errorf("payloadBody(%q, %q) = %v, want ok %v", tt.contentType, tt.body, err, tt.ok)
+			continue
+		}
+		if err == nil {
+			if data, _ := io.ReadAll(body); string(data) != tt.body {
+				t.Errorf("payloadBody(%q, %q) reads %q, want the whole body", tt.contentType, tt.body, data)
+			}
+		}
+	}
+}
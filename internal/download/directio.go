@@ -0,0 +1,142 @@
+package download
+
+import (
+	"io"
+	"os"
+	"unsafe"
+)
+
+// directAlign is the alignment O_DIRECT requires of buffers, file offsets,
+// and write sizes; 4 KiB is the logical block size of common disks and
+// divides the block sizes of Lustre and GPFS.
+const directAlign = 4096
+
+// directBufferSize is the size of the aligned buffer that direct I/O reads
+// and writes go through.
+const directBufferSize = 1 << 20
+
+// syncWriter is a file being written: an *os.File, or a directWriter over
+// a file opened with O_DIRECT.
+type syncWriter interface {
+	io.WriteCloser
+	Sync() error
+}
+
+// openFile opens path like os.OpenFile. With direct set (DownloadOptions.
+// DirectIO), it opens it with O_DIRECT instead where the platform and the
+// filesystem support it, and reports whether it did.
+func openFile(path string, flag int, direct bool) (*os.File, bool, error) {
+	if !direct {
+		f, err := os.OpenFile(path, flag, 0644)
+		return f, false, err
+	}
+	return openDirect(path, flag, 0644)
+}
+
+// newWriter returns a writer for f, opened by openFile, whose write offset
+// is off. Writes to a file opened with O_DIRECT are buffered so that they
+// reach it in aligned blocks; Close or Sync must be called to write the rest.
+func newWriter(f *os.File, direct bool, off int64) syncWriter {
+	if !direct {
+		return f
+	}
+	return &directWriter{f: f, buf: alignedBuffer(directBufferSize), off: off}
+}
+
+// alignedBuffer returns a buffer of size bytes whose start is aligned for
+// direct I/O.
+func alignedBuffer(size int) []byte {
+	b := make([]byte, size+directAlign)
+	skip := 0
+	if r := int(uintptr(unsafe.Pointer(&b[0])) % directAlign); r != 0 {
+		skip = directAlign - r
+	}
+	return b[skip : skip+size : skip+size]
+}
+
+// directWriter writes to a file opened with O_DIRECT through an aligned
+// buffer, in whole blocks at aligned offsets, bypassing the page cache. The
+// bytes that do not fill a block, before the first aligned offset and at the
+// end, are written with O_DIRECT turned off.
+type directWriter struct {
+	f      *os.File
+	buf    []byte
+	n      int   // bytes buffered
+	off    int64 // file offset of buf[0]
+	closed bool
+}
+
+func (w *directWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		c := copy(w.buf[w.n:], p)
+		w.n += c
+		written += c
+		p = p[c:]
+		if w.n == len(w.buf) {
+			if err := w.flush(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flush writes the buffered whole blocks, and with all set the partial
+// block after them too.
+func (w *directWriter) flush(all bool) error {
+	if head := int(-w.off & (directAlign - 1)); head > 0 && w.n > 0 {
+		head = min(head, w.n)
+		if err := w.writeBuffered(w.buf[:head]); err != nil {
+			return err
+		}
+		w.n = copy(w.buf, w.buf[head:w.n])
+	}
+	if full := w.n &^ (directAlign - 1); full > 0 {
+		if _, err := w.f.Write(w.buf[:full]); err != nil {
+			return err
+		}
+		w.off += int64(full)
+		w.n = copy(w.buf, w.buf[full:w.n])
+	}
+	if all && w.n > 0 {
+		if err := w.writeBuffered(w.buf[:w.n]); err != nil {
+			return err
+		}
+		w.n = 0
+	}
+	return nil
+}
+
+// writeBuffered writes p, which need not be aligned, through the page cache.
+func (w *directWriter) writeBuffered(p []byte) error {
+	if err := setDirect(w.f, false); err != nil {
+		return err
+	}
+	if _, err := w.f.Write(p); err != nil {
+		return err
+	}
+	w.off += int64(len(p))
+	return setDirect(w.f, true)
+}
+
+func (w *directWriter) Sync() error {
+	if err := w.flush(true); err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+// Close writes the buffered bytes and closes the file. Closing it again
+// does nothing.
+func (w *directWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	err := w.flush(true)
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
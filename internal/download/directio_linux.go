@@ -0,0 +1,50 @@
+package download
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// DirectIOSupported reports whether DownloadOptions.DirectIO has an effect
+// on this platform.
+const DirectIOSupported = true
+
+// openDirect opens path with O_DIRECT, or without it if the filesystem does
+// not support it (as tmpfs does not).
+func openDirect(path string, flag int, perm os.FileMode) (*os.File, bool, error) {
+	f, err := os.OpenFile(path, flag|syscall.O_DIRECT, perm)
+	if errors.Is(err, syscall.EINVAL) {
+		f, err = os.OpenFile(path, flag, perm)
+		return f, false, err
+	}
+	return f, err == nil, err
+}
+
+// setDirect turns O_DIRECT on or off for f.
+func setDirect(f *os.File, on bool) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var opErr error
+	err = rc.Control(func(fd uintptr) {
+		flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_GETFL, 0)
+		if errno != 0 {
+			opErr = errno
+			return
+		}
+		if on {
+			flags |= syscall.O_DIRECT
+		} else {
+			flags &^= syscall.O_DIRECT
+		}
+		if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_SETFL, flags); errno != 0 {
+			opErr = errno
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package download
+
+import "os"
+
+// DirectIOSupported reports whether DownloadOptions.DirectIO has an effect
+// on this platform.
+const DirectIOSupported = false
+
+// openDirect opens path normally: direct I/O is only implemented on Linux.
+func openDirect(path string, flag int, perm os.FileMode) (*os.File, bool, error) {
+	f, err := os.OpenFile(path, flag, perm)
+	return f, false, err
+}
+
+func setDirect(f *os.File, on bool) error {
+	return nil
+}
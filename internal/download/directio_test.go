@@ -0,0 +1,55 @@
+package download
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirectWriter(t *testing.T) {
+	data := make([]byte, 3*directBufferSize+1234)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	path := filepath.Join(t.TempDir(), "000.part")
+
+	// Written in two attempts, the second resuming at an unaligned offset,
+	// in writes of an awkward size.
+	for _, part := range [][]byte{data[:5000], data[5000:]} {
+		f, direct, err := openFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := f.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := newWriter(f, direct, info.Size())
+		for len(part) > 0 {
+			n := min(len(part), 32*1024+3)
+			if _, err := w.Write(part[:n]); err != nil {
+				t.Fatal(err)
+			}
+			part = part[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("wrote %d bytes that differ from the %d given", len(got), len(data))
+	}
+
+	var merged bytes.Buffer
+	if err := appendFile(&merged, path, true); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(merged.Bytes(), data) {
+		t.Errorf("read back %d bytes that differ from the file", merged.Len())
+	}
+}
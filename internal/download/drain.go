@@ -0,0 +1,22 @@
+package download
+
+import (
+	"context"
+	"fmt"
+)
+
+// errDrained is returned by downloads stopped through DownloadOptions.Drain
+// with files or chunks left to download. It wraps context.Canceled, as the
+// files are left to resume in the same way.
+var errDrained = fmt.Errorf("stopped before all chunks were started: %w", context.Canceled)
+
+// draining reports whether drain is closed: files and chunks not started yet
+// are left for the next run, while those in flight finish.
+func draining(drain <-chan struct{}) bool {
+	select {
+	case <-drain:
+		return true
+	default:
+		return false
+	}
+}
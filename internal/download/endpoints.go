@@ -0,0 +1,79 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+)
+
+// failoverAfter is how many consecutive failures of the current endpoint,
+// from any chunk, move the download on to the next endpoint.
+const failoverAfter = 2
+
+// endpointSet is the data API endpoints of a download (opts.Endpoints),
+// such as central EGA and a Federated EGA node, shared by all its chunks.
+// Chunks use the current endpoint until it keeps failing with server errors
+// or timeouts, then all move on to the next, wrapping around to the first.
+type endpointSet struct {
+	mu       sync.Mutex
+	bases    []string // base URLs, in order of preference
+	current  int
+	failures int // consecutive failures of bases[current]
+}
+
+func newEndpointSet(bases []string) *endpointSet {
+	return &endpointSet{bases: bases}
+}
+
+// fileURL returns the download URL of fileID at the current endpoint, with
+// the endpoint's index to report the outcome of the request with.
+func (e *endpointSet) fileURL(fileID string) (string, int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return api.FileURLAt(e.bases[e.current], fileID), e.current
+}
+
+// report records the outcome of a request to endpoint i. Requests started
+// before the last failover are ignored.
+func (e *endpointSet) report(ctx context.Context, i int, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if i != e.current {
+		return
+	}
+	if err == nil {
+		e.failures = 0
+		return
+	}
+	if !isEndpointFailure(err) {
+		return
+	}
+	e.failures++
+	if e.failures < failoverAfter || len(e.bases) < 2 {
+		return
+	}
+	from := e.bases[e.current]
+	e.current = (e.current + 1) % len(e.bases)
+	e.failures = 0
+	slog.WarnContext(ctx, "Endpoint unhealthy; failing over", "from", from, "to", e.bases[e.current], "error", err)
+}
+
+// isEndpointFailure reports whether err is a sign of an unhealthy endpoint
+// rather than of the request: a 5xx response, a network error or timeout, or
+// a response that stalled.
+func isEndpointFailure(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var apiErr *api.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, api.ErrStreamStalled)
+}
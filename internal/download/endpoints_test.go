@@ -0,0 +1,46 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+)
+
+func TestEndpointFailover(t *testing.T) {
+	e := newEndpointSet([]string{"https://ega.example/v2", "https://fega.example/v2/"})
+	url, i := e.fileURL("EGAF1")
+	if want := "https://ega.example/v2/files/EGAF1?destinationFormat=plain"; url != want {
+		t.Fatalf("fileURL = %q, want %q", url, want)
+	}
+
+	unavailable := &api.APIError{StatusCode: 503}
+	e.report(context.Background(), i, unavailable)
+	e.report(context.Background(), i, nil) // a success in between resets the count
+	e.report(context.Background(), i, unavailable)
+	e.report(context.Background(), i, &api.APIError{StatusCode: 404})
+	e.report(context.Background(), i, context.Canceled)
+	if _, j := e.fileURL("EGAF1"); j != 0 {
+		t.Fatalf("failed over to endpoint %d without consecutive server errors", j)
+	}
+
+	e.report(context.Background(), i, unavailable)
+	url, j := e.fileURL("EGAF1")
+	if j != 1 || !strings.HasPrefix(url, "https://fega.example/v2/files/") {
+		t.Fatalf("after repeated 5xx: endpoint %d, %q; want the second endpoint", j, url)
+	}
+	// Attempts still running against the old endpoint do not count.
+	e.report(context.Background(), i, unavailable)
+	e.report(context.Background(), i, unavailable)
+	if _, k := e.fileURL("EGAF1"); k != 1 {
+		t.Errorf("stale failures moved the download to endpoint %d", k)
+	}
+
+	e.report(context.Background(), j, errors.Join(errors.New("read body"), &api.APIError{StatusCode: 502}))
+	e.report(context.Background(), j, unavailable)
+	if _, k := e.fileURL("EGAF1"); k != 0 {
+		t.Errorf("failover did not wrap around to the first endpoint (at %d)", k)
+	}
+}
@@ -0,0 +1,104 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+	"github.com/khan-lab/EGAfetch/internal/verify"
+)
+
+// Policies for an output file that already exists but has no download state,
+// e.g. one fetched earlier with another client.
+const (
+	IfExistsVerify    = "verify"    // keep it if it matches the checksum, fail otherwise
+	IfExistsSkip      = "skip"      // leave it alone and do not download
+	IfExistsOverwrite = "overwrite" // download and replace it
+	IfExistsRename    = "rename"    // move it aside, then download
+)
+
+// IfExistsPolicies lists the accepted DownloadOptions.IfExists values.
+var IfExistsPolicies = []string{IfExistsVerify, IfExistsSkip, IfExistsOverwrite, IfExistsRename}
+
+// resolveUntracked applies the IfExists policy to the output file of spec,
+// which has no download state. It returns true if the file needs no
+// download, either because it is skipped or because it verified and was
+// recorded as complete.
+func (o *Orchestrator) resolveUntracked(ctx context.Context, spec state.FileSpec) (bool, error) {
+	path := filepath.Join(o.stateManager.BaseDir(), spec.FileName)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("check existing %s: %w", spec.FileName, err)
+	}
+
+	switch o.opts.IfExists {
+	case IfExistsOverwrite:
+		slog.DebugContext(ctx, "Overwriting existing file", "file", spec.FileName, "file_id", spec.FileID)
+		return false, nil
+
+	case IfExistsSkip:
+		slog.InfoContext(ctx, "Skipping existing file without download state", "file", spec.FileName, "file_id", spec.FileID)
+		return true, nil
+
+	case IfExistsRename:
+		renamed, err := freeName(path)
+		if err != nil {
+			return false, err
+		}
+		if err := os.Rename(path, renamed); err != nil {
+			return false, fmt.Errorf("rename existing %s: %w", spec.FileName, err)
+		}
+		slog.InfoContext(ctx, "Moved existing file aside", "file", path, "renamed_to", renamed)
+		return false, nil
+	}
+
+	// IfExistsVerify: adopt the file only if it is the one EGA serves.
+	if spec.Checksum == "" {
+		return false, fmt.Errorf("%s already exists and has no checksum to verify it against (use --if-exists overwrite, rename, or skip)", spec.FileName)
+	}
+	if info.Size() != spec.Size {
+		return false, fmt.Errorf("%s already exists with %d bytes, expected %d (use --if-exists overwrite, rename, or skip): %w",
+			spec.FileName, info.Size(), spec.Size, verify.ErrChecksumMismatch)
+	}
+	sums, err := verify.ComputeSums(path)
+	if err == nil {
+		err = sums.Verify(spec.Checksum, spec.ChecksumType)
+	}
+	if err != nil {
+		return false, fmt.Errorf("%s already exists and does not match (use --if-exists overwrite, rename, or skip): %w", spec.FileName, err)
+	}
+	if o.opts.MD5Files {
+		if err := writeMD5Sidecar(path, sums.MD5); err != nil {
+			return false, err
+		}
+	}
+	fs := state.NewFileState(spec, o.opts.ChunkSize)
+	fs.Status = state.StatusComplete
+	fs.MD5, fs.SHA256 = sums.MD5, sums.SHA256
+	now := time.Now()
+	fs.CompletedAt = &now
+	if err := o.stateManager.SaveFileState(fs); err != nil {
+		return false, fmt.Errorf("save state: %w", err)
+	}
+	slog.InfoContext(ctx, "Existing file matches its checksum; keeping it", "file", spec.FileName, "file_id", spec.FileID)
+	return true, nil
+}
+
+// freeName returns the first of path.1, path.2, ... that does not exist.
+func freeName(path string) (string, error) {
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s.%d", path, i)
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate, nil
+		} else if err != nil {
+			return "", fmt.Errorf("check %s: %w", candidate, err)
+		}
+	}
+}
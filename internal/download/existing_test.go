@@ -0,0 +1,109 @@
+package download
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+	"github.com/khan-lab/EGAfetch/internal/verify"
+)
+
+func TestResolveUntracked(t *testing.T) {
+	content := []byte("existing data\n")
+	sum := md5.Sum(content)
+	spec := state.FileSpec{
+		FileID:       "EGAF00000000001",
+		FileName:     filepath.Join("EGAF00000000001", "a.bam"),
+		Size:         int64(len(content)),
+		Checksum:     hex.EncodeToString(sum[:]),
+		ChecksumType: "MD5",
+	}
+
+	setup := func(t *testing.T, policy string, data []byte) (*Orchestrator, string) {
+		t.Helper()
+		dir := t.TempDir()
+		path := filepath.Join(dir, spec.FileName)
+		if data != nil {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return NewOrchestrator(nil, state.NewStateManager(dir), DownloadOptions{IfExists: policy}), path
+	}
+
+	t.Run("no file", func(t *testing.T) {
+		o, _ := setup(t, IfExistsVerify, nil)
+		if skip, err := o.resolveUntracked(context.Background(), spec); skip || err != nil {
+			t.Fatalf("got %v, %v; want download", skip, err)
+		}
+	})
+
+	t.Run("verify match", func(t *testing.T) {
+		o, path := setup(t, IfExistsVerify, content)
+		if skip, err := o.resolveUntracked(context.Background(), spec); !skip || err != nil {
+			t.Fatalf("got %v, %v; want skip", skip, err)
+		}
+		fs, err := o.stateManager.LoadFileState(spec.FileID)
+		if err != nil || fs == nil || !fs.IsComplete() {
+			t.Fatalf("state = %+v, %v; want complete", fs, err)
+		}
+		if fs.MD5 != spec.Checksum || fs.SHA256 == "" {
+			t.Errorf("recorded checksums MD5 %q, SHA256 %q; want MD5 %q and a SHA256", fs.MD5, fs.SHA256, spec.Checksum)
+		}
+		if _, err := os.Stat(path + ".md5"); !os.IsNotExist(err) {
+			t.Errorf(".md5 sidecar written without MD5Files: %v", err)
+		}
+	})
+
+	t.Run("verify mismatch", func(t *testing.T) {
+		o, _ := setup(t, IfExistsVerify, []byte("other data!!!\n"))
+		_, err := o.resolveUntracked(context.Background(), spec)
+		if !errors.Is(err, verify.ErrChecksumMismatch) {
+			t.Fatalf("error = %v, want checksum mismatch", err)
+		}
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		o, _ := setup(t, IfExistsSkip, []byte("partial"))
+		if skip, err := o.resolveUntracked(context.Background(), spec); !skip || err != nil {
+			t.Fatalf("got %v, %v; want skip", skip, err)
+		}
+		if fs, _ := o.stateManager.LoadFileState(spec.FileID); fs != nil {
+			t.Errorf("skip wrote state %+v", fs)
+		}
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		o, path := setup(t, IfExistsOverwrite, []byte("partial"))
+		if skip, err := o.resolveUntracked(context.Background(), spec); skip || err != nil {
+			t.Fatalf("got %v, %v; want download", skip, err)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("file removed before download: %v", err)
+		}
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		o, path := setup(t, IfExistsRename, []byte("partial"))
+		if err := os.WriteFile(path+".1", []byte("older"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if skip, err := o.resolveUntracked(context.Background(), spec); skip || err != nil {
+			t.Fatalf("got %v, %v; want download", skip, err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("original still present: %v", err)
+		}
+		if data, err := os.ReadFile(path + ".2"); err != nil || string(data) != "partial" {
+			t.Errorf("renamed file = %q, %v", data, err)
+		}
+	})
+}
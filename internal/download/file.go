@@ -2,10 +2,13 @@ package download
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/sync/errgroup"
@@ -18,15 +21,13 @@ import (
 
 const (
 	maxFileRetries = 3
-	// EGA files in plain mode have 16 bytes of IV stripped.
-	ivSize = 16
 
 	// Adaptive chunk sizing constants.
 	minAdaptiveChunkSize = 8 * 1024 * 1024   // 8 MB
-	maxAdaptiveChunkSize = 256 * 1024 * 1024  // 256 MB
-	adaptiveWindowSize   = 3                  // rolling window of throughput measurements
-	highThroughputMBps   = 50.0               // above this: scale up
-	lowThroughputMBps    = 10.0               // below this: scale down
+	maxAdaptiveChunkSize = 256 * 1024 * 1024 // 256 MB
+	adaptiveWindowSize   = 3                 // rolling window of throughput measurements
+	highThroughputMBps   = 50.0              // above this: scale up
+	lowThroughputMBps    = 10.0              // below this: scale down
 	scaleUpFactor        = 1.5
 	scaleDownFactor      = 0.5
 )
@@ -36,13 +37,32 @@ type DownloadOptions struct {
 	ParallelFiles    int
 	ParallelChunks   int
 	ChunkSize        int64
-	Limiter          *rate.Limiter // nil = no throttling; shared across all goroutines
-	AdaptiveChunking bool          // auto-adjust chunk size based on throughput
+	Limiter          *rate.Limiter   // nil = no throttling; shared across all goroutines
+	AdaptiveChunking bool            // auto-adjust chunk size based on throughput
+	AutoTune         bool            // adjust parallelism at runtime; ParallelFiles/ParallelChunks are the maximums
+	IfExists         string          // policy for output files without state; "" = IfExistsVerify
+	Permissions      Permissions     // applied to output files and directories
+	Uploader         Uploader        // nil = files stay in the output directory
+	Shared           bool            // coordinate with other processes downloading into the directory, through claims in state
+	MD5Files         bool            // also write a .md5 file next to each downloaded file
+	Indexer          Indexer         // nil = verified files are not indexed
+	Endpoints        []string        // data API base URLs to fail over between, in order; nil = central EGA
+	DirectIO         bool            // write chunk and output files with O_DIRECT, bypassing the page cache (Linux only)
+	NoHedge          bool            // never duplicate the requests of straggler chunks
+	SmallFileSize    int64           // files up to this size (and the chunk size) are downloaded in one request, without chunks; 0 = never
+	StallTimeout     time.Duration   // retry a chunk whose response delivers no data for this long; 0 = 60 seconds
+	Drain            <-chan struct{} // closed to stop starting files and chunks and let those in flight finish; nil = never
+	Striping         Striping        // Lustre layout of output files of at least StripeMinSize; zero = the filesystem default
+	BufferSize       int             // bytes each chunk download reads at a time; 0 = DefaultBufferSize
 }
 
 // ProgressCallback is called to report download progress.
 type ProgressCallback func(fileID string, bytesDownloaded int64, totalBytes int64)
 
+// ChunkCallback is called with a copy of a chunk's state as its bytes arrive
+// and when it completes or fails.
+type ChunkCallback func(fileID string, chunk state.ChunkState)
+
 // adaptiveState tracks throughput measurements for adaptive chunk sizing.
 type adaptiveState struct {
 	mu               sync.Mutex
@@ -100,15 +120,26 @@ func (a *adaptiveState) recordAndAdjust(bytesDownloaded int64, duration time.Dur
 
 // FileDownload manages the download of a single file through the state machine.
 type FileDownload struct {
-	spec           state.FileSpec
-	apiClient      *api.Client
-	stateManager   *state.StateManager
-	opts           DownloadOptions
-	fstate         *state.FileState
-	mu             sync.Mutex
-	onProgress     ProgressCallback
-	liveBytesSoFar int64          // running total for live progress, updated by chunk callbacks
-	adaptive       *adaptiveState // nil if adaptive chunking disabled
+	spec         state.FileSpec
+	apiClient    *api.Client
+	stateManager *state.StateManager
+	opts         DownloadOptions
+	fstate       *state.FileState
+	mu           sync.Mutex
+	onProgress   ProgressCallback
+	onChunk      ChunkCallback  // nil unless chunk progress is shown
+	liveBytes    atomic.Int64   // running total for live progress, added to by chunk writes
+	adaptive     *adaptiveState // nil if adaptive chunking disabled
+	lastErr      error          // most recent failure in this run, kept for errors.Is/As
+	tuner        *autoTuner     // nil if parallelism tuning disabled
+	endpoints    *endpointSet   // nil unless opts.Endpoints is set
+	retries      *retryBudget   // shared by the chunks of all files; nil = each retries on its own
+	mergeMu      sync.Mutex     // serializes appends to the merge file
+	indexFiles   []string       // index files written by opts.Indexer
+
+	// Guarded by mu, for hedging straggler chunks.
+	runs       map[*state.ChunkState]*chunkRun // chunks in flight
+	chunkRates []float64                       // bytes/sec of the chunks completed in this run
 }
 
 // NewFileDownload creates a new file download task.
@@ -143,6 +174,17 @@ func (fd *FileDownload) Run(ctx context.Context) error {
 		fd.fstate = existing
 	} else {
 		fd.fstate = state.NewFileState(fd.spec, fd.opts.ChunkSize)
+		if fd.isSmall() {
+			err := fd.downloadSmall(ctx)
+			if err == nil {
+				fd.fstate.Status = state.StatusVerifying
+			} else if ctx.Err() != nil {
+				return ctx.Err()
+			} else {
+				// Retried the usual way, in chunks.
+				slog.DebugContext(ctx, "Small-file download failed", "file_id", fd.fstate.FileID, "error", err)
+			}
+		}
 	}
 
 	for {
@@ -156,6 +198,7 @@ func (fd *FileDownload) Run(ctx context.Context) error {
 		if err := fd.saveState(); err != nil {
 			return fmt.Errorf("save state: %w", err)
 		}
+		slog.DebugContext(ctx, "File state", "file_id", fd.fstate.FileID, "status", fd.fstate.Status)
 
 		switch fd.fstate.Status {
 		case state.StatusPending, state.StatusChunking:
@@ -165,25 +208,49 @@ func (fd *FileDownload) Run(ctx context.Context) error {
 		case state.StatusDownloading:
 			downloadURL := fd.apiClient.FileDownloadURL(fd.fstate.FileID)
 			fd.fstate.DownloadURL = downloadURL
+			if fd.mergeLost() {
+				fd.resetMerged(ctx)
+			}
 
 			if err := fd.downloadChunks(ctx); err != nil {
-				return fd.fail(err)
+				return fd.failOrStop(ctx, err)
 			}
 			fd.fstate.Status = state.StatusMerging
 
 		case state.StatusMerging:
-			if err := fd.mergeChunks(); err != nil {
+			if err := fd.mergeChunks(ctx); err != nil {
+				if errors.Is(err, errMergeLost) {
+					fd.resetMerged(ctx)
+					fd.fstate.Status = state.StatusDownloading
+					continue
+				}
 				return fd.fail(err)
 			}
 			fd.fstate.Status = state.StatusVerifying
 
 		case state.StatusVerifying:
-			if err := fd.verifyChecksum(); err != nil {
+			if err := fd.checkSize(); err != nil {
 				return fd.fail(err)
 			}
-			if err := fd.writeMD5File(); err != nil {
+			if err := fd.verifyChecksum(); err != nil {
 				return fd.fail(err)
 			}
+			if fd.opts.MD5Files {
+				if err := fd.writeMD5File(); err != nil {
+					return fd.fail(err)
+				}
+			}
+			if fd.opts.Indexer != nil {
+				if err := fd.index(ctx); err != nil {
+					return err
+				}
+			}
+			fd.applyPermissions(ctx)
+			if fd.opts.Uploader != nil {
+				if err := fd.upload(ctx); err != nil {
+					return fd.failOrStop(ctx, err)
+				}
+			}
 			fd.fstate.Status = state.StatusComplete
 			now := time.Now()
 			fd.fstate.CompletedAt = &now
@@ -197,10 +264,14 @@ func (fd *FileDownload) Run(ctx context.Context) error {
 		case state.StatusFailed:
 			if fd.fstate.RetryCount < maxFileRetries {
 				fd.fstate.RetryCount++
+				slog.DebugContext(ctx, "Retrying file", "file_id", fd.fstate.FileID, "retry", fd.fstate.RetryCount, "error", fd.fstate.Error)
 				fd.fstate.Status = state.StatusDownloading
 				fd.fstate.Error = ""
 				continue
 			}
+			if fd.lastErr != nil {
+				return fmt.Errorf("download failed after %d retries: %w", fd.fstate.RetryCount, fd.lastErr)
+			}
 			return fmt.Errorf("download failed after %d retries: %s", fd.fstate.RetryCount, fd.fstate.Error)
 		}
 	}
@@ -213,8 +284,8 @@ func (fd *FileDownload) downloadChunks(ctx context.Context) error {
 		return fmt.Errorf("create chunks directory: %w", err)
 	}
 
-	// Seed liveBytesSoFar with bytes already downloaded (resume case).
-	fd.liveBytesSoFar = fd.bytesDownloaded()
+	// Seed liveBytes with bytes already downloaded (resume case).
+	fd.liveBytes.Store(fd.bytesDownloaded())
 
 	pending := fd.fstate.PendingChunks()
 	if len(pending) == 0 {
@@ -257,34 +328,48 @@ func (fd *FileDownload) downloadChunks(ctx context.Context) error {
 // downloadChunksBatch downloads a batch of chunks concurrently.
 func (fd *FileDownload) downloadChunksBatch(ctx context.Context, chunksDir string, chunks []*state.ChunkState) error {
 	g, ctx := errgroup.WithContext(ctx)
-	sem := make(chan struct{}, fd.opts.ParallelChunks)
+	slots := newSlotPool(fd.opts.ParallelChunks)
+	if fd.tuner != nil {
+		slots = fd.tuner.newChunkSlots()
+		defer fd.tuner.dropChunkSlots(slots)
+	}
 
+	stopProgress := fd.startProgress()
+	defer stopProgress()
+	var drained atomic.Bool
 	for _, chunk := range chunks {
 		chunk := chunk
 		g.Go(func() error {
-			select {
-			case sem <- struct{}{}:
-				defer func() { <-sem }()
-			case <-ctx.Done():
-				return ctx.Err()
+			if err := slots.acquire(ctx); err != nil {
+				return err
+			}
+			released := false
+			defer func() {
+				if !released {
+					slots.release()
+				}
+			}()
+			// Left for the next run, without cancelling the chunks in flight.
+			if draining(fd.opts.Drain) {
+				drained.Store(true)
+				return nil
 			}
 
 			startTime := time.Now()
 
-			// Per-byte callback: atomically update running total and notify UI.
-			onBytes := func(n int64) {
-				fd.mu.Lock()
-				fd.liveBytesSoFar += n
-				current := fd.liveBytesSoFar
-				fd.mu.Unlock()
-				if fd.onProgress != nil {
-					fd.onProgress(fd.fstate.FileID, current, fd.fstate.Size)
-				}
+			downloader := NewChunkDownloader(fd.apiClient, fd.fstate.DownloadURL, chunksDir, fd.addBytes, fd.opts.Limiter)
+			downloader.endpoints, downloader.fileID = fd.endpoints, fd.fstate.FileID
+			downloader.directIO, downloader.bufferSize = fd.opts.DirectIO, fd.opts.BufferSize
+			downloader.tuner, downloader.stallTimeout = fd.tuner, fd.opts.StallTimeout
+			downloader.retries = fd.retries
+			run, work := fd.startRun(chunk)
+			downloader.run = run
+			err := fd.downloadChunk(ctx, downloader, work, run)
+			fd.endRun(chunk, work, run, err)
+			if fd.onChunk != nil {
+				fd.onChunk(fd.fstate.FileID, *work)
 			}
 
-			downloader := NewChunkDownloader(fd.apiClient, fd.fstate.DownloadURL, chunksDir, onBytes, fd.opts.Limiter)
-			err := downloader.Download(ctx, chunk)
-
 			// Record throughput for adaptive sizing.
 			if err == nil && fd.adaptive != nil {
 				elapsed := time.Since(startTime)
@@ -294,14 +379,32 @@ func (fd *FileDownload) downloadChunksBatch(ctx context.Context, chunksDir strin
 
 			// Save state after each chunk completes (or fails).
 			fd.mu.Lock()
+			fd.syncRuns()
 			fd.saveState()
 			fd.mu.Unlock()
+			if err != nil {
+				return err
+			}
 
-			return err
+			// Merge while the rest download, without holding up the next chunk.
+			slots.release()
+			released = true
+			return fd.mergeReady(ctx)
 		})
 	}
 
-	return g.Wait()
+	if !fd.opts.NoHedge {
+		watchCtx, stopWatching := context.WithCancel(ctx)
+		defer stopWatching()
+		go fd.watchStragglers(watchCtx)
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	if drained.Load() {
+		return errDrained
+	}
+	return nil
 }
 
 // rechunkRemaining re-splits all pending chunks using the new chunk size.
@@ -358,35 +461,51 @@ func (fd *FileDownload) bytesDownloaded() int64 {
 	return total
 }
 
-// mergeChunks concatenates all chunk files into the final output file.
-func (fd *FileDownload) mergeChunks() error {
-	chunksDir := fd.stateManager.ChunksPathForFile(fd.fstate.FileID)
-	outputPath := filepath.Join(fd.stateManager.BaseDir(), fd.fstate.FileName)
-
-	return MergeChunks(chunksDir, outputPath, fd.fstate.Chunks)
-}
-
-// verifyChecksum verifies the downloaded file against the expected checksum.
+// verifyChecksum verifies the downloaded file against the expected checksum
+// and records its MD5 and SHA256 checksums in its state, for the MD5SUMS and
+// SHA256SUMS files.
 func (fd *FileDownload) verifyChecksum() error {
 	outputPath := filepath.Join(fd.stateManager.BaseDir(), fd.fstate.FileName)
 
-	if fd.fstate.ChecksumExpected == "" {
-		return nil // No checksum to verify.
+	sums, err := verify.ComputeSums(outputPath)
+	dropCache(outputPath, 0, 0)
+	if err != nil {
+		return err
 	}
-
-	return verify.Verify(outputPath, fd.fstate.ChecksumExpected, fd.fstate.ChecksumType)
+	if fd.fstate.ChecksumExpected != "" {
+		if err := sums.Verify(fd.fstate.ChecksumExpected, fd.fstate.ChecksumType); err != nil {
+			return err
+		}
+	}
+	fd.fstate.MD5, fd.fstate.SHA256 = sums.MD5, sums.SHA256
+	return nil
 }
 
-// writeMD5File computes the MD5 checksum of the downloaded file and writes it
-// to a .md5 sidecar file in standard md5sum format.
-func (fd *FileDownload) writeMD5File() error {
-	outputPath := filepath.Join(fd.stateManager.BaseDir(), fd.fstate.FileName)
-	md5sum, err := verify.ComputeChecksum(outputPath, "MD5")
+// checkSize checks that the downloaded file has the size the manifest
+// expects of it as served, so that an error in that size, or a short
+// download, is reported as such rather than as a checksum mismatch, and is
+// caught for files without a checksum.
+func (fd *FileDownload) checkSize() error {
+	info, err := os.Stat(filepath.Join(fd.stateManager.BaseDir(), fd.fstate.FileName))
 	if err != nil {
-		return fmt.Errorf("compute MD5: %w", err)
+		return err
+	}
+	if info.Size() != fd.fstate.Size {
+		return fmt.Errorf("downloaded file is %d bytes, expected %d", info.Size(), fd.fstate.Size)
 	}
+	return nil
+}
+
+// writeMD5File writes the MD5 checksum of the downloaded file to a .md5
+// sidecar file in standard md5sum format.
+func (fd *FileDownload) writeMD5File() error {
+	return writeMD5Sidecar(filepath.Join(fd.stateManager.BaseDir(), fd.fstate.FileName), fd.fstate.MD5)
+}
+
+// writeMD5Sidecar writes md5sum, outputPath's MD5 checksum, to outputPath.md5.
+func writeMD5Sidecar(outputPath, md5sum string) error {
 	md5Path := outputPath + ".md5"
-	content := fmt.Sprintf("%s  %s\n", md5sum, filepath.Base(fd.fstate.FileName))
+	content := fmt.Sprintf("%s  %s\n", md5sum, filepath.Base(outputPath))
 	if err := os.WriteFile(md5Path, []byte(content), 0644); err != nil {
 		return fmt.Errorf("write MD5 file: %w", err)
 	}
@@ -403,10 +522,21 @@ func (fd *FileDownload) cleanup() {
 func (fd *FileDownload) fail(err error) error {
 	fd.fstate.Status = state.StatusFailed
 	fd.fstate.Error = err.Error()
+	fd.lastErr = err
 	fd.saveState()
 	return err
 }
 
+// failOrStop is fail, except that a download stopped by cancelling ctx
+// keeps its status, so that resuming it does not use up a retry.
+func (fd *FileDownload) failOrStop(ctx context.Context, err error) error {
+	if ctx.Err() != nil || errors.Is(err, errDrained) {
+		fd.saveState()
+		return err
+	}
+	return fd.fail(err)
+}
+
 // saveState persists the current file state to disk.
 func (fd *FileDownload) saveState() error {
 	return fd.stateManager.SaveFileState(fd.fstate)
@@ -0,0 +1,101 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+func TestFailOrStop(t *testing.T) {
+	sm := state.NewStateManager(t.TempDir())
+	fd := &FileDownload{
+		stateManager: sm,
+		fstate:       &state.FileState{FileID: "EGAF00000000001", Status: state.StatusDownloading},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	fd.failOrStop(ctx, context.Canceled)
+	saved, err := sm.LoadFileState("EGAF00000000001")
+	if err != nil || saved == nil || saved.Status != state.StatusDownloading || saved.Error != "" {
+		t.Fatalf("stopped download saved as %+v, %v; want it still downloading", saved, err)
+	}
+
+	fd.failOrStop(context.Background(), errors.New("connection reset"))
+	saved, err = sm.LoadFileState("EGAF00000000001")
+	if err != nil || saved == nil || saved.Status != state.StatusFailed {
+		t.Fatalf("failed download saved as %+v, %v; want it failed", saved, err)
+	}
+}
+
+func TestRunDrain(t *testing.T) {
+	content := strings.Repeat("0123456789", 400)
+	requested, release := make(chan struct{}, 4), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- struct{}{}
+		<-release
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer srv.Close()
+
+	sm := state.NewStateManager(t.TempDir())
+	spec := state.FileSpec{FileID: "EGAF00000000001", FileName: "a.bam", Size: int64(len(content))}
+	drain := make(chan struct{})
+	fd := NewFileDownload(spec, api.NewClient(staticToken{}), sm,
+		DownloadOptions{ParallelChunks: 1, ChunkSize: 1000, NoHedge: true, Drain: drain}, nil)
+	fd.endpoints = newEndpointSet([]string{srv.URL})
+	done := make(chan error, 1)
+	go func() { done <- fd.Run(context.Background()) }()
+
+	// The chunk in flight when draining starts finishes; the rest wait.
+	<-requested
+	close(drain)
+	close(release)
+	if err := <-done; !errors.Is(err, errDrained) {
+		t.Fatalf("Run = %v, want errDrained", err)
+	}
+	if n := len(requested); n != 0 {
+		t.Errorf("%d chunks started after draining", n)
+	}
+	saved, err := sm.LoadFileState(spec.FileID)
+	if err != nil || saved == nil || saved.Status != state.StatusDownloading {
+		t.Fatalf("drained download saved as %+v, %v; want it still downloading", saved, err)
+	}
+	if c := saved.Chunks; len(c) != 4 || len(saved.PendingChunks()) != 3 {
+		t.Errorf("chunks = %+v; want one complete and 3 pending", c)
+	}
+}
+
+func TestRunSizeMismatch(t *testing.T) {
+	dir := t.TempDir()
+	sm := state.NewStateManager(dir)
+	spec := state.FileSpec{FileID: "EGAF00000000001", FileName: "a.bam", Size: 4016}
+	fs := state.NewFileState(spec, 1000)
+	fs.Status = state.StatusVerifying
+	if err := sm.SaveFileState(fs); err != nil {
+		t.Fatal(err)
+	}
+	// Sized as if the IV had not been stripped.
+	if err := os.WriteFile(filepath.Join(dir, "a.bam"), make([]byte, 4032), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fd := NewFileDownload(spec, api.NewClient(staticToken{}), sm, DownloadOptions{ChunkSize: 1000}, nil)
+	err := fd.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "4032 bytes, expected 4016") {
+		t.Fatalf("Run = %v, want a size mismatch", err)
+	}
+	saved, _ := sm.LoadFileState(spec.FileID)
+	if saved == nil || saved.Status != state.StatusFailed {
+		t.Errorf("saved state %+v, want failed", saved)
+	}
+}
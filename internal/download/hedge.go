@@ -0,0 +1,211 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+// Straggler hedging constants.
+const (
+	hedgeInterval     = 5 * time.Second  // how often chunks in flight are checked
+	hedgeDoneFraction = 0.95             // share of the file downloaded before chunks are hedged
+	hedgeSlowFactor   = 4                // a chunk this many times slower than the median is a straggler
+	hedgeMinAge       = 10 * time.Second // chunks younger than this are not judged
+	hedgeMinSamples   = 3                // completed chunks needed for a median
+)
+
+// chunkRun is a chunk being downloaded, as watched by hedgeStragglers and
+// reportProgress. The download works on a copy of the chunk, and publishes
+// its progress here.
+type chunkRun struct {
+	start      time.Time
+	startBytes int64         // chunk.BytesDownloaded at start
+	bytes      atomic.Int64  // chunk.BytesDownloaded of the download
+	retries    atomic.Int32  // chunk.RetryCount of the download
+	hedge      chan struct{} // closed to hedge the chunk
+	hedged     bool
+}
+
+// startRun records that chunk is being downloaded, and returns the copy of
+// it the download works on.
+func (fd *FileDownload) startRun(chunk *state.ChunkState) (*chunkRun, *state.ChunkState) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	run := &chunkRun{start: time.Now(), startBytes: chunk.BytesDownloaded, hedge: make(chan struct{})}
+	run.bytes.Store(chunk.BytesDownloaded)
+	run.retries.Store(int32(chunk.RetryCount))
+	if fd.runs == nil {
+		fd.runs = make(map[*state.ChunkState]*chunkRun)
+	}
+	fd.runs[chunk] = run
+	work := *chunk
+	return run, &work
+}
+
+// endRun records that the download of chunk ended with err, leaving work,
+// and on success its throughput, that of stragglers is compared to.
+func (fd *FileDownload) endRun(chunk, work *state.ChunkState, run *chunkRun, err error) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	delete(fd.runs, chunk)
+	*chunk = *work
+	elapsed := time.Since(run.start).Seconds()
+	if n := chunk.End - chunk.Start - run.startBytes; err == nil && n > 0 && elapsed > 0 {
+		fd.chunkRates = append(fd.chunkRates, float64(n)/elapsed)
+	}
+}
+
+// syncRuns records the progress of the chunks in flight in their states,
+// before they are saved. Callers hold mu.
+func (fd *FileDownload) syncRuns() {
+	for chunk, run := range fd.runs {
+		chunk.BytesDownloaded = run.bytes.Load()
+		chunk.RetryCount = int(run.retries.Load())
+	}
+}
+
+// watchStragglers calls hedgeStragglers every hedgeInterval until ctx is
+// done.
+func (fd *FileDownload) watchStragglers(ctx context.Context) {
+	ticker := time.NewTicker(hedgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			fd.hedgeStragglers(ctx, now)
+		}
+	}
+}
+
+// hedgeStragglers signals chunks in flight to hedge themselves if the file
+// is nearly downloaded and they run far slower than the chunks completed
+// before them: on congested servers, a single slow tail chunk can hold up a
+// large file for half an hour.
+func (fd *FileDownload) hedgeStragglers(ctx context.Context, now time.Time) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	if len(fd.runs) == 0 || len(fd.chunkRates) < hedgeMinSamples {
+		return
+	}
+	if float64(fd.liveBytes.Load()) < hedgeDoneFraction*float64(fd.fstate.Size) {
+		return
+	}
+	rates := append([]float64(nil), fd.chunkRates...)
+	sort.Float64s(rates)
+	median := rates[len(rates)/2]
+	for chunk, run := range fd.runs {
+		age := now.Sub(run.start)
+		if run.hedged || age < hedgeMinAge {
+			continue
+		}
+		if rate := float64(run.bytes.Load()-run.startBytes) / age.Seconds(); rate < median/hedgeSlowFactor {
+			slog.DebugContext(ctx, "Hedging slow chunk", "file_id", fd.fstate.FileID, "chunk", chunk.Index,
+				"bytes_per_sec", int64(rate), "median_bytes_per_sec", int64(median))
+			run.hedged = true
+			close(run.hedge)
+		}
+	}
+}
+
+// downloadChunk downloads chunk with d. If run is signalled to hedge, it
+// also requests the rest of the chunk on a second connection, into a
+// separate file, and keeps whichever download completes first.
+func (fd *FileDownload) downloadChunk(ctx context.Context, d *ChunkDownloader, chunk *state.ChunkState, run *chunkRun) error {
+	origCtx, cancelOrig := context.WithCancel(ctx)
+	defer cancelOrig()
+	orig := make(chan error, 1)
+	go func() { orig <- d.Download(origCtx, chunk) }()
+
+	select {
+	case err := <-orig:
+		return err
+	case <-run.hedge:
+	}
+
+	partPath := d.chunkPath(chunk.Index)
+	var from int64
+	if info, err := os.Stat(partPath); err == nil {
+		from = info.Size()
+	}
+	h := NewChunkDownloader(d.apiClient, d.downloadURL, filepath.Join(d.chunksDir, "hedge"), nil, d.limiter)
+	h.endpoints, h.fileID = d.endpoints, d.fileID
+	h.directIO, h.bufferSize, h.tuner = d.directIO, d.bufferSize, d.tuner
+	h.stallTimeout, h.retries = d.stallTimeout, d.retries
+	hedgePath := h.chunkPath(chunk.Index)
+	os.Remove(hedgePath)
+	defer os.Remove(hedgePath)
+
+	hedgeCtx, cancelHedge := context.WithCancel(ctx)
+	defer cancelHedge()
+	hedged := make(chan error, 1)
+	go func() {
+		rest := state.ChunkState{Index: chunk.Index, Start: chunk.Start + from, End: chunk.End}
+		hedged <- h.Download(hedgeCtx, &rest)
+	}()
+
+	select {
+	case err := <-orig:
+		if err == nil {
+			cancelHedge()
+			<-hedged
+			return nil
+		}
+		if herr := <-hedged; herr != nil {
+			return err
+		}
+	case herr := <-hedged:
+		if herr != nil {
+			return <-orig
+		}
+		cancelOrig()
+		<-orig
+	}
+	slog.DebugContext(ctx, "Hedged request won", "file_id", fd.fstate.FileID, "chunk", chunk.Index)
+	return spliceHedge(d, chunk, from, hedgePath)
+}
+
+// spliceHedge completes the chunk file of chunk with the hedged download of
+// its bytes from offset from, dropping what the first request wrote past it.
+func spliceHedge(d *ChunkDownloader, chunk *state.ChunkState, from int64, hedgePath string) error {
+	f, err := os.OpenFile(d.chunkPath(chunk.Index), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || info.Size() < from {
+		return fmt.Errorf("chunk %d shrank below the start of its hedged request", chunk.Index)
+	}
+	if err := f.Truncate(from); err != nil {
+		return err
+	}
+	if _, err := f.Seek(from, io.SeekStart); err != nil {
+		return err
+	}
+	if err := appendFile(f, hedgePath, false); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// The first request is done, so the chunk is no longer written.
+	size := chunk.End - chunk.Start
+	if d.onBytesWritten != nil && chunk.BytesDownloaded < size {
+		d.onBytesWritten(size - chunk.BytesDownloaded)
+	}
+	chunk.BytesDownloaded = size
+	chunk.Status = state.ChunkComplete
+	d.publish(chunk)
+	return nil
+}
@@ -0,0 +1,166 @@
+package download
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+type staticToken struct{}
+
+func (staticToken) GetAccessToken(context.Context) (string, error) { return "token", nil }
+
+func TestHedgeStragglers(t *testing.T) {
+	fd := &FileDownload{fstate: &state.FileState{Size: 100}, chunkRates: []float64{100, 120, 110}}
+	now := time.Now()
+	slow := &state.ChunkState{Index: 3, Start: 90, End: 100}
+	fd.runs = map[*state.ChunkState]*chunkRun{
+		slow: {start: now.Add(-20 * time.Second), hedge: make(chan struct{})},
+	}
+	fd.runs[slow].bytes.Store(2)
+
+	// 92 of 100 bytes: not yet near the end.
+	fd.liveBytes.Store(92)
+	fd.hedgeStragglers(context.Background(), now)
+	if fd.runs[slow].hedged {
+		t.Fatal("hedged before the file was 95% downloaded")
+	}
+	fd.liveBytes.Store(95)
+	fd.hedgeStragglers(context.Background(), now)
+	if !fd.runs[slow].hedged {
+		t.Fatal("straggler at 0.1 B/s against a median of 110 B/s was not hedged")
+	}
+	select {
+	case <-fd.runs[slow].hedge:
+	default:
+		t.Error("hedge signal not sent")
+	}
+	fd.hedgeStragglers(context.Background(), now) // a second signal would panic
+}
+
+func TestDownloadChunkHedged(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			// The straggler: a little data, then nothing until cancelled.
+			w.Header().Set("Content-Range", "bytes 0-9999/10000")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte(content[:100]))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer srv.Close()
+
+	chunksDir := t.TempDir()
+	var reported atomic.Int64
+	d := NewChunkDownloader(api.NewClient(staticToken{}), srv.URL, chunksDir, func(n int64) { reported.Add(n) }, nil)
+	chunk := &state.ChunkState{Index: 0, Start: 0, End: int64(len(content))}
+	fd := &FileDownload{fstate: &state.FileState{FileID: "EGAF00000000001"}}
+	run, chunk := fd.startRun(chunk)
+
+	// Hedge once the straggler has written its first bytes.
+	go func() {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			if info, err := os.Stat(ChunkPath(chunksDir, 0)); err == nil && info.Size() == 100 {
+				break
+			}
+		}
+		close(run.hedge)
+	}()
+	if err := fd.downloadChunk(context.Background(), d, chunk, run); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(ChunkPath(chunksDir, 0))
+	if err != nil || string(data) != content {
+		t.Fatalf("chunk file = %d bytes, %v; want the %d bytes served", len(data), err, len(content))
+	}
+	if chunk.Status != state.ChunkComplete || chunk.BytesDownloaded != int64(len(content)) || reported.Load() != int64(len(content)) {
+		t.Errorf("chunk %+v, %d bytes reported; want it complete", chunk, reported.Load())
+	}
+	if _, err := os.Stat(ChunkPath(filepath.Join(chunksDir, "hedge"), 0)); !os.IsNotExist(err) {
+		t.Errorf("hedge file left behind: %v", err)
+	}
+}
+
+func TestDownloadChunkHedgedWatched(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			// The straggler: a trickle of data, then nothing until cancelled.
+			w.Header().Set("Content-Range", "bytes 0-9999/10000")
+			w.WriteHeader(http.StatusPartialContent)
+			for i := 0; i < 100; i += 10 {
+				flushWriter{w}.Write([]byte(content[i : i+10]))
+				time.Sleep(5 * time.Millisecond)
+			}
+			<-r.Context().Done()
+			return
+		}
+		http.ServeContent(flushWriter{w}, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer srv.Close()
+
+	fd := &FileDownload{
+		fstate:     &state.FileState{FileID: "EGAF00000000001", Size: int64(len(content))},
+		chunkRates: []float64{1e9, 1e9, 1e9},
+		onChunk:    func(string, state.ChunkState) {},
+	}
+	fd.fstate.Chunks = []state.ChunkState{{Index: 0, Start: 0, End: int64(len(content))}}
+	chunk := &fd.fstate.Chunks[0]
+	// As if the rest of the file were downloaded, so the chunk is hedged.
+	fd.liveBytes.Store(fd.fstate.Size)
+	d := NewChunkDownloader(api.NewClient(staticToken{}), srv.URL, t.TempDir(), nil, nil)
+	run, work := fd.startRun(chunk)
+	d.run = run
+
+	// What the watcher, the progress reports, and the state saves of the
+	// other chunks read while the chunk is written, checked by -race.
+	done, watched := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(watched)
+		reported := int64(-1)
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+			}
+			if run.bytes.Load() >= 50 {
+				fd.hedgeStragglers(context.Background(), time.Now().Add(time.Minute))
+			}
+			fd.reportProgress(&reported)
+			fd.mu.Lock()
+			fd.syncRuns()
+			fd.mu.Unlock()
+		}
+	}()
+	err := fd.downloadChunk(context.Background(), d, work, run)
+	close(done)
+	<-watched
+	fd.endRun(chunk, work, run, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !run.hedged || requests.Load() != 2 {
+		t.Errorf("hedged %v, %d requests; want the straggler hedged", run.hedged, requests.Load())
+	}
+	if chunk.Status != state.ChunkComplete || chunk.BytesDownloaded != int64(len(content)) || run.bytes.Load() != chunk.BytesDownloaded {
+		t.Errorf("chunk %+v, %d bytes published; want it complete", chunk, run.bytes.Load())
+	}
+}
@@ -0,0 +1,35 @@
+package download
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+)
+
+// Indexer writes index files for verified files, such as a .bai file for a
+// BAM file, for download --index.
+type Indexer interface {
+	// Index indexes the file at path and returns the index files it wrote,
+	// each named path plus a suffix (e.g. path.bai). It returns none if the
+	// file's format is not indexed.
+	Index(ctx context.Context, path string) ([]string, error)
+}
+
+// index runs opts.Indexer on the verified file and records the index files
+// it wrote, so they are given the file's permissions and uploaded with it.
+// The file is complete either way, so a failed index is only a warning; if
+// the download is interrupted, the error is returned so the file is verified
+// and indexed again on resume.
+func (fd *FileDownload) index(ctx context.Context) error {
+	path := filepath.Join(fd.stateManager.BaseDir(), fd.fstate.FileName)
+	files, err := fd.opts.Indexer.Index(ctx, path)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Could not index downloaded file", "file", fd.fstate.FileName, "error", err)
+		return nil
+	}
+	fd.indexFiles = files
+	return nil
+}
@@ -1,67 +1,209 @@
 package download
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 
 	"github.com/khan-lab/EGAfetch/internal/state"
 )
 
-// MergeChunks concatenates chunk files into a single output file.
-// It writes to a temp file first, then renames for atomicity.
-func MergeChunks(chunksDir string, outputPath string, chunks []state.ChunkState) error {
-	// Ensure output directory exists.
+// errMergeLost means that the temp file holding the merged leading chunks
+// of a file is missing or shorter than its state records.
+var errMergeLost = errors.New("partly merged file is missing or truncated")
+
+// mergeTmpPath returns the temp file that chunks are merged into before it
+// is renamed to outputPath.
+func mergeTmpPath(outputPath string) string {
+	return outputPath + ".tmp"
+}
+
+// openMergeFile opens the temp file of outputPath for appending after its
+// first merged bytes, dropping anything written past them, with O_DIRECT if
+// direct is set. A new file is created with layout s. It returns
+// errMergeLost if the file holds fewer than merged bytes.
+func openMergeFile(ctx context.Context, outputPath string, merged int64, direct bool, s Striping) (syncWriter, error) {
 	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
-		return fmt.Errorf("create output directory: %w", err)
+		return nil, fmt.Errorf("create output directory: %w", err)
 	}
-
-	tmpPath := outputPath + ".tmp"
-	out, err := os.Create(tmpPath)
+	if merged == 0 {
+		createStriped(ctx, mergeTmpPath(outputPath), s)
+	}
+	out, direct, err := openFile(mergeTmpPath(outputPath), os.O_CREATE|os.O_WRONLY, direct)
 	if err != nil {
-		return fmt.Errorf("create temp output file: %w", err)
+		return nil, fmt.Errorf("create temp output file: %w", err)
 	}
-
-	success := false
-	defer func() {
+	info, err := out.Stat()
+	if err == nil && info.Size() < merged {
+		err = errMergeLost
+	}
+	if err == nil {
+		err = out.Truncate(merged)
+	}
+	if err == nil {
+		_, err = out.Seek(merged, io.SeekStart)
+	}
+	if err != nil {
 		out.Close()
-		if !success {
-			os.Remove(tmpPath)
-		}
-	}()
-
-	for _, chunk := range chunks {
-		chunkPath := ChunkPath(chunksDir, chunk.Index)
-		if err := appendFile(out, chunkPath); err != nil {
-			return fmt.Errorf("merge chunk %d: %w", chunk.Index, err)
-		}
+		return nil, fmt.Errorf("open temp output file: %w", err)
 	}
+	return newWriter(out, direct, merged), nil
+}
 
+// finishMergeFile syncs and closes the merged temp file, then renames it to
+// outputPath.
+func finishMergeFile(out syncWriter, outputPath string) error {
 	if err := out.Sync(); err != nil {
 		return fmt.Errorf("sync output file: %w", err)
 	}
 	if err := out.Close(); err != nil {
 		return fmt.Errorf("close output file: %w", err)
 	}
-
-	if err := os.Rename(tmpPath, outputPath); err != nil {
+	if err := os.Rename(mergeTmpPath(outputPath), outputPath); err != nil {
 		return fmt.Errorf("rename output file: %w", err)
 	}
+	return nil
+}
 
-	success = true
+// mergeReady appends the complete chunks that follow the merge watermark
+// (fstate.MergedBytes) to the temp output file, in order, until it reaches
+// one still downloading. It runs as each chunk completes, so that leading
+// chunks are merged while trailing ones download and the merge phase has
+// little left to do. Each merged chunk file is removed once the watermark
+// past it is saved.
+func (fd *FileDownload) mergeReady(ctx context.Context) error {
+	fd.mergeMu.Lock()
+	defer fd.mergeMu.Unlock()
+
+	chunksDir := fd.stateManager.ChunksPathForFile(fd.fstate.FileID)
+	outputPath := filepath.Join(fd.stateManager.BaseDir(), fd.fstate.FileName)
+	fd.mu.Lock()
+	merged := fd.fstate.MergedBytes
+	fd.mu.Unlock()
+	out, err := openMergeFile(ctx, outputPath, merged, fd.opts.DirectIO, fd.striping())
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	for {
+		fd.mu.Lock()
+		next := fd.nextMergeChunk()
+		fd.mu.Unlock()
+		if next == nil {
+			return nil
+		}
+		chunkPath := ChunkPath(chunksDir, next.Index)
+		if err := appendFile(out, chunkPath, fd.opts.DirectIO); err != nil {
+			return fmt.Errorf("merge chunk %d: %w", next.Index, err)
+		}
+		// The watermark must never get ahead of the data on disk.
+		if err := out.Sync(); err != nil {
+			return fmt.Errorf("sync output file: %w", err)
+		}
+		dropCache(mergeTmpPath(outputPath), next.Start, next.End-next.Start)
+		fd.mu.Lock()
+		fd.fstate.MergedBytes = next.End
+		err := fd.saveState()
+		fd.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("save state: %w", err)
+		}
+		os.Remove(chunkPath)
+	}
+}
+
+// nextMergeChunk returns a copy of the chunk at the merge watermark if it is
+// complete, or nil. fd.mu must be held.
+func (fd *FileDownload) nextMergeChunk() *state.ChunkState {
+	if fd.fstate.MergedBytes >= fd.fstate.Size {
+		return nil
+	}
+	for _, c := range fd.fstate.Chunks {
+		if c.Start == fd.fstate.MergedBytes {
+			if c.Status != state.ChunkComplete {
+				return nil
+			}
+			return &c
+		}
+	}
 	return nil
 }
 
-// appendFile appends the contents of src to dst.
-func appendFile(dst *os.File, srcPath string) error {
-	src, err := os.Open(srcPath)
+// mergeChunks merges the chunks left after the watermark, most having been
+// merged as they completed, and renames the temp file to the output file.
+func (fd *FileDownload) mergeChunks(ctx context.Context) error {
+	outputPath := filepath.Join(fd.stateManager.BaseDir(), fd.fstate.FileName)
+	if fd.fstate.MergedBytes == fd.fstate.Size && fd.mergeLost() {
+		// Renamed before the state was saved; verification checks it.
+		if info, err := os.Stat(outputPath); err == nil && info.Size() == fd.fstate.Size {
+			return nil
+		}
+	}
+	if err := fd.mergeReady(ctx); err != nil {
+		return err
+	}
+	if fd.fstate.MergedBytes < fd.fstate.Size {
+		return fmt.Errorf("merge: chunk at offset %d is not complete", fd.fstate.MergedBytes)
+	}
+	out, err := openMergeFile(ctx, outputPath, fd.fstate.MergedBytes, fd.opts.DirectIO, fd.striping())
+	if err != nil {
+		return err
+	}
+	if err := finishMergeFile(out, outputPath); err != nil {
+		out.Close()
+		return err
+	}
+	return nil
+}
+
+// mergeLost reports whether the temp file no longer holds the merged bytes
+// its state records, as after an interrupted merge was cleaned up or a
+// merged file failed verification.
+func (fd *FileDownload) mergeLost() bool {
+	if fd.fstate.MergedBytes == 0 {
+		return false
+	}
+	info, err := os.Stat(mergeTmpPath(filepath.Join(fd.stateManager.BaseDir(), fd.fstate.FileName)))
+	return err != nil || info.Size() < fd.fstate.MergedBytes
+}
+
+// resetMerged marks the chunks below the merge watermark for download again,
+// after the temp file they were merged into was lost.
+func (fd *FileDownload) resetMerged(ctx context.Context) {
+	slog.WarnContext(ctx, "Partly merged file is gone; downloading its merged chunks again",
+		"file", fd.fstate.FileName, "file_id", fd.fstate.FileID, "merged_bytes", fd.fstate.MergedBytes)
+	for i := range fd.fstate.Chunks {
+		c := &fd.fstate.Chunks[i]
+		if c.End <= fd.fstate.MergedBytes && c.End > c.Start {
+			c.Status = state.ChunkPending
+			c.BytesDownloaded = 0
+		}
+	}
+	fd.fstate.MergedBytes = 0
+	os.Remove(mergeTmpPath(filepath.Join(fd.stateManager.BaseDir(), fd.fstate.FileName)))
+}
+
+// appendFile appends the contents of src to dst, reading it with O_DIRECT
+// if direct is set.
+func appendFile(dst io.Writer, srcPath string, direct bool) error {
+	src, direct, err := openFile(srcPath, os.O_RDONLY, direct)
 	if err != nil {
 		return fmt.Errorf("open chunk %s: %w", srcPath, err)
 	}
 	defer src.Close()
 
-	if _, err := io.Copy(dst, src); err != nil {
+	if direct {
+		// Hide src's WriteTo, which would read into an unaligned buffer.
+		_, err = io.CopyBuffer(dst, struct{ io.Reader }{src}, alignedBuffer(directBufferSize))
+	} else {
+		_, err = io.Copy(dst, src)
+	}
+	if err != nil {
 		return fmt.Errorf("copy chunk %s: %w", srcPath, err)
 	}
 
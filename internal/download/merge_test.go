@@ -0,0 +1,85 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+func TestMergeReady(t *testing.T) {
+	dir := t.TempDir()
+	sm := state.NewStateManager(dir)
+	fd := &FileDownload{
+		stateManager: sm,
+		fstate: &state.FileState{
+			FileID:   "EGAF00000000001",
+			FileName: "a.bam",
+			Size:     9,
+			Chunks: []state.ChunkState{
+				{Index: 0, Start: 0, End: 3},
+				{Index: 1, Start: 3, End: 6},
+				{Index: 2, Start: 6, End: 9},
+			},
+		},
+	}
+	chunksDir := sm.ChunksPathForFile("EGAF00000000001")
+	if err := os.MkdirAll(chunksDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	complete := func(i int, data string) {
+		t.Helper()
+		if err := os.WriteFile(ChunkPath(chunksDir, i), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fd.fstate.Chunks[i].Status = state.ChunkComplete
+		fd.fstate.Chunks[i].BytesDownloaded = 3
+		if err := fd.mergeReady(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A trailing chunk waits for the ones before it.
+	complete(1, "def")
+	if fd.fstate.MergedBytes != 0 {
+		t.Fatalf("merged %d bytes before the first chunk completed", fd.fstate.MergedBytes)
+	}
+	complete(0, "abc")
+	if fd.fstate.MergedBytes != 6 {
+		t.Fatalf("merged %d bytes, want 6", fd.fstate.MergedBytes)
+	}
+	if _, err := os.Stat(ChunkPath(chunksDir, 0)); !os.IsNotExist(err) {
+		t.Errorf("merged chunk file still exists: %v", err)
+	}
+	saved, err := sm.LoadFileState("EGAF00000000001")
+	if err != nil || saved == nil || saved.MergedBytes != 6 {
+		t.Fatalf("saved state %+v, %v; want 6 merged bytes", saved, err)
+	}
+
+	complete(2, "ghi")
+	if err := fd.mergeChunks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "a.bam"))
+	if err != nil || string(data) != "abcdefghi" {
+		t.Fatalf("merged file = %q, %v", data, err)
+	}
+
+	// The merged file is gone, as after failed verification: the merged
+	// chunks are downloaded again.
+	fd.fstate.MergedBytes = 3
+	if !fd.mergeLost() {
+		t.Fatal("lost merge file not detected")
+	}
+	fd.fstate.MergedBytes = 6
+	if err := fd.mergeChunks(context.Background()); !errors.Is(err, errMergeLost) {
+		t.Fatalf("mergeChunks = %v, want errMergeLost", err)
+	}
+	fd.resetMerged(context.Background())
+	if fd.fstate.MergedBytes != 0 || len(fd.fstate.PendingChunks()) != 2 {
+		t.Errorf("after reset: merged %d bytes, %d chunks pending; want 0 and 2", fd.fstate.MergedBytes, len(fd.fstate.PendingChunks()))
+	}
+}
@@ -2,7 +2,12 @@ package download
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 
@@ -16,22 +21,38 @@ type Orchestrator struct {
 	stateManager *state.StateManager
 	opts         DownloadOptions
 	onProgress   ProgressCallback
+	onChunk      ChunkCallback
 	onFileStart  func(fileID, fileName string)
 	onFileDone   func(fileID, fileName string, err error)
 	onFileSkip   func(fileID, fileName string)
+	tuner        *autoTuner   // nil unless opts.AutoTune
+	endpoints    *endpointSet // nil unless opts.Endpoints is set
+	owner        string       // claim owner ID when opts.Shared
+	datasetID    string       // of the manifest being downloaded, for errors; "" if not known
 }
 
+// claimPollInterval is how often a shared download checks whether a file
+// that another process holds has been released.
+const claimPollInterval = 10 * time.Second
+
 // NewOrchestrator creates a download orchestrator.
 func NewOrchestrator(
 	apiClient *api.Client,
 	stateManager *state.StateManager,
 	opts DownloadOptions,
 ) *Orchestrator {
-	return &Orchestrator{
+	o := &Orchestrator{
 		apiClient:    apiClient,
 		stateManager: stateManager,
 		opts:         opts,
 	}
+	if opts.Shared {
+		o.owner = state.NewClaimOwner()
+	}
+	if len(opts.Endpoints) > 0 {
+		o.endpoints = newEndpointSet(opts.Endpoints)
+	}
+	return o
 }
 
 // SetProgressCallback sets the progress callback for download updates.
@@ -39,6 +60,11 @@ func (o *Orchestrator) SetProgressCallback(cb ProgressCallback) {
 	o.onProgress = cb
 }
 
+// SetChunkCallback sets the callback for the progress of individual chunks.
+func (o *Orchestrator) SetChunkCallback(cb ChunkCallback) {
+	o.onChunk = cb
+}
+
 // SetFileCallbacks sets callbacks for file lifecycle events.
 func (o *Orchestrator) SetFileCallbacks(
 	onStart func(fileID, fileName string),
@@ -60,40 +86,84 @@ func (o *Orchestrator) Download(ctx context.Context, manifest *state.Manifest) e
 	if err := o.stateManager.SaveManifest(manifest); err != nil {
 		return fmt.Errorf("save manifest: %w", err)
 	}
+	o.datasetID = manifest.DatasetID
 
-	g, ctx := errgroup.WithContext(ctx)
-	sem := make(chan struct{}, o.opts.ParallelFiles)
+	g, gctx := errgroup.WithContext(ctx)
+	slots := newSlotPool(o.opts.ParallelFiles)
+	if o.opts.AutoTune {
+		o.tuner = newAutoTuner(o.opts.ParallelFiles, o.opts.ParallelChunks)
+		slots = o.tuner.fileSlots
+		go o.tuner.run(gctx)
+	}
 
+	var drained atomic.Bool
 	for _, fileSpec := range manifest.Files {
 		fileSpec := fileSpec
 		g.Go(func() error {
-			// Check if already complete BEFORE acquiring the semaphore so
-			// finished files don't occupy a download slot and can be marked
-			// "skipped" immediately even when the context is cancelled.
-			existing, err := o.stateManager.LoadFileState(fileSpec.FileID)
-			if err != nil {
-				return fmt.Errorf("load state for %s: %w", fileSpec.FileID, err)
-			}
-			if existing != nil && existing.IsComplete() {
-				if o.onFileSkip != nil {
-					o.onFileSkip(fileSpec.FileID, fileSpec.FileName)
-				}
+			err := o.downloadSpec(gctx, slots, fileSpec)
+			if errors.Is(err, errDrained) {
+				// Left for the next run, without cancelling the files in flight.
+				drained.Store(true)
 				return nil
 			}
+			return err
+		})
+	}
 
-			// Acquire file semaphore.
-			select {
-			case sem <- struct{}{}:
-				defer func() { <-sem }()
-			case <-ctx.Done():
-				return ctx.Err()
-			}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	if drained.Load() {
+		return errDrained
+	}
 
-			return o.downloadFile(ctx, fileSpec)
-		})
+	// The files are downloaded either way, so a failure is only a warning.
+	if err := o.writeSums(ctx); err != nil {
+		slog.WarnContext(ctx, "Could not write checksum files", "output_dir", o.stateManager.BaseDir(), "error", err)
 	}
+	return nil
+}
 
-	return g.Wait()
+// downloadSpec downloads spec in one of slots, unless it is already complete.
+func (o *Orchestrator) downloadSpec(ctx context.Context, slots *slotPool, fileSpec state.FileSpec) error {
+	// Check if already complete BEFORE acquiring the semaphore so
+	// finished files don't occupy a download slot and can be marked
+	// "skipped" immediately even when the context is cancelled.
+	existing, err := o.stateManager.LoadFileState(fileSpec.FileID)
+	if err != nil {
+		return fmt.Errorf("load state for %s: %w", fileSpec.FileID, err)
+	}
+	if existing != nil && existing.IsComplete() {
+		if o.onFileSkip != nil {
+			o.onFileSkip(fileSpec.FileID, fileSpec.FileName)
+		}
+		return nil
+	}
+
+	if o.opts.Shared {
+		return o.downloadShared(ctx, slots, fileSpec)
+	}
+
+	// Acquire a file slot.
+	if err := slots.acquire(ctx); err != nil {
+		return err
+	}
+	defer slots.release()
+	if draining(o.opts.Drain) {
+		return errDrained
+	}
+
+	return o.downloadFile(ctx, fileSpec)
+}
+
+// TunedParallelism returns the parallel files and chunks that --auto-tune
+// settled on in the last Download. It reports false if tuning was off.
+func (o *Orchestrator) TunedParallelism() (files, chunks int, ok bool) {
+	if o.tuner == nil {
+		return 0, 0, false
+	}
+	files, chunks = o.tuner.parallelism()
+	return files, chunks, true
 }
 
 // downloadFile downloads a single file, checking if it's already complete.
@@ -109,13 +179,32 @@ func (o *Orchestrator) downloadFile(ctx context.Context, spec state.FileSpec) er
 		}
 		return nil
 	}
+	if existing == nil {
+		skip, err := o.resolveUntracked(ctx, spec)
+		if err != nil {
+			if o.onFileDone != nil {
+				o.onFileDone(spec.FileID, spec.FileName, err)
+			}
+			return err
+		}
+		if skip {
+			if o.onFileSkip != nil {
+				o.onFileSkip(spec.FileID, spec.FileName)
+			}
+			return nil
+		}
+	}
 
 	if o.onFileStart != nil {
 		o.onFileStart(spec.FileID, spec.FileName)
 	}
 
 	fd := NewFileDownload(spec, o.apiClient, o.stateManager, o.opts, o.onProgress)
-	err = fd.Run(ctx)
+	fd.tuner = o.tuner
+	fd.endpoints = o.endpoints
+	fd.retries = processRetries
+	fd.onChunk = o.onChunk
+	err = accessError(ctx, fd.Run(ctx), spec, o.datasetID)
 
 	if o.onFileDone != nil {
 		o.onFileDone(spec.FileID, spec.FileName, err)
@@ -123,3 +212,86 @@ func (o *Orchestrator) downloadFile(ctx context.Context, spec state.FileSpec) er
 
 	return err
 }
+
+// downloadShared downloads spec once this process holds its claim, so that
+// no two processes sharing the output directory download the same file.
+// While another process holds the claim, the file waits, without taking a
+// slot, until the claim is released (the file is then usually complete) or
+// expires.
+func (o *Orchestrator) downloadShared(ctx context.Context, slots *slotPool, spec state.FileSpec) error {
+	waiting := false
+	for {
+		if err := slots.acquire(ctx); err != nil {
+			return err
+		}
+		if draining(o.opts.Drain) {
+			slots.release()
+			return errDrained
+		}
+		claim, holder, err := o.stateManager.ClaimFile(spec.FileID, o.owner)
+		if err != nil {
+			slots.release()
+			return fmt.Errorf("claim %s: %w", spec.FileID, err)
+		}
+		if claim != nil {
+			err := o.downloadClaimed(ctx, spec, claim)
+			slots.release()
+			return err
+		}
+		slots.release()
+
+		if !waiting {
+			slog.DebugContext(ctx, "File is downloading in another process", "file_id", spec.FileID, "host", holder.Host, "pid", holder.PID)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-o.opts.Drain:
+			return errDrained
+		case <-time.After(claimPollInterval):
+		}
+	}
+}
+
+// downloadClaimed downloads spec, renewing claim until it is done and then
+// releasing it. If another process takes the claim over, the download stops
+// with state.ErrClaimLost.
+func (o *Orchestrator) downloadClaimed(ctx context.Context, spec state.FileSpec, claim *state.Claim) error {
+	fileCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var wg sync.WaitGroup
+	stopRenew := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(state.ClaimTTL / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopRenew:
+				return
+			case <-ticker.C:
+			}
+			if err := o.stateManager.RenewClaim(claim); errors.Is(err, state.ErrClaimLost) {
+				cancel(fmt.Errorf("%s: %w", spec.FileID, err))
+				return
+			} else if err != nil {
+				// Retried on the next tick, well before the claim expires.
+				slog.DebugContext(ctx, "Could not renew claim", "file_id", spec.FileID, "error", err)
+			}
+		}
+	}()
+
+	err := o.downloadFile(fileCtx, spec)
+	close(stopRenew)
+	wg.Wait()
+	if cause := context.Cause(fileCtx); errors.Is(cause, state.ErrClaimLost) {
+		return cause
+	}
+	if releaseErr := o.stateManager.ReleaseClaim(claim); releaseErr != nil {
+		slog.DebugContext(ctx, "Could not release claim", "file_id", spec.FileID, "error", releaseErr)
+	}
+	return err
+}
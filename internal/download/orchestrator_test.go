@@ -0,0 +1,55 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+func TestDownloadShared(t *testing.T) {
+	sm := state.NewStateManager(t.TempDir())
+	spec := state.FileSpec{FileID: "EGAF00000000001", FileName: "EGAF00000000001/a.bam", Size: 4}
+	o := NewOrchestrator(nil, sm, DownloadOptions{ParallelFiles: 1, Shared: true})
+	var started, skipped []string
+	o.SetFileCallbacks(
+		func(fileID, _ string) { started = append(started, fileID) },
+		nil,
+		func(fileID, _ string) { skipped = append(skipped, fileID) },
+	)
+
+	// While another process holds the file, it waits without starting it.
+	if _, _, err := sm.ClaimFile(spec.FileID, "other-host-1-00000000"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := o.downloadShared(ctx, newSlotPool(1), spec); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("downloadShared of a held file = %v, want the context's error", err)
+	}
+	if len(started) > 0 {
+		t.Errorf("started %v while another process held it", started)
+	}
+
+	// Once released and complete, it is claimed, skipped, and released.
+	claims, _ := sm.ListClaims()
+	if err := sm.ReleaseClaim(claims[0]); err != nil {
+		t.Fatal(err)
+	}
+	fs := state.NewFileState(spec, 4)
+	fs.Status = state.StatusComplete
+	if err := sm.SaveFileState(fs); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.downloadShared(context.Background(), newSlotPool(1), spec); err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped) != 1 || len(started) > 0 {
+		t.Errorf("started %v, skipped %v; want the complete file skipped", started, skipped)
+	}
+	if claims, _ := sm.ListClaims(); len(claims) != 0 {
+		t.Errorf("claims left after the file = %v", claims)
+	}
+}
@@ -0,0 +1,28 @@
+package download
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// dropCache writes back the n bytes of the file at path from off (to its
+// end if n is 0) and drops them from the page cache, so that a download of
+// terabytes does not evict the cache of every other job on the node. It is
+// advice only: errors are ignored.
+func dropCache(path string, off, n int64) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return
+	}
+	rc.Control(func(fd uintptr) {
+		// DONTNEED skips dirty pages, so wait for them to be written first.
+		unix.SyncFileRange(int(fd), off, n, unix.SYNC_FILE_RANGE_WAIT_BEFORE|unix.SYNC_FILE_RANGE_WRITE|unix.SYNC_FILE_RANGE_WAIT_AFTER)
+		unix.Fadvise(int(fd), off, n, unix.FADV_DONTNEED)
+	})
+}
@@ -0,0 +1,6 @@
+//go:build !linux
+
+package download
+
+// dropCache does nothing: page-cache advice is only given on Linux.
+func dropCache(path string, off, n int64) {}
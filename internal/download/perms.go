@@ -0,0 +1,78 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Permissions are applied to each downloaded file, its .md5 file, and the
+// directories from its parent up to the output directory, so that output on
+// shared project space is readable by collaborators. The zero value leaves
+// everything as created (0644/0755 after the umask, owned by the user's
+// primary group).
+type Permissions struct {
+	FileMode os.FileMode // 0 = as created
+	DirMode  os.FileMode // 0 = as created
+	GID      int         // group to own the output; 0 = as created
+}
+
+func (p Permissions) isZero() bool {
+	return p.FileMode == 0 && p.DirMode == 0 && p.GID == 0
+}
+
+// apply sets the permissions of the output file at path, which lies under
+// baseDir.
+func (p Permissions) apply(baseDir, path string) error {
+	if p.isZero() {
+		return nil
+	}
+	if err := p.set(path, p.FileMode); err != nil {
+		return err
+	}
+	if err := p.set(path+".md5", p.FileMode); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		rel, err := filepath.Rel(baseDir, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+		if err := p.set(dir, p.DirMode); err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+	}
+}
+
+func (p Permissions) set(path string, mode os.FileMode) error {
+	// Change the group first: on Unix, chown clears the setgid bit of files.
+	if p.GID != 0 {
+		if err := os.Chown(path, -1, p.GID); err != nil {
+			return err
+		}
+	}
+	if mode != 0 {
+		return os.Chmod(path, mode)
+	}
+	return nil
+}
+
+// applyPermissions applies opts.Permissions to the downloaded file and its
+// index files. The file is complete either way, so a failure is only a
+// warning.
+func (fd *FileDownload) applyPermissions(ctx context.Context) {
+	path := filepath.Join(fd.stateManager.BaseDir(), fd.fstate.FileName)
+	for _, p := range append([]string{path}, fd.indexFiles...) {
+		if err := fd.opts.Permissions.apply(fd.stateManager.BaseDir(), p); err != nil {
+			slog.WarnContext(ctx, "Could not set permissions of downloaded file", "file", p, "error", err)
+			return
+		}
+	}
+}
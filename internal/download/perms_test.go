@@ -0,0 +1,52 @@
+//go:build !windows
+
+package download
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPermissionsApply(t *testing.T) {
+	root := t.TempDir()
+	base := filepath.Join(root, "out")
+	path := filepath.Join(base, "EGAF00000000001", "a.bam")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{path, path + ".md5"} {
+		if err := os.WriteFile(f, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := Permissions{FileMode: 0o640, DirMode: 0o750 | os.ModeSetgid, GID: os.Getgid()}
+	if err := p.apply(base, path); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]os.FileMode{
+		path:               0o640,
+		path + ".md5":      0o640,
+		filepath.Dir(path): 0o750 | os.ModeSetgid,
+		base:               0o750 | os.ModeSetgid,
+	}
+	for f, mode := range want {
+		info, err := os.Stat(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode() & (os.ModePerm | os.ModeSetgid); got != mode {
+			t.Errorf("%s: mode %v, want %v", f, got, mode)
+		}
+	}
+	// Directories above the output directory are left alone.
+	info, err := os.Stat(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSetgid != 0 {
+		t.Errorf("%s: setgid set outside the output directory", root)
+	}
+}
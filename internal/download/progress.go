@@ -0,0 +1,66 @@
+package download
+
+import "time"
+
+// progressTick is how often the progress of a file being downloaded is
+// passed to its callbacks.
+const progressTick = 100 * time.Millisecond
+
+// addBytes records n more bytes written by a chunk download of the file.
+// It runs for every buffer written, so it only adds to counters: at
+// multi-GB/s, taking locks here would serialize the chunks.
+func (fd *FileDownload) addBytes(n int64) {
+	fd.liveBytes.Add(n)
+	if fd.tuner != nil {
+		fd.tuner.bytes.Add(n)
+	}
+}
+
+// startProgress calls the progress callbacks every progressTick until the
+// returned function is called, and once more then.
+func (fd *FileDownload) startProgress() (stop func()) {
+	if fd.onProgress == nil && fd.onChunk == nil {
+		return func() {}
+	}
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(progressTick)
+		defer ticker.Stop()
+		reported := int64(-1)
+		for {
+			select {
+			case <-done:
+				fd.reportProgress(&reported)
+				return
+			case <-ticker.C:
+				fd.reportProgress(&reported)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// reportProgress passes the bytes downloaded to onProgress, if they changed
+// since *reported, and the chunks in flight to onChunk.
+func (fd *FileDownload) reportProgress(reported *int64) {
+	if current := fd.liveBytes.Load(); fd.onProgress != nil && current != *reported {
+		fd.onProgress(fd.fstate.FileID, current, fd.fstate.Size)
+		*reported = current
+	}
+	if fd.onChunk == nil {
+		return
+	}
+	// Held while reporting, so that a chunk that completes meanwhile is
+	// reported complete after, not before, its last progress.
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	for chunk, run := range fd.runs {
+		c := *chunk
+		c.BytesDownloaded, c.RetryCount = run.bytes.Load(), int(run.retries.Load())
+		fd.onChunk(fd.fstate.FileID, c)
+	}
+}
@@ -0,0 +1,83 @@
+package download
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+func TestProgressAggregated(t *testing.T) {
+	var reports []int64
+	fd := &FileDownload{
+		fstate:     &state.FileState{FileID: "EGAF00000000001", Size: 1 << 20},
+		onProgress: func(_ string, n, _ int64) { reports = append(reports, n) },
+	}
+	stop := fd.startProgress()
+	for range 1024 {
+		fd.addBytes(1024)
+	}
+	stop()
+
+	// Writes within a tick are reported together, and the total once more
+	// when the download ends.
+	if len(reports) == 0 || len(reports) > 2 || reports[len(reports)-1] != 1<<20 {
+		t.Errorf("reported %v for 1024 writes; want at most 2 reports ending at %d", reports, 1<<20)
+	}
+}
+
+// slowReader serves its content a few bytes at a time, for downloads that
+// stay in flight across progress ticks.
+type slowReader struct{ io.ReadSeeker }
+
+func (r slowReader) Read(p []byte) (int, error) {
+	time.Sleep(10 * time.Millisecond)
+	return r.ReadSeeker.Read(p[:min(len(p), 50)])
+}
+
+// flushWriter sends each write to the client at once.
+type flushWriter struct{ http.ResponseWriter }
+
+func (w flushWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.ResponseWriter.(http.Flusher).Flush()
+	return n, err
+}
+
+func TestProgressChunks(t *testing.T) {
+	content := strings.Repeat("0123456789", 400)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(flushWriter{w}, r, "", time.Time{}, slowReader{strings.NewReader(content)})
+	}))
+	defer srv.Close()
+
+	sm := state.NewStateManager(t.TempDir())
+	spec := state.FileSpec{FileID: "EGAF00000000001", FileName: "a.bam", Size: int64(len(content))}
+	fd := NewFileDownload(spec, api.NewClient(staticToken{}), sm,
+		DownloadOptions{ParallelChunks: 4, ChunkSize: 1000, NoHedge: true}, nil)
+	fd.endpoints = newEndpointSet([]string{srv.URL})
+	var mu sync.Mutex
+	var partial int
+	fd.onChunk = func(_ string, c state.ChunkState) {
+		mu.Lock()
+		defer mu.Unlock()
+		if c.BytesDownloaded > 0 && c.BytesDownloaded < c.End-c.Start {
+			partial++
+		}
+	}
+	if err := fd.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Run under -race, this also checks that chunks written meanwhile are
+	// not read by the reports.
+	if partial == 0 {
+		t.Error("no progress reported for chunks in flight")
+	}
+}
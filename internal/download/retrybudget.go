@@ -0,0 +1,119 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// retryBudgetThreshold is how many requests in a row, across all chunks,
+// must fail before every chunk backs off together.
+const retryBudgetThreshold = 3
+
+// processRetries is the retry budget shared by every orchestrator in the
+// process, so that jobs of 'egafetch serve' back off together too.
+var processRetries = &retryBudget{}
+
+// retryBudget coordinates the retries of all chunk downloads. Left alone,
+// 4 files of 8 chunks each back off independently, and an EGA outage draws
+// hundreds of retries at once that keep the server down for longer. Once
+// requests keep failing, the budget pauses all of them, and lets through a
+// single probe request each time the pause, which doubles with every failed
+// probe, runs out. When a request succeeds, the others resume at once.
+type retryBudget struct {
+	mu       sync.Mutex
+	failures int           // requests failed in a row
+	level    int           // pauses in a row
+	until    time.Time     // no requests before this, once failures reach the threshold
+	probing  bool          // a probe request is in flight
+	changed  chan struct{} // closed when the fields above change
+}
+
+// wait blocks until a request may be sent.
+func (b *retryBudget) wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		if b.failures < retryBudgetThreshold {
+			b.mu.Unlock()
+			return nil
+		}
+		now := time.Now()
+		if !now.Before(b.until) && !b.probing {
+			b.probing = true
+			b.mu.Unlock()
+			return nil
+		}
+		changed := b.changedLocked()
+		delay := b.until.Sub(now)
+		b.mu.Unlock()
+
+		timer := time.NewTimer(max(delay, 0))
+		if delay <= 0 {
+			// The probe is in flight: the change is its outcome.
+			timer.Stop()
+		}
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// report records the outcome of a request sent after wait. A pause or
+// resume it causes is logged with ctx, the request's.
+func (b *retryBudget) report(ctx context.Context, err error) {
+	if errors.Is(err, context.Canceled) {
+		b.mu.Lock()
+		b.probing = false
+		b.notifyLocked()
+		b.mu.Unlock()
+		return
+	}
+	outage := err != nil && (isEndpointFailure(err) || isThrottled(err))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if !outage {
+		if b.level > 0 {
+			slog.InfoContext(ctx, "Server responding again; resuming requests")
+		}
+		b.failures, b.level, b.until = 0, 0, time.Time{}
+		b.notifyLocked()
+		return
+	}
+	b.failures++
+	// Requests already in flight when the pause began fail along with the
+	// one that started it, and do not lengthen it.
+	if b.failures >= retryBudgetThreshold && !time.Now().Before(b.until) {
+		delay := min(baseDelay<<b.level, maxDelay) + time.Duration(rand.Intn(1000))*time.Millisecond
+		b.level++
+		b.until = time.Now().Add(delay)
+		slog.WarnContext(ctx, "Server not responding; pausing all requests", "retry_in", delay.Round(time.Second), "error", err)
+	}
+	b.notifyLocked()
+}
+
+// changedLocked returns the channel closed on the next change. b.mu must be
+// held.
+func (b *retryBudget) changedLocked() chan struct{} {
+	if b.changed == nil {
+		b.changed = make(chan struct{})
+	}
+	return b.changed
+}
+
+// notifyLocked wakes the requests waiting for a change. b.mu must be held.
+func (b *retryBudget) notifyLocked() {
+	if b.changed != nil {
+		close(b.changed)
+		b.changed = nil
+	}
+}
@@ -0,0 +1,63 @@
+package download
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+)
+
+func TestRetryBudget(t *testing.T) {
+	b := &retryBudget{}
+	outage := &api.APIError{StatusCode: http.StatusBadGateway}
+	ctx := context.Background()
+
+	// Isolated failures do not pause anything.
+	for range retryBudgetThreshold - 1 {
+		b.report(context.Background(), outage)
+	}
+	if err := b.wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The threshold pauses all requests until the backoff runs out.
+	b.report(context.Background(), outage)
+	short, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := b.wait(short); err == nil {
+		t.Fatal("request allowed during the pause")
+	}
+	// Failures of requests in flight do not lengthen it.
+	until := b.until
+	b.report(context.Background(), outage)
+	if !b.until.Equal(until) || b.level != 1 {
+		t.Errorf("pause changed to level %d until %v by a failure in flight", b.level, b.until)
+	}
+
+	// Once it has run out, one probe goes through, and the rest wait for it.
+	b.until = time.Now()
+	if err := b.wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	released := make(chan error)
+	go func() { released <- b.wait(ctx) }()
+	select {
+	case <-released:
+		t.Fatal("second request allowed while the probe is in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	b.report(context.Background(), nil)
+	select {
+	case err := <-released:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request still waiting after the probe succeeded")
+	}
+	if b.failures != 0 || b.level != 0 {
+		t.Errorf("failures %d, level %d after a success; want both reset", b.failures, b.level)
+	}
+}
@@ -0,0 +1,98 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+func TestDownloadSmall(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	spec := state.FileSpec{FileID: "EGAF00000000001", FileName: "sample.vcf.gz.tbi", Size: int64(len(content))}
+	fd := NewFileDownload(spec, api.NewClient(staticToken{}), state.NewStateManager(dir),
+		DownloadOptions{ChunkSize: 64 << 20, SmallFileSize: DefaultSmallFileSize}, nil)
+	fd.endpoints = newEndpointSet([]string{srv.URL})
+	fd.fstate = state.NewFileState(spec, fd.opts.ChunkSize)
+	if !fd.isSmall() {
+		t.Fatal("a 10 KB file is not small")
+	}
+	os.WriteFile(mergeTmpPath(filepath.Join(dir, spec.FileName)), []byte("stale"), 0644)
+
+	if err := fd.downloadSmall(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, spec.FileName))
+	if err != nil || string(data) != content {
+		t.Fatalf("output file = %d bytes, %v; want the %d bytes served", len(data), err, len(content))
+	}
+	if c := fd.fstate.Chunks; len(c) != 1 || c[0].Status != state.ChunkComplete || c[0].BytesDownloaded != spec.Size {
+		t.Errorf("chunks = %+v; want one complete chunk", c)
+	}
+	if _, err := os.Stat(mergeTmpPath(filepath.Join(dir, spec.FileName))); !os.IsNotExist(err) {
+		t.Errorf("temp file left behind: %v", err)
+	}
+	if fd.opts.SmallFileSize = 0; fd.isSmall() {
+		t.Error("file small with SmallFileSize 0")
+	}
+}
+
+func TestSmallFilesReuseConnections(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+	var conns atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	srv.Config.ConnState = func(_ net.Conn, s http.ConnState) {
+		if s == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	// 10 rounds of 8 files downloaded at once, every connection idle
+	// between rounds.
+	const workers = 8
+	dir := t.TempDir()
+	client := api.NewClient(staticToken{})
+	endpoints := newEndpointSet([]string{srv.URL})
+	for round := range 10 {
+		var wg sync.WaitGroup
+		for w := range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				spec := state.FileSpec{FileID: fmt.Sprintf("EGAF%05d%06d", round, w), FileName: fmt.Sprintf("%d-%d.tbi", round, w), Size: int64(len(content))}
+				fd := NewFileDownload(spec, client, state.NewStateManager(dir), DownloadOptions{ChunkSize: 64 << 20, SmallFileSize: DefaultSmallFileSize}, nil)
+				fd.endpoints = endpoints
+				fd.fstate = state.NewFileState(spec, fd.opts.ChunkSize)
+				if err := fd.downloadSmall(context.Background()); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	// A connection may be dialled before an idle one is back in the pool.
+	if n := conns.Load(); n > 2*workers {
+		t.Errorf("%d connections opened for %d files at a time; want them kept open between files", n, workers)
+	}
+}
@@ -0,0 +1,69 @@
+package download
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// StripeMinSize is the size from which output files are given
+// DownloadOptions.Striping: smaller files gain nothing from striping.
+const StripeMinSize = 1 << 30
+
+// StripeSizeUnit is the unit of Lustre stripe sizes.
+const StripeSizeUnit = 64 * 1024
+
+// Striping is the Lustre layout given to large output files. The default
+// layout of many scratch filesystems puts a file on a single OST, which
+// routinely halves the write throughput of a multi-hundred-GB file.
+type Striping struct {
+	Count int   // OSTs to stripe over; -1 = all of them, 0 = the filesystem default
+	Size  int64 // bytes per stripe, a multiple of StripeSizeUnit; 0 = the filesystem default
+}
+
+// IsZero reports whether s leaves the layout to the filesystem.
+func (s Striping) IsZero() bool {
+	return s.Count == 0 && s.Size == 0
+}
+
+// striping returns the layout of the output file of fd: opts.Striping if
+// the file is large enough, else none.
+func (fd *FileDownload) striping() Striping {
+	if fd.fstate.Size < StripeMinSize {
+		return Striping{}
+	}
+	return fd.opts.Striping
+}
+
+// createStriped creates the file at path, which must not exist yet, with
+// layout s if it is on Lustre. Striping is only an optimization: if it
+// cannot be set, the file is left to be created as usual.
+func createStriped(ctx context.Context, path string, s Striping) {
+	if s.IsZero() {
+		return
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		return
+	}
+	if !onLustre(path) {
+		return
+	}
+	if out, err := exec.Command("lfs", setstripeArgs(path, s)...).CombinedOutput(); err != nil {
+		slog.WarnContext(ctx, "Could not set Lustre striping", "file", path, "stripe_count", s.Count, "stripe_size", s.Size,
+			"error", err, "output", string(out))
+	}
+}
+
+// setstripeArgs returns the arguments of lfs that create path with layout s.
+func setstripeArgs(path string, s Striping) []string {
+	args := []string{"setstripe"}
+	if s.Count != 0 {
+		args = append(args, "-c", strconv.Itoa(s.Count))
+	}
+	if s.Size != 0 {
+		args = append(args, "-S", strconv.FormatInt(s.Size, 10))
+	}
+	return append(args, path)
+}
@@ -0,0 +1,19 @@
+package download
+
+import (
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// lustreSuperMagic is the statfs filesystem type of Lustre.
+const lustreSuperMagic = 0x0BD00BD0
+
+// onLustre reports whether path would be created on a Lustre filesystem.
+func onLustre(path string) bool {
+	var st unix.Statfs_t
+	if err := unix.Statfs(filepath.Dir(path), &st); err != nil {
+		return false
+	}
+	return uint32(st.Type) == lustreSuperMagic
+}
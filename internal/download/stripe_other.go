@@ -0,0 +1,8 @@
+//go:build !linux
+
+package download
+
+// onLustre reports false: Lustre clients only run on Linux.
+func onLustre(path string) bool {
+	return false
+}
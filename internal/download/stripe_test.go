@@ -0,0 +1,46 @@
+package download
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+func TestSetstripeArgs(t *testing.T) {
+	tests := []struct {
+		s    Striping
+		want []string
+	}{
+		{Striping{Count: 8, Size: 4 << 20}, []string{"setstripe", "-c", "8", "-S", "4194304", "f.tmp"}},
+		{Striping{Count: -1}, []string{"setstripe", "-c", "-1", "f.tmp"}},
+		{Striping{Size: 1 << 20}, []string{"setstripe", "-S", "1048576", "f.tmp"}},
+	}
+	for _, tt := range tests {
+		if got := setstripeArgs("f.tmp", tt.s); !slices.Equal(got, tt.want) {
+			t.Errorf("setstripeArgs(%+v) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestStriping(t *testing.T) {
+	fd := &FileDownload{opts: DownloadOptions{Striping: Striping{Count: 4}}}
+	fd.fstate = &state.FileState{Size: StripeMinSize - 1}
+	if s := fd.striping(); !s.IsZero() {
+		t.Errorf("striping of a small file = %+v, want none", s)
+	}
+	fd.fstate.Size = StripeMinSize
+	if s := fd.striping(); s.Count != 4 {
+		t.Errorf("striping of a large file = %+v, want --stripe-count 4", s)
+	}
+
+	// Elsewhere than on Lustre, the file is left to be created as usual.
+	path := filepath.Join(t.TempDir(), "a.bam.tmp")
+	createStriped(context.Background(), path, fd.striping())
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file on a non-Lustre filesystem created by createStriped: %v", err)
+	}
+}
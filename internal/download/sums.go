@@ -0,0 +1,192 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+	"github.com/khan-lab/EGAfetch/internal/verify"
+)
+
+// Checksum files written to the output directory once a download completes,
+// covering every complete file in it, in the format of md5sum and sha256sum
+// (check with `md5sum -c MD5SUMS` from the output directory).
+const (
+	MD5SumsFile    = "MD5SUMS"
+	SHA256SumsFile = "SHA256SUMS"
+)
+
+// GeneratedNames returns the files a download with opts writes into the
+// output directory besides those of its manifest, for
+// state.UniqueFileNames: the checksum files, with opts.MD5Files the .md5
+// file of each file, and the index that indexName, if not nil, gives for
+// each file it indexes.
+func GeneratedNames(opts DownloadOptions, indexName func(name string) (string, bool)) state.GeneratedNames {
+	return state.GeneratedNames{
+		Fixed: []string{MD5SumsFile, SHA256SumsFile},
+		For: func(name string) []string {
+			var names []string
+			if opts.MD5Files {
+				names = append(names, name+".md5")
+			}
+			if indexName != nil {
+				if index, ok := indexName(name); ok {
+					names = append(names, index)
+				}
+			}
+			return names
+		},
+	}
+}
+
+// writeSums writes MD5SUMS and SHA256SUMS for the complete files in the
+// output directory, applies opts.Permissions to them, and sends them to
+// opts.Uploader if there is one.
+func (o *Orchestrator) writeSums(ctx context.Context) error {
+	states, err := o.stateManager.ListFileStates()
+	if err != nil {
+		return err
+	}
+
+	// Files whose state was removed (e.g. by egafetch clean) keep their
+	// entries from the previous checksum files while they are still there.
+	entries := o.previousSums()
+	for _, f := range states {
+		name := filepath.ToSlash(f.FileName)
+		delete(entries, name)
+		if !f.IsComplete() {
+			continue
+		}
+		if f.MD5 == "" || f.SHA256 == "" {
+			// Completed by a version that did not record the checksums.
+			sums, err := verify.ComputeSums(filepath.Join(o.stateManager.BaseDir(), f.FileName))
+			if errors.Is(err, fs.ErrNotExist) {
+				slog.WarnContext(ctx, "Leaving file out of checksum files: not in the output directory", "file", f.FileName, "file_id", f.FileID)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			f.MD5, f.SHA256 = sums.MD5, sums.SHA256
+			if err := o.stateManager.SaveFileState(f); err != nil {
+				return fmt.Errorf("save state: %w", err)
+			}
+		}
+		entries[name] = verify.Sums{MD5: f.MD5, SHA256: f.SHA256}
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var md5s, sha256s strings.Builder
+	for _, name := range names {
+		md5s.WriteString(sumsLine(entries[name].MD5, name))
+		sha256s.WriteString(sumsLine(entries[name].SHA256, name))
+	}
+
+	for _, sums := range []struct{ name, content string }{
+		{MD5SumsFile, md5s.String()},
+		{SHA256SumsFile, sha256s.String()},
+	} {
+		path := filepath.Join(o.stateManager.BaseDir(), sums.name)
+		if err := writeFileAtomic(path, sums.content); err != nil {
+			return fmt.Errorf("write %s: %w", sums.name, err)
+		}
+		if !o.opts.Permissions.isZero() {
+			if err := o.opts.Permissions.set(path, o.opts.Permissions.FileMode); err != nil {
+				slog.WarnContext(ctx, "Could not set permissions of checksum file", "file", path, "error", err)
+			}
+		}
+		if o.opts.Uploader != nil {
+			if err := o.opts.Uploader.Upload(ctx, path, sums.name); err != nil {
+				return fmt.Errorf("upload %s: %w", sums.name, err)
+			}
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("remove uploaded file: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// previousSums returns the entries of the checksum files already in the
+// output directory whose files are still there, by slash-separated name.
+func (o *Orchestrator) previousSums() map[string]verify.Sums {
+	entries := make(map[string]verify.Sums)
+	md5s := readSums(filepath.Join(o.stateManager.BaseDir(), MD5SumsFile))
+	for name, sha256sum := range readSums(filepath.Join(o.stateManager.BaseDir(), SHA256SumsFile)) {
+		md5sum, ok := md5s[name]
+		if !ok {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(o.stateManager.BaseDir(), filepath.FromSlash(name))); err != nil {
+			continue
+		}
+		entries[name] = verify.Sums{MD5: md5sum, SHA256: sha256sum}
+	}
+	return entries
+}
+
+// readSums parses a checksum file written by writeSums into checksums by
+// name. A missing or unreadable file has no entries.
+func readSums(path string) map[string]string {
+	sums := make(map[string]string)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return sums
+	}
+	unescape := strings.NewReplacer(`\\`, `\`, `\n`, "\n")
+	for _, line := range strings.Split(string(data), "\n") {
+		escaped := strings.HasPrefix(line, `\`)
+		sum, name, ok := strings.Cut(strings.TrimPrefix(line, `\`), "  ")
+		if !ok {
+			continue
+		}
+		if escaped {
+			name = unescape.Replace(name)
+		}
+		sums[name] = sum
+	}
+	return sums
+}
+
+// sumsLine returns the checksum file line for the file with the given
+// slash-separated name, escaped as coreutils does: in a name with a
+// backslash or newline, they are written as \\ and \n and the line starts
+// with a backslash.
+func sumsLine(sum, name string) string {
+	if !strings.ContainsAny(name, "\\\n") {
+		return sum + "  " + name + "\n"
+	}
+	return `\` + sum + "  " + strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(name) + "\n"
+}
+
+// writeFileAtomic replaces path with content through a temporary file, so
+// that processes sharing the directory never see a partial file.
+func writeFileAtomic(path, content string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,113 @@
+package download
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+func TestWriteSums(t *testing.T) {
+	dir := t.TempDir()
+	sm := state.NewStateManager(dir)
+	for _, f := range []struct {
+		spec   state.FileSpec
+		status state.FileStatus
+		md5    string
+	}{
+		{state.FileSpec{FileID: "EGAF2", FileName: filepath.Join("EGAF2", "b.bam")}, state.StatusComplete, "bb"},
+		{state.FileSpec{FileID: "EGAF1", FileName: filepath.Join("EGAF1", "a\n1.bam")}, state.StatusComplete, "aa"},
+		{state.FileSpec{FileID: "EGAF3", FileName: filepath.Join("EGAF3", "c.bam")}, state.StatusDownloading, ""},
+		// Completed without recorded checksums: computed from the file.
+		{state.FileSpec{FileID: "EGAF4", FileName: filepath.Join("EGAF4", "d.bam")}, state.StatusComplete, ""},
+	} {
+		fs := state.NewFileState(f.spec, 4)
+		fs.Status = f.status
+		if f.md5 != "" {
+			fs.MD5, fs.SHA256 = f.md5, f.md5+f.md5
+		}
+		if err := sm.SaveFileState(fs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, "EGAF4", "d.bam")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := md5.Sum([]byte("data"))
+	dataMD5 := hex.EncodeToString(sum[:])
+
+	u := &fakeUploader{}
+	o := NewOrchestrator(nil, sm, DownloadOptions{Uploader: u})
+	u.err = os.ErrPermission
+	if err := o.writeSums(context.Background()); err == nil {
+		t.Fatal("writeSums with a failing upload succeeded")
+	}
+	u.err, u.names = nil, nil
+
+	o.opts.Uploader = nil
+	if err := o.writeSums(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, MD5SumsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `\aa  EGAF1/a\n1.bam` + "\n" +
+		"bb  EGAF2/b.bam\n" +
+		dataMD5 + "  EGAF4/d.bam\n"
+	if string(data) != want {
+		t.Errorf("%s =\n%s\nwant\n%s", MD5SumsFile, data, want)
+	}
+	data, err = os.ReadFile(filepath.Join(dir, SHA256SumsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\nbbbb  EGAF2/b.bam\n"; !strings.Contains(string(data), want) {
+		t.Errorf("%s =\n%s\nmissing %q", SHA256SumsFile, data, want)
+	}
+	if fs, _ := sm.LoadFileState("EGAF4"); fs == nil || fs.MD5 != dataMD5 {
+		t.Errorf("computed checksum not saved in state: %+v", fs)
+	}
+	if got := readSums(filepath.Join(dir, MD5SumsFile))["EGAF1/a\n1.bam"]; got != "aa" {
+		t.Errorf("readSums of escaped name = %q, want %q", got, "aa")
+	}
+
+	// Without their state, files keep their entries while they exist.
+	for _, id := range []string{"EGAF2", "EGAF4"} {
+		if err := sm.DeleteFileState(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := o.writeSums(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(filepath.Join(dir, MD5SumsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `\aa  EGAF1/a\n1.bam` + "\n" + dataMD5 + "  EGAF4/d.bam\n"; string(data) != want {
+		t.Errorf("%s after removing state =\n%s\nwant\n%s", MD5SumsFile, data, want)
+	}
+
+	// Uploaded checksum files are removed locally.
+	o.opts.Uploader = u
+	if err := o.writeSums(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{MD5SumsFile, SHA256SumsFile}; !slices.Equal(u.names, want) {
+		t.Errorf("uploaded %v, want %v", u.names, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, MD5SumsFile)); !os.IsNotExist(err) {
+		t.Errorf("%s kept after upload: %v", MD5SumsFile, err)
+	}
+}
@@ -0,0 +1,46 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Uploader copies verified files to remote storage, for outputs that are not
+// a local directory (e.g. --output rclone:remote:path). The output directory
+// then only stages each file until it is uploaded.
+type Uploader interface {
+	// Upload copies the file at localPath to name, a slash-separated path
+	// relative to the remote output.
+	Upload(ctx context.Context, localPath, name string) error
+}
+
+// upload sends the verified file, its .md5 file, and its index files to
+// opts.Uploader, then removes the local copies. If the upload fails, the file
+// fails with its chunks kept, so a retry merges and uploads it without
+// downloading it again.
+func (fd *FileDownload) upload(ctx context.Context) error {
+	path := filepath.Join(fd.stateManager.BaseDir(), fd.fstate.FileName)
+	files := []string{path}
+	if _, err := os.Stat(path + ".md5"); err == nil {
+		files = append(files, path+".md5")
+	}
+	files = append(files, fd.indexFiles...)
+	for _, p := range files {
+		// Other files are named path plus a suffix.
+		name := filepath.ToSlash(fd.fstate.FileName) + strings.TrimPrefix(p, path)
+		if err := fd.opts.Uploader.Upload(ctx, p, name); err != nil {
+			return fmt.Errorf("upload %s: %w", name, err)
+		}
+	}
+	for _, p := range files {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("remove uploaded file: %w", err)
+		}
+	}
+	return nil
+}
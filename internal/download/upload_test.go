@@ -0,0 +1,72 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+type fakeUploader struct {
+	names []string
+	err   error
+}
+
+func (u *fakeUploader) Upload(_ context.Context, localPath, name string) error {
+	if _, err := os.Stat(localPath); err != nil {
+		return err
+	}
+	u.names = append(u.names, name)
+	return u.err
+}
+
+func TestUpload(t *testing.T) {
+	for _, fail := range []bool{false, true} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "EGAF00000000001", "a.bam")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range []string{path, path + ".md5", path + ".bai"} {
+			if err := os.WriteFile(f, []byte("data"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		u := &fakeUploader{}
+		if fail {
+			u.err = errors.New("quota exceeded")
+		}
+		fd := &FileDownload{
+			stateManager: state.NewStateManager(dir),
+			fstate:       &state.FileState{FileName: filepath.Join("EGAF00000000001", "a.bam")},
+			opts:         DownloadOptions{Uploader: u},
+			indexFiles:   []string{path + ".bai"},
+		}
+		err := fd.upload(context.Background())
+
+		_, statErr := os.Stat(path)
+		if fail {
+			if err == nil || statErr != nil {
+				t.Errorf("failed upload: err = %v, local file error = %v; want an error and the file kept", err, statErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"EGAF00000000001/a.bam", "EGAF00000000001/a.bam.md5", "EGAF00000000001/a.bam.bai"}; !slices.Equal(u.names, want) {
+			t.Errorf("uploaded %v, want %v", u.names, want)
+		}
+		if !errors.Is(statErr, os.ErrNotExist) {
+			t.Errorf("local file not removed after upload: %v", statErr)
+		}
+		if _, err := os.Stat(path + ".bai"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("index file not removed after upload: %v", err)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNoDesktop is returned by Desktop when there is no desktop session or
+// notification tool to show a notification with.
+var ErrNoDesktop = errors.New("no desktop session")
+
+// desktopTimeout bounds the helper process that shows a notification.
+const desktopTimeout = 10 * time.Second
+
+// Desktop shows a native desktop notification: Notification Center on
+// macOS, notify-send on Linux and BSD, and a toast on Windows.
+func Desktop(ctx context.Context, title, message string) error {
+	ctx, cancel := context.WithTimeout(ctx, desktopTimeout)
+	defer cancel()
+	return desktopNotify(ctx, title, message)
+}
@@ -0,0 +1,17 @@
+//go:build darwin
+
+package notify
+
+import (
+	"context"
+	"os/exec"
+)
+
+func desktopNotify(ctx context.Context, title, message string) error {
+	// Pass the text as arguments so it needs no AppleScript quoting.
+	return exec.CommandContext(ctx, "osascript",
+		"-e", "on run argv",
+		"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+		"-e", "end run",
+		title, message).Run()
+}
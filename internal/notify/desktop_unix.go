@@ -0,0 +1,20 @@
+//go:build !darwin && !windows
+
+package notify
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+func desktopNotify(ctx context.Context, title, message string) error {
+	if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+		return ErrNoDesktop
+	}
+	path, err := exec.LookPath("notify-send")
+	if err != nil {
+		return ErrNoDesktop
+	}
+	return exec.CommandContext(ctx, path, "--app-name=EGAfetch", title, message).Run()
+}
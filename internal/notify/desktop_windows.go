@@ -0,0 +1,27 @@
+//go:build windows
+
+package notify
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+// toastScript shows a toast under PowerShell's app ID, which every Windows
+// install has registered. The text comes from the environment so it needs
+// no PowerShell quoting.
+const toastScript = `$ErrorActionPreference = 'Stop'
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $xml.GetElementsByTagName('text')
+$text.Item(0).AppendChild($xml.CreateTextNode($env:EGAFETCH_NOTIFY_TITLE)) | Out-Null
+$text.Item(1).AppendChild($xml.CreateTextNode($env:EGAFETCH_NOTIFY_MESSAGE)) | Out-Null
+$notifier = [Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe')
+$notifier.Show([Windows.UI.Notifications.ToastNotification]::new($xml))`
+
+func desktopNotify(ctx context.Context, title, message string) error {
+	cmd := exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", toastScript)
+	cmd.Env = append(os.Environ(), "EGAFETCH_NOTIFY_TITLE="+title, "EGAFETCH_NOTIFY_MESSAGE="+message)
+	return cmd.Run()
+}
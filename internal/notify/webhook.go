@@ -0,0 +1,217 @@
+// Package notify reports download events to webhooks and chat services, so
+// long downloads can be followed without watching the terminal.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/khan-lab/EGAfetch/internal/ui"
+)
+
+// Webhook payload formats.
+const (
+	FormatAuto  = "auto"  // Slack or Teams from the URL host, JSON otherwise
+	FormatJSON  = "json"  // the Event as JSON
+	FormatSlack = "slack" // Slack incoming webhook message
+	FormatTeams = "teams" // Microsoft Teams Adaptive Card message
+)
+
+// Formats lists the accepted --webhook-format values.
+var Formats = []string{FormatAuto, FormatJSON, FormatSlack, FormatTeams}
+
+// Event types.
+const (
+	EventFileFailed  = "file_failed"
+	EventRunFinished = "run_finished"
+)
+
+// Run statuses in EventRunFinished events.
+const (
+	StatusComplete    = "complete"
+	StatusPartial     = "partial"
+	StatusFailed      = "failed"
+	StatusInterrupted = "interrupted"
+)
+
+// Event is one notification. Fields that do not apply to the event type
+// are left empty.
+type Event struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	Host  string    `json:"host,omitempty"`
+
+	// file_failed
+	OutputDir string `json:"output_dir,omitempty"`
+	FileID    string `json:"file_id,omitempty"`
+	FileName  string `json:"file_name,omitempty"`
+
+	Summary *RunSummary `json:"summary,omitempty"` // run_finished
+	Error   string      `json:"error,omitempty"`
+}
+
+// RunSummary describes a finished download run.
+type RunSummary struct {
+	Status             string   `json:"status"`
+	OutputDirs         []string `json:"output_dirs"`
+	DatasetIDs         []string `json:"dataset_ids,omitempty"`
+	TotalFiles         int      `json:"total_files"`
+	Complete           int      `json:"complete"`
+	Failed             int      `json:"failed"`
+	TotalSize          int64    `json:"total_size"`
+	DurationSeconds    float64  `json:"duration_seconds"`
+	SuppressedFailures int      `json:"suppressed_failures,omitempty"` // file_failed events not sent
+}
+
+// Text renders e as a short message for chat formats.
+func (e Event) Text() string {
+	var b strings.Builder
+	switch e.Event {
+	case EventFileFailed:
+		fmt.Fprintf(&b, "EGAfetch: %s (%s) failed", e.FileName, e.FileID)
+		if e.Host != "" {
+			fmt.Fprintf(&b, " on %s", e.Host)
+		}
+		if e.Error != "" {
+			fmt.Fprintf(&b, ": %s", e.Error)
+		}
+	case EventRunFinished:
+		r := e.Summary
+		if r == nil {
+			r = &RunSummary{}
+		}
+		fmt.Fprintf(&b, "EGAfetch download %s", r.Status)
+		if e.Host != "" {
+			fmt.Fprintf(&b, " on %s", e.Host)
+		}
+		fmt.Fprintf(&b, ": %s", r.Line())
+		if len(r.DatasetIDs) > 0 {
+			fmt.Fprintf(&b, "\nDatasets: %s", strings.Join(r.DatasetIDs, ", "))
+		}
+		if len(r.OutputDirs) > 0 {
+			fmt.Fprintf(&b, "\nOutput: %s", strings.Join(r.OutputDirs, ", "))
+		}
+		if e.Error != "" {
+			fmt.Fprintf(&b, "\nError: %s", e.Error)
+		}
+	default:
+		b.WriteString("EGAfetch: " + e.Event)
+	}
+	return b.String()
+}
+
+// Line summarizes the file counts, size, and duration of the run.
+func (r *RunSummary) Line() string {
+	line := fmt.Sprintf("%d of %d file(s) complete", r.Complete, r.TotalFiles)
+	if r.Failed > 0 {
+		line += fmt.Sprintf(", %d failed", r.Failed)
+	}
+	return line + fmt.Sprintf(" (%s, %s)", ui.FormatBytes(r.TotalSize), time.Duration(r.DurationSeconds)*time.Second)
+}
+
+// Webhook posts events to one URL.
+type Webhook struct {
+	URL    string
+	Format string // one of Formats; "" means FormatAuto
+}
+
+// sendTimeout bounds one webhook request.
+const sendTimeout = 15 * time.Second
+
+var httpClient = &http.Client{Timeout: sendTimeout}
+
+// Send posts e to the webhook in its format.
+func (w Webhook) Send(ctx context.Context, e Event) error {
+	body, err := Payload(w.format(), e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		// The URL itself is often the secret; report only the host.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("post to %s: %w", w.Host(), urlErr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("post to %s: HTTP %d: %s", w.Host(), resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Host returns the webhook's host name, which unlike the full URL is safe to
+// log.
+func (w Webhook) Host() string {
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return "webhook"
+	}
+	return u.Host
+}
+
+func (w Webhook) format() string {
+	if w.Format != "" && w.Format != FormatAuto {
+		return w.Format
+	}
+	return DetectFormat(w.URL)
+}
+
+// DetectFormat picks the payload format for a webhook URL from its host.
+func DetectFormat(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return FormatJSON
+	}
+	host := strings.ToLower(u.Hostname())
+	switch {
+	case host == "hooks.slack.com":
+		return FormatSlack
+	case strings.HasSuffix(host, ".webhook.office.com"), host == "outlook.office.com",
+		strings.HasSuffix(host, ".logic.azure.com"), strings.HasSuffix(host, ".powerplatform.com"):
+		return FormatTeams
+	}
+	return FormatJSON
+}
+
+// Payload encodes e as a request body in the given format.
+func Payload(format string, e Event) ([]byte, error) {
+	switch format {
+	case FormatJSON:
+		return json.Marshal(e)
+	case FormatSlack:
+		return json.Marshal(map[string]string{"text": e.Text()})
+	case FormatTeams:
+		card := map[string]interface{}{
+			"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+			"type":    "AdaptiveCard",
+			"version": "1.4",
+			"body": []map[string]interface{}{
+				{"type": "TextBlock", "text": e.Text(), "wrap": true},
+			},
+		}
+		return json.Marshal(map[string]interface{}{
+			"type": "message",
+			"attachments": []map[string]interface{}{
+				{"contentType": "application/vnd.microsoft.card.adaptive", "content": card},
+			},
+		})
+	}
+	return nil, fmt.Errorf("unknown webhook format %q", format)
+}
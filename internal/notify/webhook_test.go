@@ -0,0 +1,97 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://hooks.slack.com/services/T000/B000/XXXX", FormatSlack},
+		{"https://example.webhook.office.com/webhookb2/abc", FormatTeams},
+		{"https://prod-01.westeurope.logic.azure.com:443/workflows/abc", FormatTeams},
+		{"https://monitor.example.org/egafetch", FormatJSON},
+		{"::not a url", FormatJSON},
+	}
+	for _, tt := range tests {
+		if got := DetectFormat(tt.url); got != tt.want {
+			t.Errorf("DetectFormat(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	event := Event{
+		Event: EventRunFinished,
+		Host:  "node1",
+		Summary: &RunSummary{
+			Status:     StatusPartial,
+			OutputDirs: []string{"/data/ega"},
+			TotalFiles: 3,
+			Complete:   2,
+			Failed:     1,
+			TotalSize:  3 << 30,
+		},
+		Error: "1 of 3 file(s) failed",
+	}
+
+	tests := []struct {
+		format string
+		check  func(t *testing.T, body map[string]interface{})
+	}{
+		{FormatJSON, func(t *testing.T, body map[string]interface{}) {
+			summary, _ := body["summary"].(map[string]interface{})
+			if body["event"] != EventRunFinished || summary["status"] != StatusPartial || summary["complete"] != 2.0 {
+				t.Errorf("body = %v", body)
+			}
+		}},
+		{FormatSlack, func(t *testing.T, body map[string]interface{}) {
+			text, _ := body["text"].(string)
+			if !strings.Contains(text, "download partial on node1: 2 of 3 file(s) complete, 1 failed") {
+				t.Errorf("text = %q", text)
+			}
+		}},
+		{FormatTeams, func(t *testing.T, body map[string]interface{}) {
+			if body["type"] != "message" || len(body["attachments"].([]interface{})) != 1 {
+				t.Errorf("body = %v", body)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var body map[string]interface{}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				data, _ := io.ReadAll(r.Body)
+				if err := json.Unmarshal(data, &body); err != nil {
+					t.Errorf("invalid JSON %q: %v", data, err)
+				}
+			}))
+			defer srv.Close()
+
+			if err := (Webhook{URL: srv.URL, Format: tt.format}).Send(context.Background(), event); err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, body)
+		})
+	}
+
+	t.Run("error status", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "invalid_token", http.StatusForbidden)
+		}))
+		defer srv.Close()
+		err := (Webhook{URL: srv.URL + "/secret-path"}).Send(context.Background(), event)
+		if err == nil || !strings.Contains(err.Error(), "HTTP 403: invalid_token") || strings.Contains(err.Error(), "secret-path") {
+			t.Errorf("error = %v", err)
+		}
+	})
+}
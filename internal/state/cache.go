@@ -0,0 +1,111 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	metadataCacheDir = "metadata-cache"
+	// Cache entries are named by fetch time so the newest sorts last.
+	cacheTimestampLayout = "20060102T150405Z"
+)
+
+// MetadataCachePath returns the path to .egafetch/metadata-cache/.
+func (sm *StateManager) MetadataCachePath() string {
+	return filepath.Join(sm.EgafetchPath(), metadataCacheDir)
+}
+
+// metadataCacheDirFor returns .egafetch/metadata-cache/<datasetID>/.
+func (sm *StateManager) metadataCacheDirFor(datasetID string) string {
+	return filepath.Join(sm.MetadataCachePath(), datasetID)
+}
+
+// LoadMetadataCache decodes the newest cached metadata for datasetID into v
+// and returns the time it was fetched. A zero time means there is no entry
+// or the newest entry is older than maxAge (maxAge <= 0 disables the check).
+func (sm *StateManager) LoadMetadataCache(datasetID string, maxAge time.Duration, v interface{}) (time.Time, error) {
+	path, fetchedAt, err := sm.latestMetadataCache(datasetID)
+	if err != nil || path == "" {
+		return time.Time{}, err
+	}
+	if maxAge > 0 && time.Since(fetchedAt) > maxAge {
+		return time.Time{}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("read metadata cache: %w", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return time.Time{}, fmt.Errorf("parse metadata cache %s: %w", path, err)
+	}
+	return fetchedAt, nil
+}
+
+// SaveMetadataCache writes v as the newest cached metadata for datasetID and
+// removes older entries for the same dataset.
+func (sm *StateManager) SaveMetadataCache(datasetID string, v interface{}) error {
+	dir := sm.metadataCacheDirFor(datasetID)
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return fmt.Errorf("create directory %s: %w", dir, err)
+	}
+
+	name := time.Now().UTC().Format(cacheTimestampLayout) + ".json"
+	if err := atomicWriteJSON(filepath.Join(dir, name), v); err != nil {
+		return err
+	}
+
+	entries, err := cacheEntries(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e != name {
+			os.Remove(filepath.Join(dir, e))
+		}
+	}
+	return nil
+}
+
+// latestMetadataCache returns the path and fetch time of the newest cache
+// entry for datasetID, or an empty path if there is none.
+func (sm *StateManager) latestMetadataCache(datasetID string) (string, time.Time, error) {
+	dir := sm.metadataCacheDirFor(datasetID)
+	entries, err := cacheEntries(dir)
+	if err != nil || len(entries) == 0 {
+		return "", time.Time{}, err
+	}
+
+	latest := entries[len(entries)-1]
+	fetchedAt, err := time.Parse(cacheTimestampLayout, strings.TrimSuffix(latest, ".json"))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid metadata cache entry %s: %w", latest, err)
+	}
+	return filepath.Join(dir, latest), fetchedAt, nil
+}
+
+// cacheEntries lists cache entry file names in dir, oldest first.
+func cacheEntries(dir string) ([]string, error) {
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read metadata cache: %w", err)
+	}
+
+	var names []string
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".json") {
+			names = append(names, f.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,54 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// chunksDirFile records, in .egafetch/, a chunk directory outside the output
+// directory.
+const chunksDirFile = "chunks_dir"
+
+// SetChunksDir keeps chunk files in dir, for example on fast local storage,
+// instead of .egafetch/chunks/, and records it so that later runs and
+// commands find them. Chunks in a previously recorded directory are removed.
+func (sm *StateManager) SetChunksDir(dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("resolve chunk directory: %w", err)
+	}
+	old := sm.ChunksPath()
+	if old == dir {
+		return nil
+	}
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return fmt.Errorf("create chunk directory: %w", err)
+	}
+	if err := os.MkdirAll(sm.EgafetchPath(), dirPerm); err != nil {
+		return fmt.Errorf("create directory %s: %w", sm.EgafetchPath(), err)
+	}
+	if err := os.WriteFile(sm.chunksDirPath(), []byte(dir+"\n"), filePerm); err != nil {
+		return fmt.Errorf("record chunk directory: %w", err)
+	}
+	// Partial chunks cannot be resumed from the old location.
+	if err := os.RemoveAll(old); err != nil {
+		return fmt.Errorf("remove old chunk directory: %w", err)
+	}
+	return nil
+}
+
+func (sm *StateManager) chunksDirPath() string {
+	return filepath.Join(sm.EgafetchPath(), chunksDirFile)
+}
+
+// recordedChunksDir returns the chunk directory set with SetChunksDir, or ""
+// if chunks are kept in .egafetch/chunks/.
+func (sm *StateManager) recordedChunksDir() string {
+	data, err := os.ReadFile(sm.chunksDirPath())
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
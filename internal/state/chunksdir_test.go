@@ -0,0 +1,40 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetChunksDir(t *testing.T) {
+	sm := NewStateManager(t.TempDir())
+	if err := sm.EnsureDirs(); err != nil {
+		t.Fatal(err)
+	}
+	defaultDir := sm.ChunksPath()
+
+	tmp := filepath.Join(t.TempDir(), "egafetch-0123456789ab")
+	if err := sm.SetChunksDir(tmp); err != nil {
+		t.Fatal(err)
+	}
+	if got := sm.ChunksPath(); got != tmp {
+		t.Errorf("ChunksPath() = %q, want %q", got, tmp)
+	}
+	// A new manager for the same directory, as in a later run, finds it too.
+	if got := NewStateManager(sm.BaseDir()).ChunksPathForFile("EGAF00000000001"); got != filepath.Join(tmp, "EGAF00000000001") {
+		t.Errorf("ChunksPathForFile() = %q", got)
+	}
+	if _, err := os.Stat(defaultDir); !os.IsNotExist(err) {
+		t.Errorf("old chunk directory %s still exists (err = %v)", defaultDir, err)
+	}
+
+	if err := sm.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("Reset left chunk directory %s (err = %v)", tmp, err)
+	}
+	if got := sm.ChunksPath(); got != defaultDir {
+		t.Errorf("ChunksPath() after Reset = %q, want %q", got, defaultDir)
+	}
+}
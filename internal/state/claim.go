@@ -0,0 +1,226 @@
+package state
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const claimsDir = "claims"
+
+// ClaimTTL is how long a claim lasts unless its owner renews it. Owners
+// renew well within it; once it has passed, the owner is presumed dead (or
+// cut off from the shared filesystem) and another process may take over.
+const ClaimTTL = 2 * time.Minute
+
+// ErrClaimLost is returned by RenewClaim when another process has taken
+// over the claim, after it expired.
+var ErrClaimLost = errors.New("claim was taken over by another process")
+
+// Claim records which process is downloading a file when several
+// processes, possibly on different hosts, download into one shared output
+// directory. Claims are files in .egafetch/claims/, created atomically, so
+// only one process holds each file at a time.
+type Claim struct {
+	FileID    string    `json:"file_id"`
+	Owner     string    `json:"owner"` // unique per process, see NewClaimOwner
+	Host      string    `json:"host"`
+	PID       int       `json:"pid"`
+	ClaimedAt time.Time `json:"claimed_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Expired reports whether the claim has not been renewed in time.
+func (c *Claim) Expired(now time.Time) bool {
+	return now.After(c.ExpiresAt)
+}
+
+// NewClaimOwner returns an owner ID for this process's claims, unique
+// across hosts and process restarts.
+func NewClaimOwner() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
+}
+
+// ClaimsPath returns the path to .egafetch/claims/ under the base directory.
+func (sm *StateManager) ClaimsPath() string {
+	return filepath.Join(sm.EgafetchPath(), claimsDir)
+}
+
+func (sm *StateManager) claimPath(fileID string) string {
+	return filepath.Join(sm.ClaimsPath(), fileID+".json")
+}
+
+// ClaimFile claims fileID for owner. It returns the claim if owner now
+// holds it, or else the live claim of the process that does. An expired
+// claim is taken over.
+func (sm *StateManager) ClaimFile(fileID, owner string) (claim, holder *Claim, err error) {
+	if err := os.MkdirAll(sm.ClaimsPath(), dirPerm); err != nil {
+		return nil, nil, fmt.Errorf("create directory %s: %w", sm.ClaimsPath(), err)
+	}
+	path := sm.claimPath(fileID)
+	host, _ := os.Hostname()
+	// Each pass either claims the file, finds a live holder, or removes an
+	// expired claim; a few passes cover races with other processes.
+	for range 3 {
+		now := time.Now()
+		c := &Claim{FileID: fileID, Owner: owner, Host: host, PID: os.Getpid(), ClaimedAt: now, ExpiresAt: now.Add(ClaimTTL)}
+		created, err := createClaim(path, c)
+		if err != nil {
+			return nil, nil, err
+		}
+		if created {
+			return c, nil, nil
+		}
+
+		held, err := readClaim(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // released in the meantime
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if held.Owner == owner {
+			return held, nil, nil
+		}
+		if !held.Expired(now) {
+			return nil, held, nil
+		}
+		if err := breakClaim(path, held, owner); err != nil {
+			return nil, nil, err
+		}
+	}
+	held, err := readClaim(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("claim %s: %w", fileID, err)
+	}
+	return nil, held, nil
+}
+
+// RenewClaim extends c by ClaimTTL. It returns ErrClaimLost if c is no
+// longer held by its owner.
+func (sm *StateManager) RenewClaim(c *Claim) error {
+	path := sm.claimPath(c.FileID)
+	held, err := readClaim(path)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && held.Owner != c.Owner) {
+		return ErrClaimLost
+	}
+	if err != nil {
+		return err
+	}
+	c.ExpiresAt = time.Now().Add(ClaimTTL)
+	return atomicWriteJSON(path, c)
+}
+
+// ReleaseClaim removes c, unless another process has taken it over.
+func (sm *StateManager) ReleaseClaim(c *Claim) error {
+	path := sm.claimPath(c.FileID)
+	held, err := readClaim(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if held.Owner != c.Owner {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("release claim: %w", err)
+	}
+	return nil
+}
+
+// ListClaims returns the claims found on disk, live and expired.
+func (sm *StateManager) ListClaims() ([]*Claim, error) {
+	matches, err := filepath.Glob(filepath.Join(sm.ClaimsPath(), "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("glob claims: %w", err)
+	}
+	var claims []*Claim
+	for _, path := range matches {
+		c, err := readClaim(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		claims = append(claims, c)
+	}
+	return claims, nil
+}
+
+// createClaim writes c to path unless a claim is already there. The claim
+// is written to a temporary file and then linked into place, which fails if
+// path exists, so readers never see a partial claim.
+func createClaim(path string, c *Claim) (bool, error) {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return false, fmt.Errorf("marshal JSON: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return false, fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return false, fmt.Errorf("write temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return false, fmt.Errorf("close temp file: %w", err)
+	}
+	if err := os.Link(tmp.Name(), path); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("create claim: %w", err)
+	}
+	return true, nil
+}
+
+// breakClaim removes the expired claim held at path. The claim is first
+// renamed to a name of owner's, which only one process can do; if what was
+// moved is no longer the expired claim (it was renewed or replaced in the
+// meantime), it is put back.
+func breakClaim(path string, expired *Claim, owner string) error {
+	aside := strings.TrimSuffix(path, ".json") + ".expired-" + owner
+	if err := os.Rename(path, aside); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("take over claim: %w", err)
+	}
+	defer os.Remove(aside)
+	moved, err := readClaim(aside)
+	if err != nil {
+		return err
+	}
+	if moved.Owner != expired.Owner || !moved.ExpiresAt.Equal(expired.ExpiresAt) {
+		// Fails, as it should, if yet another process has claimed the file.
+		os.Link(aside, path)
+	}
+	return nil
+}
+
+func readClaim(path string) (*Claim, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Claim
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parse claim %s: %w", filepath.Base(path), err)
+	}
+	return &c, nil
+}
@@ -0,0 +1,56 @@
+package state
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClaimFile(t *testing.T) {
+	sm := NewStateManager(t.TempDir())
+	const fileID = "EGAF00000000001"
+
+	a, held, err := sm.ClaimFile(fileID, "node-a")
+	if err != nil || a == nil || held != nil {
+		t.Fatalf("first ClaimFile = %v, %v, %v; want a claim", a, held, err)
+	}
+	// Claiming again returns the claim already held.
+	if again, _, err := sm.ClaimFile(fileID, "node-a"); err != nil || again == nil {
+		t.Fatalf("ClaimFile by the holder = %v, %v", again, err)
+	}
+
+	b, held, err := sm.ClaimFile(fileID, "node-b")
+	if err != nil || b != nil || held == nil || held.Owner != "node-a" {
+		t.Fatalf("ClaimFile of a held file = %v, %v, %v; want holder node-a", b, held, err)
+	}
+
+	// Once a's claim expires, b takes it over and a finds it lost.
+	a.ExpiresAt = time.Now().Add(-time.Second)
+	if err := atomicWriteJSON(sm.claimPath(fileID), a); err != nil {
+		t.Fatal(err)
+	}
+	b, _, err = sm.ClaimFile(fileID, "node-b")
+	if err != nil || b == nil {
+		t.Fatalf("ClaimFile of an expired claim = %v, %v; want a claim", b, err)
+	}
+	if err := sm.RenewClaim(a); !errors.Is(err, ErrClaimLost) {
+		t.Errorf("RenewClaim of a lost claim = %v, want ErrClaimLost", err)
+	}
+	if err := sm.ReleaseClaim(a); err != nil {
+		t.Fatal(err)
+	}
+	claims, err := sm.ListClaims()
+	if err != nil || len(claims) != 1 || claims[0].Owner != "node-b" {
+		t.Fatalf("ListClaims after releasing a lost claim = %v, %v; want node-b's", claims, err)
+	}
+
+	if err := sm.RenewClaim(b); err != nil {
+		t.Errorf("RenewClaim = %v", err)
+	}
+	if err := sm.ReleaseClaim(b); err != nil {
+		t.Fatal(err)
+	}
+	if claims, _ := sm.ListClaims(); len(claims) != 0 {
+		t.Errorf("ListClaims after release = %v, want none", claims)
+	}
+}
@@ -0,0 +1,237 @@
+package state
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf16"
+)
+
+// maxFileNameLength is the longest file name NTFS allows, in UTF-16 code
+// units.
+const maxFileNameLength = 255
+
+// maxExtensionLength is the longest extension (e.g. ".vcf.gz") kept when a
+// name has to be shortened.
+const maxExtensionLength = 32
+
+// windowsReservedNames are device names Windows does not allow as file
+// names, with or without an extension.
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// Layout is how the path of a file in the output directory is formed from
+// its EGA file name, which may include directories.
+type Layout int
+
+const (
+	// LayoutByFile puts each file in a directory named after its EGAF
+	// accession instead of the directories of its EGA name: EGAF.../name.
+	LayoutByFile Layout = iota
+	// LayoutPreserve keeps the directories of the EGA name: dir/.../name.
+	LayoutPreserve
+	// LayoutFlat puts every file directly in the output directory: name.
+	LayoutFlat
+)
+
+// OutputFileName returns the path of a file in the output directory,
+// EGAF.../name, from its EGA file name. It is OutputPath with LayoutByFile.
+func OutputFileName(fileID, egaName string, sanitize bool) (name, original string) {
+	return OutputPath(fileID, egaName, LayoutByFile, sanitize)
+}
+
+// OutputPath returns the path of a file in the output directory under
+// layout, from its EGA file name, without the .cip extension: EGA serves
+// decrypted content in plain mode. The EGA name is split into directories
+// at both / and \, and empty, "." and ".." components are dropped, so that
+// no name leads outside the output directory; a leading .egafetch becomes
+// _.egafetch, so that none leads into the download state either, and a
+// name with nothing left is replaced by the file ID. With sanitize, the
+// name and directories are made valid on every platform, and original is
+// the EGA base name if it changed. Names are not made unique: see
+// UniqueFileNames.
+func OutputPath(fileID, egaName string, layout Layout, sanitize bool) (name, original string) {
+	parts := strings.FieldsFunc(egaName, func(r rune) bool { return r == '/' || r == '\\' })
+	parts = slices.DeleteFunc(parts, func(part string) bool { return part == "." || part == ".." })
+	baseName := fileID
+	if len(parts) > 0 {
+		if b := strings.TrimSuffix(parts[len(parts)-1], ".cip"); b != "" {
+			baseName = b
+		}
+		parts = parts[:len(parts)-1]
+	}
+	if sanitize {
+		if safe := SanitizeFileName(baseName); safe != baseName {
+			baseName, original = safe, baseName
+		}
+		for i := range parts {
+			parts[i] = SanitizeFileName(parts[i])
+		}
+	}
+
+	var path []string
+	switch layout {
+	case LayoutPreserve:
+		path = append(parts, baseName)
+	case LayoutFlat:
+		path = []string{baseName}
+	default:
+		return filepath.Join(fileID, baseName), original
+	}
+	if strings.EqualFold(path[0], egafetchDir) {
+		if len(path) == 1 && original == "" {
+			original = baseName
+		}
+		path[0] = "_" + path[0]
+	}
+	return filepath.Join(path...), original
+}
+
+// SanitizeFileName returns name made valid as a file name on Windows, and
+// so on every platform: characters NTFS rejects (<>:"/\|?* and control
+// characters) and trailing dots and spaces become "_", reserved device names
+// such as CON or NUL get a "_" prefix, and names longer than NTFS allows are
+// shortened, keeping the extension. Valid names are returned unchanged.
+func SanitizeFileName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if r < 0x20 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	s := b.String()
+
+	// Windows silently drops trailing dots and spaces.
+	if trimmed := strings.TrimRight(s, ". "); len(trimmed) < len(s) {
+		s = trimmed + strings.Repeat("_", len(s)-len(trimmed))
+	}
+	stem, _, _ := strings.Cut(s, ".")
+	if windowsReservedNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		s = "_" + s
+	}
+	return shortenFileName(s)
+}
+
+// shortenFileName cuts the part of name before its extension so that the
+// whole fits in maxFileNameLength UTF-16 code units.
+func shortenFileName(name string) string {
+	if utf16Len(name) <= maxFileNameLength {
+		return name
+	}
+	stem, ext := splitExtension(name)
+	return truncateUTF16(stem, maxFileNameLength-utf16Len(ext)) + ext
+}
+
+// splitExtension splits name before its extension, which may be compound
+// (e.g. ".vcf.gz") if it is at most maxExtensionLength long.
+func splitExtension(name string) (stem, ext string) {
+	if i := strings.Index(name, "."); i > 0 && len(name)-i <= maxExtensionLength {
+		return name[:i], name[i:]
+	} else if i := strings.LastIndex(name, "."); i > 0 && len(name)-i <= maxExtensionLength {
+		return name[:i], name[i:]
+	}
+	return name, ""
+}
+
+// truncateUTF16 returns the longest prefix of s that fits in budget UTF-16
+// code units.
+func truncateUTF16(s string, budget int) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		w := utf16.RuneLen(r)
+		if n+w > budget {
+			break
+		}
+		b.WriteRune(r)
+		n += w
+	}
+	return b.String()
+}
+
+// GeneratedNames are the paths, relative to the output directory, of the
+// files a download writes besides those of its manifest, which none of
+// them may take.
+type GeneratedNames struct {
+	Fixed []string                   // written once, such as MD5SUMS
+	For   func(name string) []string // written for the file named name, such as name.md5; nil = none
+}
+
+// UniqueFileNames returns files without repeats of a file, which would be
+// downloaded twice into the same output, and with the files whose FileName
+// is another's, differs from it only in case, or is one of generated,
+// renamed apart by their file ID: name_EGAF....ext, with the EGA name kept
+// as OriginalName. All files of a collision between them are renamed, so
+// that their names do not depend on the order EGA lists them in; a file
+// taking a generated name is renamed, not the file it is generated for.
+func UniqueFileNames(files []FileSpec, generated GeneratedNames) []FileSpec {
+	seen := make(map[string]bool, len(files))
+	unique := make([]FileSpec, 0, len(files))
+	for _, f := range files {
+		if !seen[f.FileID] {
+			seen[f.FileID] = true
+			unique = append(unique, f)
+		}
+	}
+
+	// A renamed file is not renamed again, so this ends; it takes a second
+	// pass only if a new name, or one generated for it, collides.
+	renamed := make([]bool, len(unique))
+	for {
+		names := make(map[string]int, len(unique))
+		taken := make(map[string]int) // generated names, by the file they are for; -1 = none
+		for _, name := range generated.Fixed {
+			taken[strings.ToLower(name)] = -1
+		}
+		for i, f := range unique {
+			names[strings.ToLower(f.FileName)]++
+			if generated.For == nil {
+				continue
+			}
+			for _, name := range generated.For(f.FileName) {
+				if _, ok := taken[strings.ToLower(name)]; !ok {
+					taken[strings.ToLower(name)] = i
+				}
+			}
+		}
+
+		changed := false
+		for i := range unique {
+			key := strings.ToLower(unique[i].FileName)
+			owner, isTaken := taken[key]
+			if renamed[i] || names[key] < 2 && (!isTaken || owner == i) {
+				continue
+			}
+			renameByID(&unique[i])
+			renamed[i], changed = true, true
+		}
+		if !changed {
+			return unique
+		}
+	}
+}
+
+// renameByID renames f to name_EGAF....ext, keeping its EGA name.
+func renameByID(f *FileSpec) {
+	dir, base := filepath.Split(f.FileName)
+	if f.OriginalName == "" {
+		f.OriginalName = base
+	}
+	stem, ext := splitExtension(base)
+	suffix := "_" + f.FileID + ext
+	f.FileName = filepath.Join(dir, truncateUTF16(stem, maxFileNameLength-utf16Len(suffix))+suffix)
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
@@ -0,0 +1,148 @@
+package state
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"sample.bam", "sample.bam"},
+		{"run:1|lane*2?.fastq.gz", "run_1_lane_2_.fastq.gz"},
+		{`a<b>"c".vcf`, "a_b__c_.vcf"},
+		{"tab\there.txt", "tab_here.txt"},
+		{"notes. ", "notes__"},
+		{"CON", "_CON"},
+		{"nul.txt", "_nul.txt"},
+		{"COM1.tar.gz", "_COM1.tar.gz"},
+		{"CONSOLE.log", "CONSOLE.log"},
+		{"..", "__"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := SanitizeFileName(tt.name); got != tt.want {
+			t.Errorf("SanitizeFileName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeFileNameLength(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantExt string
+	}{
+		{strings.Repeat("a", 300) + ".vcf.gz", ".vcf.gz"},
+		{strings.Repeat("é", 300) + ".bam", ".bam"},
+		{strings.Repeat("b", 100) + "." + strings.Repeat("c", 200) + ".cram", ".cram"},
+		{strings.Repeat("日本", 200), ""},
+	}
+	for _, tt := range tests {
+		got := SanitizeFileName(tt.name)
+		if n := len(utf16.Encode([]rune(got))); n > maxFileNameLength {
+			t.Errorf("SanitizeFileName(%.20q...) is %d UTF-16 units long", tt.name, n)
+		}
+		if !strings.HasSuffix(got, tt.wantExt) || !strings.HasPrefix(tt.name, strings.TrimSuffix(got, tt.wantExt)) {
+			t.Errorf("SanitizeFileName(%.20q...) = %.20q..., want a prefix of the name ending in %q", tt.name, got, tt.wantExt)
+		}
+	}
+}
+
+func TestUniqueFileNames(t *testing.T) {
+	files := UniqueFileNames([]FileSpec{
+		{FileID: "EGAF1", FileName: "sample.vcf.gz"},
+		{FileID: "EGAF2", FileName: "other.bam"},
+		{FileID: "EGAF3", FileName: "Sample.vcf.gz"},
+		{FileID: "EGAF2", FileName: "other.bam"},
+		{FileID: "EGAF4", FileName: "README", OriginalName: "README?"},
+		{FileID: "EGAF5", FileName: "README"},
+	}, GeneratedNames{})
+	want := []FileSpec{
+		{FileID: "EGAF1", FileName: "sample_EGAF1.vcf.gz", OriginalName: "sample.vcf.gz"},
+		{FileID: "EGAF2", FileName: "other.bam"},
+		{FileID: "EGAF3", FileName: "Sample_EGAF3.vcf.gz", OriginalName: "Sample.vcf.gz"},
+		{FileID: "EGAF4", FileName: "README_EGAF4", OriginalName: "README?"},
+		{FileID: "EGAF5", FileName: "README_EGAF5", OriginalName: "README"},
+	}
+	if len(files) != len(want) {
+		t.Fatalf("UniqueFileNames = %+v, want %+v", files, want)
+	}
+	for i := range want {
+		if files[i] != want[i] {
+			t.Errorf("file %d = %+v, want %+v", i, files[i], want[i])
+		}
+	}
+}
+
+func TestUniqueFileNamesGenerated(t *testing.T) {
+	generated := GeneratedNames{
+		Fixed: []string{"MD5SUMS", "SHA256SUMS"},
+		For: func(name string) []string {
+			names := []string{name + ".md5"}
+			if strings.HasSuffix(name, ".bam") {
+				names = append(names, name+".bai")
+			}
+			return names
+		},
+	}
+	files := UniqueFileNames([]FileSpec{
+		{FileID: "EGAF1", FileName: "md5sums"},
+		{FileID: "EGAF2", FileName: "x.bam"},
+		{FileID: "EGAF3", FileName: "x.bam.bai"},
+		{FileID: "EGAF4", FileName: "y.vcf"},
+		{FileID: "EGAF5", FileName: "y.vcf.md5"},
+		{FileID: "EGAF6", FileName: "z.txt"},
+	}, generated)
+	want := []FileSpec{
+		{FileID: "EGAF1", FileName: "md5sums_EGAF1", OriginalName: "md5sums"},
+		{FileID: "EGAF2", FileName: "x.bam"},
+		{FileID: "EGAF3", FileName: "x_EGAF3.bam.bai", OriginalName: "x.bam.bai"},
+		{FileID: "EGAF4", FileName: "y.vcf"},
+		{FileID: "EGAF5", FileName: "y_EGAF5.vcf.md5", OriginalName: "y.vcf.md5"},
+		{FileID: "EGAF6", FileName: "z.txt"},
+	}
+	if len(files) != len(want) {
+		t.Fatalf("UniqueFileNames = %+v, want %+v", files, want)
+	}
+	for i := range want {
+		if files[i] != want[i] {
+			t.Errorf("file %d = %+v, want %+v", i, files[i], want[i])
+		}
+	}
+}
+
+func TestOutputPath(t *testing.T) {
+	const id = "EGAF00000000001"
+	tests := []struct {
+		egaName      string
+		layout       Layout
+		want         string
+		wantOriginal string
+	}{
+		{"EGAZ1/run/sample.bam.cip", LayoutByFile, id + "/sample.bam", ""},
+		{"EGAZ1/run/sample.bam.cip", LayoutPreserve, "EGAZ1/run/sample.bam", ""},
+		{"EGAZ1/run/sample.bam.cip", LayoutFlat, "sample.bam", ""},
+		{`EGAZ1\run\sample.bam`, LayoutPreserve, "EGAZ1/run/sample.bam", ""},
+		{"/../../etc/./passwd", LayoutPreserve, "etc/passwd", ""},
+		{"../../etc/passwd", LayoutByFile, id + "/passwd", ""},
+		{"..", LayoutByFile, id + "/" + id, ""},
+		{"a/.cip", LayoutPreserve, "a/" + id, ""},
+		{".egafetch/state/x.json", LayoutPreserve, "_.egafetch/state/x.json", ""},
+		{"EGAZ1/.egafetch", LayoutFlat, "_.egafetch", ".egafetch"},
+	}
+	for _, tt := range tests {
+		name, original := OutputPath(id, tt.egaName, tt.layout, false)
+		if want := filepath.FromSlash(tt.want); name != want || original != tt.wantOriginal {
+			t.Errorf("OutputPath(%q, %d) = %q, %q; want %q, %q", tt.egaName, tt.layout, name, original, want, tt.wantOriginal)
+		}
+	}
+
+	name, original := OutputPath(id, "run:1/a|b.bam", LayoutPreserve, true)
+	if want := filepath.Join("run_1", "a_b.bam"); name != want || original != "a|b.bam" {
+		t.Errorf("sanitized OutputPath = %q, %q; want %q, %q", name, original, want, "a|b.bam")
+	}
+}
@@ -0,0 +1,61 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const jobFile = "job.json"
+
+// Job statuses recorded in .egafetch/job.json.
+const (
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job records the download process working in an output directory, so other
+// invocations (e.g. 'egafetch cancel') can find and signal it.
+type Job struct {
+	PID               int        `json:"pid"`
+	Host              string     `json:"host"`
+	Status            string     `json:"status"`
+	Args              []string   `json:"args,omitempty"`
+	StartedAt         time.Time  `json:"started_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+	CancelRequestedAt *time.Time `json:"cancel_requested_at,omitempty"`
+}
+
+func (sm *StateManager) jobPath() string {
+	return filepath.Join(sm.EgafetchPath(), jobFile)
+}
+
+// LoadJob reads the job record from disk.
+// Returns (nil, nil) if the file does not exist.
+func (sm *StateManager) LoadJob() (*Job, error) {
+	data, err := os.ReadFile(sm.jobPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read job: %w", err)
+	}
+	var j Job
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, fmt.Errorf("parse job: %w", err)
+	}
+	return &j, nil
+}
+
+// SaveJob writes the job record to disk atomically.
+func (sm *StateManager) SaveJob(j *Job) error {
+	if err := sm.EnsureDirs(); err != nil {
+		return err
+	}
+	j.UpdatedAt = time.Now()
+	return atomicWriteJSON(sm.jobPath(), j)
+}
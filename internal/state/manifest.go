@@ -21,6 +21,7 @@ const (
 type FileSpec struct {
 	FileID       string `json:"file_id"`
 	FileName     string `json:"file_name"`
+	OriginalName string `json:"original_name,omitempty"` // EGA file name, if FileName had to change to be valid on this platform or unique
 	Size         int64  `json:"size"`
 	Checksum     string `json:"checksum"`
 	ChecksumType string `json:"checksum_type"`
@@ -59,12 +60,16 @@ func (sm *StateManager) StatePath() string {
 	return filepath.Join(sm.EgafetchPath(), stateDir)
 }
 
-// ChunksPath returns the path to .egafetch/chunks/ under the base directory.
+// ChunksPath returns the directory holding chunk files: .egafetch/chunks/
+// under the base directory, or the directory set with SetChunksDir.
 func (sm *StateManager) ChunksPath() string {
+	if dir := sm.recordedChunksDir(); dir != "" {
+		return dir
+	}
 	return filepath.Join(sm.EgafetchPath(), chunksDir)
 }
 
-// ChunksPathForFile returns the path to .egafetch/chunks/<fileID>/.
+// ChunksPathForFile returns the chunk directory of one file, <ChunksPath>/<fileID>/.
 func (sm *StateManager) ChunksPathForFile(fileID string) string {
 	return filepath.Join(sm.ChunksPath(), fileID)
 }
@@ -108,6 +113,11 @@ func (sm *StateManager) LoadManifest() (*Manifest, error) {
 // Reset removes all EGAfetch state (manifest, file states, chunks) from the
 // output directory. This is used by --restart to force a fresh download.
 func (sm *StateManager) Reset() error {
+	if dir := sm.recordedChunksDir(); dir != "" {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
 	return os.RemoveAll(sm.EgafetchPath())
 }
 
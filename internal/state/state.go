@@ -34,8 +34,8 @@ const (
 // ChunkState tracks the state of a single chunk within a file download.
 type ChunkState struct {
 	Index           int         `json:"index"`
-	Start           int64       `json:"start"`            // Byte offset (inclusive)
-	End             int64       `json:"end"`              // Byte offset (exclusive)
+	Start           int64       `json:"start"` // Byte offset (inclusive)
+	End             int64       `json:"end"`   // Byte offset (exclusive)
 	Status          ChunkStatus `json:"status"`
 	BytesDownloaded int64       `json:"bytes_downloaded"`
 	RetryCount      int         `json:"retry_count"`
@@ -45,12 +45,16 @@ type ChunkState struct {
 type FileState struct {
 	FileID           string       `json:"file_id"`
 	FileName         string       `json:"file_name"`
+	OriginalName     string       `json:"original_name,omitempty"`
 	Status           FileStatus   `json:"status"`
 	Size             int64        `json:"size"`
 	ChecksumExpected string       `json:"checksum_expected"`
 	ChecksumType     string       `json:"checksum_type"`
+	MD5              string       `json:"md5,omitempty"`    // of the file on disk, once verified
+	SHA256           string       `json:"sha256,omitempty"` // of the file on disk, once verified
 	ChunkSize        int64        `json:"chunk_size"`
 	Chunks           []ChunkState `json:"chunks"`
+	MergedBytes      int64        `json:"merged_bytes,omitempty"` // leading bytes already merged into the output's .tmp file
 	DownloadURL      string       `json:"download_url,omitempty"`
 	URLExpiresAt     *time.Time   `json:"url_expires_at,omitempty"`
 	Error            string       `json:"error,omitempty"`
@@ -65,6 +69,7 @@ func NewFileState(spec FileSpec, chunkSize int64) *FileState {
 	return &FileState{
 		FileID:           spec.FileID,
 		FileName:         spec.FileName,
+		OriginalName:     spec.OriginalName,
 		Status:           StatusPending,
 		Size:             spec.Size,
 		ChecksumExpected: spec.Checksum,
@@ -139,6 +144,15 @@ func (fs *FileState) AllChunksComplete() bool {
 	return true
 }
 
+// BytesDownloaded returns the bytes downloaded so far across all chunks.
+func (fs *FileState) BytesDownloaded() int64 {
+	var total int64
+	for _, c := range fs.Chunks {
+		total += c.BytesDownloaded
+	}
+	return total
+}
+
 // fileStatePath returns the path to the state file for a given file ID.
 func (sm *StateManager) fileStatePath(fileID string) string {
 	return filepath.Join(sm.StatePath(), fileID+".json")
@@ -0,0 +1,103 @@
+package ui
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+// chunkProgress is one active chunk of a file, for the chunk view.
+type chunkProgress struct {
+	start, end int64 // byte range, end exclusive
+	bytes      int64
+	retries    int
+	speed      rateMeter
+}
+
+// ShowChunks makes the live display list the active chunks of fileID under
+// its line: each chunk's byte range, bytes so far, speed, and retries.
+// With fileID "", the active file furthest behind is shown, which is the
+// one to look at when a download seems stuck.
+func (pt *ProgressTracker) ShowChunks(fileID string) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.chunkView = true
+	pt.chunkFile = fileID
+}
+
+// ChunkProgress records the state of a chunk of fileID for the chunk view.
+// Completed chunks leave the view.
+func (pt *ProgressTracker) ChunkProgress(fileID string, c state.ChunkState) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	fp, ok := pt.files[fileID]
+	if !ok || !pt.chunkView {
+		return
+	}
+	if c.Status == state.ChunkComplete {
+		delete(fp.chunks, c.Index)
+		return
+	}
+	if fp.chunks == nil {
+		fp.chunks = make(map[int]*chunkProgress)
+	}
+	cp, ok := fp.chunks[c.Index]
+	if !ok {
+		cp = &chunkProgress{start: c.Start, end: c.End}
+		fp.chunks[c.Index] = cp
+	}
+	cp.bytes = c.BytesDownloaded
+	cp.retries = c.RetryCount
+}
+
+// chunkFileID returns the file of the chunk view: the chosen one, or else
+// the active file with the smallest part done. Callers hold pt.mu.
+func (pt *ProgressTracker) chunkFileID() string {
+	if pt.chunkFile != "" {
+		return pt.chunkFile
+	}
+	best, bestDone := "", 2.0
+	for _, fileID := range pt.order {
+		fp := pt.files[fileID]
+		if fp.status != "downloading" {
+			continue
+		}
+		done := 1.0
+		if fp.total > 0 {
+			done = float64(fp.current) / float64(fp.total)
+		}
+		if done < bestDone {
+			best, bestDone = fileID, done
+		}
+	}
+	return best
+}
+
+// chunkLines returns the chunk view of fp, in chunk order, sampling each
+// chunk's speed at now.
+func (pt *ProgressTracker) chunkLines(fp *fileProgress, now time.Time) []string {
+	indexes := make([]int, 0, len(fp.chunks))
+	for i := range fp.chunks {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	lines := make([]string, 0, len(indexes))
+	for _, i := range indexes {
+		cp := fp.chunks[i]
+		cp.speed.add(now, cp.bytes)
+		speed := ""
+		if cp.speed.ready() {
+			speed = FormatBytes(int64(cp.speed.rate())) + "/s"
+		}
+		line := fmt.Sprintf("      chunk %-5d %d-%d  %s / %s  %s",
+			i, cp.start, cp.end-1, FormatBytes(cp.bytes), FormatBytes(cp.end-cp.start), speed)
+		if cp.retries > 0 {
+			line += fmt.Sprintf("  retries %d", cp.retries)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
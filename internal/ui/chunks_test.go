@@ -0,0 +1,40 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+func TestChunkView(t *testing.T) {
+	pt := NewSilentProgressTracker()
+	pt.ShowChunks("")
+	pt.RegisterFile("EGAF1", "a.bam", 2000)
+	pt.RegisterFile("EGAF2", "b.bam", 2000)
+	pt.UpdateProgress("EGAF1", 1800, 2000)
+	pt.UpdateProgress("EGAF2", 500, 2000)
+	pt.ChunkProgress("EGAF2", state.ChunkState{Index: 1, Start: 1000, End: 2000, BytesDownloaded: 400, RetryCount: 2, Status: state.ChunkFailed})
+	pt.ChunkProgress("EGAF2", state.ChunkState{Index: 0, Start: 0, End: 1000, BytesDownloaded: 100})
+	pt.ChunkProgress("EGAF2", state.ChunkState{Index: 0, Start: 0, End: 1000, BytesDownloaded: 1000, Status: state.ChunkComplete})
+
+	lines, _ := pt.lines(time.Now(), 0, 0)
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want header, two files, and one chunk:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+	if !strings.Contains(lines[2], "b.bam") {
+		t.Errorf("line 2 = %q, want b.bam (the file furthest behind)", lines[2])
+	}
+	if want := "      chunk 1     1000-1999  400 B / 1000 B"; !strings.HasPrefix(lines[3], want) || !strings.HasSuffix(lines[3], "retries 2") {
+		t.Errorf("chunk line = %q, want %q ... retries 2", lines[3], want)
+	}
+
+	// A chosen file is shown even when it is not furthest behind.
+	pt.ShowChunks("EGAF1")
+	pt.ChunkProgress("EGAF1", state.ChunkState{Index: 3, Start: 0, End: 2000, BytesDownloaded: 1800})
+	lines, _ = pt.lines(time.Now(), 0, 0)
+	if len(lines) != 4 || !strings.Contains(lines[2], "chunk 3") {
+		t.Errorf("chosen file's chunks not shown under it:\n%s", strings.Join(lines, "\n"))
+	}
+}
@@ -0,0 +1,58 @@
+package ui
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// colorEnabled reports whether output may use color. Setting NO_COLOR to
+// any value turns it off (https://no-color.org).
+var colorEnabled = os.Getenv("NO_COLOR") == ""
+
+// SetColor enables or disables colored output, e.g. for --no-color.
+func SetColor(enabled bool) {
+	colorEnabled = enabled
+}
+
+// ColorEnabled reports whether output may use color.
+func ColorEnabled() bool {
+	return colorEnabled
+}
+
+// ANSI colors of file statuses.
+const (
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorRed    = "\033[31m"
+	colorReset  = "\033[0m"
+)
+
+// statusColors maps file statuses to their color: green when done, yellow
+// while in progress, red when failed. Other statuses are not colored.
+var statusColors = map[string]string{
+	"complete":    colorGreen,
+	"skipped":     colorGreen,
+	"downloading": colorYellow,
+	"chunking":    colorYellow,
+	"merging":     colorYellow,
+	"verifying":   colorYellow,
+	"failed":      colorRed,
+}
+
+// useColor reports whether output to w should be colored: color is enabled
+// and w is a terminal.
+func useColor(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return colorEnabled && ok && term.IsTerminal(int(f.Fd()))
+}
+
+// paint returns text in the color of status when on is set.
+func paint(on bool, status, text string) string {
+	c, ok := statusColors[status]
+	if !on || !ok {
+		return text
+	}
+	return c + text + colorReset
+}
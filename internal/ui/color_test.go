@@ -0,0 +1,32 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPaint(t *testing.T) {
+	tests := []struct {
+		on     bool
+		status string
+		want   string
+	}{
+		{true, "complete", "\033[32mtext\033[0m"},
+		{true, "downloading", "\033[33mtext\033[0m"},
+		{true, "failed", "\033[31mtext\033[0m"},
+		{true, "waiting", "text"},
+		{false, "failed", "text"},
+	}
+	for _, tt := range tests {
+		if got := paint(tt.on, tt.status, "text"); got != tt.want {
+			t.Errorf("paint(%v, %q) = %q, want %q", tt.on, tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestUseColorNotTerminal(t *testing.T) {
+	var b strings.Builder
+	if useColor(&b) {
+		t.Error("useColor is set for a non-terminal writer")
+	}
+}
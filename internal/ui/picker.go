@@ -0,0 +1,250 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// ErrPickerCancelled is returned by Pick when the user quits without
+// confirming a selection.
+var ErrPickerCancelled = errors.New("selection cancelled")
+
+// PickerItem is one selectable entry in Pick.
+type PickerItem struct {
+	ID    string
+	Label string
+	Size  int64 // shown and totalled when > 0
+}
+
+const pickerHeight = 15
+
+// picker holds the state of an interactive selection.
+type picker struct {
+	title    string
+	items    []PickerItem
+	selected []bool
+	visible  []int // indices into items matching the filter
+	cursor   int   // position in visible
+	offset   int   // first visible row shown
+	filter   string
+	typing   bool // editing the filter
+	rendered int
+}
+
+// Pick shows an interactive multi-select list on the terminal and returns
+// the indices of the chosen items in their original order. Keys:
+// up/down (or k/j) to move, space to toggle, a to toggle all shown items,
+// / to search, enter to confirm, q or Ctrl-C to cancel. Output goes to
+// stderr; stdin must be a terminal.
+func Pick(title string, items []PickerItem) ([]int, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("interactive selection requires a terminal")
+	}
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, fmt.Errorf("enable raw terminal mode: %w", err)
+	}
+	defer term.Restore(fd, oldState)
+
+	p := &picker{title: title, items: items, selected: make([]bool, len(items))}
+	p.applyFilter()
+
+	buf := make([]byte, 16)
+	for {
+		p.render()
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("read key: %w", err)
+		}
+		done, err := p.handleKey(string(buf[:n]))
+		if err != nil {
+			p.clear()
+			return nil, err
+		}
+		if done {
+			p.clear()
+			var chosen []int
+			for i, sel := range p.selected {
+				if sel {
+					chosen = append(chosen, i)
+				}
+			}
+			return chosen, nil
+		}
+	}
+}
+
+// handleKey applies one key press and reports whether the selection is
+// confirmed.
+func (p *picker) handleKey(key string) (bool, error) {
+	if key == "\x03" {
+		return false, ErrPickerCancelled
+	}
+
+	if p.typing {
+		switch key {
+		case "\r", "\n":
+			p.typing = false
+		case "\x1b":
+			p.typing = false
+			p.filter = ""
+			p.applyFilter()
+		case "\x7f", "\b":
+			if p.filter != "" {
+				p.filter = p.filter[:len(p.filter)-1]
+				p.applyFilter()
+			}
+		default:
+			if len(key) == 1 && key[0] >= ' ' && key[0] < 0x7f {
+				p.filter += key
+				p.applyFilter()
+			}
+		}
+		return false, nil
+	}
+
+	switch key {
+	case "\r", "\n":
+		return true, nil
+	case "q", "\x1b":
+		return false, ErrPickerCancelled
+	case "\x1b[A", "k":
+		p.move(-1)
+	case "\x1b[B", "j":
+		p.move(1)
+	case "\x1b[5~":
+		p.move(-pickerHeight)
+	case "\x1b[6~":
+		p.move(pickerHeight)
+	case " ":
+		if len(p.visible) > 0 {
+			i := p.visible[p.cursor]
+			p.selected[i] = !p.selected[i]
+		}
+	case "a":
+		all := true
+		for _, i := range p.visible {
+			all = all && p.selected[i]
+		}
+		for _, i := range p.visible {
+			p.selected[i] = !all
+		}
+	case "/":
+		p.typing = true
+	}
+	return false, nil
+}
+
+// move moves the cursor by delta rows, scrolling as needed.
+func (p *picker) move(delta int) {
+	p.cursor += delta
+	if p.cursor >= len(p.visible) {
+		p.cursor = len(p.visible) - 1
+	}
+	if p.cursor < 0 {
+		p.cursor = 0
+	}
+	if p.cursor < p.offset {
+		p.offset = p.cursor
+	}
+	if p.cursor >= p.offset+pickerHeight {
+		p.offset = p.cursor - pickerHeight + 1
+	}
+}
+
+// applyFilter recomputes the visible items for the current filter.
+func (p *picker) applyFilter() {
+	needle := strings.ToLower(p.filter)
+	p.visible = p.visible[:0]
+	for i, it := range p.items {
+		if needle == "" || strings.Contains(strings.ToLower(it.ID+" "+it.Label), needle) {
+			p.visible = append(p.visible, i)
+		}
+	}
+	p.cursor, p.offset = 0, 0
+}
+
+// render redraws the picker in place on stderr.
+func (p *picker) render() {
+	var b strings.Builder
+	if p.rendered > 0 {
+		fmt.Fprintf(&b, "\r\033[%dA", p.rendered)
+	}
+	lines := 0
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, "\r\033[K"+format+"\r\n", args...)
+		lines++
+	}
+
+	line("%s", p.title)
+	switch {
+	case p.typing:
+		line("Search: %s_", p.filter)
+	case p.filter != "":
+		line("Search: %s  (/ to edit)", p.filter)
+	default:
+		line("space select · a all · / search · enter confirm · q cancel")
+	}
+
+	for row := 0; row < pickerHeight; row++ {
+		pos := p.offset + row
+		if pos >= len(p.visible) {
+			line("")
+			continue
+		}
+		it := p.items[p.visible[pos]]
+		pointer := " "
+		if pos == p.cursor {
+			pointer = ">"
+		}
+		mark := "[ ]"
+		if p.selected[p.visible[pos]] {
+			mark = "[x]"
+		}
+		size := ""
+		if it.Size > 0 {
+			size = FormatBytes(it.Size)
+		}
+		line("%s %s %-20s %10s  %s", pointer, mark, truncate(it.ID, 20), size, truncate(it.Label, 60))
+	}
+
+	var count int
+	var total int64
+	for i, sel := range p.selected {
+		if sel {
+			count++
+			total += p.items[i].Size
+		}
+	}
+	footer := fmt.Sprintf("%d of %d selected", count, len(p.items))
+	if total > 0 {
+		footer += ", " + FormatBytes(total)
+	}
+	if len(p.visible) != len(p.items) {
+		footer += fmt.Sprintf(" (%d shown)", len(p.visible))
+	}
+	line("%s", footer)
+
+	fmt.Fprint(os.Stderr, b.String())
+	p.rendered = lines
+}
+
+// clear erases the picker from the terminal.
+func (p *picker) clear() {
+	if p.rendered == 0 {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "\r\033[%dA", p.rendered)
+	for i := 0; i < p.rendered; i++ {
+		b.WriteString("\r\033[K\r\n")
+	}
+	fmt.Fprintf(&b, "\r\033[%dA", p.rendered)
+	fmt.Fprint(os.Stderr, b.String())
+	p.rendered = 0
+}
@@ -2,26 +2,51 @@ package ui
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"sync"
 	"time"
+
+	"golang.org/x/term"
+)
+
+// Progress renderers, selected with --progress.
+const (
+	RendererAuto   = "auto"   // ansi on a terminal, plain otherwise
+	RendererANSI   = "ansi"   // live display redrawn in place
+	RendererPlain  = "plain"  // one line per file event, no escape codes
+	RendererChunks = "chunks" // ansi, plus the active chunks of one file
+	RendererNone   = "none"   // nothing
 )
 
+// Renderers lists the valid --progress values.
+var Renderers = []string{RendererAuto, RendererANSI, RendererPlain, RendererChunks, RendererNone}
+
 // ProgressTracker tracks and renders live download progress for multiple files.
 type ProgressTracker struct {
 	mu       sync.Mutex
 	files    map[string]*fileProgress
-	order    []string // insertion order for stable rendering
-	rendered int      // number of lines currently rendered on screen
-	done     chan struct{}
+	order    []string  // insertion order for stable rendering
+	rendered int       // number of lines currently rendered on screen
+	plain    io.Writer // destination of plain event lines, if any
+	speed    rateMeter // bytes transferred this session, across all files
+
+	chunkView bool   // list the active chunks of a file
+	chunkFile string // file of the chunk view; "" = the one furthest behind
+	done      chan struct{}
 }
 
 type fileProgress struct {
 	fileName string
 	total    int64
 	current  int64
+	resumed  int64  // bytes from earlier sessions, left out of speeds
 	status   string // "downloading", "complete", "failed", "skipped", "merging", "verifying"
+	speed    rateMeter
+	started  time.Time              // when this session started the file
+	finished time.Time              // when it completed or failed
+	chunks   map[int]*chunkProgress // active chunks, by index, for the chunk view
 }
 
 // NewProgressTracker creates a new progress tracker and starts a background
@@ -35,6 +60,31 @@ func NewProgressTracker() *ProgressTracker {
 	return pt
 }
 
+// NewSilentProgressTracker creates a tracker that records progress but never
+// draws it, for quiet or verbose runs.
+func NewSilentProgressTracker() *ProgressTracker {
+	return &ProgressTracker{
+		files: make(map[string]*fileProgress),
+		done:  make(chan struct{}),
+	}
+}
+
+// NewPlainProgressTracker creates a tracker that writes one line to w when
+// a file starts, completes, fails, or is skipped, and a summary line of the
+// whole download every interval (if positive), without escape codes, for
+// log files and batch schedulers.
+func NewPlainProgressTracker(w io.Writer, interval time.Duration) *ProgressTracker {
+	pt := &ProgressTracker{
+		files: make(map[string]*fileProgress),
+		plain: w,
+		done:  make(chan struct{}),
+	}
+	if interval > 0 {
+		go pt.summaryLoop(interval)
+	}
+	return pt
+}
+
 // Stop stops the background render loop and prints the final state.
 func (pt *ProgressTracker) Stop() {
 	close(pt.done)
@@ -55,6 +105,18 @@ func (pt *ProgressTracker) RegisterFile(fileID, fileName string, totalBytes int6
 	pt.order = append(pt.order, fileID)
 }
 
+// FileResumed records that bytes of a file were downloaded by an earlier
+// session: its bar starts from them, but speeds and ETAs count only bytes
+// downloaded by this one.
+func (pt *ProgressTracker) FileResumed(fileID string, bytes int64) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	if fp, ok := pt.files[fileID]; ok {
+		fp.current = bytes
+		fp.resumed = bytes
+	}
+}
+
 // UpdateProgress updates the download progress for a file.
 func (pt *ProgressTracker) UpdateProgress(fileID string, bytesDownloaded, totalBytes int64) {
 	pt.mu.Lock()
@@ -65,6 +127,9 @@ func (pt *ProgressTracker) UpdateProgress(fileID string, bytesDownloaded, totalB
 		if fp.status == "waiting" {
 			fp.status = "downloading"
 		}
+		if fp.started.IsZero() {
+			fp.started = time.Now()
+		}
 	}
 }
 
@@ -74,6 +139,14 @@ func (pt *ProgressTracker) FileStarted(fileID, fileName string) {
 	defer pt.mu.Unlock()
 	if fp, ok := pt.files[fileID]; ok {
 		fp.status = "downloading"
+		if fp.started.IsZero() {
+			fp.started = time.Now()
+		}
+		if fp.resumed > 0 {
+			pt.printEvent("Resuming %s (%s of %s done)", fp.fileName, FormatBytes(fp.resumed), FormatBytes(fp.total))
+		} else {
+			pt.printEvent("Downloading %s (%s)", fp.fileName, FormatBytes(fp.total))
+		}
 	}
 }
 
@@ -84,6 +157,8 @@ func (pt *ProgressTracker) FileCompleted(fileID, fileName string) {
 	if fp, ok := pt.files[fileID]; ok {
 		fp.status = "complete"
 		fp.current = fp.total
+		fp.finished = time.Now()
+		pt.printEvent("Completed %s (%s)", fp.fileName, FormatBytes(fp.total))
 	}
 }
 
@@ -93,6 +168,8 @@ func (pt *ProgressTracker) FileFailed(fileID, fileName string, err error) {
 	defer pt.mu.Unlock()
 	if fp, ok := pt.files[fileID]; ok {
 		fp.status = "failed"
+		fp.finished = time.Now()
+		pt.printEvent("Failed %s: %v", fp.fileName, err)
 	}
 }
 
@@ -103,6 +180,46 @@ func (pt *ProgressTracker) FileSkipped(fileID, fileName string) {
 	if fp, ok := pt.files[fileID]; ok {
 		fp.status = "skipped"
 		fp.current = fp.total
+		pt.printEvent("Skipped %s (already complete)", fp.fileName)
+	}
+}
+
+// FileStats is what a tracker saw of one file during the session.
+type FileStats struct {
+	FileID      string
+	FileName    string
+	Size        int64
+	Status      string        // complete, skipped, failed, or waiting/downloading if unfinished
+	Transferred int64         // bytes downloaded this session
+	Duration    time.Duration // from starting the file to its end this session
+	Retries     int           // not tracked; filled in from the file's state
+}
+
+// Stats returns the session statistics of every file, in registration
+// order.
+func (pt *ProgressTracker) Stats() []FileStats {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	stats := make([]FileStats, 0, len(pt.order))
+	for _, fileID := range pt.order {
+		fp := pt.files[fileID]
+		s := FileStats{FileID: fileID, FileName: fp.fileName, Size: fp.total, Status: fp.status}
+		if fp.status != "skipped" {
+			s.Transferred = max(fp.current-fp.resumed, 0)
+		}
+		if !fp.started.IsZero() && !fp.finished.IsZero() {
+			s.Duration = fp.finished.Sub(fp.started)
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// printEvent writes a line for a file event to the plain renderer, if any.
+// Callers hold pt.mu.
+func (pt *ProgressTracker) printEvent(format string, args ...interface{}) {
+	if pt.plain != nil {
+		fmt.Fprintf(pt.plain, format+"\n", args...)
 	}
 }
 
@@ -122,6 +239,26 @@ func (pt *ProgressTracker) renderLoop() {
 	}
 }
 
+// summaryLoop writes a summary line to the plain renderer every interval.
+func (pt *ProgressTracker) summaryLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	pt.mu.Lock()
+	pt.totals(time.Now()) // first speed sample
+	pt.mu.Unlock()
+	for {
+		select {
+		case <-pt.done:
+			return
+		case <-ticker.C:
+			pt.mu.Lock()
+			pt.printEvent("%s", pt.summaryLine(time.Now()))
+			pt.mu.Unlock()
+		}
+	}
+}
+
 // render draws the current progress state to stderr.
 func (pt *ProgressTracker) render() {
 	pt.mu.Lock()
@@ -132,45 +269,252 @@ func (pt *ProgressTracker) render() {
 		fmt.Fprintf(os.Stderr, "\033[%dA", pt.rendered)
 	}
 
-	lines := 0
-	for _, fileID := range pt.order {
+	width, height, err := term.GetSize(int(os.Stderr.Fd()))
+	if err != nil {
+		width, height = 0, 0
+	}
+	lines, statuses := pt.lines(time.Now(), width, height)
+	color := useColor(os.Stderr)
+	for i, line := range lines {
+		// Clear rest of line to handle shrinking text.
+		fmt.Fprintf(os.Stderr, "\033[K%s\n", paint(color, statuses[i], line))
+	}
+	// Clear lines left from a longer previous display.
+	if len(lines) < pt.rendered {
+		fmt.Fprint(os.Stderr, "\033[J")
+	}
+	pt.rendered = len(lines)
+}
+
+// progressFixedWidth is the width of a file line besides its name and the
+// inside of its bar: indent, spaces, bar brackets and percentage, sizes, and
+// speed.
+const progressFixedWidth = 2 + 1 + 2 + 5 + 2 + 19 + 2 + 10
+
+// columns returns the widths of the name column and the bar for a terminal
+// width columns wide, or the defaults when the width is unknown (0).
+func columns(width int) (name, bar int) {
+	if width <= 0 {
+		return 30, 25
+	}
+	// Leave the last column free so lines never wrap.
+	avail := width - 1 - progressFixedWidth
+	bar = min(max(avail/3, 10), 25)
+	name = min(max(avail-bar, 12), 60)
+	return name, bar
+}
+
+// lines returns the lines of the display for a terminal of the given size
+// (0 if unknown), with the status of the file of each line ("" for others)
+// to color it by. When the files do not fit, only active and failed files
+// are listed, followed by a line counting the rest.
+func (pt *ProgressTracker) lines(now time.Time, width, height int) (lines, statuses []string) {
+	nameWidth, barWidth := columns(width)
+	if len(pt.order) > 1 {
+		lines = append(lines, pt.totalLine(now, nameWidth, barWidth))
+		statuses = append(statuses, "")
+	}
+
+	var detailID string
+	var detail []string
+	if pt.chunkView {
+		if detailID = pt.chunkFileID(); detailID != "" {
+			if fp, ok := pt.files[detailID]; ok {
+				detail = pt.chunkLines(fp, now)
+			}
+		}
+	}
+
+	shown := pt.order
+	var rest string
+	// Keep a line free for the cursor.
+	if height > 0 && len(lines)+len(pt.order)+len(detail) > height-1 {
+		shown, rest = pt.compact(height - 1 - len(lines) - len(detail) - 1)
+	}
+	for _, fileID := range shown {
 		fp := pt.files[fileID]
+		lines = append(lines, pt.fileLine(fp, now, nameWidth, barWidth))
+		statuses = append(statuses, fp.status)
+		if fileID == detailID {
+			lines = append(lines, detail...)
+			statuses = append(statuses, make([]string, len(detail))...)
+		}
+	}
+	if rest != "" {
+		lines = append(lines, "  "+rest)
+		statuses = append(statuses, "")
+	}
 
-		// Truncate filename to fit.
-		name := fp.fileName
-		if len(name) > 30 {
-			name = "..." + name[len(name)-27:]
+	if width > 0 {
+		for i, line := range lines {
+			lines[i] = clip(line, width-1)
 		}
+	}
+	return lines, statuses
+}
 
-		var line string
-		switch fp.status {
-		case "complete":
-			line = fmt.Sprintf("  %-30s %s  %s\n",
-				name,
-				formatBar(fp.total, fp.total, 25),
-				FormatBytes(fp.total))
-		case "skipped":
-			line = fmt.Sprintf("  %-30s [---- skipped ----]  %s\n",
-				name,
-				FormatBytes(fp.total))
+// compact picks up to n files to list, active before failed, and describes
+// the others, e.g. "950 complete, 3 failed, 40 waiting, 2 more downloading".
+func (pt *ProgressTracker) compact(n int) (shown []string, rest string) {
+	var active, failed []string
+	counts := make(map[string]int)
+	for _, fileID := range pt.order {
+		switch status := pt.files[fileID].status; status {
+		case "complete", "skipped", "waiting":
+			counts[status]++
 		case "failed":
-			line = fmt.Sprintf("  %-30s [---- FAILED  ----]\n", name)
-		case "waiting":
-			line = fmt.Sprintf("  %-30s [waiting...]\n", name)
+			failed = append(failed, fileID)
 		default:
-			line = fmt.Sprintf("  %-30s %s  %s / %s\n",
-				name,
-				formatBar(fp.current, fp.total, 25),
-				FormatBytes(fp.current),
-				FormatBytes(fp.total))
+			active = append(active, fileID)
+		}
+	}
+	for _, ids := range [][]string{active, failed} {
+		for _, fileID := range ids {
+			if len(shown) < n {
+				shown = append(shown, fileID)
+			} else {
+				counts[pt.files[fileID].status]++
+			}
 		}
+	}
 
-		// Clear rest of line to handle shrinking text.
-		fmt.Fprintf(os.Stderr, "\033[K%s", line)
-		lines++
+	failedLabel := "failed"
+	if len(failed) > counts["failed"] {
+		failedLabel = "more failed"
+	}
+	var parts []string
+	for _, c := range []struct{ status, label string }{
+		{"complete", "complete"},
+		{"skipped", "skipped"},
+		{"failed", failedLabel},
+		{"waiting", "waiting"},
+	} {
+		if counts[c.status] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[c.status], c.label))
+		}
+	}
+	if hidden := len(active) - min(len(active), n); hidden > 0 {
+		parts = append(parts, fmt.Sprintf("%d more downloading", hidden))
+	}
+	return shown, strings.Join(parts, ", ")
+}
+
+// fileLine returns the display line of one file.
+func (pt *ProgressTracker) fileLine(fp *fileProgress, now time.Time, nameWidth, barWidth int) string {
+	name := truncateName(fp.fileName, nameWidth)
+	switch fp.status {
+	case "complete":
+		return fmt.Sprintf("  %-*s %s  %s", nameWidth, name, formatBar(fp.total, fp.total, barWidth), FormatBytes(fp.total))
+	case "skipped":
+		return fmt.Sprintf("  %-*s [---- skipped ----]  %s", nameWidth, name, FormatBytes(fp.total))
+	case "failed":
+		return fmt.Sprintf("  %-*s [---- FAILED  ----]", nameWidth, name)
+	case "waiting":
+		return fmt.Sprintf("  %-*s [waiting...]", nameWidth, name)
+	}
+	return fmt.Sprintf("  %-*s %s  %s / %s  %s",
+		nameWidth, name,
+		formatBar(fp.current, fp.total, barWidth),
+		FormatBytes(fp.current),
+		FormatBytes(fp.total),
+		fp.speedText(now))
+}
+
+// truncateName shortens name to width characters, keeping its end (which
+// tells files of a dataset apart) after "...".
+func truncateName(name string, width int) string {
+	r := []rune(name)
+	if len(r) <= width {
+		return name
 	}
+	return "..." + string(r[len(r)-(width-3):])
+}
+
+// clip cuts line to at most width characters.
+func clip(line string, width int) string {
+	r := []rune(line)
+	if len(r) <= width {
+		return line
+	}
+	return string(r[:width])
+}
 
-	pt.rendered = lines
+// totalLine returns the header summarizing all files: bytes done out of the
+// total, the current aggregate speed, and the time left at that speed.
+func (pt *ProgressTracker) totalLine(now time.Time, nameWidth, barWidth int) string {
+	t := pt.totals(now)
+	label := fmt.Sprintf("Total (%d/%d files)", t.finished, t.files)
+	line := fmt.Sprintf("  %-*s %s  %s / %s", nameWidth, label, formatBar(t.done, t.total, barWidth), FormatBytes(t.done), FormatBytes(t.total))
+	if t.rate > 0 {
+		line += fmt.Sprintf("  %s/s", FormatBytes(int64(t.rate)))
+		if eta, ok := t.eta(); ok {
+			line += "  ETA " + eta.Round(time.Second).String()
+		}
+	}
+	return line
+}
+
+// summaryLine returns the periodic line of the plain renderer, e.g.
+// "Progress: 37/210 files, 1.3 TB / 3.9 TB, 212.0 MB/s, ETA 3h24m10s".
+func (pt *ProgressTracker) summaryLine(now time.Time) string {
+	t := pt.totals(now)
+	line := fmt.Sprintf("Progress: %d/%d files, %s / %s", t.finished, t.files, FormatBytes(t.done), FormatBytes(t.total))
+	if t.rate > 0 {
+		line += fmt.Sprintf(", %s/s", FormatBytes(int64(t.rate)))
+		if eta, ok := t.eta(); ok {
+			line += ", ETA " + eta.Round(time.Second).String()
+		}
+	}
+	return line
+}
+
+// progressTotals sums the progress of all files.
+type progressTotals struct {
+	files, finished int
+	done, total     int64
+	rate            float64 // aggregate bytes per second
+}
+
+// eta returns the time left at the current rate, if known.
+func (t progressTotals) eta() (time.Duration, bool) {
+	remaining := t.total - t.done
+	if t.rate <= 0 || remaining <= 0 {
+		return 0, false
+	}
+	return time.Duration(float64(remaining) / t.rate * float64(time.Second)), true
+}
+
+// totals sums all files and samples the aggregate speed at now. Skipped
+// files and bytes from earlier sessions count as done but not towards the
+// speed. Callers hold pt.mu.
+func (pt *ProgressTracker) totals(now time.Time) progressTotals {
+	t := progressTotals{files: len(pt.files)}
+	var transferred int64
+	for _, fp := range pt.files {
+		t.done += fp.current
+		t.total += fp.total
+		switch fp.status {
+		case "skipped":
+			t.finished++
+			continue
+		case "complete":
+			t.finished++
+		}
+		transferred += fp.current - fp.resumed
+	}
+	pt.speed.add(now, transferred)
+	t.rate = pt.speed.rate()
+	return t
+}
+
+// speedText samples the file's progress at now and returns its smoothed
+// speed, e.g. "12.5 MB/s". A stalled file shows "0 B/s".
+func (fp *fileProgress) speedText(now time.Time) string {
+	fp.speed.add(now, fp.current-fp.resumed)
+	if !fp.speed.ready() {
+		return ""
+	}
+	return FormatBytes(int64(fp.speed.rate())) + "/s"
 }
 
 // formatBar builds a progress bar like [========>         ] 45%
@@ -0,0 +1,199 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateMeter(t *testing.T) {
+	var m rateMeter
+	start := time.Now()
+	if r := m.rate(); r != 0 {
+		t.Errorf("empty rate = %v, want 0", r)
+	}
+	m.add(start, 0)
+	m.add(start.Add(100*time.Millisecond), 900) // too soon to measure
+	m.add(start.Add(time.Second), 1000)
+	if r := m.rate(); r != 1000 {
+		t.Errorf("rate = %v, want 1000", r)
+	}
+
+	// A burst moves the rate only part of the way.
+	m.add(start.Add(2*time.Second), 1000+100000)
+	if r := m.rate(); r <= 1000 || r >= 50000 {
+		t.Errorf("rate after burst = %v, want between 1000 and 50000", r)
+	}
+
+	// A steady speed is reached after a few time constants.
+	bytes := int64(101000)
+	for i := 3; i <= 40; i++ {
+		bytes += 5000
+		m.add(start.Add(time.Duration(i)*time.Second), bytes)
+	}
+	if r := m.rate(); math.Abs(r-5000) > 50 {
+		t.Errorf("steady rate = %v, want about 5000", r)
+	}
+
+	// No new bytes for rateStallTimeout reads as 0.
+	m.add(start.Add(45*time.Second), bytes)
+	if r := m.rate(); r == 0 || r >= 5000 {
+		t.Errorf("rate after 5s idle = %v, want between 0 and 5000", r)
+	}
+	m.add(start.Add(51*time.Second), bytes)
+	if r := m.rate(); r != 0 {
+		t.Errorf("stalled rate = %v, want 0", r)
+	}
+}
+
+func TestTotalLine(t *testing.T) {
+	pt := NewSilentProgressTracker()
+	pt.RegisterFile("EGAF1", "a.bam", 1000)
+	pt.RegisterFile("EGAF2", "b.bam", 3000)
+	pt.RegisterFile("EGAF3", "c.bam", 1000)
+	pt.FileSkipped("EGAF3", "c.bam")
+
+	start := time.Now()
+	pt.totalLine(start, 30, 25)
+	pt.UpdateProgress("EGAF1", 1000, 1000)
+	pt.FileCompleted("EGAF1", "a.bam")
+	line := pt.totalLine(start.Add(time.Second), 30, 25)
+
+	for _, want := range []string{"Total (2/3 files)", "2.0 KB / 4.9 KB", "1000 B/s", "ETA 3s"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("totalLine = %q, missing %q", line, want)
+		}
+	}
+}
+
+func TestSpeedText(t *testing.T) {
+	fp := &fileProgress{total: 4096}
+	start := time.Now()
+	if s := fp.speedText(start); s != "" {
+		t.Errorf("first sample speed = %q, want none", s)
+	}
+	fp.current = 2048
+	if s := fp.speedText(start.Add(time.Second)); s != "2.0 KB/s" {
+		t.Errorf("speed = %q, want 2.0 KB/s", s)
+	}
+	// A stalled file shows no progress once it has received nothing for a while.
+	for i := 2; i <= 13; i++ {
+		fp.speedText(start.Add(time.Duration(i) * time.Second))
+	}
+	if s := fp.speedText(start.Add(14 * time.Second)); s != "0 B/s" {
+		t.Errorf("stalled speed = %q, want 0 B/s", s)
+	}
+}
+
+func TestPlainProgressTracker(t *testing.T) {
+	var buf strings.Builder
+	pt := NewPlainProgressTracker(&buf, 0)
+	pt.RegisterFile("EGAF1", "a.bam", 2048)
+	pt.RegisterFile("EGAF2", "b.bam", 1024)
+	pt.FileStarted("EGAF1", "a.bam")
+	pt.FileCompleted("EGAF1", "a.bam")
+	pt.FileFailed("EGAF2", "b.bam", errors.New("checksum mismatch"))
+	pt.Stop()
+
+	want := "Downloading a.bam (2.0 KB)\nCompleted a.bam (2.0 KB)\nFailed b.bam: checksum mismatch\n"
+	if buf.String() != want {
+		t.Errorf("plain output = %q, want %q", buf.String(), want)
+	}
+	if strings.Contains(buf.String(), "\033") {
+		t.Error("plain output contains escape codes")
+	}
+	if got := pt.summaryLine(time.Now()); got != "Progress: 1/2 files, 2.0 KB / 3.0 KB" {
+		t.Errorf("summaryLine = %q", got)
+	}
+}
+
+func TestColumns(t *testing.T) {
+	tests := []struct{ width, name, bar int }{
+		{0, 30, 25},
+		{80, 24, 12},
+		{200, 60, 25},
+		{40, 12, 10},
+	}
+	for _, tt := range tests {
+		if name, bar := columns(tt.width); name != tt.name || bar != tt.bar {
+			t.Errorf("columns(%d) = %d, %d, want %d, %d", tt.width, name, bar, tt.name, tt.bar)
+		}
+	}
+}
+
+func TestLinesCompact(t *testing.T) {
+	pt := NewSilentProgressTracker()
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprintf("EGAF%03d", i)
+		pt.RegisterFile(id, id+".bam", 1000)
+		switch {
+		case i < 90:
+			pt.FileCompleted(id, id+".bam")
+		case i < 93:
+			pt.UpdateProgress(id, 500, 1000)
+		case i == 93:
+			pt.FileFailed(id, id+".bam", errors.New("checksum mismatch"))
+		}
+	}
+
+	lines, _ := pt.lines(time.Now(), 80, 10)
+	if len(lines) != 6 {
+		t.Fatalf("got %d lines, want 6:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+	for _, line := range lines {
+		if n := len([]rune(line)); n > 79 {
+			t.Errorf("line of %d characters is wider than the terminal: %q", n, line)
+		}
+	}
+	if !strings.HasPrefix(lines[0], "  Total (90/100 files)") {
+		t.Errorf("header = %q", lines[0])
+	}
+	for i, want := range []string{"EGAF090", "EGAF091", "EGAF092", "EGAF093"} {
+		if !strings.Contains(lines[i+1], want) {
+			t.Errorf("line %d = %q, want %s", i+1, lines[i+1], want)
+		}
+	}
+	if want := "  90 complete, 6 waiting"; lines[5] != want {
+		t.Errorf("summary = %q, want %q", lines[5], want)
+	}
+
+	// Everything fits on a tall terminal.
+	if lines, _ := pt.lines(time.Now(), 80, 200); len(lines) != 101 {
+		t.Errorf("tall terminal shows %d lines, want 101", len(lines))
+	}
+}
+
+func TestTruncateName(t *testing.T) {
+	if got := truncateName("short.bam", 12); got != "short.bam" {
+		t.Errorf("truncateName kept = %q", got)
+	}
+	if got := truncateName("SLX-9630.A006.bwa.bam", 12); got != "...6.bwa.bam" {
+		t.Errorf("truncateName = %q", got)
+	}
+}
+
+func TestFileResumed(t *testing.T) {
+	var buf strings.Builder
+	pt := NewPlainProgressTracker(&buf, 0)
+	pt.RegisterFile("EGAF1", "a.bam", 4000)
+	pt.RegisterFile("EGAF2", "b.bam", 4000)
+	pt.FileResumed("EGAF1", 3000)
+	pt.FileStarted("EGAF1", "a.bam")
+
+	start := time.Now()
+	pt.totals(start)
+	pt.UpdateProgress("EGAF1", 3500, 4000)
+	got := pt.totals(start.Add(time.Second))
+	if got.done != 3500 || got.rate != 500 {
+		t.Errorf("totals = %+v, want 3500 done at 500 B/s (resumed bytes left out of the speed)", got)
+	}
+	if eta, ok := got.eta(); !ok || eta != 9*time.Second {
+		t.Errorf("eta = %v, want 9s", eta)
+	}
+	if want := "Resuming a.bam (2.9 KB of 3.9 KB done)\n"; buf.String() != want {
+		t.Errorf("plain output = %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,73 @@
+package ui
+
+import (
+	"math"
+	"time"
+)
+
+// rateTimeConstant is how quickly a rateMeter follows a change in speed:
+// samples lose weight by a factor e every rateTimeConstant, which smooths
+// over chunks starting and finishing.
+const rateTimeConstant = 5 * time.Second
+
+// rateMinInterval is the shortest time a rate is measured over; samples
+// closer to the last one are ignored until it has passed.
+const rateMinInterval = 500 * time.Millisecond
+
+// rateStallTimeout is how long without new bytes before a rate reads 0, so
+// a stalled transfer stands out instead of slowly decaying.
+const rateStallTimeout = 10 * time.Second
+
+// rateMeter measures a byte rate from samples of a running byte count, as an
+// exponentially weighted moving average (EWMA) of the speed between samples.
+type rateMeter struct {
+	last     rateSample
+	moved    time.Time // when the byte count last grew
+	samples  int
+	smoothed float64
+}
+
+type rateSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// add records the byte count at time at. The speed since the last sample
+// is weighted by the time it covers, so irregular samples average correctly.
+func (m *rateMeter) add(at time.Time, bytes int64) {
+	if m.samples == 0 {
+		m.last, m.moved, m.samples = rateSample{at, bytes}, at, 1
+		return
+	}
+	dt := at.Sub(m.last.at)
+	if dt < rateMinInterval {
+		return
+	}
+	speed := 0.0
+	if bytes > m.last.bytes {
+		speed = float64(bytes-m.last.bytes) / dt.Seconds()
+		m.moved = at
+	}
+	if m.samples == 1 {
+		m.smoothed = speed
+	} else {
+		alpha := 1 - math.Exp(-dt.Seconds()/rateTimeConstant.Seconds())
+		m.smoothed += alpha * (speed - m.smoothed)
+	}
+	m.last = rateSample{at, bytes}
+	m.samples++
+}
+
+// ready reports whether there are two samples to measure a rate from.
+func (m *rateMeter) ready() bool {
+	return m.samples > 1
+}
+
+// rate returns the smoothed bytes per second, or 0 before there are two
+// samples or when the byte count has not grown for rateStallTimeout.
+func (m *rateMeter) rate() float64 {
+	if !m.ready() || m.last.at.Sub(m.moved) > rateStallTimeout {
+		return 0
+	}
+	return m.smoothed
+}
@@ -0,0 +1,141 @@
+package ui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// spinnerFrames are drawn in turn in front of a spinner's text.
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+// spinnerMu guards the running spinners and their drawing.
+var (
+	spinnerMu sync.Mutex
+	spinners  []*Spinner // running spinners; the last one is drawn
+)
+
+// Spinner redraws one line on a terminal while a slow phase runs (listing a
+// dataset, resolving files, fetching metadata), so it is visibly alive. A
+// nil *Spinner draws nothing.
+type Spinner struct {
+	w     io.Writer
+	text  string
+	frame int
+	done  chan struct{}
+}
+
+// StartSpinner draws text with a spinner on w, a terminal, until Stop. When
+// spinners run at once (e.g. metadata of several datasets fetched in
+// parallel), the newest is drawn, and stopping it shows the one before.
+func StartSpinner(w io.Writer, text string) *Spinner {
+	s := &Spinner{w: w, text: text, done: make(chan struct{})}
+	spinnerMu.Lock()
+	spinners = append(spinners, s)
+	s.draw()
+	spinnerMu.Unlock()
+	go s.loop()
+	return s
+}
+
+// Update replaces the spinner's text, e.g. with a new count.
+func (s *Spinner) Update(text string) {
+	if s == nil {
+		return
+	}
+	spinnerMu.Lock()
+	defer spinnerMu.Unlock()
+	s.text = text
+	if activeSpinner() == s {
+		s.draw()
+	}
+}
+
+// Updatef replaces the spinner's text with a formatted one.
+func (s *Spinner) Updatef(format string, args ...interface{}) {
+	s.Update(fmt.Sprintf(format, args...))
+}
+
+// Stop stops the spinner and clears its line, or draws the spinner started
+// before it that is still running. Stopping a stopped spinner does nothing.
+func (s *Spinner) Stop() {
+	if s == nil {
+		return
+	}
+	spinnerMu.Lock()
+	defer spinnerMu.Unlock()
+	i := slices.Index(spinners, s)
+	if i < 0 {
+		return
+	}
+	wasActive := i == len(spinners)-1
+	spinners = slices.Delete(spinners, i, i+1)
+	close(s.done)
+	if !wasActive {
+		return
+	}
+	if next := activeSpinner(); next != nil {
+		next.draw()
+	} else {
+		fmt.Fprint(s.w, "\r\033[K")
+	}
+}
+
+// activeSpinner returns the spinner being drawn, or nil. Callers hold
+// spinnerMu.
+func activeSpinner() *Spinner {
+	if len(spinners) == 0 {
+		return nil
+	}
+	return spinners[len(spinners)-1]
+}
+
+func (s *Spinner) loop() {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			spinnerMu.Lock()
+			if activeSpinner() == s {
+				s.frame++
+				s.draw()
+			}
+			spinnerMu.Unlock()
+		}
+	}
+}
+
+// draw redraws the spinner's line. Callers hold spinnerMu.
+func (s *Spinner) draw() {
+	line := spinnerFrames[s.frame%len(spinnerFrames)] + " " + s.text
+	if f, ok := s.w.(*os.File); ok {
+		if width, _, err := term.GetSize(int(f.Fd())); err == nil && width > 0 {
+			line = clip(line, width-1)
+		}
+	}
+	fmt.Fprintf(s.w, "\r\033[K%s", line)
+}
+
+// ClearSpinner runs write with the line of the running spinner, if any,
+// cleared, and redraws the spinner after it. Log output to the spinner's
+// terminal goes through it, so lines do not run into the spinner.
+func ClearSpinner(write func()) {
+	spinnerMu.Lock()
+	defer spinnerMu.Unlock()
+	active := activeSpinner()
+	if active != nil {
+		fmt.Fprint(active.w, "\r\033[K")
+	}
+	write()
+	if active != nil {
+		active.draw()
+	}
+}
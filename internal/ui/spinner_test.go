@@ -0,0 +1,54 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSpinnerNil(t *testing.T) {
+	var s *Spinner
+	s.Update("text")
+	s.Updatef("%d", 1)
+	s.Stop()
+}
+
+func TestSpinnerNested(t *testing.T) {
+	var outer, inner strings.Builder
+	a := StartSpinner(&outer, "Fetching datasets")
+	b := StartSpinner(&inner, "Fetching metadata")
+	a.Update("Fetching datasets (1/2)") // not drawn while b runs
+	b.Stop()
+	b.Stop()
+	a.Stop()
+
+	if got := inner.String(); !strings.HasPrefix(got, "\r\033[K| Fetching metadata") {
+		t.Errorf("inner spinner drew %q", got)
+	}
+	got := outer.String()
+	if !strings.HasPrefix(got, "\r\033[K| Fetching datasets") {
+		t.Errorf("outer spinner drew %q", got)
+	}
+	// Stopping b redraws a with its new text; stopping a clears the line.
+	if !strings.HasSuffix(got, "Fetching datasets (1/2)\r\033[K") {
+		t.Errorf("outer spinner ended with %q", got)
+	}
+	if len(spinners) != 0 {
+		t.Errorf("%d spinners still running", len(spinners))
+	}
+}
+
+func TestClearSpinner(t *testing.T) {
+	var b strings.Builder
+	ClearSpinner(func() { b.WriteString("log\n") })
+	if got := b.String(); got != "log\n" {
+		t.Errorf("without a spinner, got %q", got)
+	}
+
+	b.Reset()
+	s := StartSpinner(&b, "Resolving")
+	ClearSpinner(func() { b.WriteString("log\n") })
+	s.Stop()
+	if want := "\r\033[K| Resolving\r\033[Klog\n"; !strings.HasPrefix(b.String(), want) {
+		t.Errorf("got %q, want prefix %q", b.String(), want)
+	}
+}
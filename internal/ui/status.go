@@ -2,23 +2,102 @@ package ui
 
 import (
 	"fmt"
+	"io"
 	"strings"
+	"time"
 
 	"github.com/khan-lab/EGAfetch/internal/state"
 )
 
-// PrintFileStates prints a formatted table of file download states.
-func PrintFileStates(states []*state.FileState) {
+// SampleAnnotation identifies the sample a file belongs to.
+type SampleAnnotation struct {
+	SampleID string
+	Alias    string
+}
+
+// String returns "SampleID (Alias)", or just the ID when there is no alias.
+func (a SampleAnnotation) String() string {
+	if a.Alias == "" || a.Alias == a.SampleID {
+		return a.SampleID
+	}
+	return fmt.Sprintf("%s (%s)", a.SampleID, a.Alias)
+}
+
+// sampleColumnWidth returns the width of the sample column for the given
+// annotations, or 0 if none are set.
+func sampleColumnWidth(annotations []SampleAnnotation) int {
+	width := 0
+	for _, a := range annotations {
+		if n := len(a.String()); n > width {
+			width = n
+		}
+	}
+	if width == 0 {
+		return 0
+	}
+	if width < len("Sample") {
+		width = len("Sample")
+	}
+	if width > 40 {
+		width = 40
+	}
+	return width
+}
+
+// annotatedTable formats rows of a table whose fixed-width columns are
+// followed by a Sample column, shown only when some row has a sample, and a
+// free-width last column.
+type annotatedTable struct {
+	format      string
+	sampleWidth int
+	ruleWidth   int
+}
+
+// newAnnotatedTable builds the row format from the format of the fixed
+// columns (each followed by a space) and the width of their rule.
+func newAnnotatedTable(fixedFormat string, fixedRuleWidth, sampleWidth int) annotatedTable {
+	t := annotatedTable{format: fixedFormat, sampleWidth: sampleWidth, ruleWidth: fixedRuleWidth}
+	if sampleWidth > 0 {
+		t.format += fmt.Sprintf("%%-%ds ", sampleWidth)
+		t.ruleWidth += 1 + sampleWidth
+	}
+	t.format += "%s\n"
+	return t
+}
+
+// printHeader prints the column titles and a rule beneath them.
+func (t annotatedTable) printHeader(w io.Writer, fixed []interface{}, last string) {
+	fmt.Fprintln(w)
+	t.printRow(w, fixed, "Sample", last)
+	fmt.Fprintln(w, strings.Repeat("-", t.ruleWidth))
+}
+
+// printRow prints one row; sample is dropped when the column is not shown.
+func (t annotatedTable) printRow(w io.Writer, fixed []interface{}, sample, last string) {
+	args := fixed
+	if t.sampleWidth > 0 {
+		args = append(args, truncate(sample, t.sampleWidth))
+	}
+	fmt.Fprintf(w, t.format, append(args, last)...)
+}
+
+// PrintFileStates prints a formatted table of file download states to w. When
+// samples is non-empty, the sample of each file is shown as well.
+func PrintFileStates(w io.Writer, states []*state.FileState, samples map[string]SampleAnnotation) {
 	if len(states) == 0 {
-		fmt.Println("No downloads found.")
+		fmt.Fprintln(w, "No downloads found.")
 		return
 	}
 
-	fmt.Printf("\n%-20s %-15s %-12s %-10s %s\n",
-		"File ID", "Status", "Size", "Progress", "File Name")
-	fmt.Println(strings.Repeat("-", 80))
+	annotations := make([]SampleAnnotation, len(states))
+	for i, fs := range states {
+		annotations[i] = samples[fs.FileID]
+	}
+	table := newAnnotatedTable("%-20s %-15s %-12s %-10s ", 80, sampleColumnWidth(annotations))
+	table.printHeader(w, []interface{}{"File ID", "Status", "Size", "Progress"}, "File Name")
+	color := useColor(w)
 
-	for _, fs := range states {
+	for i, fs := range states {
 		var progress string
 		if fs.Status == state.StatusComplete {
 			progress = "100%"
@@ -33,45 +112,89 @@ func PrintFileStates(states []*state.FileState) {
 			progress = "-"
 		}
 
-		fmt.Printf("%-20s %-15s %-12s %-10s %s\n",
+		// Pad before coloring: escape codes would count towards the width.
+		table.printRow(w, []interface{}{
 			truncate(fs.FileID, 20),
-			fs.Status,
+			paint(color, string(fs.Status), fmt.Sprintf("%-15s", fs.Status)),
 			FormatBytes(fs.Size),
 			progress,
-			fs.FileName,
-		)
+		}, annotations[i].String(), fs.FileName)
 
 		if fs.Error != "" {
-			fmt.Printf("  Error: %s\n", fs.Error)
+			fmt.Fprintf(w, "  Error: %s\n", fs.Error)
 		}
 	}
-	fmt.Println()
+	fmt.Fprintln(w)
 }
 
-// PrintDatasetFiles prints a formatted table of files in a dataset.
-func PrintDatasetFiles(files []FileInfo) {
+// PrintRunStats prints the end-of-run table of a download session to w:
+// each file's size, how long it took, its average speed, retries, and
+// result, then the session totals.
+func PrintRunStats(w io.Writer, stats []FileStats) {
+	if len(stats) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%-10s %-10s %-12s %-7s %-20s %s\n", "Size", "Duration", "Speed", "Retries", "Result", "File Name")
+	fmt.Fprintln(w, strings.Repeat("-", 90))
+
+	var transferred int64
+	counts := make(map[string]int)
+	color := useColor(w)
+	for _, s := range stats {
+		duration, speed := "-", "-"
+		if s.Duration > 0 {
+			duration = s.Duration.Round(time.Second).String()
+			speed = FormatBytes(int64(float64(s.Transferred)/s.Duration.Seconds())) + "/s"
+		}
+		result := s.Status
+		switch s.Status {
+		case "skipped":
+			result = "already complete"
+		case "waiting", "downloading", "merging", "verifying":
+			result = "not finished"
+		}
+		fmt.Fprintf(w, "%-10s %-10s %-12s %-7d %s %s\n",
+			FormatBytes(s.Size), duration, speed, s.Retries, paint(color, s.Status, fmt.Sprintf("%-20s", result)), s.FileName)
+		transferred += s.Transferred
+		counts[result]++
+	}
+
+	fmt.Fprintf(w, "\n%d of %d file(s) downloaded", counts["complete"], len(stats))
+	for _, result := range []string{"already complete", "failed", "not finished"} {
+		if counts[result] > 0 {
+			fmt.Fprintf(w, ", %d %s", counts[result], result)
+		}
+	}
+	fmt.Fprintf(w, "; %s transferred\n\n", FormatBytes(transferred))
+}
+
+// PrintDatasetFiles prints a formatted table of files in a dataset to w.
+func PrintDatasetFiles(w io.Writer, files []FileInfo) {
 	if len(files) == 0 {
-		fmt.Println("No files found.")
+		fmt.Fprintln(w, "No files found.")
 		return
 	}
 
-	fmt.Printf("\n%-20s %-12s %-6s %-34s %s\n",
-		"File ID", "Size", "Check", "Checksum", "File Name")
-	fmt.Println(strings.Repeat("-", 110))
+	annotations := make([]SampleAnnotation, len(files))
+	for i, f := range files {
+		annotations[i] = f.Sample
+	}
+	table := newAnnotatedTable("%-20s %-12s %-6s %-34s ", 110, sampleColumnWidth(annotations))
+	table.printHeader(w, []interface{}{"File ID", "Size", "Check", "Checksum"}, "File Name")
 
 	var totalSize int64
 	for _, f := range files {
-		fmt.Printf("%-20s %-12s %-6s %-34s %s\n",
+		table.printRow(w, []interface{}{
 			truncate(f.FileID, 20),
 			FormatBytes(f.FileSize),
 			f.ChecksumType,
 			f.Checksum,
-			f.FileName,
-		)
+		}, f.Sample.String(), f.FileName)
 		totalSize += f.FileSize
 	}
 
-	fmt.Printf("\n%d files, %s total\n\n", len(files), FormatBytes(totalSize))
+	fmt.Fprintf(w, "\n%d files, %s total\n\n", len(files), FormatBytes(totalSize))
 }
 
 // FileInfo holds display information for a file.
@@ -81,6 +204,7 @@ type FileInfo struct {
 	FileSize     int64
 	Checksum     string
 	ChecksumType string
+	Sample       SampleAnnotation // zero if no metadata is available
 }
 
 // DatasetSummary holds display information for a dataset.
@@ -91,16 +215,16 @@ type DatasetSummary struct {
 	NumSamples  int
 }
 
-// PrintDatasets prints a formatted table of authorized datasets.
-func PrintDatasets(datasets []DatasetSummary) {
+// PrintDatasets prints a formatted table of authorized datasets to w.
+func PrintDatasets(w io.Writer, datasets []DatasetSummary) {
 	if len(datasets) == 0 {
-		fmt.Println("No authorized datasets found.")
+		fmt.Fprintln(w, "No authorized datasets found.")
 		return
 	}
 
-	fmt.Printf("\nAuthorized datasets (%d):\n\n", len(datasets))
-	fmt.Printf("%-20s %8s  %s\n", "Dataset ID", "Samples", "Title")
-	fmt.Println(strings.Repeat("-", 90))
+	fmt.Fprintf(w, "\nAuthorized datasets (%d):\n\n", len(datasets))
+	fmt.Fprintf(w, "%-20s %8s  %s\n", "Dataset ID", "Samples", "Title")
+	fmt.Fprintln(w, strings.Repeat("-", 90))
 
 	for _, d := range datasets {
 		samples := "-"
@@ -108,19 +232,19 @@ func PrintDatasets(datasets []DatasetSummary) {
 			samples = fmt.Sprintf("%d", d.NumSamples)
 		}
 		title := truncate(d.Title, 58)
-		fmt.Printf("%-20s %8s  %s\n", d.DatasetID, samples, title)
+		fmt.Fprintf(w, "%-20s %8s  %s\n", d.DatasetID, samples, title)
 	}
-	fmt.Println()
+	fmt.Fprintln(w)
 }
 
-// PrintAuthStatus prints the current authentication status.
-func PrintAuthStatus(username string, expiresIn string, loggedIn bool) {
+// PrintAuthStatus prints the current authentication status to w.
+func PrintAuthStatus(w io.Writer, username string, expiresIn string, loggedIn bool) {
 	if !loggedIn {
-		fmt.Println("Not logged in. Run 'egafetch auth login' to authenticate.")
+		fmt.Fprintln(w, "Not logged in. Run 'egafetch auth login' to authenticate.")
 		return
 	}
-	fmt.Printf("Logged in as: %s\n", username)
-	fmt.Printf("Token expires: %s\n", expiresIn)
+	fmt.Fprintf(w, "Logged in as: %s\n", username)
+	fmt.Fprintf(w, "Token expires: %s\n", expiresIn)
 }
 
 func truncate(s string, max int) string {
@@ -129,3 +253,43 @@ func truncate(s string, max int) string {
 	}
 	return s[:max-3] + "..."
 }
+
+// PrintTable prints to w rows under the given headers with columns padded to the
+// widest value in each column.
+func PrintTable(w io.Writer, headers []string, rows [][]string) {
+	widths := make([]int, len(headers))
+	for i, h := range headers {
+		widths[i] = len(h)
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			if i < len(widths) && len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+
+	printRow := func(cells []string) {
+		var b strings.Builder
+		for i, cell := range cells {
+			if i == len(cells)-1 {
+				b.WriteString(cell)
+				break
+			}
+			fmt.Fprintf(&b, "%-*s  ", widths[i], cell)
+		}
+		fmt.Fprintln(w, strings.TrimRight(b.String(), " "))
+	}
+
+	total := 0
+	for _, width := range widths {
+		total += width + 2
+	}
+
+	fmt.Fprintln(w)
+	printRow(headers)
+	fmt.Fprintln(w, strings.Repeat("-", total))
+	for _, row := range rows {
+		printRow(row)
+	}
+}
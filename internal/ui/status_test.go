@@ -0,0 +1,50 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrintRunStats(t *testing.T) {
+	var b strings.Builder
+	PrintRunStats(&b, []FileStats{
+		{FileName: "a.bam", Size: 4096, Status: "complete", Transferred: 4096, Duration: 2 * time.Second},
+		{FileName: "b.bam", Size: 1024, Status: "skipped"},
+		{FileName: "c.bam", Size: 2048, Status: "failed", Transferred: 1024, Duration: time.Second, Retries: 3},
+		{FileName: "d.bam", Size: 2048, Status: "waiting"},
+	})
+	out := b.String()
+	for _, want := range []string{
+		"4.0 KB     2s         2.0 KB/s     0       complete             a.bam",
+		"1.0 KB     -          -            0       already complete     b.bam",
+		"2.0 KB     1s         1.0 KB/s     3       failed               c.bam",
+		"not finished         d.bam",
+		"1 of 4 file(s) downloaded, 1 already complete, 1 failed, 1 not finished; 5.0 KB transferred",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	pt := NewSilentProgressTracker()
+	pt.RegisterFile("EGAF1", "a.bam", 1000)
+	pt.RegisterFile("EGAF2", "b.bam", 1000)
+	pt.FileResumed("EGAF1", 400)
+	pt.FileStarted("EGAF1", "a.bam")
+	pt.FileCompleted("EGAF1", "a.bam")
+	pt.FileSkipped("EGAF2", "b.bam")
+
+	stats := pt.Stats()
+	if len(stats) != 2 {
+		t.Fatalf("got %d stats, want 2", len(stats))
+	}
+	if s := stats[0]; s.FileID != "EGAF1" || s.Status != "complete" || s.Transferred != 600 {
+		t.Errorf("resumed file = %+v, want complete with 600 bytes transferred", s)
+	}
+	if s := stats[1]; s.Status != "skipped" || s.Transferred != 0 || s.Duration != 0 {
+		t.Errorf("skipped file = %+v, want nothing transferred", s)
+	}
+}
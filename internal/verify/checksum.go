@@ -4,6 +4,7 @@ import (
 	"crypto/md5"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
@@ -11,6 +12,10 @@ import (
 	"strings"
 )
 
+// ErrChecksumMismatch is wrapped by errors from Verify when the file's
+// checksum differs from the expected value.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
 // Verify computes the checksum of the file at filePath and compares it
 // against the expected value. Returns nil on match, error on mismatch
 // or if the file cannot be read.
@@ -32,7 +37,7 @@ func Verify(filePath string, expected string, checksumType string) error {
 
 	actual := hex.EncodeToString(h.Sum(nil))
 	if !strings.EqualFold(actual, expected) {
-		return fmt.Errorf("checksum mismatch: expected %s, got %s", expected, actual)
+		return fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, expected, actual)
 	}
 
 	return nil
@@ -58,6 +63,52 @@ func ComputeChecksum(filePath string, checksumType string) (string, error) {
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
+// Sums holds the hex-encoded MD5 and SHA256 checksums of a file.
+type Sums struct {
+	MD5    string
+	SHA256 string
+}
+
+// ComputeSums returns the MD5 and SHA256 checksums of the file, reading it
+// once.
+func ComputeSums(filePath string) (Sums, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return Sums{}, fmt.Errorf("open file for checksum: %w", err)
+	}
+	defer f.Close()
+
+	md5h, sha256h := md5.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(md5h, sha256h), f); err != nil {
+		return Sums{}, fmt.Errorf("read file for checksum: %w", err)
+	}
+
+	return Sums{
+		MD5:    hex.EncodeToString(md5h.Sum(nil)),
+		SHA256: hex.EncodeToString(sha256h.Sum(nil)),
+	}, nil
+}
+
+// Verify compares the checksum of the given type against the expected
+// value, as the package-level Verify does for a file.
+func (s Sums) Verify(expected string, checksumType string) error {
+	var actual string
+	switch strings.ToUpper(checksumType) {
+	case "MD5":
+		actual = s.MD5
+	case "SHA256":
+		actual = s.SHA256
+	default:
+		return fmt.Errorf("unsupported checksum type: %s", checksumType)
+	}
+
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, expected, actual)
+	}
+
+	return nil
+}
+
 func newHash(checksumType string) (hash.Hash, error) {
 	switch strings.ToUpper(checksumType) {
 	case "MD5":
@@ -0,0 +1,146 @@
+// Package egafetch embeds EGAfetch's download engine in other Go programs,
+// such as LIMS or data portals, so they can download from the European
+// Genome-phenome Archive without running the egafetch command and parsing
+// its output.
+//
+// A download resolves EGA identifiers to files with a Client, then fetches
+// them into an output directory with an Orchestrator:
+//
+//	session, err := egafetch.Login(ctx, username, password)
+//	if err != nil {
+//		return err
+//	}
+//	client := egafetch.NewClient(session)
+//	files, err := client.Resolve(ctx, "EGAD00001001938")
+//	if err != nil {
+//		return err
+//	}
+//	orch := egafetch.NewOrchestrator(client, egafetch.NewStateManager("/data/ega"), egafetch.Options{
+//		Events: egafetch.EventHandlerFunc(func(e egafetch.Event) {
+//			log.Println(e.Kind, e.File.Name, e.Downloaded, e.Err)
+//		}),
+//	})
+//	return orch.Download(ctx, files)
+//
+// Downloads keep their state in the output directory exactly as the egafetch
+// command does, so an interrupted download resumes with either, and the
+// egafetch status, verify, and clean commands work on it.
+//
+// The types of this package are its stable API: fields and methods may be
+// added, but existing ones keep their meaning.
+package egafetch
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/khan-lab/EGAfetch/internal/api"
+	"github.com/khan-lab/EGAfetch/internal/auth"
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+// TokenProvider supplies EGA access tokens to a Client. It must be safe for
+// concurrent use. The sessions returned by Login and LoadSession implement
+// it, refreshing their token before it expires.
+type TokenProvider interface {
+	GetAccessToken(ctx context.Context) (string, error)
+}
+
+// Login signs in to EGA with a username and password and returns the
+// session, kept in memory only.
+func Login(ctx context.Context, username, password string) (TokenProvider, error) {
+	m := auth.NewEphemeralManager()
+	if err := m.Login(ctx, username, password); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// LoadSession returns the session saved by 'egafetch auth login'. Its
+// refreshed tokens are saved back for the egafetch command to use.
+func LoadSession() (TokenProvider, error) {
+	m, err := auth.NewManager()
+	if err != nil {
+		return nil, err
+	}
+	if m.Status() == nil {
+		return nil, auth.ErrNotAuthenticated
+	}
+	return m, nil
+}
+
+// Client resolves identifiers and downloads files through the EGA APIs.
+type Client struct {
+	api *api.Client
+}
+
+// NewClient returns a client authenticated by tp.
+func NewClient(tp TokenProvider) *Client {
+	return &Client{api: api.NewClient(tp)}
+}
+
+// File is a file to download.
+type File struct {
+	ID           string // EGAF accession
+	DatasetID    string // EGAD the file was listed from; "" if named by its EGAF accession
+	Name         string // path in the output directory, EGAF.../name; Download renames files whose Name collides
+	OriginalName string // EGA file name, if Name had to change to be valid on this platform or unique
+	Size         int64  // bytes of the decrypted file
+	Checksum     string // expected checksum of the decrypted file; "" if EGA publishes none
+	ChecksumType string // "md5" or "sha256"
+}
+
+// Resolve returns the files that ids name: every file of each dataset
+// (EGAD...) and each file (EGAF...) itself, in order.
+func (c *Client) Resolve(ctx context.Context, ids ...string) ([]File, error) {
+	var files []File
+	for _, id := range ids {
+		switch {
+		case strings.HasPrefix(id, "EGAD"):
+			listed, err := c.api.ListDatasetFiles(ctx, id)
+			if err != nil {
+				return nil, fmt.Errorf("list dataset %s: %w", id, err)
+			}
+			for _, f := range listed {
+				checksum, checksumType := f.GetChecksum()
+				files = append(files, newFile(f.FileID, id, f.FileName, f.FileSize, checksum, checksumType))
+			}
+		case strings.HasPrefix(id, "EGAF"):
+			meta, err := c.api.GetFileMetadata(ctx, id)
+			if err != nil {
+				return nil, fmt.Errorf("get metadata for %s: %w", id, err)
+			}
+			checksum, checksumType := meta.GetChecksum()
+			files = append(files, newFile(meta.FileID, "", meta.FileName, meta.FileSize, checksum, checksumType))
+		default:
+			return nil, fmt.Errorf("unrecognized identifier %q: expected EGAD... or EGAF...", id)
+		}
+	}
+	return files, nil
+}
+
+func newFile(fileID, datasetID, egaName string, egaSize int64, checksum, checksumType string) File {
+	name, original := state.OutputFileName(fileID, egaName, runtime.GOOS == "windows")
+	return File{
+		ID:           fileID,
+		DatasetID:    datasetID,
+		Name:         name,
+		OriginalName: original,
+		Size:         api.DownloadSize(egaSize, api.FormatPlain),
+		Checksum:     checksum,
+		ChecksumType: checksumType,
+	}
+}
+
+func (f File) spec() state.FileSpec {
+	return state.FileSpec{
+		FileID:       f.ID,
+		FileName:     f.Name,
+		OriginalName: f.OriginalName,
+		Size:         f.Size,
+		Checksum:     f.Checksum,
+		ChecksumType: f.ChecksumType,
+	}
+}
@@ -0,0 +1,70 @@
+package egafetch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+func TestDownloadEvents(t *testing.T) {
+	dir := t.TempDir()
+	files := []File{
+		{ID: "EGAF1", DatasetID: "EGAD1", Name: filepath.Join("EGAF1", "a.bam"), Size: 4},
+		{ID: "EGAF2", DatasetID: "EGAD1", Name: filepath.Join("EGAF2", "b.bam"), Size: 4},
+	}
+	// EGAF1 is complete; EGAF2 exists without state and is skipped by policy.
+	fs := state.NewFileState(files[0].spec(), DefaultChunkSize)
+	fs.Status = state.StatusComplete
+	fs.MD5, fs.SHA256 = "aa", "bb"
+	if err := state.NewStateManager(dir).SaveFileState(fs); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, files[1].Name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	events := make(map[string]EventKind)
+	sm := NewStateManager(dir)
+	orch := NewOrchestrator(NewClient(nil), sm, Options{
+		IfExists: IfExistsSkip,
+		Events: EventHandlerFunc(func(e Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			events[e.File.Name] = e.Kind
+		}),
+	})
+	if err := orch.Download(context.Background(), files); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if events[f.Name] != FileSkipped {
+			t.Errorf("event of %s = %v, want %v", f.Name, events[f.Name], FileSkipped)
+		}
+	}
+
+	states, err := sm.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 1 || states[0].ID != "EGAF1" || states[0].Status != StatusComplete || states[0].MD5 != "aa" {
+		t.Errorf("Files() = %+v, want EGAF1 complete", states)
+	}
+	manifest, err := sm.sm.LoadManifest()
+	if err != nil || manifest.DatasetID != "EGAD1" || len(manifest.Files) != 2 {
+		t.Errorf("saved manifest = %+v, %v", manifest, err)
+	}
+
+	orch = NewOrchestrator(NewClient(nil), sm, Options{IfExists: "keep"})
+	if err := orch.Download(context.Background(), files); err == nil {
+		t.Error("Download with an invalid IfExists policy succeeded")
+	}
+}
@@ -0,0 +1,225 @@
+package egafetch
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/khan-lab/EGAfetch/internal/download"
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+// Defaults of Options, the same as those of 'egafetch download'.
+const (
+	DefaultParallelFiles  = 4
+	DefaultParallelChunks = 8
+	DefaultChunkSize      = 64 * 1024 * 1024
+	DefaultSmallFileSize  = download.DefaultSmallFileSize
+)
+
+// Policies for output files that exist without download state (Options.IfExists).
+const (
+	IfExistsVerify    = download.IfExistsVerify    // keep it if it matches the checksum, fail otherwise
+	IfExistsSkip      = download.IfExistsSkip      // leave it alone and do not download
+	IfExistsOverwrite = download.IfExistsOverwrite // download and replace it
+	IfExistsRename    = download.IfExistsRename    // move it aside, then download
+)
+
+// Options configures an Orchestrator. The zero value downloads with the
+// egafetch command's defaults.
+type Options struct {
+	ParallelFiles  int             // files downloaded at once; 0 = DefaultParallelFiles
+	ParallelChunks int             // chunks per file downloaded at once; 0 = DefaultParallelChunks
+	ChunkSize      int64           // bytes per chunk; 0 = DefaultChunkSize
+	MaxBandwidth   int64           // bytes per second across all files; 0 = unlimited
+	AdaptiveChunks bool            // adjust the chunk size to the throughput
+	AutoTune       bool            // adjust parallelism at runtime; ParallelFiles and ParallelChunks are the maximums
+	IfExists       string          // policy for output files without state; "" = IfExistsVerify
+	Shared         bool            // coordinate with other processes downloading into the same directory
+	MD5Files       bool            // also write a .md5 file next to each downloaded file
+	Uploader       Uploader        // nil = files stay in the output directory
+	Indexer        Indexer         // nil = verified files are not indexed
+	Events         EventHandler    // nil = no events
+	Endpoints      []string        // data API base URLs to fail over between, in order; nil = central EGA
+	DirectIO       bool            // write chunk and output files with O_DIRECT, bypassing the page cache (Linux only)
+	BufferSize     int             // bytes each chunk download reads at a time; 0 = 1 MiB
+	NoHedge        bool            // never duplicate the requests of straggler chunks
+	SmallFileSize  int64           // files up to this size are downloaded in one request, without chunks; 0 = DefaultSmallFileSize, negative = never
+	StallTimeout   time.Duration   // retry a chunk whose response delivers no data for this long; 0 = 60 seconds
+	Drain          <-chan struct{} // closed to stop starting files and chunks and let those in flight finish; nil = never
+	StripeCount    int             // on Lustre, OSTs to stripe output files of 1 GiB or more over; -1 = all, 0 = the filesystem default
+	StripeSize     int64           // on Lustre, stripe size of output files of 1 GiB or more, a multiple of 64 KiB; 0 = the filesystem default
+}
+
+// Uploader copies verified files to remote storage. The output directory
+// then only stages each file until it is uploaded, and the local copy is
+// removed once Upload returns.
+type Uploader interface {
+	// Upload copies the file at localPath to name, a slash-separated path
+	// relative to the remote output.
+	Upload(ctx context.Context, localPath, name string) error
+}
+
+// Indexer indexes verified files, such as BAM or VCF files.
+type Indexer interface {
+	// Index indexes the file at path if its format is indexed, and returns
+	// the index files it wrote next to it. A failure leaves the download
+	// complete and is only logged.
+	Index(ctx context.Context, path string) ([]string, error)
+}
+
+// EventKind is the kind of an Event.
+type EventKind int
+
+const (
+	FileStarted  EventKind = iota + 1 // the file's download started
+	FileProgress                      // bytes of the file arrived; Event.Downloaded is set
+	FileSkipped                       // the file was already complete, or skipped by Options.IfExists
+	FileDone                          // the file finished; Event.Err is set if it failed
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case FileStarted:
+		return "started"
+	case FileProgress:
+		return "progress"
+	case FileSkipped:
+		return "skipped"
+	case FileDone:
+		return "done"
+	}
+	return fmt.Sprintf("EventKind(%d)", int(k))
+}
+
+// Event reports a change in the download of a file.
+type Event struct {
+	Kind       EventKind
+	File       File
+	Downloaded int64 // bytes of the file downloaded so far, for FileProgress
+	Err        error // why the file failed, for FileDone
+}
+
+// EventHandler receives the events of a download. Files download in
+// parallel, so HandleEvent is called concurrently and should return
+// quickly; FileProgress events arrive as often as data does.
+type EventHandler interface {
+	HandleEvent(Event)
+}
+
+// EventHandlerFunc adapts a function to an EventHandler.
+type EventHandlerFunc func(Event)
+
+// HandleEvent calls f(e).
+func (f EventHandlerFunc) HandleEvent(e Event) {
+	f(e)
+}
+
+// Orchestrator downloads files into the output directory of a
+// StateManager, in parallel and resuming earlier downloads.
+type Orchestrator struct {
+	client *Client
+	state  *StateManager
+	opts   download.DownloadOptions
+	events EventHandler
+}
+
+// NewOrchestrator returns an orchestrator that downloads through client
+// into the output directory of sm.
+func NewOrchestrator(client *Client, sm *StateManager, opts Options) *Orchestrator {
+	o := &Orchestrator{
+		client: client,
+		state:  sm,
+		events: opts.Events,
+		opts: download.DownloadOptions{
+			ParallelFiles:    opts.ParallelFiles,
+			ParallelChunks:   opts.ParallelChunks,
+			ChunkSize:        opts.ChunkSize,
+			AdaptiveChunking: opts.AdaptiveChunks,
+			AutoTune:         opts.AutoTune,
+			IfExists:         opts.IfExists,
+			Shared:           opts.Shared,
+			MD5Files:         opts.MD5Files,
+			Endpoints:        opts.Endpoints,
+			DirectIO:         opts.DirectIO,
+			BufferSize:       opts.BufferSize,
+			NoHedge:          opts.NoHedge,
+			StallTimeout:     opts.StallTimeout,
+			Drain:            opts.Drain,
+			Striping:         download.Striping{Count: opts.StripeCount, Size: opts.StripeSize},
+		},
+	}
+	if o.opts.ParallelFiles <= 0 {
+		o.opts.ParallelFiles = DefaultParallelFiles
+	}
+	if o.opts.ParallelChunks <= 0 {
+		o.opts.ParallelChunks = DefaultParallelChunks
+	}
+	if o.opts.ChunkSize <= 0 {
+		o.opts.ChunkSize = DefaultChunkSize
+	}
+	switch {
+	case opts.SmallFileSize == 0:
+		o.opts.SmallFileSize = DefaultSmallFileSize
+	case opts.SmallFileSize > 0:
+		o.opts.SmallFileSize = opts.SmallFileSize
+	}
+	if opts.MaxBandwidth > 0 {
+		o.opts.Limiter = rate.NewLimiter(rate.Limit(opts.MaxBandwidth), 256*1024)
+	}
+	// Nil interfaces must stay nil, not become non-nil ones holding nil.
+	if opts.Uploader != nil {
+		o.opts.Uploader = opts.Uploader
+	}
+	if opts.Indexer != nil {
+		o.opts.Indexer = opts.Indexer
+	}
+	return o
+}
+
+// Download downloads files, skipping those already complete, and returns
+// once every file is complete or one has failed. Cancelling ctx stops the
+// download; a later Download of the same files resumes it.
+func (o *Orchestrator) Download(ctx context.Context, files []File) error {
+	if o.opts.IfExists != "" && !slices.Contains(download.IfExistsPolicies, o.opts.IfExists) {
+		return fmt.Errorf("invalid IfExists policy %q (use %s)", o.opts.IfExists, strings.Join(download.IfExistsPolicies, ", "))
+	}
+	manifest := &state.Manifest{CreatedAt: time.Now()}
+	byID := make(map[string]File, len(files))
+	for _, f := range files {
+		manifest.Files = append(manifest.Files, f.spec())
+		if f.DatasetID != "" {
+			manifest.DatasetID = f.DatasetID
+		}
+		byID[f.ID] = f
+	}
+	manifest.Files = state.UniqueFileNames(manifest.Files, download.GeneratedNames(o.opts, nil))
+	for _, spec := range manifest.Files {
+		f := byID[spec.FileID]
+		f.Name, f.OriginalName = spec.FileName, spec.OriginalName
+		byID[spec.FileID] = f
+	}
+
+	orch := download.NewOrchestrator(o.client.api, o.state.sm, o.opts)
+	if h := o.events; h != nil {
+		orch.SetProgressCallback(func(fileID string, bytesDownloaded, totalBytes int64) {
+			h.HandleEvent(Event{Kind: FileProgress, File: byID[fileID], Downloaded: bytesDownloaded})
+		})
+		orch.SetFileCallbacks(
+			func(fileID, fileName string) {
+				h.HandleEvent(Event{Kind: FileStarted, File: byID[fileID]})
+			},
+			func(fileID, fileName string, err error) {
+				h.HandleEvent(Event{Kind: FileDone, File: byID[fileID], Err: err})
+			},
+			func(fileID, fileName string) {
+				h.HandleEvent(Event{Kind: FileSkipped, File: byID[fileID]})
+			},
+		)
+	}
+	return orch.Download(ctx, manifest)
+}
@@ -0,0 +1,88 @@
+package egafetch
+
+import (
+	"sort"
+	"time"
+
+	"github.com/khan-lab/EGAfetch/internal/state"
+)
+
+// Status is the download status of a file.
+type Status string
+
+const (
+	StatusPending     Status = Status(state.StatusPending)
+	StatusChunking    Status = Status(state.StatusChunking)
+	StatusDownloading Status = Status(state.StatusDownloading)
+	StatusMerging     Status = Status(state.StatusMerging)
+	StatusVerifying   Status = Status(state.StatusVerifying)
+	StatusComplete    Status = Status(state.StatusComplete)
+	StatusFailed      Status = Status(state.StatusFailed)
+)
+
+// FileState is the recorded download state of a file.
+type FileState struct {
+	File
+	Status      Status
+	Downloaded  int64     // bytes downloaded so far
+	MD5         string    // of the file on disk, once verified
+	SHA256      string    // of the file on disk, once verified
+	Error       string    // why the file last failed
+	CompletedAt time.Time // zero until complete
+}
+
+// StateManager holds the download state kept in an output directory, in
+// its .egafetch subdirectory.
+type StateManager struct {
+	sm *state.StateManager
+}
+
+// NewStateManager returns the state manager of the output directory dir.
+func NewStateManager(dir string) *StateManager {
+	return &StateManager{sm: state.NewStateManager(dir)}
+}
+
+// Dir returns the output directory.
+func (s *StateManager) Dir() string {
+	return s.sm.BaseDir()
+}
+
+// Files returns the state of every file downloaded into the directory, or
+// being downloaded, by file accession.
+func (s *StateManager) Files() ([]FileState, error) {
+	states, err := s.sm.ListFileStates()
+	if err != nil {
+		return nil, err
+	}
+	files := make([]FileState, 0, len(states))
+	for _, fs := range states {
+		f := FileState{
+			File: File{
+				ID:           fs.FileID,
+				Name:         fs.FileName,
+				OriginalName: fs.OriginalName,
+				Size:         fs.Size,
+				Checksum:     fs.ChecksumExpected,
+				ChecksumType: fs.ChecksumType,
+			},
+			Status:     Status(fs.Status),
+			Downloaded: fs.BytesDownloaded(),
+			MD5:        fs.MD5,
+			SHA256:     fs.SHA256,
+			Error:      fs.Error,
+		}
+		if fs.CompletedAt != nil {
+			f.CompletedAt = *fs.CompletedAt
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ID < files[j].ID })
+	return files, nil
+}
+
+// Reset removes all download state and downloaded chunks from the
+// directory, so that the next Download starts afresh. Complete files are
+// kept, and are checked against Options.IfExists.
+func (s *StateManager) Reset() error {
+	return s.sm.Reset()
+}